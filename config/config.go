@@ -3,6 +3,8 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/log"
@@ -12,6 +14,7 @@ import (
 type AppConfig struct {
 	Server     Server
 	Deployment Deployment
+	Cluster    Cluster
 }
 
 func NewAppConfig() *AppConfig {
@@ -46,6 +49,7 @@ func (a *AppConfig) Load() {
 	}
 	a.LoadServerConfig()
 	a.LoadDeploymentConfig()
+	a.LoadClusterConfig()
 }
 
 // LoadServerConfig load server config
@@ -56,6 +60,12 @@ func (a *AppConfig) LoadServerConfig() {
 	a.Server.Port = "3000"
 	a.Server.MaxQueue = 100
 	a.Server.MaxMessages = 100
+	a.Server.PingInterval = 30 * time.Second
+	a.Server.ReadTimeout = 60 * time.Second
+	a.Server.ReapInterval = 30 * time.Second
+	a.Server.ShutdownTimeout = 15 * time.Second
+	a.Server.InboxTTL = time.Minute
+	a.Server.InboxSize = 100
 
 	host := os.Getenv("SERVER_HOST")
 	if host != "" {
@@ -75,6 +85,103 @@ func (a *AppConfig) LoadServerConfig() {
 			a.Server.MaxMessages = v
 		}
 	}
+	if pi := os.Getenv("WS_PING_INTERVAL"); pi != "" {
+		if v, err := time.ParseDuration(pi); err == nil {
+			a.Server.PingInterval = v
+		}
+	}
+	if rt := os.Getenv("WS_READ_TIMEOUT"); rt != "" {
+		if v, err := time.ParseDuration(rt); err == nil {
+			a.Server.ReadTimeout = v
+		}
+	}
+	if ri := os.Getenv("WS_REAP_INTERVAL"); ri != "" {
+		if v, err := time.ParseDuration(ri); err == nil {
+			a.Server.ReapInterval = v
+		}
+	}
+	if st := os.Getenv("SHUTDOWN_TIMEOUT"); st != "" {
+		if v, err := time.ParseDuration(st); err == nil {
+			a.Server.ShutdownTimeout = v
+		}
+	}
+	a.Server.StateFile = os.Getenv("STATE_FILE")
+	a.Server.MQTTAddr = os.Getenv("MQTT_ADDR")
+	if mp := os.Getenv("MQTT_MAX_PACKET_SIZE"); mp != "" {
+		if v, err := strconv.Atoi(mp); err == nil {
+			a.Server.MQTTMaxPacketSize = v
+		}
+	}
+	if mi := os.Getenv("MQTT_MAX_INFLIGHT"); mi != "" {
+		if v, err := strconv.Atoi(mi); err == nil {
+			a.Server.MQTTMaxInflight = v
+		}
+	}
+	a.Server.RESPAddr = os.Getenv("RESP_ADDR")
+	a.Server.ClientRateLimit = loadRateLimit("RATE_LIMIT_CLIENT")
+	a.Server.TopicRateLimit = loadRateLimit("RATE_LIMIT_TOPIC")
+	a.Server.NamespaceRateLimit = loadRateLimit("RATE_LIMIT_NAMESPACE")
+
+	a.Server.AutoCreate = os.Getenv("AUTO_CREATE")
+	// AUTO_CREATE_NAMESPACES lists namespace=mode pairs, comma separated
+	a.Server.AutoCreateNamespaces = make(map[string]string)
+	for _, entry := range strings.Split(os.Getenv("AUTO_CREATE_NAMESPACES"), ",") {
+		ns, mode, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if ok && ns != "" {
+			a.Server.AutoCreateNamespaces[ns] = mode
+		}
+	}
+	if mm := os.Getenv("AUTO_CREATE_MAX_MESSAGES"); mm != "" {
+		if v, err := strconv.Atoi(mm); err == nil {
+			a.Server.AutoCreateMaxMessages = v
+		}
+	}
+	a.Server.AutoCreateRateLimit = loadRateLimit("AUTO_CREATE_RATE_LIMIT")
+	a.Server.AutoCreateCompacted, _ = strconv.ParseBool(os.Getenv("AUTO_CREATE_COMPACTED"))
+	if it := os.Getenv("TOPIC_IDLE_TIMEOUT"); it != "" {
+		if v, err := time.ParseDuration(it); err == nil {
+			a.Server.TopicIdleTimeout = v
+		}
+	}
+
+	if ttl := os.Getenv("INBOX_TTL"); ttl != "" {
+		if v, err := time.ParseDuration(ttl); err == nil {
+			a.Server.InboxTTL = v
+		}
+	}
+	if size := os.Getenv("INBOX_MAX_MESSAGES"); size != "" {
+		if v, err := strconv.Atoi(size); err == nil {
+			a.Server.InboxSize = v
+		}
+	}
+
+	a.Server.Tracing, _ = strconv.ParseBool(os.Getenv("TRACING"))
+	a.Server.TraceExporter = os.Getenv("TRACE_EXPORTER")
+	a.Server.OTLPEndpoint = os.Getenv("OTLP_ENDPOINT")
+	if retain := os.Getenv("TRACE_RETAIN"); retain != "" {
+		if v, err := strconv.Atoi(retain); err == nil {
+			a.Server.TraceRetain = v
+		}
+	}
+
+	// INTERCEPTORS lists interceptor names in chain order, comma separated
+	for _, name := range strings.Split(os.Getenv("INTERCEPTORS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			a.Server.Interceptors = append(a.Server.Interceptors, name)
+		}
+	}
+}
+
+// loadRateLimit reads <prefix>_MSGS and <prefix>_BYTES per second
+func loadRateLimit(prefix string) RateLimit {
+	var limit RateLimit
+	if v, err := strconv.ParseFloat(os.Getenv(prefix+"_MSGS"), 64); err == nil && v > 0 {
+		limit.Messages = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv(prefix+"_BYTES"), 64); err == nil && v > 0 {
+		limit.Bytes = v
+	}
+	return limit
 }
 
 // LoadDeploymentConfig loads the deployment config
@@ -94,3 +201,33 @@ func (a *AppConfig) LoadDeploymentConfig() {
 		a.Deployment.Name = name
 	}
 }
+
+// LoadClusterConfig loads the cluster config
+func (a *AppConfig) LoadClusterConfig() {
+	// load the default values
+	// then load from env variables
+	a.Cluster.BindAddr = ":7946"
+	a.Cluster.HeartbeatInterval = time.Second
+	a.Cluster.Peers = make(map[string]string)
+
+	a.Cluster.NodeID = os.Getenv("CLUSTER_NODE_ID")
+
+	bind := os.Getenv("CLUSTER_BIND_ADDR")
+	if bind != "" {
+		a.Cluster.BindAddr = bind
+	}
+
+	// CLUSTER_PEERS lists every node as id=host:port, comma separated
+	for _, entry := range strings.Split(os.Getenv("CLUSTER_PEERS"), ",") {
+		id, addr, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if ok && id != "" && addr != "" {
+			a.Cluster.Peers[id] = addr
+		}
+	}
+
+	if hb := os.Getenv("CLUSTER_HEARTBEAT"); hb != "" {
+		if v, err := time.ParseDuration(hb); err == nil {
+			a.Cluster.HeartbeatInterval = v
+		}
+	}
+}
@@ -1,13 +1,66 @@
 package config
 
+import "time"
+
 type Server struct {
 	Host        string
 	Port        string
 	MaxQueue    int
 	MaxMessages int
+
+	// WebSocket keepalive settings
+	PingInterval time.Duration // how often the server pings each client
+	ReadTimeout  time.Duration // read deadline, extended on every frame or pong
+	ReapInterval time.Duration // how often orphaned subscribers are reaped
+
+	ShutdownTimeout time.Duration // how long shutdown waits for clients to drain
+	StateFile       string        // pubsub snapshot path, empty disables persistence
+
+	MQTTAddr          string // MQTT listener address, empty disables it
+	MQTTMaxPacketSize int    // largest MQTT packet in bytes, 0 uses the default
+	MQTTMaxInflight   int    // unacknowledged QoS 1 messages per MQTT client, 0 uses the default
+	RESPAddr          string // Redis protocol listener address, empty disables it
+
+	// Publish rate limits, each bucket holding one second of burst
+	ClientRateLimit    RateLimit
+	TopicRateLimit     RateLimit
+	NamespaceRateLimit RateLimit
+
+	// Topic auto-creation on first publish or subscribe
+	AutoCreate            string            // off, on_publish, on_subscribe or both
+	AutoCreateNamespaces  map[string]string // namespace -> mode, overriding AutoCreate
+	AutoCreateMaxMessages int               // retention of auto-created topics, 0 uses MaxMessages
+	AutoCreateRateLimit   RateLimit         // publish limit of auto-created topics, zero uses TopicRateLimit
+	AutoCreateCompacted   bool              // auto-created topics are compacted
+	TopicIdleTimeout      time.Duration     // idle auto-created topics are removed after this, 0 keeps them
+
+	Interceptors []string // registered interceptors to run, in order; nil runs all of them
+
+	// Client inboxes hold messages while their client is offline
+	InboxTTL  time.Duration // how long, 0 drops them instead
+	InboxSize int           // how many per client
+
+	// Message tracing, spans kept for lookup and optionally exported
+	Tracing       bool   // record the spans of every message
+	TraceExporter string // stdout or otlp, empty exports nothing
+	OTLPEndpoint  string // collector traces URL, empty uses the local default
+	TraceRetain   int    // message ids whose spans are kept for lookup, 0 uses the default
+}
+
+// RateLimit is a per-second publish limit; zero fields are unlimited
+type RateLimit struct {
+	Messages float64
+	Bytes    float64
 }
 
 type Deployment struct {
 	Environment string
 	Name        string
 }
+
+type Cluster struct {
+	NodeID            string            // empty runs the server standalone
+	BindAddr          string            // TCP address for node-to-node traffic
+	Peers             map[string]string // node id -> cluster address
+	HeartbeatInterval time.Duration
+}
@@ -1,6 +1,13 @@
 package main
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/log"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -13,9 +20,19 @@ import (
 func main() {
 	app := fiber.New()
 
-	cnf := setupServer(app)
+	cnf, prv := setupServer(app)
 
-	routes.RegisterRoutes(app)
+	hooks := routes.RegisterRoutes(app)
+	hooks = append(hooks, prv.S.PubSub.Shutdown)
+	if prv.S.Cluster != nil {
+		hooks = append(hooks, func(ctx context.Context) error { return prv.S.Cluster.Close() })
+	}
+	if prv.S.MQTT != nil {
+		hooks = append(hooks, func(ctx context.Context) error { return prv.S.MQTT.Close() })
+	}
+	if prv.S.RESP != nil {
+		hooks = append(hooks, func(ctx context.Context) error { return prv.S.RESP.Close() })
+	}
 
 	for _, route := range app.GetRoutes() {
 		if route.Method == "OPTIONS" || route.Method == "HEAD" || route.Method == "TRACE" || route.Method == "CONNECT" {
@@ -27,14 +44,51 @@ func main() {
 		log.Infof("%s %s", route.Method, route.Path)
 	}
 
-	err := app.Listen(":" + cnf.Server.Port)
-	if err != nil {
-		log.Fatal(err)
-		return
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		if err := app.Listen(":" + cnf.Server.Port); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Infow("Shutting down", "timeout", cnf.Server.ShutdownTimeout)
+	shutdown(app, hooks, cnf.Server.ShutdownTimeout)
+}
+
+// shutdown stops accepting connections and drains every service in parallel.
+// Fiber's own shutdown waits for hijacked WebSocket connections, so it runs
+// alongside the hooks that actually close them.
+func shutdown(app *fiber.App, hooks []routes.ShutdownHook, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := app.ShutdownWithContext(ctx); err != nil {
+			log.Errorw("server shutdown incomplete", "error", err)
+		}
+	}()
+
+	for _, hook := range hooks {
+		wg.Add(1)
+		go func(hook routes.ShutdownHook) {
+			defer wg.Done()
+			if err := hook(ctx); err != nil {
+				log.Errorw("service shutdown failed", "error", err)
+			}
+		}(hook)
 	}
+
+	wg.Wait()
+	log.Info("Shutdown complete")
 }
 
-func setupServer(app *fiber.App) *config.AppConfig {
+func setupServer(app *fiber.App) (*config.AppConfig, *providers.Provider) {
 	cnf := config.NewAppConfig()
 	log.Infow("Loaded Configurations",
 		"host", cnf.Server.Host,
@@ -51,5 +105,5 @@ func setupServer(app *fiber.App) *config.AppConfig {
 	app.Use(cors.New(cors.Config{
 		AllowOrigins: "http://localhost:5173",
 	}))
-	return cnf
+	return cnf, prv
 }
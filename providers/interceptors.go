@@ -0,0 +1,56 @@
+package providers
+
+import (
+	"sync"
+
+	"github.com/gofiber/fiber/v2/log"
+
+	"github.com/Aryaman/pub-sub/services/pubsub"
+)
+
+var (
+	interceptorsMu sync.Mutex
+	interceptors   []pubsub.Interceptor // in registration order
+)
+
+// RegisterInterceptor makes an interceptor available to the pubsub service
+// under its name. Modules call it from init; the server's INTERCEPTORS
+// setting then picks which of them run, and in what order.
+func RegisterInterceptor(i pubsub.Interceptor) {
+	interceptorsMu.Lock()
+	defer interceptorsMu.Unlock()
+	for n, registered := range interceptors {
+		if registered.Name == i.Name {
+			interceptors[n] = i
+			return
+		}
+	}
+	interceptors = append(interceptors, i)
+}
+
+// interceptorChain returns the registered interceptors named, in that
+// order, or every registered one in registration order when names is nil.
+// Unknown names are skipped.
+func interceptorChain(names []string) []pubsub.Interceptor {
+	interceptorsMu.Lock()
+	defer interceptorsMu.Unlock()
+	if names == nil {
+		return append([]pubsub.Interceptor(nil), interceptors...)
+	}
+
+	chain := make([]pubsub.Interceptor, 0, len(names))
+	for _, name := range names {
+		found := false
+		for _, i := range interceptors {
+			if i.Name == name {
+				chain = append(chain, i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			log.Errorw("unknown interceptor", "name", name)
+		}
+	}
+	return chain
+}
@@ -1,17 +1,136 @@
 package providers
 
 import (
+	"os"
+
+	"github.com/gofiber/fiber/v2/log"
+
 	"github.com/Aryaman/pub-sub/config"
+	"github.com/Aryaman/pub-sub/sdk"
+	"github.com/Aryaman/pub-sub/services/cluster"
+	"github.com/Aryaman/pub-sub/services/mqtt"
 	"github.com/Aryaman/pub-sub/services/pubsub"
+	"github.com/Aryaman/pub-sub/services/resp"
 )
 
 type Service struct {
-	PubSub pubsub.PubSub
+	PubSub  pubsub.PubSub
+	Cluster *cluster.Node // nil when running standalone
+	MQTT    *mqtt.Server  // nil when the MQTT listener is disabled
+	RESP    *resp.Server  // nil when the Redis protocol listener is disabled
 }
 
 func NewServicesWithConfig(cnf config.AppConfig) *Service {
 	pubsubSvc := pubsub.NewService(cnf.Server.MaxQueue, cnf.Server.MaxMessages)
-	return &Service{PubSub: pubsubSvc}
+	pubsubSvc.PingInterval = cnf.Server.PingInterval
+	pubsubSvc.ReadTimeout = cnf.Server.ReadTimeout
+	pubsubSvc.StateFile = cnf.Server.StateFile
+	pubsubSvc.RateLimits = pubsub.RateLimits{
+		Client:    pubsub.RateLimit(cnf.Server.ClientRateLimit),
+		Topic:     pubsub.RateLimit(cnf.Server.TopicRateLimit),
+		Namespace: pubsub.RateLimit(cnf.Server.NamespaceRateLimit),
+	}
+	pubsubSvc.AutoCreate = autoCreatePolicy(cnf.Server)
+	pubsubSvc.InboxTTL = cnf.Server.InboxTTL
+	pubsubSvc.InboxSize = cnf.Server.InboxSize
+	pubsubSvc.Use(interceptorChain(cnf.Server.Interceptors)...)
+	if cnf.Server.Tracing {
+		pubsubSvc.EnableTracing(traceConfig(cnf))
+	}
+	if err := pubsubSvc.LoadState(); err != nil {
+		log.Errorw("failed to restore pubsub state", "error", err)
+	}
+	pubsubSvc.StartReaper(cnf.Server.ReapInterval)
+
+	var node *cluster.Node
+	if cnf.Cluster.NodeID != "" {
+		node = cluster.NewNode(cluster.Config{
+			NodeID:            cnf.Cluster.NodeID,
+			BindAddr:          cnf.Cluster.BindAddr,
+			Peers:             cnf.Cluster.Peers,
+			HeartbeatInterval: cnf.Cluster.HeartbeatInterval,
+		}, pubsubSvc)
+		if err := node.Start(); err != nil {
+			log.Errorw("failed to join cluster", "error", err)
+		}
+	}
+
+	var mqttSrv *mqtt.Server
+	if cnf.Server.MQTTAddr != "" {
+		mqttSrv = mqtt.NewServer(pubsubSvc)
+		if cnf.Server.MQTTMaxPacketSize > 0 {
+			mqttSrv.MaxPacketSize = cnf.Server.MQTTMaxPacketSize
+		}
+		if cnf.Server.MQTTMaxInflight > 0 {
+			mqttSrv.MaxInflight = cnf.Server.MQTTMaxInflight
+		}
+		if err := mqttSrv.Start(cnf.Server.MQTTAddr); err != nil {
+			log.Errorw("failed to start mqtt listener", "error", err)
+		}
+	}
+
+	var respSrv *resp.Server
+	if cnf.Server.RESPAddr != "" {
+		respSrv = resp.NewServer(pubsubSvc)
+		if err := respSrv.Start(cnf.Server.RESPAddr); err != nil {
+			log.Errorw("failed to start resp listener", "error", err)
+		}
+	}
+
+	return &Service{PubSub: pubsubSvc, Cluster: node, MQTT: mqttSrv, RESP: respSrv}
+}
+
+// autoCreatePolicy builds the topic auto-create policy, leaving invalid
+// modes off
+func autoCreatePolicy(cnf config.Server) pubsub.AutoCreatePolicy {
+	policy := pubsub.AutoCreatePolicy{
+		Namespaces:  make(map[string]pubsub.AutoCreateMode),
+		IdleTimeout: cnf.TopicIdleTimeout,
+	}
+	mode, err := pubsub.ParseAutoCreateMode(cnf.AutoCreate)
+	if err != nil {
+		log.Errorw("invalid AUTO_CREATE", "error", err)
+	}
+	policy.Mode = mode
+	for ns, value := range cnf.AutoCreateNamespaces {
+		mode, err := pubsub.ParseAutoCreateMode(value)
+		if err != nil {
+			log.Errorw("invalid AUTO_CREATE_NAMESPACES entry", "namespace", ns, "error", err)
+		}
+		policy.Namespaces[ns] = mode
+	}
+
+	if cnf.AutoCreateMaxMessages > 0 {
+		policy.Settings.MaxMessages = &cnf.AutoCreateMaxMessages
+	}
+	if limit := cnf.AutoCreateRateLimit; limit.Messages > 0 || limit.Bytes > 0 {
+		policy.Settings.RateLimit = &sdk.TopicRateLimit{MessagesPerSec: limit.Messages, BytesPerSec: limit.Bytes}
+	}
+	if cnf.AutoCreateCompacted {
+		policy.Settings.Compacted = &cnf.AutoCreateCompacted
+	}
+	return policy
+}
+
+// traceConfig picks the span exporter, leaving spans unexported for an
+// unknown one
+func traceConfig(cnf config.AppConfig) pubsub.TraceConfig {
+	tc := pubsub.TraceConfig{
+		Retain: cnf.Server.TraceRetain,
+		OnExportError: func(err error) {
+			log.Errorw("failed to export spans", "error", err)
+		},
+	}
+	switch cnf.Server.TraceExporter {
+	case "":
+	case "stdout":
+		tc.Exporter = pubsub.NewStdoutExporter(os.Stdout)
+	case "otlp":
+		tc.Exporter = pubsub.NewOTLPExporter(cnf.Server.OTLPEndpoint, cnf.Deployment.Name)
+	default:
+		log.Errorw("invalid TRACE_EXPORTER", "exporter", cnf.Server.TraceExporter)
+	}
+	return tc
 }
 
 func NewServices() *Service {
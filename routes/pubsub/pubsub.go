@@ -43,6 +43,357 @@ func DeleteTopic(c *fiber.Ctx) error {
 	return nil
 }
 
+// GetTopic returns a topic's configuration and counters
+func GetTopic(c *fiber.Ctx) error {
+	log.Debug("received get topic request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.GetTopic(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to get topic", "error", err)
+		return err
+	}
+	log.Debug("topic retrieved successfully")
+	return nil
+}
+
+// UpdateTopic changes a topic's retention and rate limit
+func UpdateTopic(c *fiber.Ctx) error {
+	log.Debug("received update topic request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.UpdateTopic(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to update topic", "error", err)
+		return err
+	}
+	log.Debug("topic updated successfully")
+	return nil
+}
+
+// PurgeTopic clears a topic's retained messages
+func PurgeTopic(c *fiber.Ctx) error {
+	log.Debug("received purge topic request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.PurgeTopic(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to purge topic", "error", err)
+		return err
+	}
+	log.Debug("topic purged successfully")
+	return nil
+}
+
+// PauseTopic stops delivery on a topic while still accepting publishes
+func PauseTopic(c *fiber.Ctx) error {
+	log.Debug("received pause topic request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.PauseTopic(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to pause topic", "error", err)
+		return err
+	}
+	log.Debug("topic paused successfully")
+	return nil
+}
+
+// ResumeTopic flushes buffered messages and restarts delivery on a topic
+func ResumeTopic(c *fiber.Ctx) error {
+	log.Debug("received resume topic request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.ResumeTopic(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to resume topic", "error", err)
+		return err
+	}
+	log.Debug("topic resumed successfully")
+	return nil
+}
+
+// BrowseMessages lists a topic's retained messages without subscribing
+func BrowseMessages(c *fiber.Ctx) error {
+	log.Debug("received browse messages request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.BrowseMessages(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to browse messages", "error", err)
+		return err
+	}
+	log.Debug("messages browsed successfully")
+	return nil
+}
+
+// ExportTopic streams a topic's config and retained messages as JSON Lines
+func ExportTopic(c *fiber.Ctx) error {
+	log.Debug("received export topic request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.ExportTopic(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to export topic", "error", err)
+		return err
+	}
+	log.Debug("topic export started")
+	return nil
+}
+
+// ImportTopic loads a JSON Lines export into a topic
+func ImportTopic(c *fiber.Ctx) error {
+	log.Debug("received import topic request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.ImportTopic(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to import topic", "error", err)
+		return err
+	}
+	log.Debug("topic imported successfully")
+	return nil
+}
+
+// PublishTx publishes to several topics all together or not at all
+func PublishTx(c *fiber.Ctx) error {
+	log.Debug("received publish transaction request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.PublishTx(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to publish transaction", "error", err)
+		return err
+	}
+	log.Debug("publish transaction handled successfully")
+	return nil
+}
+
+// CreateRoute adds a rule copying messages between topics
+func CreateRoute(c *fiber.Ctx) error {
+	log.Debug("received create route request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.CreateRoute(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to create route", "error", err)
+		return err
+	}
+	log.Debug("route created successfully")
+	return nil
+}
+
+// ListRoutes returns every routing rule with its counters
+func ListRoutes(c *fiber.Ctx) error {
+	log.Debug("received list routes request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.ListRoutes(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to list routes", "error", err)
+		return err
+	}
+	log.Debug("routes listed successfully")
+	return nil
+}
+
+// GetRoute returns one routing rule with its counters
+func GetRoute(c *fiber.Ctx) error {
+	log.Debug("received get route request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.GetRoute(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to get route", "error", err)
+		return err
+	}
+	log.Debug("route retrieved successfully")
+	return nil
+}
+
+// DeleteRoute removes a routing rule
+func DeleteRoute(c *fiber.Ctx) error {
+	log.Debug("received delete route request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.DeleteRoute(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to delete route", "error", err)
+		return err
+	}
+	log.Debug("route deleted successfully")
+	return nil
+}
+
+// ListClients returns the connected WebSocket clients and their subscriptions
+func ListClients(c *fiber.Ctx) error {
+	log.Debug("received list clients request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.ListClients(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to list clients", "error", err)
+		return err
+	}
+	log.Debug("clients listed successfully")
+	return nil
+}
+
+// DisconnectClient force-disconnects a client, telling it why
+func DisconnectClient(c *fiber.Ctx) error {
+	log.Debug("received disconnect client request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.DisconnectClient(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to disconnect client", "error", err)
+		return err
+	}
+	log.Debug("client disconnected successfully")
+	return nil
+}
+
+// MessageClient sends a server-initiated info message to one client
+func MessageClient(c *fiber.Ctx) error {
+	log.Debug("received message client request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.MessageClient(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to message client", "error", err)
+		return err
+	}
+	log.Debug("client messaged successfully")
+	return nil
+}
+
+// BroadcastMessage sends a server-initiated info message to every client
+func BroadcastMessage(c *fiber.Ctx) error {
+	log.Debug("received broadcast message request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.BroadcastMessage(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to broadcast message", "error", err)
+		return err
+	}
+	log.Debug("message broadcast successfully")
+	return nil
+}
+
+// CreateReplay starts a job replaying a range of one topic into another
+func CreateReplay(c *fiber.Ctx) error {
+	log.Debug("received create replay request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.CreateReplay(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to create replay", "error", err)
+		return err
+	}
+	log.Debug("replay created successfully")
+	return nil
+}
+
+// ListJobs returns every background job and its progress
+func ListJobs(c *fiber.Ctx) error {
+	log.Debug("received list jobs request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.ListJobs(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to list jobs", "error", err)
+		return err
+	}
+	log.Debug("jobs listed successfully")
+	return nil
+}
+
+// GetJob returns one background job and its progress
+func GetJob(c *fiber.Ctx) error {
+	log.Debug("received get job request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.GetJob(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to get job", "error", err)
+		return err
+	}
+	log.Debug("job retrieved successfully")
+	return nil
+}
+
+// PauseJob pauses a running job
+func PauseJob(c *fiber.Ctx) error {
+	log.Debug("received pause job request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.PauseJob(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to pause job", "error", err)
+		return err
+	}
+	log.Debug("job paused successfully")
+	return nil
+}
+
+// ResumeJob resumes a paused job
+func ResumeJob(c *fiber.Ctx) error {
+	log.Debug("received resume job request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.ResumeJob(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to resume job", "error", err)
+		return err
+	}
+	log.Debug("job resumed successfully")
+	return nil
+}
+
+// CancelJob stops a job for good
+func CancelJob(c *fiber.Ctx) error {
+	log.Debug("received cancel job request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.CancelJob(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to cancel job", "error", err)
+		return err
+	}
+	log.Debug("job cancelled successfully")
+	return nil
+}
+
+// CreateGenerator starts a synthetic event generator
+func CreateGenerator(c *fiber.Ctx) error {
+	log.Debug("received create generator request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.CreateGenerator(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to create generator", "error", err)
+		return err
+	}
+	log.Debug("generator created successfully")
+	return nil
+}
+
+// ListGenerators returns the generator jobs
+func ListGenerators(c *fiber.Ctx) error {
+	log.Debug("received list generators request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.ListGenerators(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to list generators", "error", err)
+		return err
+	}
+	log.Debug("generators listed successfully")
+	return nil
+}
+
+// DeleteGenerator stops a generator
+func DeleteGenerator(c *fiber.Ctx) error {
+	log.Debug("received delete generator request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.DeleteGenerator(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to stop generator", "error", err)
+		return err
+	}
+	log.Debug("generator stopped successfully")
+	return nil
+}
+
+// GetTrace returns the recorded spans of a message
+func GetTrace(c *fiber.Ctx) error {
+	log.Debug("received get trace request")
+	pr := providers.GetProviders(c)
+	err := pr.S.PubSub.GetTrace(c.Context(), c)
+	if err != nil {
+		log.Errorw("failed to get trace", "error", err)
+		return err
+	}
+	log.Debug("trace retrieved successfully")
+	return nil
+}
+
 // ListTopics returns all available topics with subscriber counts
 func ListTopics(c *fiber.Ctx) error {
 	log.Debug("received list topics request")
@@ -1,6 +1,7 @@
 package pubsub
 
 import (
+	"github.com/Aryaman/pub-sub/sdk/codec"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
 )
@@ -10,8 +11,35 @@ func RegisterRoutes(router fiber.Router) {
 	v1 := router.Group("/v1")
 	v1.Post("/topics", CreateTopic)
 	v1.Delete("/topics/:name", DeleteTopic)
+	v1.Get("/topics/:name", GetTopic)
+	v1.Patch("/topics/:name", UpdateTopic)
+	v1.Post("/topics/:name/purge", PurgeTopic)
+	v1.Post("/topics/:name/pause", PauseTopic)
+	v1.Post("/topics/:name/resume", ResumeTopic)
+	v1.Get("/topics/:name/messages", BrowseMessages)
+	v1.Get("/topics/:name/export", ExportTopic)
+	v1.Post("/topics/:name/import", ImportTopic)
 	v1.Get("/topics", ListTopics)
+	v1.Post("/publish_tx", PublishTx)
+	v1.Post("/routes", CreateRoute)
+	v1.Get("/routes", ListRoutes)
+	v1.Get("/routes/:id", GetRoute)
+	v1.Delete("/routes/:id", DeleteRoute)
+	v1.Get("/clients", ListClients)
+	v1.Post("/clients/messages", BroadcastMessage)
+	v1.Delete("/clients/:id", DisconnectClient)
+	v1.Post("/clients/:id/messages", MessageClient)
+	v1.Post("/replays", CreateReplay)
+	v1.Get("/jobs", ListJobs)
+	v1.Get("/jobs/:id", GetJob)
+	v1.Post("/jobs/:id/pause", PauseJob)
+	v1.Post("/jobs/:id/resume", ResumeJob)
+	v1.Post("/jobs/:id/cancel", CancelJob)
+	v1.Post("/generators", CreateGenerator)
+	v1.Get("/generators", ListGenerators)
+	v1.Delete("/generators/:id", DeleteGenerator)
+	v1.Get("/traces/:id", GetTrace)
 	v1.Get("/health", Health)
 	v1.Get("/stats", Stats)
-	v1.Get("/ws", websocket.New(HandleWebSocket))
+	v1.Get("/ws", websocket.New(HandleWebSocket, websocket.Config{Subprotocols: codec.Subprotocols}))
 }
@@ -1,6 +1,8 @@
 package routes
 
 import (
+	"context"
+
 	"github.com/Aryaman/pub-sub/routes/connector"
 	"github.com/Aryaman/pub-sub/routes/pubsub"
 	"github.com/Aryaman/pub-sub/routes/tester"
@@ -9,20 +11,26 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
-// RegisterRoutes registers all main API routes
-func RegisterRoutes(app *fiber.App) {
+// ShutdownHook drains a component's in-flight work before the process exits
+type ShutdownHook func(ctx context.Context) error
+
+// RegisterRoutes registers all main API routes and returns the shutdown hooks
+// of the services created along the way
+func RegisterRoutes(app *fiber.App) []ShutdownHook {
 	// PubSub routes
 	pubsub.RegisterRoutes(app.Group("/pubsub"))
 
 	// Connector/Tunnel routes
-	setupConnectorRoutes(app)
+	tunnelShutdown := setupConnectorRoutes(app)
 
 	// Testing routes
-	setupTesterRoutes(app)
+	testerShutdown := setupTesterRoutes(app)
+
+	return []ShutdownHook{tunnelShutdown, testerShutdown}
 }
 
 // setupConnectorRoutes initializes and registers connector routes
-func setupConnectorRoutes(app *fiber.App) {
+func setupConnectorRoutes(app *fiber.App) ShutdownHook {
 	// Initialize store and service
 	store := connectorService.NewMemoryStore()
 	config := connectorService.GenerateTunnelConfig("https://api.syntra.dev")
@@ -39,10 +47,12 @@ func setupConnectorRoutes(app *fiber.App) {
 	// Register routes
 	connectorGroup := app.Group("/tunnel")
 	connector.RegisterRoutes(connectorGroup, handler, wsHandler)
+
+	return tunnelManager.Shutdown
 }
 
 // setupTesterRoutes initializes and registers testing routes
-func setupTesterRoutes(app *fiber.App) {
+func setupTesterRoutes(app *fiber.App) ShutdownHook {
 	// Initialize store and service
 	store := testerService.NewMemoryStore()
 	service := testerService.NewService(store)
@@ -53,4 +63,6 @@ func setupTesterRoutes(app *fiber.App) {
 	// Register routes
 	testerGroup := app.Group("/test")
 	tester.RegisterRoutes(testerGroup, handler)
+
+	return service.Shutdown
 }
@@ -0,0 +1,515 @@
+// Package client is a Go client for the pubsub WebSocket protocol. It
+// correlates acks and errors with the requests that caused them, reconnects
+// with exponential backoff when the connection drops and resubscribes each
+// subscription after the last message it received. It does not depend on
+// Fiber, so services can import it without pulling in the server.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+	"github.com/Aryaman/pub-sub/sdk/codec"
+	"github.com/fasthttp/websocket"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrClosed is returned by requests made after Close
+	ErrClosed = errors.New("client closed")
+	// ErrConnectionLost is returned when the connection drops before the
+	// server answers. The request may or may not have taken effect.
+	ErrConnectionLost = errors.New("connection lost before the server replied")
+	// ErrAlreadySubscribed is returned when the client already holds a
+	// subscription to the topic
+	ErrAlreadySubscribed = errors.New("already subscribed to topic")
+)
+
+// Error is an error frame the server sent in reply to a request
+type Error struct {
+	Code       string // one of the sdk.ErrorCode constants
+	Message    string
+	RetryAfter time.Duration // set with sdk.ErrorCodeRateLimited
+}
+
+func (e *Error) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("%s: %s (retry after %s)", e.Code, e.Message, e.RetryAfter)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Options configures a Client. The zero value is usable.
+type Options struct {
+	ClientID       string            // identifies the client's subscriptions and publishes, a random id when empty
+	Subprotocol    string            // frame encoding: codec.JSON (default), codec.MsgPack or codec.Protobuf
+	Header         http.Header       // extra handshake headers, such as credentials
+	Dialer         *websocket.Dialer // websocket.DefaultDialer when nil
+	RequestTimeout time.Duration     // bounds requests whose context has no deadline, default 10s
+	MinBackoff     time.Duration     // first reconnect delay, default 100ms
+	MaxBackoff     time.Duration     // longest reconnect delay, default 30s
+
+	// OnError receives errors that have no caller to return to, such as a
+	// failed reconnect, a subscription the server refused to restore or one
+	// it closed for falling behind
+	OnError func(error)
+}
+
+func (o *Options) setDefaults() {
+	if o.ClientID == "" {
+		o.ClientID = uuid.NewString()
+	}
+	if o.Dialer == nil {
+		o.Dialer = websocket.DefaultDialer
+	}
+	if o.RequestTimeout <= 0 {
+		o.RequestTimeout = 10 * time.Second
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = 100 * time.Millisecond
+	}
+	if o.MaxBackoff < o.MinBackoff {
+		o.MaxBackoff = 30 * time.Second
+		if o.MaxBackoff < o.MinBackoff {
+			o.MaxBackoff = o.MinBackoff
+		}
+	}
+}
+
+// Client is a connection to a pubsub server that survives reconnects. It is
+// safe for concurrent use.
+type Client struct {
+	url  string
+	opts Options
+
+	mu     sync.Mutex
+	conn   *conn         // nil while reconnecting
+	ready  chan struct{} // closed once conn is set
+	subs   map[string]*Subscription
+	closed bool
+
+	nextID atomic.Uint64
+	done   chan struct{}
+}
+
+// Connect dials the server's WebSocket endpoint, such as
+// ws://localhost:8080/pubsub/v1/ws. Only the first dial fails Connect; after
+// that the client reconnects on its own until Close.
+func Connect(ctx context.Context, url string, opts Options) (*Client, error) {
+	opts.setDefaults()
+	c := &Client{
+		url:   url,
+		opts:  opts,
+		ready: make(chan struct{}),
+		subs:  make(map[string]*Subscription),
+		done:  make(chan struct{}),
+	}
+
+	cn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.attach(cn)
+	go c.run(cn)
+	return c, nil
+}
+
+// ClientID returns the id the client subscribes and publishes with
+func (c *Client) ClientID() string {
+	return c.opts.ClientID
+}
+
+// Publish sends msg to topic and waits for the server's ack. A message
+// without an id is given a random one.
+func (c *Client) Publish(ctx context.Context, topic string, msg sdk.Message) error {
+	if msg.ID == "" {
+		msg.ID = uuid.NewString()
+	}
+	_, err := c.request(ctx, sdk.WebSocketRequest{
+		Type:     sdk.MessageTypePublish,
+		Topic:    topic,
+		ClientID: c.opts.ClientID,
+		Message:  &msg,
+	})
+	return err
+}
+
+// PublishBatch sends msgs to topic in one frame and returns the outcome of
+// each, in order
+func (c *Client) PublishBatch(ctx context.Context, topic string, msgs []sdk.Message) ([]sdk.PublishResult, error) {
+	batch := make([]sdk.Message, len(msgs))
+	for i, msg := range msgs {
+		if msg.ID == "" {
+			msg.ID = uuid.NewString()
+		}
+		batch[i] = msg
+	}
+	resp, err := c.request(ctx, sdk.WebSocketRequest{
+		Type:     sdk.MessageTypePublishBatch,
+		Topic:    topic,
+		ClientID: c.opts.ClientID,
+		Messages: batch,
+	})
+	return resp.Results, err
+}
+
+// PublishTx publishes to several topics all together or not at all and
+// returns the outcome of each message, in order
+func (c *Client) PublishTx(ctx context.Context, entries []sdk.TopicMessage) ([]sdk.PublishResult, error) {
+	tx := make([]sdk.TopicMessage, len(entries))
+	for i, entry := range entries {
+		if entry.Message.ID == "" {
+			entry.Message.ID = uuid.NewString()
+		}
+		tx[i] = entry
+	}
+	resp, err := c.request(ctx, sdk.WebSocketRequest{
+		Type:      sdk.MessageTypePublishTx,
+		ClientID:  c.opts.ClientID,
+		Publishes: tx,
+	})
+	return resp.Results, err
+}
+
+// Close disconnects and stops reconnecting. No handler is called after
+// Close returns, apart from any already running.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	cn := c.conn
+	subs := c.subs
+	c.subs = make(map[string]*Subscription)
+	c.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.stop()
+	}
+	if cn != nil {
+		cn.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		cn.ws.Close()
+	}
+	return nil
+}
+
+// request sends req on the current connection, waiting for one if the
+// client is reconnecting, and returns the server's reply
+func (c *Client) request(ctx context.Context, req sdk.WebSocketRequest) (sdk.WebSocketResponse, error) {
+	ctx, cancel := c.withDeadline(ctx)
+	defer cancel()
+
+	for {
+		c.mu.Lock()
+		closed, cn, ready := c.closed, c.conn, c.ready
+		c.mu.Unlock()
+		if closed {
+			return sdk.WebSocketResponse{}, ErrClosed
+		}
+		if cn != nil {
+			return c.send(ctx, cn, req)
+		}
+		select {
+		case <-ready:
+		case <-c.done:
+		case <-ctx.Done():
+			return sdk.WebSocketResponse{}, ctx.Err()
+		}
+	}
+}
+
+// withDeadline applies RequestTimeout to a context without a deadline
+func (c *Client) withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, c.opts.RequestTimeout)
+}
+
+// send writes req on cn with a fresh request id and waits for its reply
+func (c *Client) send(ctx context.Context, cn *conn, req sdk.WebSocketRequest) (sdk.WebSocketResponse, error) {
+	req.RequestID = strconv.FormatUint(c.nextID.Add(1), 10)
+	reply := make(chan sdk.WebSocketResponse, 1)
+	cn.pendingMu.Lock()
+	cn.pending[req.RequestID] = reply
+	cn.pendingMu.Unlock()
+	defer func() {
+		cn.pendingMu.Lock()
+		delete(cn.pending, req.RequestID)
+		cn.pendingMu.Unlock()
+	}()
+
+	if err := cn.write(ctx, req); err != nil {
+		return sdk.WebSocketResponse{}, fmt.Errorf("%w: %v", ErrConnectionLost, err)
+	}
+
+	select {
+	case resp := <-reply:
+		if resp.Type == sdk.MessageTypeError {
+			return resp, replyError(resp)
+		}
+		return resp, nil
+	case <-cn.closed:
+		return sdk.WebSocketResponse{}, ErrConnectionLost
+	case <-ctx.Done():
+		return sdk.WebSocketResponse{}, ctx.Err()
+	}
+}
+
+// replyError converts an error frame to an *Error
+func replyError(resp sdk.WebSocketResponse) error {
+	if resp.Error == nil {
+		return &Error{Code: sdk.ErrorCodeInternal, Message: "error frame without detail"}
+	}
+	return &Error{
+		Code:       resp.Error.Code,
+		Message:    resp.Error.Message,
+		RetryAfter: time.Duration(resp.Error.RetryAfterMS) * time.Millisecond,
+	}
+}
+
+// attach makes cn the connection requests go out on
+func (c *Client) attach(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn = cn
+	close(c.ready)
+}
+
+// detach forgets cn once it has dropped and reports whether the client
+// should reconnect
+func (c *Client) detach(cn *conn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	if c.conn == cn {
+		c.conn = nil
+		c.ready = make(chan struct{})
+	}
+	return true
+}
+
+// run replaces the connection each time it drops, until Close
+func (c *Client) run(cn *conn) {
+	for cn != nil {
+		<-cn.closed
+		if !c.detach(cn) {
+			return
+		}
+		cn = c.reconnect()
+	}
+}
+
+// reconnect dials with exponential backoff and full jitter until it has a
+// connection with every subscription restored, or the client is closed
+func (c *Client) reconnect() *conn {
+	backoff := c.opts.MinBackoff
+	for {
+		wait := time.Duration(rand.Int63n(int64(backoff)) + 1)
+		select {
+		case <-time.After(wait):
+		case <-c.done:
+			return nil
+		}
+
+		cn, err := c.connectOnce()
+		if err == nil {
+			c.mu.Lock()
+			if c.closed {
+				c.mu.Unlock()
+				cn.ws.Close()
+				return nil
+			}
+			c.mu.Unlock()
+			c.attach(cn)
+			return cn
+		}
+		c.report(fmt.Errorf("reconnect failed: %w", err))
+
+		backoff *= 2
+		if backoff > c.opts.MaxBackoff {
+			backoff = c.opts.MaxBackoff
+		}
+	}
+}
+
+// connectOnce dials and restores the subscriptions on the new connection
+func (c *Client) connectOnce() (*conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.RequestTimeout)
+	defer cancel()
+
+	cn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.resubscribe(ctx, cn); err != nil {
+		cn.ws.Close()
+		return nil, err
+	}
+	return cn, nil
+}
+
+// resubscribe restores every subscription on cn, resuming after the last
+// message each received. A subscription the server refuses, say because
+// its topic was deleted, is dropped and reported rather than retried.
+func (c *Client) resubscribe(ctx context.Context, cn *conn) error {
+	c.mu.Lock()
+	subs := make([]*Subscription, 0, len(c.subs))
+	for _, sub := range c.subs {
+		if sub.restorable() {
+			subs = append(subs, sub)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, sub := range subs {
+		resp, err := c.send(ctx, cn, sub.request(true))
+		var refused *Error
+		if errors.As(err, &refused) {
+			c.forget(sub)
+			sub.stop()
+			c.report(fmt.Errorf("resubscribe to %q refused: %w", sub.topic, err))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		sub.started(resp.Seq)
+	}
+	return nil
+}
+
+// forget removes sub if it is still the client's subscription to its topic
+func (c *Client) forget(sub *Subscription) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subs[sub.topic] == sub {
+		delete(c.subs, sub.topic)
+	}
+}
+
+// subscription returns the subscription to topic, nil if there is none
+func (c *Client) subscription(topic string) *Subscription {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.subs[topic]
+}
+
+func (c *Client) report(err error) {
+	if c.opts.OnError != nil {
+		c.opts.OnError(err)
+	}
+}
+
+// conn is a single WebSocket connection and the requests awaiting replies
+// on it
+type conn struct {
+	ws   *websocket.Conn
+	wire codec.Codec
+
+	writeMu sync.Mutex
+
+	pendingMu sync.Mutex
+	pending   map[string]chan sdk.WebSocketResponse // request_id -> reply
+
+	closed chan struct{} // closed when the read loop stops
+}
+
+// dial opens a connection and starts reading from it
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	dialer := *c.opts.Dialer
+	if c.opts.Subprotocol != "" {
+		dialer.Subprotocols = []string{c.opts.Subprotocol}
+	}
+	ws, _, err := dialer.DialContext(ctx, c.url, c.opts.Header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", c.url, err)
+	}
+
+	cn := &conn{
+		ws:      ws,
+		wire:    codec.ForSubprotocol(ws.Subprotocol()),
+		pending: make(map[string]chan sdk.WebSocketResponse),
+		closed:  make(chan struct{}),
+	}
+	go cn.read(c)
+	return cn, nil
+}
+
+// write encodes and sends one request
+func (cn *conn) write(ctx context.Context, req sdk.WebSocketRequest) error {
+	data, err := cn.wire.EncodeRequest(req)
+	if err != nil {
+		return err
+	}
+	frameType := websocket.TextMessage
+	if cn.wire.Binary() {
+		frameType = websocket.BinaryMessage
+	}
+
+	cn.writeMu.Lock()
+	defer cn.writeMu.Unlock()
+	deadline, _ := ctx.Deadline()
+	cn.ws.SetWriteDeadline(deadline)
+	return cn.ws.WriteMessage(frameType, data)
+}
+
+// read routes replies to their requests and events to their subscriptions
+// until the connection fails
+func (cn *conn) read(c *Client) {
+	defer close(cn.closed)
+	defer cn.ws.Close()
+
+	for {
+		_, data, err := cn.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		var resp sdk.WebSocketResponse
+		if err := cn.wire.DecodeResponse(data, &resp); err != nil {
+			c.report(fmt.Errorf("undecodable frame: %w", err))
+			return
+		}
+
+		switch resp.Type {
+		case sdk.MessageTypeEvent:
+			if sub := c.subscription(resp.Topic); sub != nil && resp.Message != nil {
+				sub.deliver(*resp.Message)
+			}
+		case sdk.MessageTypeEventBatch:
+			if sub := c.subscription(resp.Topic); sub != nil {
+				for _, msg := range resp.Messages {
+					sub.deliver(msg)
+				}
+			}
+		default:
+			if resp.RequestID == "" {
+				// Unsolicited info and error frames; the server follows
+				// a shutdown notice by closing the connection
+				if resp.Type == sdk.MessageTypeError && resp.Topic != "" {
+					c.report(fmt.Errorf("subscription to %q closed: %w", resp.Topic, replyError(resp)))
+				}
+				continue
+			}
+			cn.pendingMu.Lock()
+			reply, ok := cn.pending[resp.RequestID]
+			cn.pendingMu.Unlock()
+			if ok {
+				reply <- resp
+			}
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+	"github.com/Aryaman/pub-sub/sdk/client"
+	"github.com/Aryaman/pub-sub/sdk/codec"
+	"github.com/Aryaman/pub-sub/services/pubsub"
+	"github.com/gofiber/fiber/v2"
+	fiberws "github.com/gofiber/websocket/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer serves a fresh service on a loopback port
+func startServer(t *testing.T) (*pubsub.ServiceImpl, string) {
+	t.Helper()
+	service := pubsub.NewService(100, 100)
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/ws", fiberws.New(func(c *fiberws.Conn) {
+		service.HandleWebSocket(context.Background(), c)
+	}, fiberws.Config{Subprotocols: codec.Subprotocols}))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go app.Listener(ln)
+	t.Cleanup(func() { app.Shutdown() })
+	return service, ln.Addr().String()
+}
+
+// proxy forwards TCP connections to a server and can cut them all at once
+type proxy struct {
+	ln     net.Listener
+	target string
+
+	mu      sync.Mutex
+	conns   []net.Conn
+	refused bool
+}
+
+func startProxy(t *testing.T, target string) *proxy {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	p := &proxy{ln: ln, target: target}
+	t.Cleanup(func() { ln.Close(); p.drop() })
+
+	go func() {
+		for {
+			down, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			p.mu.Lock()
+			refused := p.refused
+			p.mu.Unlock()
+			if refused {
+				down.Close()
+				continue
+			}
+			up, err := net.Dial("tcp", target)
+			if err != nil {
+				down.Close()
+				continue
+			}
+			p.mu.Lock()
+			p.conns = append(p.conns, down, up)
+			p.mu.Unlock()
+			go io.Copy(up, down)
+			go io.Copy(down, up)
+		}
+	}()
+	return p
+}
+
+func (p *proxy) url() string {
+	return "ws://" + p.ln.Addr().String() + "/ws"
+}
+
+// drop severs every connection made so far
+func (p *proxy) drop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.conns {
+		c.Close()
+	}
+	p.conns = nil
+}
+
+// refuse makes the proxy hang up on new connections until called with false
+func (p *proxy) refuse(refused bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refused = refused
+}
+
+// collector records the messages a handler receives
+type collector struct {
+	mu   sync.Mutex
+	msgs []sdk.Message
+}
+
+func (c *collector) handle(msg sdk.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.msgs = append(c.msgs, msg)
+}
+
+func (c *collector) ids() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ids := make([]string, len(c.msgs))
+	for i, msg := range c.msgs {
+		ids[i] = msg.ID
+	}
+	return ids
+}
+
+func TestPublishAndSubscribe(t *testing.T) {
+	for _, subprotocol := range codec.Subprotocols {
+		t.Run(subprotocol, func(t *testing.T) {
+			service, addr := startServer(t)
+			require.NoError(t, service.AddTopic("orders"))
+			_, err := service.Publish("orders", sdk.Message{ID: "retained", Payload: "old"})
+			require.NoError(t, err)
+
+			ctx := context.Background()
+			c, err := client.Connect(ctx, "ws://"+addr+"/ws", client.Options{ClientID: "svc-a", Subprotocol: subprotocol})
+			require.NoError(t, err)
+			defer c.Close()
+
+			var got collector
+			sub, err := c.Subscribe(ctx, "orders", got.handle, client.SubscribeOptions{LastN: 1})
+			require.NoError(t, err)
+
+			require.NoError(t, c.Publish(ctx, "orders", sdk.Message{ID: "m1", Payload: map[string]interface{}{"n": 1.0}}))
+			require.NoError(t, c.Publish(ctx, "orders", sdk.Message{Payload: "no id"}))
+			assert.Eventually(t, func() bool { return len(got.ids()) == 3 }, 2*time.Second, 10*time.Millisecond)
+			ids := got.ids()
+			assert.Equal(t, []string{"retained", "m1"}, ids[:2])
+			assert.NotEmpty(t, ids[2])
+			assert.Equal(t, uint64(3), sub.LastSeq())
+
+			require.NoError(t, service.AddTopic("inventory"))
+			results, err := c.PublishTx(ctx, []sdk.TopicMessage{
+				{Topic: "orders", Message: sdk.Message{ID: "tx-order"}},
+				{Topic: "inventory", Message: sdk.Message{ID: "tx-stock"}},
+			})
+			require.NoError(t, err)
+			require.Len(t, results, 2)
+			assert.Equal(t, uint64(4), results[0].Seq)
+			assert.Eventually(t, func() bool { return len(got.ids()) == 4 }, 2*time.Second, 10*time.Millisecond)
+
+			// Errors come back to the request that caused them
+			err = c.Publish(ctx, "missing", sdk.Message{ID: "x"})
+			var serverErr *client.Error
+			require.ErrorAs(t, err, &serverErr)
+			assert.Equal(t, sdk.ErrorCodeTopicNotFound, serverErr.Code)
+			_, err = c.Subscribe(ctx, "orders", got.handle, client.SubscribeOptions{})
+			assert.ErrorIs(t, err, client.ErrAlreadySubscribed)
+
+			require.NoError(t, sub.Unsubscribe(ctx))
+			assert.Eventually(t, func() bool { return service.SubscriberCount("orders") == 0 }, time.Second, 10*time.Millisecond)
+			require.NoError(t, c.Publish(ctx, "orders", sdk.Message{ID: "after"}))
+			time.Sleep(50 * time.Millisecond)
+			assert.Len(t, got.ids(), 4)
+
+			require.NoError(t, c.Close())
+			assert.ErrorIs(t, c.Publish(ctx, "orders", sdk.Message{ID: "closed"}), client.ErrClosed)
+		})
+	}
+}
+
+func TestReconnectResumesSubscriptions(t *testing.T) {
+	service, addr := startServer(t)
+	require.NoError(t, service.AddTopic("orders"))
+	p := startProxy(t, addr)
+
+	var (
+		errsMu sync.Mutex
+		errs   []error
+	)
+	ctx := context.Background()
+	c, err := client.Connect(ctx, p.url(), client.Options{
+		ClientID:   "svc-b",
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 50 * time.Millisecond,
+		OnError: func(err error) {
+			errsMu.Lock()
+			errs = append(errs, err)
+			errsMu.Unlock()
+		},
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	var got collector
+	sub, err := c.Subscribe(ctx, "orders", got.handle, client.SubscribeOptions{})
+	require.NoError(t, err)
+	require.NoError(t, c.Publish(ctx, "orders", sdk.Message{ID: "m1"}))
+	assert.Eventually(t, func() bool { return len(got.ids()) == 1 }, 2*time.Second, 10*time.Millisecond)
+
+	// Messages published while the client is cut off are replayed once it
+	// is back, exactly once and in order
+	p.refuse(true)
+	p.drop()
+	assert.Eventually(t, func() bool { return service.SubscriberCount("orders") == 0 }, 2*time.Second, 10*time.Millisecond)
+	for _, id := range []string{"m2", "m3"} {
+		_, err := service.Publish("orders", sdk.Message{ID: id})
+		require.NoError(t, err)
+	}
+	p.refuse(false)
+
+	// Requests made while reconnecting wait for the new connection
+	require.NoError(t, c.Publish(ctx, "orders", sdk.Message{ID: "m4"}))
+	assert.Eventually(t, func() bool { return len(got.ids()) == 4 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"m1", "m2", "m3", "m4"}, got.ids())
+	assert.Equal(t, uint64(4), sub.LastSeq())
+
+	// A subscription whose topic is gone is dropped and reported
+	require.NoError(t, service.RemoveTopicLocal("orders"))
+	p.drop()
+	assert.Eventually(t, func() bool {
+		errsMu.Lock()
+		defer errsMu.Unlock()
+		for _, err := range errs {
+			var serverErr *client.Error
+			if errors.As(err, &serverErr) && serverErr.Code == sdk.ErrorCodeTopicNotFound {
+				return true
+			}
+		}
+		return false
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestExclusiveStandbyTakesOver(t *testing.T) {
+	service, addr := startServer(t)
+	require.NoError(t, service.AddTopic("ledger"))
+	ctx := context.Background()
+	connect := func(clientID string) *client.Client {
+		c, err := client.Connect(ctx, "ws://"+addr+"/ws", client.Options{ClientID: clientID})
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+
+	first, second := connect("writer-a"), connect("writer-b")
+	var gotA, gotB collector
+	subA, err := first.Subscribe(ctx, "ledger", gotA.handle, client.SubscribeOptions{Exclusive: true})
+	require.NoError(t, err)
+	_, err = second.Subscribe(ctx, "ledger", gotB.handle, client.SubscribeOptions{Exclusive: true})
+	require.NoError(t, err)
+
+	for _, id := range []string{"m1", "m2", "m3"} {
+		require.NoError(t, first.Publish(ctx, "ledger", sdk.Message{ID: id}))
+	}
+	assert.Eventually(t, func() bool { return len(gotA.ids()) == 3 }, 2*time.Second, 10*time.Millisecond)
+	require.NoError(t, subA.Ack(ctx, 2))
+	assert.Eventually(t, func() bool {
+		detail, err := service.Describe("ledger")
+		return err == nil && detail.Exclusive.AckedSeq == 2
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Empty(t, gotB.ids())
+
+	// The unacknowledged message is redelivered to the standby
+	require.NoError(t, first.Close())
+	assert.Eventually(t, func() bool { return len(gotB.ids()) == 1 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"m3"}, gotB.ids())
+}
+
+func TestInboxDelivery(t *testing.T) {
+	service, addr := startServer(t)
+	service.InboxTTL = time.Minute
+	service.InboxSize = 10
+	ctx := context.Background()
+
+	sender, err := client.Connect(ctx, "ws://"+addr+"/ws", client.Options{ClientID: "sender"})
+	require.NoError(t, err)
+	defer sender.Close()
+	require.NoError(t, sender.Publish(ctx, sdk.InboxTopic("worker"), sdk.Message{ID: "while-offline"}))
+
+	worker, err := client.Connect(ctx, "ws://"+addr+"/ws", client.Options{ClientID: "worker"})
+	require.NoError(t, err)
+	defer worker.Close()
+	var got collector
+	_, err = worker.SubscribeInbox(ctx, got.handle)
+	require.NoError(t, err)
+	require.NoError(t, sender.Publish(ctx, sdk.InboxTopic("worker"), sdk.Message{ID: "live"}))
+	assert.Eventually(t, func() bool { return len(got.ids()) == 2 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"while-offline", "live"}, got.ids())
+
+	// Nobody else may read the worker's inbox
+	_, err = sender.Subscribe(ctx, sdk.InboxTopic("worker"), got.handle, client.SubscribeOptions{})
+	var serverErr *client.Error
+	require.ErrorAs(t, err, &serverErr)
+	assert.Equal(t, sdk.ErrorCodeUnauthorized, serverErr.Code)
+}
+
+func TestConnectFailsWhenServerIsDown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	_, err = client.Connect(context.Background(), "ws://"+addr+"/ws", client.Options{})
+	assert.Error(t, err)
+}
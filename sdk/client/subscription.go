@@ -0,0 +1,242 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+)
+
+// Handler is called with each message of a subscription, one at a time and
+// in sequence order. It may publish or subscribe through the same client.
+type Handler func(sdk.Message)
+
+// SubscribeOptions configures a subscription. Replay and batching settings
+// apply to the first subscribe; after a reconnect the subscription resumes
+// after the last message it received instead, falling back to the current
+// state when State is set and the server no longer has every message since.
+type SubscribeOptions struct {
+	LastN     int           // retained messages to replay first
+	State     bool          // start from the current value of every key of a compacted topic
+	Exclusive bool          // only one client id is active at a time; the others wait as standbys
+	BatchSize int           // receive events in batches of up to this many messages
+	BatchWait time.Duration // longest the server holds back a partial batch
+}
+
+// Subscription is a client's interest in one topic. Messages are queued as
+// they arrive and handed to the handler from a goroutine of their own, so a
+// slow handler never holds up replies to other requests.
+type Subscription struct {
+	client  *Client
+	topic   string
+	handler Handler
+	opts    SubscribeOptions
+
+	mu      sync.Mutex
+	lastSeq uint64        // position to resume after
+	seen    bool          // an event arrived since the last (re)subscribe
+	queue   []sdk.Message // received but not yet handled
+	wake    chan struct{}
+	active  bool // acknowledged, so restored after a reconnect
+
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+// Subscribe starts delivering the topic's messages to handler and returns
+// once the server has acknowledged the subscription
+func (c *Client) Subscribe(ctx context.Context, topic string, handler Handler, opts SubscribeOptions) (*Subscription, error) {
+	sub := &Subscription{
+		client:  c,
+		topic:   topic,
+		handler: handler,
+		opts:    opts,
+		wake:    make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+	}
+
+	// Registered before the request goes out, because the replay can reach
+	// the client ahead of the ack
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if _, exists := c.subs[topic]; exists {
+		c.mu.Unlock()
+		return nil, ErrAlreadySubscribed
+	}
+	c.subs[topic] = sub
+	c.mu.Unlock()
+	go sub.dispatch()
+
+	resp, err := c.request(ctx, sub.request(false))
+	if err != nil {
+		c.forget(sub)
+		sub.stop()
+		return nil, err
+	}
+	sub.started(resp.Seq)
+	return sub, nil
+}
+
+// SubscribeInbox starts delivering the messages published to the client's
+// own inbox, sdk.InboxTopic(ClientID()). Messages sent while the client was
+// offline are delivered first, if the server still holds them.
+func (c *Client) SubscribeInbox(ctx context.Context, handler Handler) (*Subscription, error) {
+	return c.Subscribe(ctx, sdk.InboxTopic(c.opts.ClientID), handler, SubscribeOptions{})
+}
+
+// Topic returns the subscribed topic
+func (s *Subscription) Topic() string {
+	return s.topic
+}
+
+// LastSeq returns the sequence number the subscription resumes after on
+// reconnect: that of the last message received, or where the subscription
+// started when none has been
+func (s *Subscription) LastSeq() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastSeq
+}
+
+// Unsubscribe stops the subscription. Messages already received but not yet
+// handled are dropped.
+func (s *Subscription) Unsubscribe(ctx context.Context) error {
+	s.client.forget(s)
+	s.stop()
+
+	ctx, cancel := s.client.withDeadline(ctx)
+	defer cancel()
+	s.client.mu.Lock()
+	cn := s.client.conn
+	s.client.mu.Unlock()
+	if cn == nil {
+		// Not connected, so nothing to undo; it will not be restored
+		return nil
+	}
+	_, err := s.client.send(ctx, cn, sdk.WebSocketRequest{
+		Type:     sdk.MessageTypeUnsubscribe,
+		Topic:    s.topic,
+		ClientID: s.client.opts.ClientID,
+	})
+	return err
+}
+
+// Ack tells the server an exclusive subscription has handled every message
+// up to seq, so a standby taking over resumes after it. It does not wait
+// for the server; an ack the server refuses is dropped.
+func (s *Subscription) Ack(ctx context.Context, seq uint64) error {
+	s.client.mu.Lock()
+	closed, cn := s.client.closed, s.client.conn
+	s.client.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	if cn == nil {
+		return ErrConnectionLost
+	}
+
+	ctx, cancel := s.client.withDeadline(ctx)
+	defer cancel()
+	return cn.write(ctx, sdk.WebSocketRequest{
+		Type:     sdk.MessageTypeAck,
+		Topic:    s.topic,
+		ClientID: s.client.opts.ClientID,
+		Seq:      seq,
+	})
+}
+
+// request builds the subscribe frame, resuming after lastSeq when resume is
+// set
+func (s *Subscription) request(resume bool) sdk.WebSocketRequest {
+	req := sdk.WebSocketRequest{
+		Type:     sdk.MessageTypeSubscribe,
+		Topic:    s.topic,
+		ClientID: s.client.opts.ClientID,
+	}
+	if s.opts.BatchSize > 1 {
+		req.BatchSize = s.opts.BatchSize
+		req.BatchWaitMS = int(s.opts.BatchWait / time.Millisecond)
+	}
+	req.State = s.opts.State
+	req.Exclusive = s.opts.Exclusive
+	if !resume {
+		req.LastN = s.opts.LastN
+		return req
+	}
+
+	s.mu.Lock()
+	after := s.lastSeq
+	s.seen = false
+	s.mu.Unlock()
+	req.AfterSeq = &after
+	return req
+}
+
+// started records where the server started the subscription, unless events
+// received before the ack already moved past it
+func (s *Subscription) started(seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.seen || seq > s.lastSeq {
+		s.lastSeq = seq
+	}
+	s.active = true
+}
+
+// restorable reports whether a reconnect should resubscribe; one still
+// waiting for its first ack is sent by Subscribe itself
+func (s *Subscription) restorable() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active
+}
+
+// deliver queues msg for the handler
+func (s *Subscription) deliver(msg sdk.Message) {
+	s.mu.Lock()
+	s.queue = append(s.queue, msg)
+	if msg.Seq > s.lastSeq {
+		s.lastSeq = msg.Seq
+	}
+	s.seen = true
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch hands queued messages to the handler until the subscription stops
+func (s *Subscription) dispatch() {
+	for {
+		select {
+		case <-s.wake:
+		case <-s.stopped:
+			return
+		}
+
+		s.mu.Lock()
+		msgs := s.queue
+		s.queue = nil
+		s.mu.Unlock()
+
+		for _, msg := range msgs {
+			select {
+			case <-s.stopped:
+				return
+			default:
+			}
+			s.handler(msg)
+		}
+	}
+}
+
+// stop ends dispatch; a handler already running is left to finish
+func (s *Subscription) stop() {
+	s.stopOnce.Do(func() { close(s.stopped) })
+}
@@ -0,0 +1,68 @@
+// Package codec encodes the WebSocket protocol frames. JSON is the default;
+// clients may negotiate msgpack or protobuf through the
+// Sec-WebSocket-Protocol header. Every codec carries the same
+// sdk.WebSocketRequest and sdk.WebSocketResponse shapes.
+package codec
+
+import (
+	"encoding/json"
+
+	"github.com/Aryaman/pub-sub/sdk"
+)
+
+// Subprotocol names accepted in Sec-WebSocket-Protocol
+const (
+	JSON     = "json"
+	MsgPack  = "msgpack"
+	Protobuf = "protobuf"
+)
+
+// Subprotocols lists every negotiable encoding in server preference order
+var Subprotocols = []string{MsgPack, Protobuf, JSON}
+
+// Codec converts protocol frames to and from their wire form
+type Codec interface {
+	// Name is the subprotocol the codec answers to
+	Name() string
+	// Binary reports whether frames go in binary rather than text messages
+	Binary() bool
+
+	EncodeRequest(req sdk.WebSocketRequest) ([]byte, error)
+	DecodeRequest(data []byte, req *sdk.WebSocketRequest) error
+	EncodeResponse(resp sdk.WebSocketResponse) ([]byte, error)
+	DecodeResponse(data []byte, resp *sdk.WebSocketResponse) error
+}
+
+// ForSubprotocol returns the codec for a negotiated subprotocol, falling back
+// to JSON when none was negotiated
+func ForSubprotocol(name string) Codec {
+	switch name {
+	case MsgPack:
+		return msgpackCodec{}
+	case Protobuf:
+		return protobufCodec{}
+	default:
+		return jsonCodec{}
+	}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return JSON }
+func (jsonCodec) Binary() bool { return false }
+
+func (jsonCodec) EncodeRequest(req sdk.WebSocketRequest) ([]byte, error) {
+	return json.Marshal(req)
+}
+
+func (jsonCodec) DecodeRequest(data []byte, req *sdk.WebSocketRequest) error {
+	return json.Unmarshal(data, req)
+}
+
+func (jsonCodec) EncodeResponse(resp sdk.WebSocketResponse) ([]byte, error) {
+	return json.Marshal(resp)
+}
+
+func (jsonCodec) DecodeResponse(data []byte, resp *sdk.WebSocketResponse) error {
+	return json.Unmarshal(data, resp)
+}
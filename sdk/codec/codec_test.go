@@ -0,0 +1,197 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/Aryaman/pub-sub/sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sampleEvent() sdk.WebSocketResponse {
+	return sdk.WebSocketResponse{
+		Type:      sdk.MessageTypeEvent,
+		Topic:     "orders",
+		Timestamp: "2025-01-01T00:00:00Z",
+		Message: &sdk.Message{
+			ID:  "2f1c9a52-6a3e-4bb1-9d0c-5f0a3c1e7b10",
+			Seq: 42,
+			Payload: map[string]interface{}{
+				"order_id": "ORD-123",
+				"amount":   99.5,
+				"currency": "USD",
+				"items":    []interface{}{"book", "pen"},
+				"express":  true,
+			},
+		},
+	}
+}
+
+func TestRoundTripPreservesShapes(t *testing.T) {
+	for _, name := range Subprotocols {
+		t.Run(name, func(t *testing.T) {
+			wire := ForSubprotocol(name)
+			assert.Equal(t, name, wire.Name())
+
+			req := sdk.WebSocketRequest{
+				Type:      sdk.MessageTypePublish,
+				Topic:     "orders",
+				ClientID:  "c1",
+				LastN:     5,
+				RequestID: "r1",
+				Credit:    10,
+				Message:   &sdk.Message{ID: "m1", Payload: "hello", Attributes: map[string]string{"region": "eu"}},
+			}
+			data, err := wire.EncodeRequest(req)
+			require.NoError(t, err)
+			var gotReq sdk.WebSocketRequest
+			require.NoError(t, wire.DecodeRequest(data, &gotReq))
+			assert.Equal(t, req, gotReq)
+
+			resp := sampleEvent()
+			data, err = wire.EncodeResponse(resp)
+			require.NoError(t, err)
+			var gotResp sdk.WebSocketResponse
+			require.NoError(t, wire.DecodeResponse(data, &gotResp))
+			assert.Equal(t, resp, gotResp)
+
+			batch := sdk.WebSocketRequest{
+				Type:        sdk.MessageTypePublishBatch,
+				Topic:       "orders",
+				Messages:    []sdk.Message{{ID: "m1", Payload: "a"}, {ID: "m2", Payload: true}},
+				BatchSize:   50,
+				BatchWaitMS: 20,
+			}
+			data, err = wire.EncodeRequest(batch)
+			require.NoError(t, err)
+			gotReq = sdk.WebSocketRequest{}
+			require.NoError(t, wire.DecodeRequest(data, &gotReq))
+			assert.Equal(t, batch, gotReq)
+
+			batchAck := sdk.WebSocketResponse{
+				Type:     sdk.MessageTypeAck,
+				Messages: []sdk.Message{{ID: "m1", Payload: "a", Seq: 1}},
+				Results: []sdk.PublishResult{
+					{ID: "m1", Seq: 1, Status: sdk.StatusOK},
+					{ID: "", Status: sdk.StatusError, Error: &sdk.ErrorDetail{Code: sdk.ErrorCodeBadRequest, Message: "id required"}},
+				},
+			}
+			data, err = wire.EncodeResponse(batchAck)
+			require.NoError(t, err)
+			gotResp = sdk.WebSocketResponse{}
+			require.NoError(t, wire.DecodeResponse(data, &gotResp))
+			assert.Equal(t, batchAck, gotResp)
+
+			// after_seq 0 must survive as present, not be dropped as unset
+			fromStart := uint64(0)
+			resume := sdk.WebSocketRequest{Type: sdk.MessageTypeSubscribe, Topic: "orders", ClientID: "c1", AfterSeq: &fromStart, State: true, Exclusive: true}
+			data, err = wire.EncodeRequest(resume)
+			require.NoError(t, err)
+			gotReq = sdk.WebSocketRequest{}
+			require.NoError(t, wire.DecodeRequest(data, &gotReq))
+			assert.Equal(t, resume, gotReq)
+
+			progress := sdk.WebSocketRequest{Type: sdk.MessageTypeAck, Topic: "orders", ClientID: "c1", Seq: 42}
+			data, err = wire.EncodeRequest(progress)
+			require.NoError(t, err)
+			gotReq = sdk.WebSocketRequest{}
+			require.NoError(t, wire.DecodeRequest(data, &gotReq))
+			assert.Equal(t, progress, gotReq)
+
+			tx := sdk.WebSocketRequest{
+				Type:      sdk.MessageTypePublishTx,
+				RequestID: "r2",
+				Publishes: []sdk.TopicMessage{
+					{Topic: "order.created", Message: sdk.Message{ID: "o1", Payload: map[string]interface{}{"total": 12.5}}},
+					{Topic: "inventory.reserved", Message: sdk.Message{ID: "i1", Key: "sku-1", Payload: "sku-1", Attributes: map[string]string{"order": "o1"}}},
+				},
+			}
+			data, err = wire.EncodeRequest(tx)
+			require.NoError(t, err)
+			gotReq = sdk.WebSocketRequest{}
+			require.NoError(t, wire.DecodeRequest(data, &gotReq))
+			assert.Equal(t, tx, gotReq)
+
+			subscribeAck := sdk.WebSocketResponse{Type: sdk.MessageTypeAck, Topic: "orders", Status: sdk.StatusOK, Seq: 42}
+			data, err = wire.EncodeResponse(subscribeAck)
+			require.NoError(t, err)
+			gotResp = sdk.WebSocketResponse{}
+			require.NoError(t, wire.DecodeResponse(data, &gotResp))
+			assert.Equal(t, subscribeAck, gotResp)
+
+			errResp := sdk.WebSocketResponse{
+				Type:  sdk.MessageTypeError,
+				Error: &sdk.ErrorDetail{Code: sdk.ErrorCodeRateLimited, Message: "slow down", RetryAfterMS: 250},
+			}
+			data, err = wire.EncodeResponse(errResp)
+			require.NoError(t, err)
+			gotResp = sdk.WebSocketResponse{}
+			require.NoError(t, wire.DecodeResponse(data, &gotResp))
+			assert.Equal(t, errResp, gotResp)
+		})
+	}
+}
+
+func TestUnknownSubprotocolFallsBackToJSON(t *testing.T) {
+	assert.Equal(t, JSON, ForSubprotocol("").Name())
+	assert.Equal(t, JSON, ForSubprotocol("xml").Name())
+}
+
+func TestProtobufAcceptsTypedPayloads(t *testing.T) {
+	type order struct {
+		ID    string `json:"id"`
+		Count int    `json:"count"`
+	}
+	wire := ForSubprotocol(Protobuf)
+	data, err := wire.EncodeResponse(sdk.WebSocketResponse{
+		Type:    sdk.MessageTypeEvent,
+		Message: &sdk.Message{ID: "m1", Payload: order{ID: "o1", Count: 2}},
+	})
+	require.NoError(t, err)
+
+	var resp sdk.WebSocketResponse
+	require.NoError(t, wire.DecodeResponse(data, &resp))
+	assert.Equal(t, map[string]interface{}{"id": "o1", "count": float64(2)}, resp.Message.Payload)
+}
+
+// BenchmarkEncodeEvent measures server-side cost per delivered event
+func BenchmarkEncodeEvent(b *testing.B) {
+	event := sampleEvent()
+	for _, name := range Subprotocols {
+		b.Run(name, func(b *testing.B) {
+			wire := ForSubprotocol(name)
+			data, _ := wire.EncodeResponse(event)
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := wire.EncodeResponse(event); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkRoundTrip measures encode plus decode, the full cost a frame
+// pays between server and client
+func BenchmarkRoundTrip(b *testing.B) {
+	event := sampleEvent()
+	for _, name := range Subprotocols {
+		b.Run(name, func(b *testing.B) {
+			wire := ForSubprotocol(name)
+			data, _ := wire.EncodeResponse(event)
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				data, err := wire.EncodeResponse(event)
+				if err != nil {
+					b.Fatal(err)
+				}
+				var resp sdk.WebSocketResponse
+				if err := wire.DecodeResponse(data, &resp); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
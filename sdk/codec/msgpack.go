@@ -0,0 +1,50 @@
+package codec
+
+import (
+	"bytes"
+
+	"github.com/Aryaman/pub-sub/sdk"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// msgpackCodec reuses the json struct tags so field names and omitempty
+// behaviour match the JSON protocol exactly
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return MsgPack }
+func (msgpackCodec) Binary() bool { return true }
+
+func (msgpackCodec) EncodeRequest(req sdk.WebSocketRequest) ([]byte, error) {
+	return msgpackMarshal(req)
+}
+
+func (msgpackCodec) DecodeRequest(data []byte, req *sdk.WebSocketRequest) error {
+	return msgpackUnmarshal(data, req)
+}
+
+func (msgpackCodec) EncodeResponse(resp sdk.WebSocketResponse) ([]byte, error) {
+	return msgpackMarshal(resp)
+}
+
+func (msgpackCodec) DecodeResponse(data []byte, resp *sdk.WebSocketResponse) error {
+	return msgpackUnmarshal(data, resp)
+}
+
+func msgpackMarshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func msgpackUnmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	// Widen numbers to int64, uint64 or float64 instead of the smallest
+	// type that fits, so payloads stay easy to handle server side
+	dec.UseLooseInterfaceDecoding(true)
+	return dec.Decode(v)
+}
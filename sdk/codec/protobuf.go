@@ -0,0 +1,472 @@
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Aryaman/pub-sub/sdk"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// protobufCodec implements pubsub.proto directly on protowire, which keeps
+// protoc out of the build. Payloads travel as google.protobuf.Value.
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string { return Protobuf }
+func (protobufCodec) Binary() bool { return true }
+
+var errProtoMalformed = errors.New("malformed protobuf frame")
+
+// Field numbers from pubsub.proto
+const (
+	messageID         protowire.Number = 1
+	messagePayload    protowire.Number = 2
+	messageSeq        protowire.Number = 3
+	messageAttributes protowire.Number = 4
+	messageKey        protowire.Number = 5
+
+	// map entries are messages with the key and value as fields 1 and 2
+	entryKey   protowire.Number = 1
+	entryValue protowire.Number = 2
+
+	errorCode       protowire.Number = 1
+	errorMessage    protowire.Number = 2
+	errorRetryAfter protowire.Number = 3
+
+	requestType      protowire.Number = 1
+	requestTopic     protowire.Number = 2
+	requestMessage   protowire.Number = 3
+	requestClientID  protowire.Number = 4
+	requestLastN     protowire.Number = 5
+	requestRequestID protowire.Number = 6
+	requestCredit    protowire.Number = 7
+	requestMessages  protowire.Number = 8
+	requestBatchSize protowire.Number = 9
+	requestBatchWait protowire.Number = 10
+	requestAfterSeq  protowire.Number = 11
+	requestPublishes protowire.Number = 12
+	requestState     protowire.Number = 13
+	requestExclusive protowire.Number = 14
+	requestSeq       protowire.Number = 15
+
+	responseType      protowire.Number = 1
+	responseRequestID protowire.Number = 2
+	responseTopic     protowire.Number = 3
+	responseMessage   protowire.Number = 4
+	responseStatus    protowire.Number = 5
+	responseError     protowire.Number = 6
+	responseTS        protowire.Number = 7
+	responseMsg       protowire.Number = 8
+	responseMessages  protowire.Number = 9
+	responseResults   protowire.Number = 10
+	responseSeq       protowire.Number = 11
+
+	topicMessageTopic   protowire.Number = 1
+	topicMessageMessage protowire.Number = 2
+
+	resultID     protowire.Number = 1
+	resultSeq    protowire.Number = 2
+	resultStatus protowire.Number = 3
+	resultError  protowire.Number = 4
+)
+
+func (protobufCodec) EncodeRequest(req sdk.WebSocketRequest) ([]byte, error) {
+	var b []byte
+	b = appendString(b, requestType, req.Type)
+	b = appendString(b, requestTopic, req.Topic)
+	if req.Message != nil {
+		msg, err := encodeMessage(*req.Message)
+		if err != nil {
+			return nil, err
+		}
+		b = appendBytes(b, requestMessage, msg)
+	}
+	b = appendString(b, requestClientID, req.ClientID)
+	b = appendInt(b, requestLastN, req.LastN)
+	b = appendString(b, requestRequestID, req.RequestID)
+	b = appendInt(b, requestCredit, req.Credit)
+	b, err := appendMessages(b, requestMessages, req.Messages)
+	if err != nil {
+		return nil, err
+	}
+	b = appendInt(b, requestBatchSize, req.BatchSize)
+	b = appendInt(b, requestBatchWait, req.BatchWaitMS)
+	if req.AfterSeq != nil {
+		// Explicit presence: after_seq 0 resumes from the start
+		b = protowire.AppendTag(b, requestAfterSeq, protowire.VarintType)
+		b = protowire.AppendVarint(b, *req.AfterSeq)
+	}
+	for _, entry := range req.Publishes {
+		msg, err := encodeMessage(entry.Message)
+		if err != nil {
+			return nil, err
+		}
+		var e []byte
+		e = appendString(e, topicMessageTopic, entry.Topic)
+		e = appendBytes(e, topicMessageMessage, msg)
+		b = appendBytes(b, requestPublishes, e)
+	}
+	if req.State {
+		b = protowire.AppendTag(b, requestState, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	if req.Exclusive {
+		b = protowire.AppendTag(b, requestExclusive, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	if req.Seq != 0 {
+		b = protowire.AppendTag(b, requestSeq, protowire.VarintType)
+		b = protowire.AppendVarint(b, req.Seq)
+	}
+	return b, nil
+}
+
+func (protobufCodec) DecodeRequest(data []byte, req *sdk.WebSocketRequest) error {
+	return walkFields(data, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch num {
+		case requestType:
+			req.Type = string(v)
+		case requestTopic:
+			req.Topic = string(v)
+		case requestMessage:
+			msg, err := decodeMessage(v)
+			if err != nil {
+				return err
+			}
+			req.Message = &msg
+		case requestClientID:
+			req.ClientID = string(v)
+		case requestLastN:
+			req.LastN = int(int64(n))
+		case requestRequestID:
+			req.RequestID = string(v)
+		case requestCredit:
+			req.Credit = int(int64(n))
+		case requestMessages:
+			msg, err := decodeMessage(v)
+			if err != nil {
+				return err
+			}
+			req.Messages = append(req.Messages, msg)
+		case requestBatchSize:
+			req.BatchSize = int(int64(n))
+		case requestBatchWait:
+			req.BatchWaitMS = int(int64(n))
+		case requestAfterSeq:
+			seq := n
+			req.AfterSeq = &seq
+		case requestPublishes:
+			var entry sdk.TopicMessage
+			err := walkFields(v, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+				switch num {
+				case topicMessageTopic:
+					entry.Topic = string(v)
+				case topicMessageMessage:
+					msg, err := decodeMessage(v)
+					if err != nil {
+						return err
+					}
+					entry.Message = msg
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			req.Publishes = append(req.Publishes, entry)
+		case requestState:
+			req.State = n != 0
+		case requestExclusive:
+			req.Exclusive = n != 0
+		case requestSeq:
+			req.Seq = n
+		}
+		return nil
+	})
+}
+
+func (protobufCodec) EncodeResponse(resp sdk.WebSocketResponse) ([]byte, error) {
+	var b []byte
+	b = appendString(b, responseType, resp.Type)
+	b = appendString(b, responseRequestID, resp.RequestID)
+	b = appendString(b, responseTopic, resp.Topic)
+	if resp.Message != nil {
+		msg, err := encodeMessage(*resp.Message)
+		if err != nil {
+			return nil, err
+		}
+		b = appendBytes(b, responseMessage, msg)
+	}
+	b = appendString(b, responseStatus, resp.Status)
+	b = appendErrorDetail(b, responseError, resp.Error)
+	b = appendString(b, responseTS, resp.Timestamp)
+	b = appendString(b, responseMsg, resp.Msg)
+	b, err := appendMessages(b, responseMessages, resp.Messages)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range resp.Results {
+		var r []byte
+		r = appendString(r, resultID, result.ID)
+		if result.Seq != 0 {
+			r = protowire.AppendTag(r, resultSeq, protowire.VarintType)
+			r = protowire.AppendVarint(r, result.Seq)
+		}
+		r = appendString(r, resultStatus, result.Status)
+		r = appendErrorDetail(r, resultError, result.Error)
+		b = appendBytes(b, responseResults, r)
+	}
+	if resp.Seq != 0 {
+		b = protowire.AppendTag(b, responseSeq, protowire.VarintType)
+		b = protowire.AppendVarint(b, resp.Seq)
+	}
+	return b, nil
+}
+
+func (protobufCodec) DecodeResponse(data []byte, resp *sdk.WebSocketResponse) error {
+	return walkFields(data, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch num {
+		case responseType:
+			resp.Type = string(v)
+		case responseRequestID:
+			resp.RequestID = string(v)
+		case responseTopic:
+			resp.Topic = string(v)
+		case responseMessage:
+			msg, err := decodeMessage(v)
+			if err != nil {
+				return err
+			}
+			resp.Message = &msg
+		case responseStatus:
+			resp.Status = string(v)
+		case responseError:
+			detail, err := decodeErrorDetail(v)
+			if err != nil {
+				return err
+			}
+			resp.Error = detail
+		case responseTS:
+			resp.Timestamp = string(v)
+		case responseMsg:
+			resp.Msg = string(v)
+		case responseMessages:
+			msg, err := decodeMessage(v)
+			if err != nil {
+				return err
+			}
+			resp.Messages = append(resp.Messages, msg)
+		case responseResults:
+			var result sdk.PublishResult
+			err := walkFields(v, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+				switch num {
+				case resultID:
+					result.ID = string(v)
+				case resultSeq:
+					result.Seq = n
+				case resultStatus:
+					result.Status = string(v)
+				case resultError:
+					detail, err := decodeErrorDetail(v)
+					if err != nil {
+						return err
+					}
+					result.Error = detail
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			resp.Results = append(resp.Results, result)
+		case responseSeq:
+			resp.Seq = n
+		}
+		return nil
+	})
+}
+
+func encodeMessage(msg sdk.Message) ([]byte, error) {
+	var b []byte
+	b = appendString(b, messageID, msg.ID)
+	if msg.Payload != nil {
+		payload, err := payloadValue(msg.Payload)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := proto.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		b = appendBytes(b, messagePayload, raw)
+	}
+	if msg.Seq != 0 {
+		b = protowire.AppendTag(b, messageSeq, protowire.VarintType)
+		b = protowire.AppendVarint(b, msg.Seq)
+	}
+	for key, value := range msg.Attributes {
+		var entry []byte
+		entry = appendString(entry, entryKey, key)
+		entry = appendString(entry, entryValue, value)
+		b = appendBytes(b, messageAttributes, entry)
+	}
+	b = appendString(b, messageKey, msg.Key)
+	return b, nil
+}
+
+func decodeMessage(data []byte) (sdk.Message, error) {
+	var msg sdk.Message
+	err := walkFields(data, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch num {
+		case messageID:
+			msg.ID = string(v)
+		case messagePayload:
+			var payload structpb.Value
+			if err := proto.Unmarshal(v, &payload); err != nil {
+				return err
+			}
+			msg.Payload = payload.AsInterface()
+		case messageSeq:
+			msg.Seq = n
+		case messageAttributes:
+			var key, value string
+			err := walkFields(v, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+				switch num {
+				case entryKey:
+					key = string(v)
+				case entryValue:
+					value = string(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if msg.Attributes == nil {
+				msg.Attributes = make(map[string]string)
+			}
+			msg.Attributes[key] = value
+		case messageKey:
+			msg.Key = string(v)
+		}
+		return nil
+	})
+	return msg, err
+}
+
+func appendMessages(b []byte, num protowire.Number, msgs []sdk.Message) ([]byte, error) {
+	for _, msg := range msgs {
+		encoded, err := encodeMessage(msg)
+		if err != nil {
+			return nil, err
+		}
+		b = appendBytes(b, num, encoded)
+	}
+	return b, nil
+}
+
+func appendErrorDetail(b []byte, num protowire.Number, detail *sdk.ErrorDetail) []byte {
+	if detail == nil {
+		return b
+	}
+	var e []byte
+	e = appendString(e, errorCode, detail.Code)
+	e = appendString(e, errorMessage, detail.Message)
+	e = appendInt(e, errorRetryAfter, int(detail.RetryAfterMS))
+	return appendBytes(b, num, e)
+}
+
+func decodeErrorDetail(data []byte) (*sdk.ErrorDetail, error) {
+	detail := &sdk.ErrorDetail{}
+	err := walkFields(data, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch num {
+		case errorCode:
+			detail.Code = string(v)
+		case errorMessage:
+			detail.Message = string(v)
+		case errorRetryAfter:
+			detail.RetryAfterMS = int64(n)
+		}
+		return nil
+	})
+	return detail, err
+}
+
+// payloadValue converts a payload to google.protobuf.Value. Values structpb
+// cannot take directly, such as typed structs or maps, go through JSON first.
+func payloadValue(payload interface{}) (*structpb.Value, error) {
+	if v, err := structpb.NewValue(payload); err == nil {
+		return v, nil
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("payload cannot be encoded: %w", err)
+	}
+	var generic interface{}
+	if err := json.Unmarshal(raw, &generic); err != nil {
+		return nil, err
+	}
+	return structpb.NewValue(generic)
+}
+
+// walkFields calls fn for every field in a message. Length-delimited fields
+// pass their bytes in v, varints their value in n; unknown fields are skipped.
+func walkFields(data []byte, fn func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error) error {
+	for len(data) > 0 {
+		num, typ, tagLen := protowire.ConsumeTag(data)
+		if tagLen < 0 {
+			return errProtoMalformed
+		}
+		data = data[tagLen:]
+
+		var (
+			v       []byte
+			n       uint64
+			usedLen int
+		)
+		switch typ {
+		case protowire.BytesType:
+			v, usedLen = protowire.ConsumeBytes(data)
+		case protowire.VarintType:
+			n, usedLen = protowire.ConsumeVarint(data)
+		default:
+			usedLen = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if usedLen < 0 {
+			return errProtoMalformed
+		}
+		data = data[usedLen:]
+
+		if err := fn(num, typ, v, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendString writes a string field, omitting it when empty as proto3 does
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// appendInt writes an int64 field, omitting it when zero as proto3 does
+func appendInt(b []byte, num protowire.Number, n int) []byte {
+	if n == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(int64(n)))
+}
+
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
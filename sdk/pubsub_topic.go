@@ -2,36 +2,44 @@ package sdk
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/gofiber/websocket/v2"
 )
 
 // Message represents a published message with server timestamp
 type Message struct {
-	ID      string      `json:"id"`
-	Payload interface{} `json:"payload"`
-	TS      time.Time   `json:"-"` // server timestamp, not serialized in message field
+	ID         string            `json:"id"`
+	Key        string            `json:"key,omitempty"` // compacted topics keep the newest message per key; a nil payload deletes the key
+	Payload    interface{}       `json:"payload"`
+	Attributes map[string]string `json:"attributes,omitempty"` // publisher-set metadata, not interpreted by the server
+	Seq        uint64            `json:"seq,omitempty"`        // per-topic sequence number assigned on publish
+	TS         time.Time         `json:"-"`                    // server timestamp, not serialized in message field
 }
 
 // Subscriber represents a client connection with buffered message queue
 type Subscriber struct {
-	Conn         *websocket.Conn
+	Conn         interface{} // connection owning the subscription, nil for frontends without one
 	ClientID     string
 	Queue        chan Message
 	QueueSize    int
 	LastActive   time.Time
 	CloseOnce    sync.Once
 	CloseChannel chan struct{}
-}
 
-// Topic holds subscribers and implements ring buffer for message replay
-type Topic struct {
-	Name        string
-	Subscribers map[string]*Subscriber // client_id -> Subscriber
-	Messages    []Message              // ring buffer for last_n replay
-	MaxMessages int
-	Mu          sync.RWMutex // exported field
+	// FlowControl marks a credit-mode subscriber. It is never evicted when
+	// its queue overflows; Lagging is set instead and the writer catches up
+	// from the topic's ring buffer.
+	FlowControl bool
+	Lagging     atomic.Bool
+	StartSeq    uint64 // sequence number the subscription starts after, set by Subscribe
+
+	// Evicted is set before an overflowing subscriber is closed, so its
+	// writer can tell the client why
+	Evicted atomic.Bool
+
+	// Held counts messages a batching writer has taken off Queue but not
+	// yet sent, so draining waits for them too
+	Held atomic.Int64
 }
 
 // TopicStats represents statistics for a single topic
@@ -44,37 +52,66 @@ type TopicStats struct {
 
 // WebSocketRequest represents incoming WebSocket messages from clients
 type WebSocketRequest struct {
-	Type      string   `json:"type"`
-	Topic     string   `json:"topic,omitempty"`
-	Message   *Message `json:"message,omitempty"`
-	ClientID  string   `json:"client_id,omitempty"`
-	LastN     int      `json:"last_n,omitempty"`
-	RequestID string   `json:"request_id,omitempty"`
+	Type        string    `json:"type"`
+	Topic       string    `json:"topic,omitempty"`
+	Message     *Message  `json:"message,omitempty"`
+	Messages    []Message `json:"messages,omitempty"` // publish_batch
+	ClientID    string    `json:"client_id,omitempty"`
+	LastN       int       `json:"last_n,omitempty"`
+	RequestID   string    `json:"request_id,omitempty"`
+	Credit      int       `json:"credit,omitempty"`        // subscribe: opt into credit mode with this window; credit: events to add
+	BatchSize   int       `json:"batch_size,omitempty"`    // subscribe: opt into event_batch frames of up to this many messages
+	BatchWaitMS int       `json:"batch_wait_ms,omitempty"` // subscribe: longest a partial batch is held back
+	AfterSeq    *uint64   `json:"after_seq,omitempty"`     // subscribe: resume by replaying retained messages after this sequence number instead of last_n
+	State       bool      `json:"state,omitempty"`         // subscribe: replay the current value of every key of a compacted topic; with after_seq, only if resuming would leave a gap
+	Exclusive   bool      `json:"exclusive,omitempty"`     // subscribe: only one client_id is active at a time, the others wait as standbys
+	Seq         uint64    `json:"seq,omitempty"`           // ack: the active exclusive subscriber has handled every message up to this sequence number
+
+	Publishes []TopicMessage `json:"publishes,omitempty"` // publish_tx: appended all together or not at all
+}
+
+// TopicMessage is a message addressed to a topic, one entry of a publish_tx
+type TopicMessage struct {
+	Topic   string  `json:"topic"`
+	Message Message `json:"message"`
 }
 
 // WebSocketResponse represents outgoing WebSocket messages to clients
 type WebSocketResponse struct {
-	Type      string       `json:"type"`
-	RequestID string       `json:"request_id,omitempty"`
-	Topic     string       `json:"topic,omitempty"`
-	Message   *Message     `json:"message,omitempty"`
-	Status    string       `json:"status,omitempty"`
-	Error     *ErrorDetail `json:"error,omitempty"`
-	Timestamp string       `json:"ts,omitempty"`
-	Msg       string       `json:"msg,omitempty"`
+	Type      string          `json:"type"`
+	RequestID string          `json:"request_id,omitempty"`
+	Topic     string          `json:"topic,omitempty"`
+	Message   *Message        `json:"message,omitempty"`
+	Messages  []Message       `json:"messages,omitempty"` // event_batch
+	Results   []PublishResult `json:"results,omitempty"`  // ack of publish_batch or publish_tx, one per message in order
+	Status    string          `json:"status,omitempty"`
+	Error     *ErrorDetail    `json:"error,omitempty"`
+	Timestamp string          `json:"ts,omitempty"`
+	Msg       string          `json:"msg,omitempty"`
+	Seq       uint64          `json:"seq,omitempty"` // ack of subscribe: sequence number the subscription starts after
+}
+
+// PublishResult reports the outcome of one message of a publish_batch
+type PublishResult struct {
+	ID     string       `json:"id"`
+	Seq    uint64       `json:"seq,omitempty"`
+	Status string       `json:"status"`
+	Error  *ErrorDetail `json:"error,omitempty"`
 }
 
 // ErrorDetail represents error information in responses
 type ErrorDetail struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
+	Code         string `json:"code"`
+	Message      string `json:"message"`
+	RetryAfterMS int64  `json:"retry_after_ms,omitempty"` // set with RATE_LIMITED
 }
 
 // HTTP API Structs
 
 // CreateTopicRequest represents a topic creation request
 type CreateTopicRequest struct {
-	Name string `json:"name"`
+	Name      string `json:"name"`
+	Compacted bool   `json:"compacted,omitempty"` // keep the newest message per key
 }
 
 // CreateTopicResponse represents a topic creation response
@@ -95,6 +132,253 @@ type TopicInfo struct {
 	Subscribers int    `json:"subscribers"`
 }
 
+// TopicRateLimit represents a topic's publish rate limit; zero is unlimited
+type TopicRateLimit struct {
+	MessagesPerSec float64 `json:"messages_per_sec"`
+	BytesPerSec    float64 `json:"bytes_per_sec"`
+}
+
+// TopicDetail represents a topic's configuration and counters
+type TopicDetail struct {
+	Name        string          `json:"name"`
+	MaxMessages int             `json:"max_messages"`
+	RateLimit   *TopicRateLimit `json:"rate_limit,omitempty"` // nil when unlimited
+	Compacted   bool            `json:"compacted"`
+	Keys        int             `json:"keys,omitempty"` // live keys of a compacted topic
+	AutoCreated bool            `json:"auto_created"`   // created on first use, so removed once idle
+	Paused      bool            `json:"paused"`
+	LastSeq     uint64          `json:"last_seq"`
+	Messages    int             `json:"messages"` // retained in the ring buffer
+	Pending     int             `json:"pending"`  // held back while paused
+	Subscribers int             `json:"subscribers"`
+	Published   int64           `json:"published"`
+	Delivered   int64           `json:"delivered"`
+	Evicted     int64           `json:"evicted"` // slow consumers disconnected
+
+	Exclusive *ExclusiveGroup `json:"exclusive,omitempty"` // nil when the topic has never had an exclusive subscriber
+}
+
+// ExclusiveGroup represents the exclusive subscribers of a topic
+type ExclusiveGroup struct {
+	Active   string   `json:"active,omitempty"` // client_id receiving messages, empty when none is
+	Standbys []string `json:"standbys"`         // client_ids in the order they take over
+	AckedSeq uint64   `json:"acked_seq"`        // a standby taking over resumes after this
+}
+
+// UpdateTopicRequest represents a topic update; omitted fields are unchanged
+type UpdateTopicRequest struct {
+	MaxMessages *int            `json:"max_messages,omitempty"`
+	RateLimit   *TopicRateLimit `json:"rate_limit,omitempty"` // overrides the server-wide topic limit
+	Compacted   *bool           `json:"compacted,omitempty"`
+}
+
+// MessageRecord represents a retained message with its server timestamp
+type MessageRecord struct {
+	ID         string            `json:"id"`
+	Key        string            `json:"key,omitempty"`
+	Payload    interface{}       `json:"payload"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Seq        uint64            `json:"seq"`
+	Timestamp  string            `json:"ts"`
+}
+
+// BrowseMessagesResponse represents a page of retained messages
+type BrowseMessagesResponse struct {
+	Topic        string          `json:"topic"`
+	Messages     []MessageRecord `json:"messages"`
+	NextAfterSeq uint64          `json:"next_after_seq,omitempty"` // after_seq of the next page, omitted on the last one
+}
+
+// ExportTopic is the first line of a topic export
+type ExportTopic struct {
+	Type        string          `json:"type"` // ExportTypeTopic
+	Name        string          `json:"name"`
+	MaxMessages int             `json:"max_messages"`
+	RateLimit   *TopicRateLimit `json:"rate_limit,omitempty"`
+	Compacted   bool            `json:"compacted,omitempty"`
+	LastSeq     uint64          `json:"last_seq"`
+}
+
+// ExportMessage is a message line of a topic export, oldest first
+type ExportMessage struct {
+	Type string `json:"type"` // ExportTypeMessage
+	MessageRecord
+}
+
+// ImportTopicResponse represents the result of a topic import
+type ImportTopicResponse struct {
+	Topic    string `json:"topic"`
+	Created  bool   `json:"created"`
+	Imported int    `json:"imported"`
+}
+
+// PublishTxRequest represents an atomic publish to one or more topics
+type PublishTxRequest struct {
+	ClientID string         `json:"client_id,omitempty"` // rate limits are charged to it, else to the caller's address
+	Messages []TopicMessage `json:"messages"`
+}
+
+// PublishTxResponse represents a committed publish_tx
+type PublishTxResponse struct {
+	Status  string          `json:"status"`
+	Results []PublishResult `json:"results"` // one per message in order
+}
+
+// RouteRule copies the matching messages published to Source into Target
+type RouteRule struct {
+	ID         string            `json:"id"` // assigned when empty
+	Source     string            `json:"source"`
+	Target     string            `json:"target"`
+	Match      RouteMatch        `json:"match,omitempty"`
+	Fields     []string          `json:"fields,omitempty"`     // dotted payload paths to keep; the whole payload when empty
+	Attributes map[string]string `json:"attributes,omitempty"` // set on the copies, replacing any with the same name
+}
+
+// RouteMatch selects messages by exact values; an empty match selects all
+type RouteMatch struct {
+	Attributes map[string]string `json:"attributes,omitempty"` // attribute -> value
+	Payload    map[string]string `json:"payload,omitempty"`    // dotted payload path -> value
+}
+
+// RouteRuleDetail represents a rule with its counters
+type RouteRuleDetail struct {
+	RouteRule
+	Routed int64 `json:"routed"`
+	Failed int64 `json:"failed"` // copies the target refused
+}
+
+// ListRouteRulesResponse represents the response from listing routing rules
+type ListRouteRulesResponse struct {
+	Rules []RouteRuleDetail `json:"rules"`
+}
+
+// DeleteRouteRuleResponse represents a routing rule deletion response
+type DeleteRouteRuleResponse struct {
+	Status string `json:"status"`
+	ID     string `json:"id"`
+}
+
+// ReplayRequest starts a job republishing a range of a topic's retained
+// messages into another topic, spaced as they originally arrived
+type ReplayRequest struct {
+	Source    string    `json:"source"`
+	Target    string    `json:"target"`
+	AfterSeq  uint64    `json:"after_seq,omitempty"`  // first message replayed is the one after this
+	BeforeSeq uint64    `json:"before_seq,omitempty"` // replay stops short of this, 0 runs to the newest
+	Since     time.Time `json:"since,omitempty"`
+	Until     time.Time `json:"until,omitempty"`
+	Speed     float64   `json:"speed,omitempty"` // multiplier of the original pace, 2 replays twice as fast; default 1
+}
+
+// Span is one step of a message's path through the server, in the W3C
+// trace of the message's traceparent attribute
+type Span struct {
+	TraceID      string            `json:"trace_id"`
+	SpanID       string            `json:"span_id"`
+	ParentSpanID string            `json:"parent_span_id,omitempty"`
+	Name         string            `json:"name"` // one of the SpanName constants
+	Topic        string            `json:"topic"`
+	MessageID    string            `json:"message_id"`
+	ClientID     string            `json:"client_id,omitempty"` // publisher or subscriber
+	Start        time.Time         `json:"start"`
+	End          time.Time         `json:"end"`
+	DurationUS   int64             `json:"duration_us"`
+	Error        string            `json:"error,omitempty"` // why the message went no further
+	Attributes   map[string]string `json:"attributes,omitempty"`
+}
+
+// TraceResponse represents the recorded spans of a message id, oldest first
+type TraceResponse struct {
+	MessageID string `json:"message_id"`
+	Spans     []Span `json:"spans"`
+	Truncated bool   `json:"truncated,omitempty"` // later spans were not kept
+}
+
+// GeneratorRequest starts a job publishing synthetic messages to a topic,
+// either steadily at Rate or in bursts. Payloads follow Schema, a JSON
+// Schema, or Template, a payload whose strings may hold {{field}}
+// placeholders such as {{name}}, {{email}} or {{int 1 10}}.
+type GeneratorRequest struct {
+	Topic      string                 `json:"topic"`
+	Rate       float64                `json:"rate,omitempty"`  // messages per second, evenly spaced
+	Burst      *GeneratorBurst        `json:"burst,omitempty"` // or Size messages at once every interval
+	Count      int64                  `json:"count,omitempty"` // messages to publish, 0 runs until stopped
+	Seed       int64                  `json:"seed,omitempty"`  // the same seed gives the same payloads; random when 0
+	Schema     map[string]interface{} `json:"schema,omitempty"`
+	Template   interface{}            `json:"template,omitempty"`
+	Key        string                 `json:"key,omitempty"`        // template of each message's key
+	Attributes map[string]string      `json:"attributes,omitempty"` // templates of each message's attributes
+}
+
+// GeneratorBurst publishes Size messages back to back every IntervalMS
+type GeneratorBurst struct {
+	Size       int   `json:"size"`
+	IntervalMS int64 `json:"interval_ms"`
+}
+
+// Job is a background job and its progress
+type Job struct {
+	ID         string            `json:"id"`
+	Kind       string            `json:"kind"`
+	State      string            `json:"state"`
+	Target     string            `json:"target"`
+	Published  int64             `json:"published"`
+	Total      int64             `json:"total,omitempty"` // messages the job will publish, 0 when unknown
+	Error      string            `json:"error,omitempty"` // why a failed job stopped
+	CreatedAt  time.Time         `json:"created_at"`
+	FinishedAt *time.Time        `json:"finished_at,omitempty"`
+	Replay     *ReplayRequest    `json:"replay,omitempty"`
+	Generator  *GeneratorRequest `json:"generator,omitempty"`
+}
+
+// ListJobsResponse represents the response from listing jobs
+type ListJobsResponse struct {
+	Jobs []Job `json:"jobs"`
+}
+
+// ClientSession describes a connected WebSocket client
+type ClientSession struct {
+	ID            string               `json:"id"`
+	ClientIDs     []string             `json:"client_ids"` // client ids its requests have used
+	RemoteAddr    string               `json:"remote_addr"`
+	Subprotocol   string               `json:"subprotocol,omitempty"`
+	ConnectedAt   time.Time            `json:"connected_at"`
+	LastSeen      time.Time            `json:"last_seen"`
+	Subscriptions []ClientSubscription `json:"subscriptions"`
+}
+
+// ClientSubscription is one subscription held by a connected client
+type ClientSubscription struct {
+	Topic    string `json:"topic"`
+	ClientID string `json:"client_id"`
+	Queued   int    `json:"queued"` // events waiting to be written to the client
+	Credit   bool   `json:"credit,omitempty"`
+}
+
+// ListClientsResponse represents the response from listing connected clients
+type ListClientsResponse struct {
+	Clients []ClientSession `json:"clients"`
+}
+
+// DisconnectClientResponse represents a forced client disconnect response
+type DisconnectClientResponse struct {
+	Status   string `json:"status"`
+	ID       string `json:"id"`
+	Sessions int    `json:"sessions"` // connections closed
+}
+
+// ClientMessageRequest represents a server-initiated info message to one
+// client or all of them
+type ClientMessageRequest struct {
+	Msg string `json:"msg"`
+}
+
+// ClientMessageResponse represents the response to sending an info message
+type ClientMessageResponse struct {
+	Status   string `json:"status"`
+	Sessions int    `json:"sessions"` // connections the message was queued on
+}
+
 // ListTopicsResponse represents the response from listing topics
 type ListTopicsResponse struct {
 	Topics []TopicInfo `json:"topics"`
@@ -107,9 +391,37 @@ type HealthResponse struct {
 	Subscribers   int `json:"subscribers"`
 }
 
+// ConnectionStats represents WebSocket connection and reaper counters
+type ConnectionStats struct {
+	Active            int   `json:"active"`
+	ReapedClients     int64 `json:"reaped_clients"`
+	ReapedSubscribers int64 `json:"reaped_subscribers"`
+}
+
+// RateUsage represents one rate limit bucket. The per-second limits and
+// available tokens are omitted for dimensions without a limit.
+type RateUsage struct {
+	Messages          int64   `json:"messages"`  // admitted publishes
+	Bytes             int64   `json:"bytes"`     // admitted payload bytes
+	Throttled         int64   `json:"throttled"` // publishes rejected with RATE_LIMITED
+	MessagesPerSec    float64 `json:"messages_per_sec,omitempty"`
+	AvailableMessages float64 `json:"available_messages,omitempty"`
+	BytesPerSec       float64 `json:"bytes_per_sec,omitempty"`
+	AvailableBytes    float64 `json:"available_bytes,omitempty"`
+}
+
+// RateLimitStats represents publish rate limit usage by scope
+type RateLimitStats struct {
+	Clients    map[string]RateUsage `json:"clients"`
+	Topics     map[string]RateUsage `json:"topics"`
+	Namespaces map[string]RateUsage `json:"namespaces"`
+}
+
 // StatsResponse represents system statistics
 type StatsResponse struct {
-	Topics map[string]TopicStats `json:"topics"`
+	Topics      map[string]TopicStats `json:"topics"`
+	Connections ConnectionStats       `json:"connections"`
+	RateLimits  *RateLimitStats       `json:"rate_limits,omitempty"` // nil when no limits are configured
 }
 
 // Error Response for HTTP APIs
@@ -119,15 +431,64 @@ type ErrorResponse struct {
 
 // Constants for WebSocket message types
 const (
-	MessageTypeSubscribe   = "subscribe"
-	MessageTypeUnsubscribe = "unsubscribe"
-	MessageTypePublish     = "publish"
-	MessageTypePing        = "ping"
-	MessageTypeAck         = "ack"
-	MessageTypeEvent       = "event"
-	MessageTypeError       = "error"
-	MessageTypePong        = "pong"
-	MessageTypeInfo        = "info"
+	MessageTypeSubscribe    = "subscribe"
+	MessageTypeUnsubscribe  = "unsubscribe"
+	MessageTypePublish      = "publish"
+	MessageTypePing         = "ping"
+	MessageTypeAck          = "ack" // from clients, records the progress of an exclusive subscription; not acknowledged
+	MessageTypeEvent        = "event"
+	MessageTypeError        = "error"
+	MessageTypePong         = "pong"
+	MessageTypeInfo         = "info"
+	MessageTypeCredit       = "credit" // grants more events to a credit-mode subscription; not acknowledged
+	MessageTypePublishBatch = "publish_batch"
+	MessageTypeEventBatch   = "event_batch"
+	MessageTypePublishTx    = "publish_tx"
+)
+
+// InboxPrefix starts the name of a client's inbox, $inbox.<client_id>,
+// which anyone may publish to and only that client may subscribe to
+const InboxPrefix = "$inbox."
+
+// InboxTopic returns the inbox name of a client id
+func InboxTopic(clientID string) string {
+	return InboxPrefix + clientID
+}
+
+// TraceParentAttribute is the message attribute carrying the W3C trace
+// context, 00-<trace-id>-<parent-id>-<flags>. With tracing enabled the
+// server continues the trace it names, or starts one, and stores the
+// publish span's context in it.
+const TraceParentAttribute = "traceparent"
+
+// Span names
+const (
+	SpanNamePublish = "publish" // accepted, or refused, by the server
+	SpanNameEnqueue = "enqueue" // queued for a subscriber
+	SpanNameDrop    = "drop"    // withheld from a subscriber by an interceptor
+	SpanNameEvict   = "evict"   // subscriber evicted, its queue being full
+	SpanNameWrite   = "write"   // written to a subscriber's socket
+)
+
+// Line types of a topic export
+const (
+	ExportTypeTopic   = "topic"
+	ExportTypeMessage = "message"
+)
+
+// Job kinds
+const (
+	JobKindReplay    = "replay"
+	JobKindGenerator = "generator"
+)
+
+// Job states; the last three are final
+const (
+	JobStateRunning   = "running"
+	JobStatePaused    = "paused"
+	JobStateCompleted = "completed"
+	JobStateCancelled = "cancelled"
+	JobStateFailed    = "failed"
 )
 
 // Constants for error codes
@@ -137,6 +498,7 @@ const (
 	ErrorCodeSlowConsumer  = "SLOW_CONSUMER"
 	ErrorCodeUnauthorized  = "UNAUTHORIZED"
 	ErrorCodeInternal      = "INTERNAL"
+	ErrorCodeRateLimited   = "RATE_LIMITED"
 )
 
 // Constants for HTTP status messages
@@ -145,4 +507,8 @@ const (
 	StatusDeleted  = "deleted"
 	StatusOK       = "ok"
 	StatusConflict = "conflict"
+	StatusError    = "error"
+	StatusStandby  = "standby" // ack of an exclusive subscribe that waits behind the active subscriber
+
+	StatusDisconnected = "disconnected"
 )
@@ -0,0 +1,507 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+	"github.com/Aryaman/pub-sub/services/pubsub"
+)
+
+// Config describes this node and the static set of peers it clusters with
+type Config struct {
+	NodeID            string
+	BindAddr          string            // TCP address for cluster traffic
+	Peers             map[string]string // node id -> cluster address
+	HeartbeatInterval time.Duration
+	PeerTimeout       time.Duration // per-request timeout between nodes
+}
+
+// Node joins a pubsub.ServiceImpl to a cluster. Each topic is owned by one
+// node and replicated to a follower, both chosen by rendezvous hashing over
+// the nodes currently reachable. Operations on any node are routed to the
+// owner; the owner pushes events to nodes with local subscribers.
+type Node struct {
+	cfg   Config
+	svc   *pubsub.ServiceImpl
+	peers map[string]*peer
+
+	ln        net.Listener
+	inboundMu sync.Mutex
+	inbound   map[net.Conn]struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	topicLocks [topicLockStripes]sync.Mutex
+
+	interestMu sync.Mutex
+	interest   map[string]map[string]bool // topic -> node ids with local subscribers
+
+	rebalanceMu sync.Mutex
+}
+
+// NewNode wires a cluster node to svc and installs itself as svc's router
+func NewNode(cfg Config, svc *pubsub.ServiceImpl) *Node {
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = time.Second
+	}
+	if cfg.PeerTimeout <= 0 {
+		cfg.PeerTimeout = 3 * cfg.HeartbeatInterval
+	}
+
+	n := &Node{
+		cfg:      cfg,
+		svc:      svc,
+		peers:    make(map[string]*peer),
+		inbound:  make(map[net.Conn]struct{}),
+		done:     make(chan struct{}),
+		interest: make(map[string]map[string]bool),
+	}
+	for id, addr := range cfg.Peers {
+		if id == cfg.NodeID {
+			continue
+		}
+		n.peers[id] = &peer{id: id, addr: addr, node: n, pending: make(map[uint64]chan frame)}
+	}
+
+	svc.Cluster = n
+	return n
+}
+
+// Start listens on BindAddr and begins connecting to peers
+func (n *Node) Start() error {
+	ln, err := net.Listen("tcp", n.cfg.BindAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for cluster traffic: %w", err)
+	}
+	n.Serve(ln)
+	return nil
+}
+
+// Serve accepts cluster traffic on ln and begins connecting to peers
+func (n *Node) Serve(ln net.Listener) {
+	n.ln = ln
+	go n.acceptLoop()
+	for _, p := range n.peers {
+		go p.maintain()
+	}
+}
+
+// Close leaves the cluster and drops every connection
+func (n *Node) Close() error {
+	n.closeOnce.Do(func() {
+		close(n.done)
+		if n.ln != nil {
+			n.ln.Close()
+		}
+		n.inboundMu.Lock()
+		for conn := range n.inbound {
+			conn.Close()
+		}
+		n.inboundMu.Unlock()
+	})
+	return nil
+}
+
+// Members returns the ids of every node this node considers up, itself included
+func (n *Node) Members() []string {
+	members := []string{n.cfg.NodeID}
+	for id, p := range n.peers {
+		if p.isUp() {
+			members = append(members, id)
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+// Placement returns the owner and follower of a topic under the current
+// membership. Follower is empty when this node is alone.
+func (n *Node) Placement(topic string) (owner, follower string) {
+	members := n.Members()
+	sort.Slice(members, func(i, j int) bool {
+		return score(members[i], topic) > score(members[j], topic)
+	})
+	owner = members[0]
+	if len(members) > 1 {
+		follower = members[1]
+	}
+	return owner, follower
+}
+
+// score is the rendezvous hash weight of a node for a topic
+func score(nodeID, topic string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(nodeID))
+	h.Write([]byte{0})
+	h.Write([]byte(topic))
+	return h.Sum64()
+}
+
+// CreateTopic creates the topic on its owner and announces it to every node
+func (n *Node) CreateTopic(name string) error {
+	owner, _ := n.Placement(name)
+	if owner == n.cfg.NodeID {
+		return n.createOwned(name)
+	}
+	return n.forward(owner, frame{Op: opCreate, Topic: name})
+}
+
+// DeleteTopic deletes the topic on its owner and announces it to every node
+func (n *Node) DeleteTopic(name string) error {
+	owner, _ := n.Placement(name)
+	if owner == n.cfg.NodeID {
+		return n.deleteOwned(name)
+	}
+	return n.forward(owner, frame{Op: opDelete, Topic: name})
+}
+
+// Publish appends msg on the owner, which replicates and fans it out
+func (n *Node) Publish(topic string, msg sdk.Message) (sdk.Message, error) {
+	owner, _ := n.Placement(topic)
+	if owner == n.cfg.NodeID {
+		return n.publishOwned(topic, msg)
+	}
+
+	resp, err := n.request(owner, frame{Op: opPublish, Topic: topic, Messages: toWire([]sdk.Message{msg})})
+	if err != nil {
+		return msg, err
+	}
+	if len(resp.Messages) != 1 {
+		return msg, fmt.Errorf("malformed publish reply from %s", owner)
+	}
+	return fromWire(resp.Messages)[0], nil
+}
+
+// Subscribe registers interest with the owner and returns its last n messages
+func (n *Node) Subscribe(topic string, lastN int) ([]sdk.Message, error) {
+	owner, _ := n.Placement(topic)
+	if owner == n.cfg.NodeID {
+		return n.svc.LastMessages(topic, lastN)
+	}
+
+	resp, err := n.request(owner, frame{Op: opSubscribe, Topic: topic, N: lastN})
+	if err != nil {
+		return nil, err
+	}
+	return fromWire(resp.Messages), nil
+}
+
+// createOwned creates a topic this node owns and tells the rest of the cluster
+func (n *Node) createOwned(name string) error {
+	if err := n.svc.AddTopicLocal(name); err != nil {
+		return err
+	}
+	n.broadcast(frame{Op: opTopicAdded, Topic: name})
+	return nil
+}
+
+// deleteOwned deletes a topic this node owns and tells the rest of the cluster
+func (n *Node) deleteOwned(name string) error {
+	if err := n.svc.RemoveTopicLocal(name); err != nil {
+		return err
+	}
+	n.interestMu.Lock()
+	delete(n.interest, name)
+	n.interestMu.Unlock()
+	n.broadcast(frame{Op: opTopicRemoved, Topic: name})
+	return nil
+}
+
+// publishOwned appends a message on the owner, replicates it to the follower
+// and pushes it to interested nodes. Frames are written while holding the
+// topic lock so every node sees messages in sequence order.
+func (n *Node) publishOwned(topic string, msg sdk.Message) (sdk.Message, error) {
+	lock := n.topicLock(topic)
+	lock.Lock()
+
+	stored, err := n.svc.PublishLocal(topic, msg)
+	if err != nil {
+		lock.Unlock()
+		return msg, err
+	}
+
+	wire := toWire([]sdk.Message{stored})
+	_, follower := n.Placement(topic)
+
+	var replicated <-chan frame
+	if p, ok := n.peers[follower]; ok {
+		replicated, _ = p.send(frame{Op: opReplicate, Topic: topic, Messages: wire})
+	}
+
+	for _, id := range n.interestedNodes(topic) {
+		if id == follower {
+			continue // the follower fans out replicas itself
+		}
+		if reply, err := n.peers[id].send(frame{Op: opDeliver, Topic: topic, Messages: wire}); err == nil {
+			go n.awaitDeliver(topic, id, reply)
+		}
+	}
+	lock.Unlock()
+
+	// Acknowledge once the follower holds a copy, or it has been declared lost
+	if replicated != nil {
+		select {
+		case <-replicated:
+		case <-time.After(n.cfg.PeerTimeout):
+			log.Printf("cluster: replication of %s seq %d to %s timed out", topic, stored.Seq, follower)
+		}
+	}
+	return stored, nil
+}
+
+// awaitDeliver drops a node's interest once it reports no local subscribers
+func (n *Node) awaitDeliver(topic, id string, reply <-chan frame) {
+	select {
+	case resp, ok := <-reply:
+		if ok && !resp.Interested {
+			n.setInterest(topic, id, false)
+		}
+	case <-time.After(n.cfg.PeerTimeout):
+	}
+}
+
+// handle executes a request from another node and builds its reply
+func (n *Node) handle(f frame) frame {
+	resp := frame{ID: f.ID, Reply: true}
+	var err error
+
+	switch f.Op {
+	case opPing:
+	case opSync:
+		for _, name := range f.Topics {
+			n.svc.AddTopicLocal(name)
+		}
+	case opCreate:
+		err = n.createOwned(f.Topic)
+	case opDelete:
+		err = n.deleteOwned(f.Topic)
+	case opTopicAdded:
+		n.svc.AddTopicLocal(f.Topic)
+	case opTopicRemoved:
+		n.svc.RemoveTopicLocal(f.Topic)
+	case opPublish:
+		if len(f.Messages) != 1 {
+			err = fmt.Errorf("publish requires exactly one message")
+			break
+		}
+		var stored sdk.Message
+		stored, err = n.publishOwned(f.Topic, fromWire(f.Messages)[0])
+		resp.Messages = toWire([]sdk.Message{stored})
+	case opReplicate:
+		for _, msg := range fromWire(f.Messages) {
+			err = n.svc.ApplyReplica(f.Topic, msg)
+		}
+	case opDeliver:
+		for _, msg := range fromWire(f.Messages) {
+			resp.Interested = n.svc.Deliver(f.Topic, msg)
+		}
+	case opSubscribe:
+		var msgs []sdk.Message
+		msgs, err = n.svc.LastMessages(f.Topic, f.N)
+		if err == nil {
+			n.setInterest(f.Topic, f.From, true)
+		}
+		resp.Messages = toWire(msgs)
+	case opSnapshot:
+		snap := pubsub.TopicSnapshot{
+			Name:        f.Topic,
+			MaxMessages: f.MaxMessages,
+			LastSeq:     f.LastSeq,
+			Compacted:   f.Compacted,
+			Messages:    fromWire(f.Messages),
+		}
+		// A new owner that adopts fresher data passes it on to its follower
+		if n.svc.Restore(snap) {
+			if owner, follower := n.Placement(f.Topic); owner == n.cfg.NodeID && follower != f.From {
+				n.pushSnapshot(f.Topic, follower)
+			}
+		}
+	default:
+		err = fmt.Errorf("unknown cluster op %q", f.Op)
+	}
+
+	if err != nil {
+		resp.Error = err.Error()
+		switch {
+		case errors.Is(err, pubsub.ErrTopicNotFound):
+			resp.Code = codeTopicNotFound
+		case errors.Is(err, pubsub.ErrTopicExists):
+			resp.Code = codeTopicExists
+		}
+	}
+	return resp
+}
+
+// request calls another node and converts an error reply back into an error
+func (n *Node) request(id string, f frame) (frame, error) {
+	p, ok := n.peers[id]
+	if !ok {
+		return frame{}, fmt.Errorf("unknown cluster node %s", id)
+	}
+	resp, err := p.call(f, n.cfg.PeerTimeout)
+	if err != nil {
+		return resp, err
+	}
+	switch {
+	case resp.Code == codeTopicNotFound:
+		return resp, pubsub.ErrTopicNotFound
+	case resp.Code == codeTopicExists:
+		return resp, pubsub.ErrTopicExists
+	case resp.Error != "":
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// forward sends a request that only reports success or failure
+func (n *Node) forward(id string, f frame) error {
+	_, err := n.request(id, f)
+	return err
+}
+
+// broadcast sends f to every reachable peer and waits for their replies
+func (n *Node) broadcast(f frame) {
+	var wg sync.WaitGroup
+	for _, p := range n.peers {
+		if !p.isUp() {
+			continue
+		}
+		wg.Add(1)
+		go func(p *peer) {
+			defer wg.Done()
+			if _, err := p.call(f, n.cfg.PeerTimeout); err != nil {
+				log.Printf("cluster: %s to %s failed: %v", f.Op, p.id, err)
+			}
+		}(p)
+	}
+	wg.Wait()
+}
+
+// peerUp shares this node's topics with a peer that just joined and rebalances
+func (n *Node) peerUp(p *peer) {
+	log.Printf("cluster: node %s joined", p.id)
+	p.call(frame{Op: opSync, Topics: n.svc.TopicNames()}, n.cfg.PeerTimeout)
+	go n.rebalance()
+}
+
+// peerDown rebalances after a peer is lost
+func (n *Node) peerDown(p *peer) {
+	log.Printf("cluster: node %s left", p.id)
+	go n.rebalance()
+}
+
+// rebalance reacts to a membership change: retained messages are pushed to
+// each topic's new owner and follower, and local subscribers re-register
+// their interest with the new owner
+func (n *Node) rebalance() {
+	n.rebalanceMu.Lock()
+	defer n.rebalanceMu.Unlock()
+
+	for _, name := range n.svc.TopicNames() {
+		owner, follower := n.Placement(name)
+		if owner != n.cfg.NodeID {
+			n.pushSnapshot(name, owner)
+			if n.svc.HasSubscribers(name) {
+				n.request(owner, frame{Op: opSubscribe, Topic: name})
+			}
+		} else if follower != "" {
+			n.pushSnapshot(name, follower)
+		}
+	}
+}
+
+// pushSnapshot sends this node's retained messages for a topic to another node
+func (n *Node) pushSnapshot(topic, id string) {
+	p, ok := n.peers[id]
+	if !ok {
+		return
+	}
+	snap, err := n.svc.Snapshot(topic)
+	if err != nil || snap.LastSeq == 0 {
+		return
+	}
+	p.call(frame{
+		Op:          opSnapshot,
+		Topic:       topic,
+		LastSeq:     snap.LastSeq,
+		MaxMessages: snap.MaxMessages,
+		Compacted:   snap.Compacted,
+		Messages:    toWire(snap.Messages),
+	}, n.cfg.PeerTimeout)
+}
+
+// acceptLoop serves inbound connections until the listener closes
+func (n *Node) acceptLoop() {
+	for {
+		conn, err := n.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		n.inboundMu.Lock()
+		select {
+		case <-n.done:
+			n.inboundMu.Unlock()
+			conn.Close()
+			return
+		default:
+		}
+		n.inbound[conn] = struct{}{}
+		n.inboundMu.Unlock()
+
+		go func() {
+			n.serveConn(conn)
+			n.inboundMu.Lock()
+			delete(n.inbound, conn)
+			n.inboundMu.Unlock()
+		}()
+	}
+}
+
+// topicLockStripes is how many locks the topics share for publishing
+const topicLockStripes = 256
+
+// topicLock returns the mutex serializing publishes on a topic. Topics
+// share a fixed set of locks by name hash, so topics coming and going do
+// not grow the set.
+func (n *Node) topicLock(topic string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(topic))
+	return &n.topicLocks[h.Sum32()%topicLockStripes]
+}
+
+// setInterest records whether a node has local subscribers for a topic
+func (n *Node) setInterest(topic, id string, interested bool) {
+	n.interestMu.Lock()
+	defer n.interestMu.Unlock()
+
+	if !interested {
+		delete(n.interest[topic], id)
+		return
+	}
+	if n.interest[topic] == nil {
+		n.interest[topic] = make(map[string]bool)
+	}
+	n.interest[topic][id] = true
+}
+
+// interestedNodes lists reachable nodes with local subscribers for a topic
+func (n *Node) interestedNodes(topic string) []string {
+	n.interestMu.Lock()
+	defer n.interestMu.Unlock()
+
+	ids := make([]string, 0, len(n.interest[topic]))
+	for id := range n.interest[topic] {
+		if p, ok := n.peers[id]; ok && p.isUp() {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,239 @@
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+	"github.com/Aryaman/pub-sub/services/pubsub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startCluster runs n nodes on loopback, each with its own pubsub service
+func startCluster(t *testing.T, n int) map[string]*Node {
+	t.Helper()
+
+	listeners := make(map[string]net.Listener)
+	peers := make(map[string]string)
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("node-%d", i)
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		listeners[id] = ln
+		peers[id] = ln.Addr().String()
+	}
+
+	nodes := make(map[string]*Node)
+	for id, ln := range listeners {
+		node := NewNode(Config{
+			NodeID:            id,
+			Peers:             peers,
+			HeartbeatInterval: 50 * time.Millisecond,
+			PeerTimeout:       500 * time.Millisecond,
+		}, pubsub.NewService(100, 100))
+		node.Serve(ln)
+		nodes[id] = node
+		t.Cleanup(func() { node.Close() })
+	}
+
+	waitForMembers(t, nodes, n)
+	return nodes
+}
+
+// waitForMembers blocks until every node sees the expected membership size
+func waitForMembers(t *testing.T, nodes map[string]*Node, size int) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		for _, node := range nodes {
+			if len(node.Members()) != size {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+// newSubscriber returns a subscriber that is not bound to a connection
+func newSubscriber(clientID string) *sdk.Subscriber {
+	return &sdk.Subscriber{
+		ClientID:     clientID,
+		Queue:        make(chan sdk.Message, 10),
+		QueueSize:    10,
+		CloseChannel: make(chan struct{}),
+	}
+}
+
+// nonOwner picks a node other than the ones given
+func nonOwner(nodes map[string]*Node, exclude ...string) *Node {
+	for id, node := range nodes {
+		skip := false
+		for _, ex := range exclude {
+			skip = skip || id == ex
+		}
+		if !skip {
+			return node
+		}
+	}
+	return nil
+}
+
+func receive(t *testing.T, sub *sdk.Subscriber) sdk.Message {
+	t.Helper()
+	select {
+	case msg := <-sub.Queue:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no message delivered to %s", sub.ClientID)
+		return sdk.Message{}
+	}
+}
+
+func TestPlacementIsConsistentAcrossNodes(t *testing.T) {
+	nodes := startCluster(t, 3)
+
+	for _, topic := range []string{"orders", "payments", "inventory"} {
+		var owner, follower string
+		for _, node := range nodes {
+			o, f := node.Placement(topic)
+			if owner == "" {
+				owner, follower = o, f
+			}
+			assert.Equal(t, owner, o)
+			assert.Equal(t, follower, f)
+		}
+		assert.NotEqual(t, owner, follower)
+	}
+}
+
+func TestPublishAndSubscribeAcrossNodes(t *testing.T) {
+	nodes := startCluster(t, 3)
+	first := nodes["node-0"]
+
+	require.NoError(t, first.svc.AddTopic("orders"))
+	assert.ErrorIs(t, nonOwner(nodes, "node-0").svc.AddTopic("orders"), pubsub.ErrTopicExists)
+	for _, node := range nodes {
+		assert.Contains(t, node.svc.TopicNames(), "orders")
+	}
+
+	owner, follower := first.Placement("orders")
+	subNode := nonOwner(nodes, owner, follower)
+	pubNode := nonOwner(nodes, owner, subNode.cfg.NodeID)
+
+	sub := newSubscriber("c1")
+	_, err := subNode.svc.Subscribe("orders", sub, 0)
+	require.NoError(t, err)
+
+	stored, err := pubNode.svc.Publish("orders", sdk.Message{ID: "m1", Payload: "hello"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), stored.Seq)
+
+	got := receive(t, sub)
+	assert.Equal(t, "m1", got.ID)
+	assert.Equal(t, uint64(1), got.Seq)
+
+	// Owner and follower both retain the message
+	for _, id := range []string{owner, follower} {
+		msgs, err := nodes[id].svc.LastMessages("orders", 10)
+		require.NoError(t, err)
+		require.Len(t, msgs, 1)
+		assert.Equal(t, "m1", msgs[0].ID)
+	}
+
+	_, err = pubNode.svc.Publish("missing", sdk.Message{ID: "m2"})
+	assert.ErrorIs(t, err, pubsub.ErrTopicNotFound)
+}
+
+func TestFollowerTakesOverWhenOwnerLeaves(t *testing.T) {
+	nodes := startCluster(t, 3)
+	require.NoError(t, nodes["node-0"].svc.AddTopic("orders"))
+
+	owner, follower := nodes["node-0"].Placement("orders")
+	third := nonOwner(nodes, owner, follower)
+
+	sub := newSubscriber("c1")
+	_, err := third.svc.Subscribe("orders", sub, 0)
+	require.NoError(t, err)
+
+	_, err = third.svc.Publish("orders", sdk.Message{ID: "m1", Payload: 1})
+	require.NoError(t, err)
+	receive(t, sub)
+
+	// Lose the owner; the follower becomes owner under rendezvous hashing
+	nodes[owner].Close()
+	delete(nodes, owner)
+	waitForMembers(t, nodes, 2)
+
+	newOwner, _ := third.Placement("orders")
+	assert.Equal(t, follower, newOwner)
+
+	require.Eventually(t, func() bool {
+		_, err := third.svc.Publish("orders", sdk.Message{ID: "m2", Payload: 2})
+		return err == nil
+	}, 2*time.Second, 50*time.Millisecond)
+
+	got := receive(t, sub)
+	assert.Equal(t, "m2", got.ID)
+	assert.Equal(t, uint64(2), got.Seq)
+
+	// The surviving replica still serves the full history
+	replay, err := third.Subscribe("orders", 10)
+	require.NoError(t, err)
+	require.Len(t, replay, 2)
+	assert.Equal(t, "m1", replay[0].ID)
+}
+
+func TestReplayRejectedInClusterMode(t *testing.T) {
+	nodes := startCluster(t, 2)
+	node := nodes["node-0"]
+	require.NoError(t, node.svc.AddTopic("orders"))
+	require.NoError(t, node.svc.AddTopic("audit"))
+
+	// Browsing sees only this node's copy, which may be missing history
+	_, err := node.svc.StartReplay(sdk.ReplayRequest{Source: "orders", Target: "audit"})
+	assert.ErrorIs(t, err, pubsub.ErrReplayClustered)
+}
+
+func TestRoutingRejectedInClusterMode(t *testing.T) {
+	nodes := startCluster(t, 2)
+	node := nodes["node-0"]
+	require.NoError(t, node.svc.AddTopic("orders"))
+	require.NoError(t, node.svc.AddTopic("audit"))
+
+	// A rule held by one node would miss publishes through the others
+	_, err := node.svc.AddRoute(sdk.RouteRule{Source: "orders", Target: "audit"})
+	assert.ErrorIs(t, err, pubsub.ErrRoutingClustered)
+	assert.Empty(t, node.svc.Routes())
+}
+
+func TestSnapshotKeepsCompaction(t *testing.T) {
+	nodes := startCluster(t, 2)
+	owner, follower := nodes["node-0"].Placement("prices")
+	src, dst := nodes[owner], nodes[follower]
+
+	// Compaction cannot be switched on per node in cluster mode, but a
+	// compacted topic restored from a state file must stay compacted
+	require.NoError(t, src.svc.AddTopic("prices"))
+	compacted := true
+	assert.ErrorIs(t, src.svc.Configure("prices", sdk.UpdateTopicRequest{Compacted: &compacted}), pubsub.ErrCompactedClustered)
+	require.True(t, src.svc.Restore(pubsub.TopicSnapshot{
+		Name:        "prices",
+		MaxMessages: 100,
+		LastSeq:     2,
+		Compacted:   true,
+		Messages: []sdk.Message{
+			{ID: "p1", Key: "eur", Payload: 1, Seq: 1, TS: time.Now()},
+			{ID: "p2", Key: "usd", Payload: 2, Seq: 2, TS: time.Now()},
+		},
+	}))
+
+	src.pushSnapshot("prices", follower)
+	snap, err := dst.svc.Snapshot("prices")
+	require.NoError(t, err)
+	assert.True(t, snap.Compacted)
+	assert.Equal(t, uint64(2), snap.LastSeq)
+	_, err = dst.svc.PublishLocal("prices", sdk.Message{ID: "p3"})
+	assert.ErrorIs(t, err, pubsub.ErrKeyRequired)
+}
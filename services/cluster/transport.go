@@ -0,0 +1,298 @@
+package cluster
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+)
+
+// Operations exchanged between nodes
+const (
+	opPing         = "ping"
+	opSync         = "sync"
+	opCreate       = "create"
+	opDelete       = "delete"
+	opTopicAdded   = "topic_added"
+	opTopicRemoved = "topic_removed"
+	opPublish      = "publish"
+	opReplicate    = "replicate"
+	opDeliver      = "deliver"
+	opSubscribe    = "subscribe"
+	opSnapshot     = "snapshot"
+)
+
+// Error codes carried in replies so callers can map them back to pubsub errors
+const (
+	codeTopicNotFound = "topic_not_found"
+	codeTopicExists   = "topic_exists"
+)
+
+// errPeerDown is returned when a request cannot reach the target node
+var errPeerDown = errors.New("cluster peer unavailable")
+
+// frame is the single message shape on the wire, one JSON object per line
+type frame struct {
+	ID          uint64        `json:"id"`
+	Reply       bool          `json:"reply,omitempty"`
+	Op          string        `json:"op,omitempty"`
+	From        string        `json:"from,omitempty"`
+	Topic       string        `json:"topic,omitempty"`
+	Topics      []string      `json:"topics,omitempty"`
+	Messages    []wireMessage `json:"messages,omitempty"`
+	N           int           `json:"n,omitempty"`
+	LastSeq     uint64        `json:"last_seq,omitempty"`
+	MaxMessages int           `json:"max_messages,omitempty"`
+	Compacted   bool          `json:"compacted,omitempty"`
+	Interested  bool          `json:"interested,omitempty"`
+	Code        string        `json:"code,omitempty"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// wireMessage keeps the server timestamp that sdk.Message does not serialize
+type wireMessage struct {
+	ID         string            `json:"id"`
+	Key        string            `json:"key,omitempty"`
+	Payload    interface{}       `json:"payload"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Seq        uint64            `json:"seq"`
+	TS         time.Time         `json:"ts"`
+}
+
+func toWire(msgs []sdk.Message) []wireMessage {
+	out := make([]wireMessage, len(msgs))
+	for i, msg := range msgs {
+		out[i] = wireMessage{ID: msg.ID, Key: msg.Key, Payload: msg.Payload, Attributes: msg.Attributes, Seq: msg.Seq, TS: msg.TS}
+	}
+	return out
+}
+
+func fromWire(msgs []wireMessage) []sdk.Message {
+	out := make([]sdk.Message, len(msgs))
+	for i, msg := range msgs {
+		out[i] = sdk.Message{ID: msg.ID, Key: msg.Key, Payload: msg.Payload, Attributes: msg.Attributes, Seq: msg.Seq, TS: msg.TS}
+	}
+	return out
+}
+
+// frameConn serializes frame writes on a TCP connection
+type frameConn struct {
+	conn    net.Conn
+	dec     *json.Decoder
+	writeMu sync.Mutex
+	enc     *json.Encoder
+}
+
+func newFrameConn(conn net.Conn) *frameConn {
+	return &frameConn{
+		conn: conn,
+		dec:  json.NewDecoder(bufio.NewReader(conn)),
+		enc:  json.NewEncoder(conn),
+	}
+}
+
+func (fc *frameConn) write(f frame, timeout time.Duration) error {
+	fc.writeMu.Lock()
+	defer fc.writeMu.Unlock()
+	fc.conn.SetWriteDeadline(time.Now().Add(timeout))
+	return fc.enc.Encode(f)
+}
+
+func (fc *frameConn) read() (frame, error) {
+	var f frame
+	err := fc.dec.Decode(&f)
+	return f, err
+}
+
+// peer is the outbound connection to another node. Requests are written on
+// it and replies come back on the same connection, matched by frame ID.
+type peer struct {
+	id   string
+	addr string
+	node *Node
+
+	mu      sync.Mutex
+	fc      *frameConn
+	up      bool
+	nextID  uint64
+	pending map[uint64]chan frame
+}
+
+// call sends a request and waits for its reply
+func (p *peer) call(f frame, timeout time.Duration) (frame, error) {
+	reply, err := p.send(f)
+	if err != nil {
+		return frame{}, err
+	}
+	select {
+	case resp, ok := <-reply:
+		if !ok {
+			return frame{}, errPeerDown
+		}
+		return resp, nil
+	case <-time.After(timeout):
+		return frame{}, fmt.Errorf("cluster request %s to %s timed out", f.Op, p.id)
+	}
+}
+
+// send writes a request and returns the channel its reply will arrive on.
+// The channel is closed if the connection drops first.
+func (p *peer) send(f frame) (<-chan frame, error) {
+	p.mu.Lock()
+	fc := p.fc
+	if fc == nil {
+		p.mu.Unlock()
+		return nil, errPeerDown
+	}
+	p.nextID++
+	f.ID = p.nextID
+	f.From = p.node.cfg.NodeID
+	reply := make(chan frame, 1)
+	p.pending[f.ID] = reply
+	p.mu.Unlock()
+
+	if err := fc.write(f, p.node.cfg.PeerTimeout); err != nil {
+		p.disconnect(fc)
+		return nil, errPeerDown
+	}
+	return reply, nil
+}
+
+// isUp reports whether the peer currently counts as a cluster member
+func (p *peer) isUp() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.up
+}
+
+// maintain dials the peer, heartbeats it and redials after failures
+func (p *peer) maintain() {
+	ticker := time.NewTicker(p.node.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		p.mu.Lock()
+		connected := p.fc != nil
+		p.mu.Unlock()
+
+		if !connected {
+			p.dial()
+		} else if _, err := p.call(frame{Op: opPing}, p.node.cfg.PeerTimeout); err != nil {
+			p.mu.Lock()
+			fc := p.fc
+			p.mu.Unlock()
+			p.disconnect(fc)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-p.node.done:
+			p.mu.Lock()
+			fc := p.fc
+			p.mu.Unlock()
+			p.disconnect(fc)
+			return
+		}
+	}
+}
+
+// dial opens the connection and marks the peer up once it answers a ping
+func (p *peer) dial() {
+	conn, err := net.DialTimeout("tcp", p.addr, p.node.cfg.PeerTimeout)
+	if err != nil {
+		return
+	}
+	fc := newFrameConn(conn)
+
+	p.mu.Lock()
+	p.fc = fc
+	p.mu.Unlock()
+	go p.readReplies(fc)
+
+	if _, err := p.call(frame{Op: opPing}, p.node.cfg.PeerTimeout); err != nil {
+		p.disconnect(fc)
+		return
+	}
+
+	p.mu.Lock()
+	p.up = true
+	p.mu.Unlock()
+	p.node.peerUp(p)
+}
+
+// readReplies routes replies to their waiting callers
+func (p *peer) readReplies(fc *frameConn) {
+	for {
+		f, err := fc.read()
+		if err != nil {
+			p.disconnect(fc)
+			return
+		}
+		p.mu.Lock()
+		reply, ok := p.pending[f.ID]
+		delete(p.pending, f.ID)
+		p.mu.Unlock()
+		if ok {
+			reply <- f
+		}
+	}
+}
+
+// disconnect drops fc if it is still the active connection and fails every
+// request waiting on it
+func (p *peer) disconnect(fc *frameConn) {
+	if fc == nil {
+		return
+	}
+
+	p.mu.Lock()
+	if p.fc != fc {
+		p.mu.Unlock()
+		return
+	}
+	wasUp := p.up
+	p.fc = nil
+	p.up = false
+	for id, reply := range p.pending {
+		close(reply)
+		delete(p.pending, id)
+	}
+	p.mu.Unlock()
+
+	fc.conn.Close()
+	if wasUp {
+		p.node.peerDown(p)
+	}
+}
+
+// serveConn handles requests arriving from another node. Frames that must
+// stay ordered (replication, delivery) are applied inline; anything that may
+// itself call other nodes runs in its own goroutine so it cannot stall the
+// connection.
+func (n *Node) serveConn(conn net.Conn) {
+	defer conn.Close()
+	fc := newFrameConn(conn)
+
+	for {
+		f, err := fc.read()
+		if err != nil {
+			return
+		}
+
+		switch f.Op {
+		case opCreate, opDelete, opPublish, opSubscribe, opSnapshot:
+			go func(f frame) {
+				fc.write(n.handle(f), n.cfg.PeerTimeout)
+			}(f)
+		default:
+			if err := fc.write(n.handle(f), n.cfg.PeerTimeout); err != nil {
+				return
+			}
+		}
+	}
+}
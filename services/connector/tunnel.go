@@ -43,6 +43,7 @@ type TunnelConnection struct {
 	CreatedAt   time.Time
 	pendingReqs map[string]chan TunnelMessage
 	mutex       sync.RWMutex
+	writeMu     sync.Mutex // serializes writes to Conn
 	ctx         context.Context
 	cancel      context.CancelFunc
 }
@@ -53,6 +54,7 @@ type TunnelManager struct {
 	subdomains  map[string]*TunnelConnection // subdomain -> connection
 	mutex       sync.RWMutex
 	store       Store
+	draining    bool
 }
 
 // NewTunnelManager creates a new tunnel manager
@@ -73,6 +75,11 @@ func NewTunnelManager(store Store) *TunnelManager {
 func (tm *TunnelManager) HandleWebSocket(c *websocket.Conn, userID string) {
 	defer c.Close()
 
+	if tm.isDraining() {
+		c.WriteJSON(shutdownMessage())
+		return
+	}
+
 	// Create new tunnel connection
 	ctx, cancel := context.WithCancel(context.Background())
 	tunnelConn := &TunnelConnection{
@@ -106,7 +113,7 @@ func (tm *TunnelManager) HandleWebSocket(c *websocket.Conn, userID string) {
 		},
 	}
 
-	if err := c.WriteJSON(handshake); err != nil {
+	if err := tunnelConn.writeJSON(handshake); err != nil {
 		log.Printf("Failed to send handshake: %v", err)
 		return
 	}
@@ -189,6 +196,14 @@ func (tm *TunnelManager) handleMessages(conn *TunnelConnection) {
 	}
 }
 
+// writeJSON sends msg to the CLI. The heartbeat loop, forwarded requests and
+// shutdown all write to the same connection, which allows one writer at a time.
+func (conn *TunnelConnection) writeJSON(msg TunnelMessage) error {
+	conn.writeMu.Lock()
+	defer conn.writeMu.Unlock()
+	return conn.Conn.WriteJSON(msg)
+}
+
 // handleResponse handles response messages from the CLI
 func (tm *TunnelManager) handleResponse(conn *TunnelConnection, msg TunnelMessage) {
 	conn.mutex.RLock()
@@ -214,7 +229,7 @@ func (tm *TunnelManager) handleHeartbeat(conn *TunnelConnection) {
 		},
 	}
 
-	conn.Conn.WriteJSON(heartbeat)
+	conn.writeJSON(heartbeat)
 }
 
 // heartbeatLoop maintains connection with periodic heartbeats
@@ -240,8 +255,12 @@ func (tm *TunnelManager) heartbeatLoop(conn *TunnelConnection) {
 func (tm *TunnelManager) ForwardRequest(subdomain string, req *http.Request) (*http.Response, error) {
 	tm.mutex.RLock()
 	conn, exists := tm.subdomains[subdomain]
+	draining := tm.draining
 	tm.mutex.RUnlock()
 
+	if draining {
+		return nil, fmt.Errorf("tunnel server is shutting down")
+	}
 	if !exists {
 		return nil, fmt.Errorf("no active tunnel for subdomain: %s", subdomain)
 	}
@@ -281,7 +300,7 @@ func (tm *TunnelManager) ForwardRequest(subdomain string, req *http.Request) (*h
 	conn.mutex.Unlock()
 
 	// Send request through WebSocket
-	if err := conn.Conn.WriteJSON(requestMsg); err != nil {
+	if err := conn.writeJSON(requestMsg); err != nil {
 		conn.mutex.Lock()
 		delete(conn.pendingReqs, requestID)
 		conn.mutex.Unlock()
@@ -333,6 +352,66 @@ func (tm *TunnelManager) GetActiveConnections() map[string]*TunnelConnection {
 	return connections
 }
 
+// Shutdown stops accepting tunnels and requests, waits for in-flight requests
+// to complete until ctx expires, then disconnects every CLI
+func (tm *TunnelManager) Shutdown(ctx context.Context) error {
+	tm.mutex.Lock()
+	tm.draining = true
+	conns := make([]*TunnelConnection, 0, len(tm.connections))
+	for _, conn := range tm.connections {
+		conns = append(conns, conn)
+	}
+	tm.mutex.Unlock()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+wait:
+	for pendingRequests(conns) > 0 {
+		select {
+		case <-ctx.Done():
+			log.Printf("Tunnel drain deadline hit with %d requests in flight", pendingRequests(conns))
+			break wait
+		case <-ticker.C:
+		}
+	}
+
+	for _, conn := range conns {
+		conn.writeJSON(shutdownMessage())
+		conn.cancel()
+	}
+	return nil
+}
+
+// isDraining reports whether Shutdown has been called
+func (tm *TunnelManager) isDraining() bool {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+	return tm.draining
+}
+
+// pendingRequests counts requests still waiting for a CLI response
+func pendingRequests(conns []*TunnelConnection) int {
+	pending := 0
+	for _, conn := range conns {
+		conn.mutex.RLock()
+		pending += len(conn.pendingReqs)
+		conn.mutex.RUnlock()
+	}
+	return pending
+}
+
+// shutdownMessage tells the CLI the server is going away
+func shutdownMessage() TunnelMessage {
+	return TunnelMessage{
+		ID:   uuid.New().String(),
+		Type: MessageTypeDisconnect,
+		Data: map[string]interface{}{
+			"reason": "server shutting down",
+		},
+	}
+}
+
 // cleanupRoutine periodically cleans up stale connections
 func (tm *TunnelManager) cleanupRoutine() {
 	ticker := time.NewTicker(5 * time.Minute)
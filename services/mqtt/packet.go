@@ -0,0 +1,276 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MQTT 3.1.1 control packet types
+const (
+	packetConnect     byte = 1
+	packetConnack     byte = 2
+	packetPublish     byte = 3
+	packetPuback      byte = 4
+	packetSubscribe   byte = 8
+	packetSuback      byte = 9
+	packetUnsubscribe byte = 10
+	packetUnsuback    byte = 11
+	packetPingreq     byte = 12
+	packetPingresp    byte = 13
+	packetDisconnect  byte = 14
+)
+
+// CONNACK return codes
+const (
+	connackAccepted           byte = 0
+	connackBadProtocol        byte = 1
+	connackIdentifierRejected byte = 2
+)
+
+// subackFailure marks a rejected topic filter in SUBACK
+const subackFailure byte = 0x80
+
+// maxRemainingLength is the largest length the 4-byte varint can encode
+const maxRemainingLength = 268435455
+
+// DefaultMaxPacketSize is the default limit on a packet's remaining length
+const DefaultMaxPacketSize = 1024 * 1024
+
+var (
+	errMalformed = errors.New("malformed mqtt packet")
+	// errPacketTooLarge is returned for a packet whose declared length is
+	// over the limit, before its body is read
+	errPacketTooLarge = errors.New("mqtt packet too large")
+)
+
+// packet is a decoded control packet: the fixed header plus its raw body
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+// readPacket reads one control packet whose remaining length is at most
+// maxSize bytes
+func readPacket(r *bufio.Reader, maxSize int) (packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return packet{}, errMalformed
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		length += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	if length > maxSize {
+		return packet{}, errPacketTooLarge
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return packet{}, err
+	}
+	return packet{kind: header >> 4, flags: header & 0x0f, body: body}, nil
+}
+
+// encodePacket serializes a control packet
+func encodePacket(kind, flags byte, body []byte) ([]byte, error) {
+	if len(body) > maxRemainingLength {
+		return nil, fmt.Errorf("mqtt packet too large: %d bytes", len(body))
+	}
+
+	out := make([]byte, 0, len(body)+5)
+	out = append(out, kind<<4|flags)
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if length == 0 {
+			break
+		}
+	}
+	return append(out, body...), nil
+}
+
+// decoder walks a packet body
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uint8() byte {
+	if d.err != nil || len(d.buf) < 1 {
+		d.err = errMalformed
+		return 0
+	}
+	v := d.buf[0]
+	d.buf = d.buf[1:]
+	return v
+}
+
+func (d *decoder) uint16() uint16 {
+	if d.err != nil || len(d.buf) < 2 {
+		d.err = errMalformed
+		return 0
+	}
+	v := binary.BigEndian.Uint16(d.buf)
+	d.buf = d.buf[2:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := int(d.uint16())
+	if d.err != nil || len(d.buf) < n {
+		d.err = errMalformed
+		return nil
+	}
+	v := d.buf[:n]
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return binary.BigEndian.AppendUint16(b, v)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// connectPacket holds the CONNECT fields the broker uses
+type connectPacket struct {
+	protocol     string
+	level        byte
+	cleanSession bool
+	keepAlive    uint16
+	clientID     string
+}
+
+func parseConnect(body []byte) (connectPacket, error) {
+	d := &decoder{buf: body}
+	c := connectPacket{
+		protocol: d.string(),
+		level:    d.uint8(),
+	}
+	flags := d.uint8()
+	c.cleanSession = flags&0x02 != 0
+	c.keepAlive = d.uint16()
+	c.clientID = d.string()
+
+	// Will, username and password are read and ignored
+	if flags&0x04 != 0 {
+		d.string()
+		d.bytes()
+	}
+	if flags&0x80 != 0 {
+		d.string()
+	}
+	if flags&0x40 != 0 {
+		d.bytes()
+	}
+	return c, d.err
+}
+
+// publishPacket is a PUBLISH in either direction
+type publishPacket struct {
+	topic    string
+	qos      byte
+	retain   bool
+	dup      bool
+	packetID uint16
+	payload  []byte
+}
+
+func parsePublish(p packet) (publishPacket, error) {
+	d := &decoder{buf: p.body}
+	pub := publishPacket{
+		dup:    p.flags&0x08 != 0,
+		qos:    (p.flags >> 1) & 0x03,
+		retain: p.flags&0x01 != 0,
+		topic:  d.string(),
+	}
+	if pub.qos > 0 {
+		pub.packetID = d.uint16()
+	}
+	if d.err != nil {
+		return pub, d.err
+	}
+	pub.payload = d.buf
+	return pub, nil
+}
+
+func (pub publishPacket) encode() ([]byte, error) {
+	var flags byte
+	if pub.dup {
+		flags |= 0x08
+	}
+	flags |= pub.qos << 1
+	if pub.retain {
+		flags |= 0x01
+	}
+
+	body := appendString(nil, pub.topic)
+	if pub.qos > 0 {
+		body = appendUint16(body, pub.packetID)
+	}
+	body = append(body, pub.payload...)
+	return encodePacket(packetPublish, flags, body)
+}
+
+// subscription is one topic filter from SUBSCRIBE
+type subscription struct {
+	filter string
+	qos    byte
+}
+
+func parseSubscribe(body []byte) (uint16, []subscription, error) {
+	d := &decoder{buf: body}
+	id := d.uint16()
+	var subs []subscription
+	for d.err == nil && len(d.buf) > 0 {
+		subs = append(subs, subscription{filter: d.string(), qos: d.uint8()})
+	}
+	if d.err == nil && len(subs) == 0 {
+		d.err = errMalformed
+	}
+	return id, subs, d.err
+}
+
+func parseUnsubscribe(body []byte) (uint16, []string, error) {
+	d := &decoder{buf: body}
+	id := d.uint16()
+	var filters []string
+	for d.err == nil && len(d.buf) > 0 {
+		filters = append(filters, d.string())
+	}
+	return id, filters, d.err
+}
+
+func parsePacketID(body []byte) (uint16, error) {
+	d := &decoder{buf: body}
+	id := d.uint16()
+	return id, d.err
+}
@@ -0,0 +1,618 @@
+package mqtt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+	"github.com/Aryaman/pub-sub/services/pubsub"
+	"github.com/Aryaman/pub-sub/utils"
+	"github.com/google/uuid"
+)
+
+// DefaultMaxInflight is the default QoS 1 window of a client
+const DefaultMaxInflight = 100
+
+// clientPrefix namespaces MQTT client ids among a topic's subscribers so
+// they cannot collide with WebSocket clients
+const clientPrefix = "mqtt/"
+
+// Server is an MQTT 3.1.1 frontend for pubsub topics. MQTT topic names map
+// one-to-one onto pubsub topics, so MQTT and WebSocket clients share traffic.
+// Only clean sessions and QoS 0 and 1 are supported; the retained message of
+// a topic is the newest message in its ring buffer.
+type Server struct {
+	svc *pubsub.ServiceImpl
+
+	RetryInterval time.Duration // QoS 1 redelivery interval for unacknowledged messages
+	MaxPacketSize int           // largest accepted packet body; bigger packets close the connection
+	MaxInflight   int           // unacknowledged QoS 1 messages per client, at most 65535
+
+	ln        net.Listener
+	mu        sync.Mutex
+	sessions  map[string]*session // client id -> session
+	unobserve func()
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewServer creates an MQTT frontend backed by svc
+func NewServer(svc *pubsub.ServiceImpl) *Server {
+	srv := &Server{
+		svc:           svc,
+		RetryInterval: 10 * time.Second,
+		MaxPacketSize: DefaultMaxPacketSize,
+		MaxInflight:   DefaultMaxInflight,
+		sessions:      make(map[string]*session),
+		done:          make(chan struct{}),
+	}
+	srv.unobserve = svc.ObserveTopics(srv.topicChanged)
+	return srv
+}
+
+// Start listens on addr and serves MQTT clients
+func (srv *Server) Start(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for mqtt: %w", err)
+	}
+	go srv.Serve(ln)
+	return nil
+}
+
+// Serve accepts MQTT clients on ln until Close is called
+func (srv *Server) Serve(ln net.Listener) {
+	srv.mu.Lock()
+	srv.ln = ln
+	srv.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go srv.handleConn(conn)
+	}
+}
+
+// Close stops the listener and disconnects every client
+func (srv *Server) Close() error {
+	srv.closeOnce.Do(func() {
+		close(srv.done)
+		srv.unobserve()
+
+		srv.mu.Lock()
+		if srv.ln != nil {
+			srv.ln.Close()
+		}
+		sessions := make([]*session, 0, len(srv.sessions))
+		for _, sess := range srv.sessions {
+			sessions = append(sessions, sess)
+		}
+		srv.mu.Unlock()
+
+		for _, sess := range sessions {
+			sess.conn.Close()
+		}
+	})
+	return nil
+}
+
+// topicChanged attaches sessions whose filters match a newly created topic
+func (srv *Server) topicChanged(name string, created bool) {
+	if !created {
+		return
+	}
+
+	srv.mu.Lock()
+	sessions := make([]*session, 0, len(srv.sessions))
+	for _, sess := range srv.sessions {
+		sessions = append(sessions, sess)
+	}
+	srv.mu.Unlock()
+
+	for _, sess := range sessions {
+		sess.attachIfMatched(name)
+	}
+}
+
+// register makes sess the live session for its client id, taking over any
+// previous connection with the same id as the spec requires
+func (srv *Server) register(sess *session) {
+	srv.mu.Lock()
+	prev := srv.sessions[sess.clientID]
+	srv.sessions[sess.clientID] = sess
+	srv.mu.Unlock()
+
+	if prev != nil {
+		prev.conn.Close()
+	}
+}
+
+func (srv *Server) unregister(sess *session) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.sessions[sess.clientID] == sess {
+		delete(srv.sessions, sess.clientID)
+	}
+}
+
+// handleConn runs a client connection from CONNECT to disconnect
+func (srv *Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	// The first packet must arrive promptly and must be CONNECT
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	p, err := readPacket(r, srv.MaxPacketSize)
+	if err != nil || p.kind != packetConnect {
+		return
+	}
+	connect, err := parseConnect(p.body)
+	if err != nil {
+		return
+	}
+
+	sess := &session{
+		srv:      srv,
+		conn:     conn,
+		clientID: connect.clientID,
+		filters:  make(map[string]byte),
+		subs:     make(map[string]*topicSub),
+		inflight: make(map[uint16]*inflightMessage),
+		window:   make(chan struct{}, min(max(srv.MaxInflight, 1), 65535)),
+		stop:     make(chan struct{}),
+	}
+
+	if connect.protocol != "MQTT" || connect.level != 4 {
+		sess.writePacket(packetConnack, 0, []byte{0, connackBadProtocol})
+		return
+	}
+	if sess.clientID == "" {
+		if !connect.cleanSession {
+			sess.writePacket(packetConnack, 0, []byte{0, connackIdentifierRejected})
+			return
+		}
+		sess.clientID = uuid.New().String()
+	}
+
+	srv.register(sess)
+	defer srv.unregister(sess)
+	defer sess.detachAll()
+
+	// Sessions are always clean, so session present is never set
+	if err := sess.writePacket(packetConnack, 0, []byte{0, connackAccepted}); err != nil {
+		return
+	}
+
+	go sess.retryLoop()
+	defer close(sess.stop)
+
+	// Allow one and a half keepalive periods between packets
+	var idle time.Duration
+	if connect.keepAlive > 0 {
+		idle = time.Duration(connect.keepAlive) * time.Second * 3 / 2
+	}
+
+	for {
+		if idle > 0 {
+			conn.SetReadDeadline(time.Now().Add(idle))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+
+		p, err := readPacket(r, srv.MaxPacketSize)
+		if err != nil {
+			if errors.Is(err, errPacketTooLarge) {
+				log.Printf("mqtt: closing %s: %v", sess.clientID, err)
+			}
+			return
+		}
+		if err := sess.handlePacket(p); err != nil {
+			if !errors.Is(err, errDisconnect) {
+				log.Printf("mqtt: closing %s: %v", sess.clientID, err)
+			}
+			return
+		}
+	}
+}
+
+// errDisconnect signals a clean client-initiated disconnect
+var errDisconnect = errors.New("client disconnected")
+
+// topicSub is the pubsub subscription backing one matched topic
+type topicSub struct {
+	sub *sdk.Subscriber
+	qos byte
+}
+
+// inflightMessage is a QoS 1 delivery awaiting PUBACK
+type inflightMessage struct {
+	pub    publishPacket
+	sentAt time.Time
+}
+
+// session is one connected MQTT client
+type session struct {
+	srv      *Server
+	conn     net.Conn
+	clientID string
+	writeMu  sync.Mutex
+
+	mu       sync.Mutex
+	filters  map[string]byte      // topic filter -> granted QoS
+	subs     map[string]*topicSub // topic name -> subscription
+	nextID   uint16
+	inflight map[uint16]*inflightMessage
+	window   chan struct{} // one slot per in-flight message, so packet ids never run out
+	stop     chan struct{} // closed when the connection ends
+}
+
+func (sess *session) handlePacket(p packet) error {
+	switch p.kind {
+	case packetPublish:
+		return sess.handlePublish(p)
+	case packetPuback:
+		id, err := parsePacketID(p.body)
+		if err != nil {
+			return err
+		}
+		sess.mu.Lock()
+		if _, ok := sess.inflight[id]; ok {
+			delete(sess.inflight, id)
+			<-sess.window
+		}
+		sess.mu.Unlock()
+		return nil
+	case packetSubscribe:
+		return sess.handleSubscribe(p.body)
+	case packetUnsubscribe:
+		return sess.handleUnsubscribe(p.body)
+	case packetPingreq:
+		return sess.writePacket(packetPingresp, 0, nil)
+	case packetDisconnect:
+		return errDisconnect
+	default:
+		return fmt.Errorf("unsupported packet type %d", p.kind)
+	}
+}
+
+// handlePublish maps an inbound PUBLISH onto the pubsub topic of the same name
+func (sess *session) handlePublish(p packet) error {
+	pub, err := parsePublish(p)
+	if err != nil {
+		return err
+	}
+	if pub.qos > 1 {
+		return fmt.Errorf("qos %d is not supported", pub.qos)
+	}
+	if pub.topic == "" || strings.ContainsAny(pub.topic, "+#") {
+		return fmt.Errorf("invalid topic name %q", pub.topic)
+	}
+
+	msg := sdk.Message{ID: uuid.New().String(), Payload: utils.DecodePayload(pub.payload)}
+	if _, err := sess.srv.svc.PublishFrom(sess.clientID, pub.topic, msg); err != nil {
+		// MQTT has no negative acknowledgement; the message is dropped
+		log.Printf("mqtt: dropping publish from %s to %s: %v", sess.clientID, pub.topic, err)
+	}
+
+	if pub.qos == 1 {
+		return sess.writePacket(packetPuback, 0, appendUint16(nil, pub.packetID))
+	}
+	return nil
+}
+
+// handleSubscribe records the filters and attaches every matching topic
+func (sess *session) handleSubscribe(body []byte) error {
+	id, subs, err := parseSubscribe(body)
+	if err != nil {
+		return err
+	}
+
+	codes := make([]byte, 0, len(subs))
+	sess.mu.Lock()
+	for _, s := range subs {
+		if !validFilter(s.filter) {
+			codes = append(codes, subackFailure)
+			continue
+		}
+		granted := s.qos
+		if granted > 1 {
+			granted = 1
+		}
+		sess.filters[s.filter] = granted
+		codes = append(codes, granted)
+	}
+	sess.mu.Unlock()
+
+	suback, err := encodePacket(packetSuback, 0, append(appendUint16(nil, id), codes...))
+	if err != nil {
+		return err
+	}
+
+	// Attach while holding the write lock: once the client sees SUBACK it is
+	// subscribed, yet retained messages cannot overtake the SUBACK
+	sess.writeMu.Lock()
+	defer sess.writeMu.Unlock()
+	for _, name := range sess.srv.svc.TopicNames() {
+		sess.attachIfMatched(name)
+	}
+	return sess.writeLocked(suback)
+}
+
+// handleUnsubscribe drops filters and detaches topics no filter still matches
+func (sess *session) handleUnsubscribe(body []byte) error {
+	id, filters, err := parseUnsubscribe(body)
+	if err != nil {
+		return err
+	}
+
+	sess.mu.Lock()
+	for _, filter := range filters {
+		delete(sess.filters, filter)
+	}
+	var detach []string
+	for name := range sess.subs {
+		if _, ok := sess.grantedQoS(name); !ok {
+			detach = append(detach, name)
+		}
+	}
+	sess.mu.Unlock()
+
+	for _, name := range detach {
+		sess.detach(name)
+	}
+	return sess.writePacket(packetUnsuback, 0, appendUint16(nil, id))
+}
+
+// grantedQoS returns the highest QoS among filters matching a topic.
+// Caller must hold sess.mu.
+func (sess *session) grantedQoS(topic string) (byte, bool) {
+	var qos byte
+	matched := false
+	for filter, granted := range sess.filters {
+		if matchTopic(filter, topic) {
+			matched = true
+			if granted > qos {
+				qos = granted
+			}
+		}
+	}
+	return qos, matched
+}
+
+// attachIfMatched subscribes to a topic when one of the session's filters
+// matches it, delivering its retained message first
+func (sess *session) attachIfMatched(name string) {
+	sess.mu.Lock()
+	qos, matched := sess.grantedQoS(name)
+	if ts, exists := sess.subs[name]; exists || !matched {
+		if exists {
+			ts.qos = qos
+		}
+		sess.mu.Unlock()
+		return
+	}
+
+	sub := &sdk.Subscriber{
+		ClientID:     clientPrefix + sess.clientID,
+		Queue:        make(chan sdk.Message, sess.srv.svc.MaxQueue),
+		QueueSize:    sess.srv.svc.MaxQueue,
+		LastActive:   time.Now(),
+		CloseChannel: make(chan struct{}),
+	}
+	ts := &topicSub{sub: sub, qos: qos}
+	sess.subs[name] = ts
+	sess.mu.Unlock()
+
+	retained, err := sess.srv.svc.Subscribe(name, sub, 1)
+	if err != nil {
+		sess.mu.Lock()
+		delete(sess.subs, name)
+		sess.mu.Unlock()
+		return
+	}
+	go sess.forward(name, ts, retained)
+}
+
+// forward writes queued messages for one topic to the client
+func (sess *session) forward(name string, ts *topicSub, retained int) {
+	for {
+		select {
+		case msg := <-ts.sub.Queue:
+			sess.mu.Lock()
+			qos := ts.qos
+			sess.mu.Unlock()
+
+			if err := sess.deliver(name, msg, qos, retained > 0, ts.sub.CloseChannel); err != nil {
+				sess.conn.Close()
+				return
+			}
+			if retained > 0 {
+				retained--
+			}
+		case <-ts.sub.CloseChannel:
+			// Unsubscribed, evicted or topic deleted; forget the subscription
+			sess.mu.Lock()
+			if sess.subs[name] == ts {
+				delete(sess.subs, name)
+			}
+			sess.mu.Unlock()
+			return
+		}
+	}
+}
+
+// deliver sends one message as PUBLISH, tracking it until PUBACK for QoS 1.
+// A QoS 1 message waits for room in the window first; meanwhile the
+// subscriber queue fills up until the client is evicted as a slow consumer,
+// which closes closed.
+func (sess *session) deliver(topic string, msg sdk.Message, qos byte, retain bool, closed <-chan struct{}) error {
+	payload, err := utils.EncodePayload(msg.Payload)
+	if err != nil {
+		return err
+	}
+	pub := publishPacket{topic: topic, qos: qos, retain: retain, payload: payload}
+
+	if qos == 1 {
+		select {
+		case sess.window <- struct{}{}:
+		case <-closed:
+			return nil
+		case <-sess.stop:
+			return errDisconnect
+		}
+		sess.mu.Lock()
+		pub.packetID = sess.allocateID()
+		sess.inflight[pub.packetID] = &inflightMessage{pub: pub, sentAt: time.Now()}
+		sess.mu.Unlock()
+	}
+	return sess.writePublish(pub)
+}
+
+// allocateID returns a packet id not in flight. The window keeps fewer
+// than 65535 messages in flight, so one is always free. Caller must hold
+// sess.mu.
+func (sess *session) allocateID() uint16 {
+	for {
+		sess.nextID++
+		if sess.nextID == 0 {
+			continue
+		}
+		if _, busy := sess.inflight[sess.nextID]; !busy {
+			return sess.nextID
+		}
+	}
+}
+
+// retryLoop redelivers unacknowledged QoS 1 messages with the DUP flag
+func (sess *session) retryLoop() {
+	ticker := time.NewTicker(sess.srv.RetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sess.stop:
+			return
+		case now := <-ticker.C:
+			sess.mu.Lock()
+			var due []publishPacket
+			for _, m := range sess.inflight {
+				if now.Sub(m.sentAt) >= sess.srv.RetryInterval {
+					m.pub.dup = true
+					m.sentAt = now
+					due = append(due, m.pub)
+				}
+			}
+			sess.mu.Unlock()
+
+			for _, pub := range due {
+				if err := sess.writePublish(pub); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// detach drops the pubsub subscription for one topic
+func (sess *session) detach(name string) {
+	sess.mu.Lock()
+	ts, ok := sess.subs[name]
+	delete(sess.subs, name)
+	sess.mu.Unlock()
+
+	if ok {
+		sess.srv.svc.Detach(name, ts.sub)
+	}
+}
+
+// detachAll drops every subscription when the connection ends
+func (sess *session) detachAll() {
+	sess.mu.Lock()
+	names := make([]string, 0, len(sess.subs))
+	for name := range sess.subs {
+		names = append(names, name)
+	}
+	sess.mu.Unlock()
+
+	for _, name := range names {
+		sess.detach(name)
+	}
+}
+
+func (sess *session) writePublish(pub publishPacket) error {
+	data, err := pub.encode()
+	if err != nil {
+		return err
+	}
+	return sess.write(data)
+}
+
+func (sess *session) writePacket(kind, flags byte, body []byte) error {
+	data, err := encodePacket(kind, flags, body)
+	if err != nil {
+		return err
+	}
+	return sess.write(data)
+}
+
+func (sess *session) write(data []byte) error {
+	sess.writeMu.Lock()
+	defer sess.writeMu.Unlock()
+	return sess.writeLocked(data)
+}
+
+// writeLocked writes data. Caller must hold sess.writeMu.
+func (sess *session) writeLocked(data []byte) error {
+	sess.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := sess.conn.Write(data)
+	return err
+}
+
+// matchTopic reports whether an MQTT topic filter matches a topic name
+func matchTopic(filter, topic string) bool {
+	// Wildcards at the first level never match topics starting with '$'
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	for i, part := range f {
+		if part == "#" {
+			return true
+		}
+		if i >= len(t) {
+			return false
+		}
+		if part != "+" && part != t[i] {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
+
+// validFilter checks wildcard placement: '#' only as the whole last level,
+// '+' only as a whole level
+func validFilter(filter string) bool {
+	if filter == "" {
+		return false
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return false
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return false
+		}
+	}
+	return true
+}
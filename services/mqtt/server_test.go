@@ -0,0 +1,242 @@
+package mqtt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+	"github.com/Aryaman/pub-sub/services/pubsub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testClient speaks raw MQTT using the package codec
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startServer(t *testing.T, svc *pubsub.ServiceImpl, opts ...func(*Server)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := NewServer(svc)
+	for _, opt := range opts {
+		opt(srv)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+func connect(t *testing.T, addr, clientID string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	c := &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, 0x02)
+	body = appendUint16(body, 30)
+	body = appendString(body, clientID)
+	c.send(packetConnect, 0, body)
+
+	p := c.read()
+	require.Equal(t, packetConnack, p.kind)
+	require.Equal(t, []byte{0, connackAccepted}, p.body)
+	return c
+}
+
+func (c *testClient) send(kind, flags byte, body []byte) {
+	c.t.Helper()
+	data, err := encodePacket(kind, flags, body)
+	require.NoError(c.t, err)
+	_, err = c.conn.Write(data)
+	require.NoError(c.t, err)
+}
+
+func (c *testClient) read() packet {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	p, err := readPacket(c.r, maxRemainingLength)
+	require.NoError(c.t, err)
+	return p
+}
+
+func (c *testClient) subscribe(filter string, qos byte) []byte {
+	c.t.Helper()
+	body := appendUint16(nil, 1)
+	body = appendString(body, filter)
+	c.send(packetSubscribe, 0x02, append(body, qos))
+
+	p := c.read()
+	require.Equal(c.t, packetSuback, p.kind)
+	return p.body[2:]
+}
+
+func TestMatchTopic(t *testing.T) {
+	assert.True(t, matchTopic("sensors/+/temp", "sensors/a/temp"))
+	assert.True(t, matchTopic("sensors/#", "sensors/a/temp"))
+	assert.True(t, matchTopic("sensors/#", "sensors"))
+	assert.False(t, matchTopic("sensors/+", "sensors/a/temp"))
+	assert.False(t, matchTopic("#", "$SYS/uptime"))
+	assert.True(t, validFilter("a/+/#"))
+	assert.False(t, validFilter("a/b#"))
+	assert.False(t, validFilter("a/#/b"))
+}
+
+func TestWildcardSubscriptionReceivesPubSubMessages(t *testing.T) {
+	svc := pubsub.NewService(10, 10)
+	require.NoError(t, svc.AddTopic("sensors/a/temp"))
+	addr := startServer(t, svc)
+
+	c := connect(t, addr, "dev-1")
+	assert.Equal(t, []byte{1, subackFailure}, append(c.subscribe("sensors/+/temp", 1), c.subscribe("bad#", 0)...))
+
+	_, err := svc.Publish("sensors/a/temp", sdk.Message{ID: "m1", Payload: map[string]interface{}{"c": 21.5}})
+	require.NoError(t, err)
+
+	p := c.read()
+	require.Equal(t, packetPublish, p.kind)
+	pub, err := parsePublish(p)
+	require.NoError(t, err)
+	assert.Equal(t, "sensors/a/temp", pub.topic)
+	assert.Equal(t, byte(1), pub.qos)
+	assert.JSONEq(t, `{"c":21.5}`, string(pub.payload))
+	c.send(packetPuback, 0, appendUint16(nil, pub.packetID))
+
+	// Topics created after SUBSCRIBE are picked up by the filter too
+	require.NoError(t, svc.AddTopic("sensors/b/temp"))
+	_, err = svc.Publish("sensors/b/temp", sdk.Message{ID: "m2", Payload: "warm"})
+	require.NoError(t, err)
+
+	pub, err = parsePublish(c.read())
+	require.NoError(t, err)
+	assert.Equal(t, "sensors/b/temp", pub.topic)
+	assert.Equal(t, "warm", string(pub.payload))
+}
+
+func TestPublishReachesPubSubSubscribers(t *testing.T) {
+	svc := pubsub.NewService(10, 10)
+	require.NoError(t, svc.AddTopic("orders"))
+	addr := startServer(t, svc)
+
+	sub := &sdk.Subscriber{
+		ClientID:     "ws-1",
+		Queue:        make(chan sdk.Message, 10),
+		QueueSize:    10,
+		CloseChannel: make(chan struct{}),
+	}
+	_, err := svc.Subscribe("orders", sub, 0)
+	require.NoError(t, err)
+
+	c := connect(t, addr, "dev-1")
+	body := appendString(nil, "orders")
+	body = appendUint16(body, 7)
+	c.send(packetPublish, 0x02, append(body, `{"id":42}`...))
+
+	p := c.read()
+	require.Equal(t, packetPuback, p.kind)
+	assert.Equal(t, appendUint16(nil, 7), p.body)
+
+	select {
+	case msg := <-sub.Queue:
+		assert.Equal(t, map[string]interface{}{"id": float64(42)}, msg.Payload)
+		assert.NotEmpty(t, msg.ID)
+	case <-time.After(2 * time.Second):
+		t.Fatal("message not delivered")
+	}
+}
+
+func TestSubscribeDeliversRetainedMessage(t *testing.T) {
+	svc := pubsub.NewService(10, 10)
+	require.NoError(t, svc.AddTopic("status"))
+	_, err := svc.Publish("status", sdk.Message{ID: "m1", Payload: "old"})
+	require.NoError(t, err)
+	_, err = svc.Publish("status", sdk.Message{ID: "m2", Payload: "online"})
+	require.NoError(t, err)
+	addr := startServer(t, svc)
+
+	c := connect(t, addr, "dev-1")
+	c.subscribe("status", 0)
+
+	pub, err := parsePublish(c.read())
+	require.NoError(t, err)
+	assert.True(t, pub.retain)
+	assert.Equal(t, "online", string(pub.payload))
+
+	// Live messages are not flagged as retained
+	_, err = svc.Publish("status", sdk.Message{ID: "m3", Payload: "busy"})
+	require.NoError(t, err)
+	pub, err = parsePublish(c.read())
+	require.NoError(t, err)
+	assert.False(t, pub.retain)
+	assert.Equal(t, "busy", string(pub.payload))
+}
+
+func TestOversizedPacketClosesConnection(t *testing.T) {
+	svc := pubsub.NewService(10, 10)
+	require.NoError(t, svc.AddTopic("orders"))
+	addr := startServer(t, svc)
+
+	// Only the header is sent: the declared length alone must be refused
+	// before any body is allocated or read
+	c := connect(t, addr, "dev-1")
+	_, err := c.conn.Write([]byte{packetPublish << 4, 0xff, 0xff, 0xff, 0x7f})
+	require.NoError(t, err)
+
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = c.r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// The CONNECT packet is held to the limit as well
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte{packetConnect << 4, 0x80, 0x80, 0x80, 0x01})
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestInflightWindowHoldsBackDeliveries(t *testing.T) {
+	svc := pubsub.NewService(3, 10)
+	require.NoError(t, svc.AddTopic("orders"))
+	addr := startServer(t, svc, func(srv *Server) { srv.MaxInflight = 2 })
+
+	c := connect(t, addr, "dev-1")
+	c.subscribe("orders", 1)
+	for i := 1; i <= 3; i++ {
+		_, err := svc.Publish("orders", sdk.Message{ID: fmt.Sprint(i), Payload: i})
+		require.NoError(t, err)
+	}
+
+	// Two messages fill the window; the third waits for a PUBACK
+	var ids []uint16
+	for i := 0; i < 2; i++ {
+		pub, err := parsePublish(c.read())
+		require.NoError(t, err)
+		ids = append(ids, pub.packetID)
+	}
+	c.conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, err := c.r.ReadByte()
+	require.Error(t, err)
+	c.send(packetPuback, 0, appendUint16(nil, ids[0]))
+	pub, err := parsePublish(c.read())
+	require.NoError(t, err)
+	assert.Equal(t, "3", string(pub.payload))
+
+	// A client that never acknowledges is evicted once its queue overflows
+	for i := 4; i <= 10; i++ {
+		_, err := svc.Publish("orders", sdk.Message{ID: fmt.Sprint(i), Payload: i})
+		require.NoError(t, err)
+	}
+	assert.Eventually(t, func() bool { return svc.SubscriberCount("orders") == 0 }, 2*time.Second, 10*time.Millisecond)
+}
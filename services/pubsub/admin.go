@@ -0,0 +1,228 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Aryaman/pub-sub/sdk"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Topic administration applies to the node that handles the request
+
+// Describe returns a topic's configuration and counters
+func (s *ServiceImpl) Describe(name string) (sdk.TopicDetail, error) {
+	topic, ok := s.getTopic(name)
+	if !ok {
+		return sdk.TopicDetail{}, ErrTopicNotFound
+	}
+
+	topic.mu.RLock()
+	detail := sdk.TopicDetail{
+		Name:        name,
+		MaxMessages: topic.maxMessages,
+		Compacted:   topic.state != nil,
+		Keys:        len(topic.state),
+		AutoCreated: !topic.createdAt.IsZero(),
+		Paused:      topic.paused,
+		LastSeq:     topic.lastSeq,
+		Messages:    topic.ring.len(),
+	}
+	if topic.paused {
+		detail.Pending = len(topic.ring.after(topic.pausedAt))
+	}
+	topic.mu.RUnlock()
+
+	limit := s.RateLimits.Topic
+	if override := topic.limit.Load(); override != nil {
+		limit = *override
+	}
+	if limit.enabled() {
+		detail.RateLimit = &sdk.TopicRateLimit{MessagesPerSec: limit.Messages, BytesPerSec: limit.Bytes}
+	}
+	detail.Subscribers = len(topic.subscriberList())
+	detail.Published = topic.published.Load()
+	detail.Delivered = topic.delivered.Load()
+	detail.Evicted = topic.evicted.Load()
+	detail.Exclusive = topic.exclusive.detail()
+	return detail, nil
+}
+
+// Configure changes a topic's retention, compaction and publish rate limit.
+// Shrinking the retention drops the oldest messages, except the current
+// values of a compacted topic. Compaction cannot be enabled in cluster mode.
+func (s *ServiceImpl) Configure(name string, req sdk.UpdateTopicRequest) error {
+	if s.Cluster != nil && req.Compacted != nil && *req.Compacted {
+		return ErrCompactedClustered
+	}
+	topic, ok := s.getTopic(name)
+	if !ok {
+		return ErrTopicNotFound
+	}
+
+	topic.configure(req)
+	return nil
+}
+
+// configure applies the set fields of req to the topic
+func (t *topic) configure(req sdk.UpdateTopicRequest) {
+	if req.MaxMessages != nil || req.Compacted != nil {
+		t.mu.Lock()
+		if req.MaxMessages != nil {
+			t.maxMessages = *req.MaxMessages
+			t.ring.resize(*req.MaxMessages)
+		}
+		if req.Compacted != nil {
+			t.setCompacted(*req.Compacted)
+		}
+		t.mu.Unlock()
+	}
+	if req.RateLimit != nil {
+		t.limit.Store(&RateLimit{Messages: req.RateLimit.MessagesPerSec, Bytes: req.RateLimit.BytesPerSec})
+	}
+}
+
+// Purge drops every retained message, including any held back by a pause
+// and the state of a compacted topic
+func (s *ServiceImpl) Purge(name string) error {
+	topic, ok := s.getTopic(name)
+	if !ok {
+		return ErrTopicNotFound
+	}
+
+	topic.mu.Lock()
+	defer topic.mu.Unlock()
+	topic.clear()
+	return nil
+}
+
+// Pause stops delivery on a topic. Publishes are still accepted and held in
+// the ring buffer, so at most MaxMessages of them survive until resume.
+func (s *ServiceImpl) Pause(name string) error {
+	topic, ok := s.getTopic(name)
+	if !ok {
+		return ErrTopicNotFound
+	}
+
+	topic.mu.Lock()
+	defer topic.mu.Unlock()
+	if !topic.paused {
+		topic.paused = true
+		topic.pausedAt = topic.lastSeq
+	}
+	return nil
+}
+
+// Resume restarts delivery on a topic, first flushing the messages still
+// buffered since the pause in order
+func (s *ServiceImpl) Resume(name string) error {
+	topic, ok := s.getTopic(name)
+	if !ok {
+		return ErrTopicNotFound
+	}
+
+	// Publishers wait on fanMu, so nothing new overtakes the flush
+	topic.fanMu.Lock()
+	defer topic.fanMu.Unlock()
+
+	topic.mu.Lock()
+	if !topic.paused {
+		topic.mu.Unlock()
+		return nil
+	}
+	pending := topic.ring.after(topic.pausedAt)
+	topic.paused = false
+	subs := topic.subscriberList()
+	topic.mu.Unlock()
+
+	deliver := s.delivery(name)
+	for _, msg := range pending {
+		topic.fanOut(subs, msg, deliver, s.traceFanOut(name, msg))
+	}
+	return nil
+}
+
+// topicAdminError writes the HTTP response for a failed admin operation
+func topicAdminError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, ErrTopicNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(sdk.ErrorResponse{
+			Error: "topic not found",
+		})
+	}
+	if errors.Is(err, ErrCompactedClustered) {
+		return c.Status(fiber.StatusBadRequest).JSON(sdk.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(sdk.ErrorResponse{
+		Error: err.Error(),
+	})
+}
+
+// describeTopic writes the topic detail as the response
+func (s *ServiceImpl) describeTopic(c *fiber.Ctx, name string) error {
+	detail, err := s.Describe(name)
+	if err != nil {
+		return topicAdminError(c, err)
+	}
+	return c.JSON(detail)
+}
+
+// GetTopic returns a topic's configuration and counters via REST API
+func (s *ServiceImpl) GetTopic(ctx context.Context, c *fiber.Ctx) error {
+	return s.describeTopic(c, c.Params("name"))
+}
+
+// UpdateTopic changes a topic's retention, compaction or rate limit via REST API
+func (s *ServiceImpl) UpdateTopic(ctx context.Context, c *fiber.Ctx) error {
+	var req sdk.UpdateTopicRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(sdk.ErrorResponse{
+			Error: "invalid request body",
+		})
+	}
+	if req.MaxMessages != nil && *req.MaxMessages < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(sdk.ErrorResponse{
+			Error: "max_messages must not be negative",
+		})
+	}
+	if req.RateLimit != nil && (req.RateLimit.MessagesPerSec < 0 || req.RateLimit.BytesPerSec < 0) {
+		return c.Status(fiber.StatusBadRequest).JSON(sdk.ErrorResponse{
+			Error: "rate limits must not be negative",
+		})
+	}
+
+	name := c.Params("name")
+	if err := s.Configure(name, req); err != nil {
+		return topicAdminError(c, err)
+	}
+	return s.describeTopic(c, name)
+}
+
+// PurgeTopic clears a topic's ring buffer via REST API
+func (s *ServiceImpl) PurgeTopic(ctx context.Context, c *fiber.Ctx) error {
+	name := c.Params("name")
+	if err := s.Purge(name); err != nil {
+		return topicAdminError(c, err)
+	}
+	return s.describeTopic(c, name)
+}
+
+// PauseTopic stops delivery on a topic via REST API
+func (s *ServiceImpl) PauseTopic(ctx context.Context, c *fiber.Ctx) error {
+	name := c.Params("name")
+	if err := s.Pause(name); err != nil {
+		return topicAdminError(c, err)
+	}
+	return s.describeTopic(c, name)
+}
+
+// ResumeTopic restarts delivery on a topic via REST API
+func (s *ServiceImpl) ResumeTopic(ctx context.Context, c *fiber.Ctx) error {
+	name := c.Params("name")
+	if err := s.Resume(name); err != nil {
+		return topicAdminError(c, err)
+	}
+	return s.describeTopic(c, name)
+}
@@ -0,0 +1,149 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+)
+
+// AutoCreateMode selects which operations create an unknown topic
+type AutoCreateMode string
+
+const (
+	AutoCreateOff         AutoCreateMode = "off"
+	AutoCreateOnPublish   AutoCreateMode = "on_publish"
+	AutoCreateOnSubscribe AutoCreateMode = "on_subscribe"
+	AutoCreateBoth        AutoCreateMode = "both"
+)
+
+// ParseAutoCreateMode validates a mode name; empty means off
+func ParseAutoCreateMode(s string) (AutoCreateMode, error) {
+	switch mode := AutoCreateMode(s); mode {
+	case "":
+		return AutoCreateOff, nil
+	case AutoCreateOff, AutoCreateOnPublish, AutoCreateOnSubscribe, AutoCreateBoth:
+		return mode, nil
+	default:
+		return AutoCreateOff, fmt.Errorf("unknown auto-create mode %q", s)
+	}
+}
+
+// allows reports whether the mode creates topics for op, which is
+// AutoCreateOnPublish or AutoCreateOnSubscribe
+func (m AutoCreateMode) allows(op AutoCreateMode) bool {
+	return m == op || m == AutoCreateBoth
+}
+
+// AutoCreatePolicy decides whether publishing or subscribing to an unknown
+// topic creates it instead of failing with ErrTopicNotFound. Namespaces,
+// the part of a topic name before the first '.', can override the
+// server-wide mode.
+type AutoCreatePolicy struct {
+	Mode       AutoCreateMode
+	Namespaces map[string]AutoCreateMode
+
+	// Settings are applied to every topic the policy creates
+	Settings sdk.UpdateTopicRequest
+
+	// IdleTimeout removes auto-created topics that have had no subscribers
+	// and no new messages for this long, 0 keeps them. Topics created
+	// through the API are never removed.
+	IdleTimeout time.Duration
+}
+
+// modeFor returns the mode that applies to a topic
+func (p AutoCreatePolicy) modeFor(name string) AutoCreateMode {
+	if mode, ok := p.Namespaces[namespaceOf(name)]; ok {
+		return mode
+	}
+	return p.Mode
+}
+
+// createsOn reports whether op on name creates the topic when it is missing
+func (s *ServiceImpl) createsOn(name string, op AutoCreateMode) bool {
+	return name != "" && !isInbox(name) && s.AutoCreate.modeFor(name).allows(op)
+}
+
+// autoCreate creates name with the policy's settings when it does not exist
+// and the policy allows op. Otherwise the topic is left alone and the
+// caller's lookup reports ErrTopicNotFound.
+func (s *ServiceImpl) autoCreate(name string, op AutoCreateMode) error {
+	if !s.createsOn(name, op) || s.HasTopic(name) {
+		return nil
+	}
+
+	if s.Cluster != nil {
+		// Created on every node; only this one knows it was automatic
+		if err := s.Cluster.CreateTopic(name); err != nil && !errors.Is(err, ErrTopicExists) {
+			return err
+		}
+		if t, ok := s.getTopic(name); ok {
+			t.configure(s.AutoCreate.Settings)
+			t.mu.Lock()
+			t.createdAt = time.Now()
+			t.mu.Unlock()
+		}
+		return nil
+	}
+
+	// Configured before it is registered, so no publish sees the defaults
+	t := newTopic(name, s.MaxMessages)
+	t.configure(s.AutoCreate.Settings)
+	t.createdAt = time.Now()
+	if err := s.addTopic(t); err != nil && !errors.Is(err, ErrTopicExists) {
+		return err
+	}
+	return nil
+}
+
+// idleSince returns when an auto-created topic was last used: its newest
+// message, or its creation when it has none. Caller must hold t.mu.
+func (t *topic) idleSince() time.Time {
+	last := t.createdAt
+	if n := t.ring.len(); n > 0 {
+		if ts := t.ring.at(n - 1).TS; ts.After(last) {
+			last = ts
+		}
+	}
+	return last
+}
+
+// collectIdleTopics removes the auto-created topics that have been idle for
+// longer than the policy allows and returns their names. In cluster mode
+// idle topics are kept, since subscribers on other nodes are not visible
+// here.
+func (s *ServiceImpl) collectIdleTopics(now time.Time) []string {
+	if s.AutoCreate.IdleTimeout <= 0 || s.Cluster != nil {
+		return nil
+	}
+
+	var removed []string
+	for _, t := range s.topics.all() {
+		if s.removeIfIdle(t, now) {
+			removed = append(removed, t.name)
+		}
+	}
+	return removed
+}
+
+// removeIfIdle removes t if it is auto-created and idle. Publishes append
+// under fanMu and subscriptions register under mu, so with both held
+// nothing can use the topic between the check and its removal; publishes
+// and subscriptions that looked it up earlier find it gone once they get
+// the lock.
+func (s *ServiceImpl) removeIfIdle(t *topic, now time.Time) bool {
+	t.fanMu.Lock()
+	t.mu.Lock()
+	idle := !t.createdAt.IsZero() && len(t.subscriberList()) == 0 && now.Sub(t.idleSince()) >= s.AutoCreate.IdleTimeout
+	removed := idle && s.topics.removeTopic(t)
+	t.mu.Unlock()
+	t.fanMu.Unlock()
+
+	if removed {
+		t.closeAll()
+		s.notifyTopic(t.name, false)
+	}
+	return removed
+}
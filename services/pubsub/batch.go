@@ -0,0 +1,107 @@
+package pubsub
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+)
+
+// maxBatch caps publish_batch length and event_batch size
+const maxBatch = 1000
+
+// publishBatch publishes each message in order on behalf of clientID and
+// reports a result per message. Rate limits apply message by message. A
+// missing topic fails the whole batch with ErrTopicNotFound.
+func (s *ServiceImpl) publishBatch(clientID, topic string, msgs []sdk.Message) ([]sdk.PublishResult, error) {
+	results := make([]sdk.PublishResult, len(msgs))
+	for i, msg := range msgs {
+		results[i] = sdk.PublishResult{ID: msg.ID, Status: sdk.StatusOK}
+		if msg.ID == "" {
+			results[i].Status = sdk.StatusError
+			results[i].Error = &sdk.ErrorDetail{Code: sdk.ErrorCodeBadRequest, Message: "message id required"}
+			continue
+		}
+
+		stored, err := s.PublishFrom(clientID, topic, msg)
+		if errors.Is(err, ErrTopicNotFound) {
+			return nil, err
+		}
+		if err != nil {
+			results[i].Status = sdk.StatusError
+			results[i].Error = topicErrorFrame("", err).Error
+			continue
+		}
+		results[i].Seq = stored.Seq
+	}
+	return results, nil
+}
+
+// batchWriter coalesces queued messages into event_batch frames of up to
+// size messages, holding a partial batch back for at most wait. With no
+// wait, a batch is whatever is already queued. A held batch is sent when the
+// subscription closes.
+func (s *ServiceImpl) batchWriter(sub *sdk.Subscriber, topic string, size int, wait time.Duration, sendMessage func(string, interface{})) {
+	var (
+		batch   []sdk.Message
+		timer   *time.Timer
+		timeout <-chan time.Time
+	)
+
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, timeout = nil, nil
+		}
+		if len(batch) == 0 {
+			return
+		}
+		sub.LastActive = time.Now()
+		sendMessage("event_batch", sdk.WebSocketResponse{
+			Type:      sdk.MessageTypeEventBatch,
+			Topic:     topic,
+			Messages:  batch,
+			Timestamp: batch[len(batch)-1].TS.Format(time.RFC3339),
+		})
+		// The frame keeps the slice, so start a fresh one
+		batch = nil
+		sub.Held.Store(0)
+	}
+
+	for {
+		select {
+		case msg := <-sub.Queue:
+			batch = append(batch, msg)
+			// Take whatever else is already queued
+		drain:
+			for len(batch) < size {
+				select {
+				case msg := <-sub.Queue:
+					batch = append(batch, msg)
+				default:
+					break drain
+				}
+			}
+
+			if len(batch) >= size || wait <= 0 {
+				flush()
+				continue
+			}
+			sub.Held.Store(int64(len(batch)))
+			if timer == nil {
+				timer = time.NewTimer(wait)
+				timeout = timer.C
+			}
+		case <-timeout:
+			timer, timeout = nil, nil
+			flush()
+		case <-sub.CloseChannel:
+			// Messages already taken off the queue still go out
+			flush()
+			if sub.Evicted.Load() {
+				sendMessage("error", slowConsumerFrame(topic))
+			}
+			return
+		}
+	}
+}
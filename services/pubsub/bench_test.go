@@ -0,0 +1,231 @@
+package pubsub
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+)
+
+// Benchmarks comparing the sharded, copy-on-write engine with the previous
+// design, reproduced below as legacyEngine. Run with
+//
+//	go test ./services/pubsub -run '^$' -bench 'Engine|Retention' -benchmem
+
+const benchSubscribers = 10000
+
+// engine is the slice of the service the benchmarks drive
+type engine interface {
+	addTopic(name string)
+	publish(name string, msg sdk.Message)
+	subscribe(name string, sub *sdk.Subscriber)
+	unsubscribe(name, clientID string)
+}
+
+type serviceEngine struct{ s *ServiceImpl }
+
+func (e serviceEngine) addTopic(name string) { e.s.AddTopic(name) }
+func (e serviceEngine) publish(name string, msg sdk.Message) {
+	e.s.Publish(name, msg)
+}
+func (e serviceEngine) subscribe(name string, sub *sdk.Subscriber) {
+	e.s.Subscribe(name, sub, 0)
+}
+func (e serviceEngine) unsubscribe(name, clientID string) { e.s.Unsubscribe(name, clientID) }
+
+// legacyEngine is the hot path before the registry rewrite: one RWMutex
+// over the topic map, the topic lock held across the whole fan-out and a
+// message buffer trimmed by re-slicing
+type legacyEngine struct {
+	mu          sync.RWMutex
+	topics      map[string]*legacyTopic
+	maxMessages int
+}
+
+type legacyTopic struct {
+	mu          sync.RWMutex
+	subscribers map[string]*sdk.Subscriber
+	messages    []sdk.Message
+	lastSeq     uint64
+}
+
+func newLegacyEngine(maxMessages int) *legacyEngine {
+	return &legacyEngine{topics: make(map[string]*legacyTopic), maxMessages: maxMessages}
+}
+
+func (e *legacyEngine) topic(name string) *legacyTopic {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.topics[name]
+}
+
+func (e *legacyEngine) addTopic(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.topics[name] = &legacyTopic{
+		subscribers: make(map[string]*sdk.Subscriber),
+		messages:    make([]sdk.Message, 0, e.maxMessages),
+	}
+}
+
+func (e *legacyEngine) publish(name string, msg sdk.Message) {
+	t := e.topic(name)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	msg.TS = time.Now().UTC()
+	t.lastSeq++
+	msg.Seq = t.lastSeq
+	if len(t.messages) >= e.maxMessages {
+		t.messages = t.messages[1:]
+	}
+	t.messages = append(t.messages, msg)
+
+	slow := make([]string, 0)
+	for clientID, sub := range t.subscribers {
+		select {
+		case sub.Queue <- msg:
+		default:
+			slow = append(slow, clientID)
+		}
+	}
+	for _, clientID := range slow {
+		close(t.subscribers[clientID].CloseChannel)
+		delete(t.subscribers, clientID)
+	}
+}
+
+func (e *legacyEngine) subscribe(name string, sub *sdk.Subscriber) {
+	t := e.topic(name)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.subscribers[sub.ClientID] = sub
+}
+
+func (e *legacyEngine) unsubscribe(name, clientID string) {
+	t := e.topic(name)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if sub, ok := t.subscribers[clientID]; ok {
+		close(sub.CloseChannel)
+		delete(t.subscribers, clientID)
+	}
+}
+
+func benchEngines() map[string]func() engine {
+	return map[string]func() engine{
+		"legacy":  func() engine { return newLegacyEngine(1000) },
+		"sharded": func() engine { return serviceEngine{NewService(1000, 1000)} },
+	}
+}
+
+// attachDrained subscribes n clients spread over topics, each drained by its
+// own goroutine until stop closes
+func attachDrained(e engine, topics []string, n int, stop <-chan struct{}, wg *sync.WaitGroup) {
+	for i := 0; i < n; i++ {
+		sub := createTestSubscriber(fmt.Sprintf("sub-%d", i), 1000)
+		e.subscribe(topics[i%len(topics)], sub)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-sub.Queue:
+				case <-sub.CloseChannel:
+					return
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+}
+
+func topicNames(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("topic-%d", i)
+	}
+	return names
+}
+
+// BenchmarkEnginePublish10kSubscribers publishes to one topic with 10k
+// subscribers
+func BenchmarkEnginePublish10kSubscribers(b *testing.B) {
+	for name, newEngine := range benchEngines() {
+		b.Run(name, func(b *testing.B) {
+			e := newEngine()
+			e.addTopic("fanout")
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			attachDrained(e, []string{"fanout"}, benchSubscribers, stop, &wg)
+			defer func() { close(stop); wg.Wait() }()
+
+			msg := sdk.Message{ID: "m", Payload: "benchmark payload"}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				e.publish("fanout", msg)
+			}
+		})
+	}
+}
+
+// BenchmarkEngineParallelPublishManyTopics publishes from every CPU across
+// 1k topics sharing 10k subscribers
+func BenchmarkEngineParallelPublishManyTopics(b *testing.B) {
+	for name, newEngine := range benchEngines() {
+		b.Run(name, func(b *testing.B) {
+			e := newEngine()
+			topics := topicNames(1000)
+			for _, topic := range topics {
+				e.addTopic(topic)
+			}
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			attachDrained(e, topics, benchSubscribers, stop, &wg)
+			defer func() { close(stop); wg.Wait() }()
+
+			var next atomic.Int64
+			msg := sdk.Message{ID: "m", Payload: "benchmark payload"}
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(next.Add(1)) * 7919
+				for pb.Next() {
+					e.publish(topics[i%len(topics)], msg)
+					i++
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkRetention compares the fixed ring with the re-sliced buffer it
+// replaced once both are full
+func BenchmarkRetention(b *testing.B) {
+	const capacity = 1000
+	msg := sdk.Message{ID: "m", Payload: "benchmark payload"}
+
+	b.Run("reslice", func(b *testing.B) {
+		messages := make([]sdk.Message, 0, capacity)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if len(messages) >= capacity {
+				messages = messages[1:]
+			}
+			messages = append(messages, msg)
+		}
+	})
+
+	b.Run("ring", func(b *testing.B) {
+		r := newRing(capacity)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			r.push(msg)
+		}
+	})
+}
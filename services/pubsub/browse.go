@@ -0,0 +1,200 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultBrowseLimit = 100
+	maxBrowseLimit     = 1000
+)
+
+// BrowseQuery selects retained messages. Zero fields do not filter.
+type BrowseQuery struct {
+	ID         string
+	Key        string
+	AfterSeq   uint64
+	BeforeSeq  uint64
+	Since      time.Time
+	Until      time.Time
+	Attributes map[string]string // attribute -> exact value
+	Payload    map[string]string // dotted payload field path -> value
+	Limit      int
+}
+
+// matches reports whether msg passes every filter but the sequence bounds
+func (q BrowseQuery) matches(msg sdk.Message) bool {
+	if q.ID != "" && msg.ID != q.ID {
+		return false
+	}
+	if q.Key != "" && msg.Key != q.Key {
+		return false
+	}
+	if !q.Since.IsZero() && msg.TS.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !msg.TS.Before(q.Until) {
+		return false
+	}
+	for key, value := range q.Attributes {
+		if got, ok := msg.Attributes[key]; !ok || got != value {
+			return false
+		}
+	}
+	for path, value := range q.Payload {
+		if got, ok := payloadField(msg.Payload, path); !ok || got != value {
+			return false
+		}
+	}
+	return true
+}
+
+// payloadField returns the field at a dotted path of a JSON object payload,
+// rendered as a string: strings as is, anything else as JSON
+func payloadField(payload interface{}, path string) (string, bool) {
+	value, ok := payloadValue(payload, path)
+	if !ok {
+		return "", false
+	}
+	if str, ok := value.(string); ok {
+		return str, true
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// payloadValue returns the field at a dotted path of a JSON object payload
+func payloadValue(payload interface{}, path string) (interface{}, bool) {
+	value := payload
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// Browse pages through a topic's retained messages on this node, oldest
+// first. It only reads the ring buffer, so delivery is unaffected. It also
+// reports whether more messages match past the page.
+func (s *ServiceImpl) Browse(name string, q BrowseQuery) ([]sdk.Message, bool, error) {
+	topic, ok := s.getTopic(name)
+	if !ok {
+		return nil, false, ErrTopicNotFound
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultBrowseLimit
+	}
+
+	topic.mu.RLock()
+	defer topic.mu.RUnlock()
+
+	var (
+		page []sdk.Message
+		more bool
+	)
+	topic.ring.scan(q.AfterSeq, func(msg sdk.Message) bool {
+		if q.BeforeSeq != 0 && msg.Seq >= q.BeforeSeq {
+			return false
+		}
+		if !q.matches(msg) {
+			return true
+		}
+		if len(page) == q.Limit {
+			more = true
+			return false
+		}
+		page = append(page, msg)
+		return true
+	})
+	return page, more, nil
+}
+
+// parseBrowseQuery reads a BrowseQuery from the request's query string
+func parseBrowseQuery(c *fiber.Ctx) (BrowseQuery, string) {
+	q := BrowseQuery{
+		ID:         c.Query("id"),
+		Key:        c.Query("key"),
+		Attributes: make(map[string]string),
+		Payload:    make(map[string]string),
+	}
+
+	var problem string
+	c.Context().QueryArgs().VisitAll(func(k, v []byte) {
+		key, value := string(k), string(v)
+		var err error
+		switch {
+		case key == "after_seq":
+			q.AfterSeq, err = strconv.ParseUint(value, 10, 64)
+		case key == "before_seq":
+			q.BeforeSeq, err = strconv.ParseUint(value, 10, 64)
+		case key == "since":
+			q.Since, err = time.Parse(time.RFC3339, value)
+		case key == "until":
+			q.Until, err = time.Parse(time.RFC3339, value)
+		case key == "limit":
+			q.Limit, err = strconv.Atoi(value)
+			if err == nil && (q.Limit < 1 || q.Limit > maxBrowseLimit) {
+				problem = "limit must be 1-" + strconv.Itoa(maxBrowseLimit)
+			}
+		case strings.HasPrefix(key, "attr."):
+			q.Attributes[strings.TrimPrefix(key, "attr.")] = value
+		case strings.HasPrefix(key, "payload."):
+			q.Payload[strings.TrimPrefix(key, "payload.")] = value
+		}
+		if err != nil && problem == "" {
+			problem = "invalid " + key
+		}
+	})
+	return q, problem
+}
+
+// BrowseMessages lists retained messages via REST API without subscribing
+func (s *ServiceImpl) BrowseMessages(ctx context.Context, c *fiber.Ctx) error {
+	q, problem := parseBrowseQuery(c)
+	if problem != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(sdk.ErrorResponse{
+			Error: problem,
+		})
+	}
+
+	name := c.Params("name")
+	msgs, more, err := s.Browse(name, q)
+	if err != nil {
+		return topicAdminError(c, err)
+	}
+
+	resp := sdk.BrowseMessagesResponse{
+		Topic:    name,
+		Messages: make([]sdk.MessageRecord, 0, len(msgs)),
+	}
+	for _, msg := range msgs {
+		resp.Messages = append(resp.Messages, sdk.MessageRecord{
+			ID:         msg.ID,
+			Key:        msg.Key,
+			Payload:    msg.Payload,
+			Attributes: msg.Attributes,
+			Seq:        msg.Seq,
+			Timestamp:  msg.TS.Format(time.RFC3339Nano),
+		})
+	}
+	if more {
+		resp.NextAfterSeq = msgs[len(msgs)-1].Seq
+	}
+	return c.JSON(resp)
+}
@@ -0,0 +1,275 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+)
+
+// Client management covers the WebSocket connections to this node only; in
+// cluster mode each node lists and acts on its own clients.
+
+// kickNotice is the reason given to a client disconnected without one
+const kickNotice = "disconnected by administrator"
+
+// maxCloseReason is the longest reason a close frame can carry
+const maxCloseReason = 123
+
+// ErrClientNotFound is returned when no connected client has the id
+var ErrClientNotFound = errors.New("client not found")
+
+// identify records a client id the connection has used. The first one
+// becomes the connection's identity.
+func (sess *wsSession) identify(clientID string) {
+	if clientID == "" {
+		return
+	}
+	sess.mu.Lock()
+	if sess.identity == "" {
+		sess.identity = clientID
+	}
+	sess.clientIDs[clientID] = struct{}{}
+	sess.mu.Unlock()
+}
+
+// mayReadInbox reports whether the connection may subscribe to the inbox
+// name: only the inbox of its identity
+func (sess *wsSession) mayReadInbox(name string) bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.identity != "" && name == sdk.InboxTopic(sess.identity)
+}
+
+// setSubscription records the connection's subscriber for topic, or forgets
+// it when sub is nil
+func (sess *wsSession) setSubscription(topic string, sub *sdk.Subscriber) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sub == nil {
+		delete(sess.subscriptions, topic)
+		return
+	}
+	sess.subscriptions[topic] = sub
+}
+
+// subscription returns the connection's subscriber for topic, nil if none
+func (sess *wsSession) subscription(topic string) *sdk.Subscriber {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.subscriptions[topic]
+}
+
+// subscriptionList snapshots the connection's subscribers by topic name
+func (sess *wsSession) subscriptionList() map[string]*sdk.Subscriber {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	subs := make(map[string]*sdk.Subscriber, len(sess.subscriptions))
+	for topic, sub := range sess.subscriptions {
+		subs[topic] = sub
+	}
+	return subs
+}
+
+// usesClientID reports whether any of the connection's requests used clientID
+func (sess *wsSession) usesClientID(clientID string) bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	_, ok := sess.clientIDs[clientID]
+	return ok
+}
+
+// detail describes the connection. Subscriptions the server has since
+// closed, such as evicted ones, are left out.
+func (sess *wsSession) detail() sdk.ClientSession {
+	detail := sdk.ClientSession{
+		ID:            sess.id,
+		RemoteAddr:    sess.remoteAddr,
+		Subprotocol:   sess.conn.Subprotocol(),
+		ConnectedAt:   sess.connectedAt,
+		LastSeen:      time.Unix(0, sess.lastSeen.Load()),
+		ClientIDs:     []string{},
+		Subscriptions: []sdk.ClientSubscription{},
+	}
+
+	sess.mu.Lock()
+	for clientID := range sess.clientIDs {
+		detail.ClientIDs = append(detail.ClientIDs, clientID)
+	}
+	for topic, sub := range sess.subscriptions {
+		select {
+		case <-sub.CloseChannel:
+			continue
+		default:
+		}
+		detail.Subscriptions = append(detail.Subscriptions, sdk.ClientSubscription{
+			Topic:    topic,
+			ClientID: sub.ClientID,
+			Queued:   len(sub.Queue),
+			Credit:   sub.FlowControl,
+		})
+	}
+	sess.mu.Unlock()
+
+	sort.Strings(detail.ClientIDs)
+	sort.Slice(detail.Subscriptions, func(i, j int) bool {
+		return detail.Subscriptions[i].Topic < detail.Subscriptions[j].Topic
+	})
+	return detail
+}
+
+// Clients describes every connected WebSocket client, oldest first
+func (s *ServiceImpl) Clients() []sdk.ClientSession {
+	sessions := s.liveSessions()
+	clients := make([]sdk.ClientSession, 0, len(sessions))
+	for _, sess := range sessions {
+		clients = append(clients, sess.detail())
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		if !clients[i].ConnectedAt.Equal(clients[j].ConnectedAt) {
+			return clients[i].ConnectedAt.Before(clients[j].ConnectedAt)
+		}
+		return clients[i].ID < clients[j].ID
+	})
+	return clients
+}
+
+// sessionsFor returns the connection with session id id, or else every
+// connection whose requests used id as their client id
+func (s *ServiceImpl) sessionsFor(id string) []*wsSession {
+	var matched []*wsSession
+	for _, sess := range s.liveSessions() {
+		if sess.id == id {
+			return []*wsSession{sess}
+		}
+		if sess.usesClientID(id) {
+			matched = append(matched, sess)
+		}
+	}
+	return matched
+}
+
+// Kick disconnects the clients sessionsFor matches, telling them why first,
+// and returns how many connections it closed
+func (s *ServiceImpl) Kick(id, reason string) int {
+	if reason == "" {
+		reason = kickNotice
+	}
+	closeReason := reason
+	if len(closeReason) > maxCloseReason {
+		closeReason = strings.ToValidUTF8(closeReason[:maxCloseReason], "")
+	}
+
+	sessions := s.sessionsFor(id)
+	for _, sess := range sessions {
+		sess.kicked.Store(true)
+		sess.send("info", infoFrame(reason))
+		sess.send(wsTypeClose, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, closeReason))
+
+		// A client that never answers the close frame is cut off
+		time.AfterFunc(writeWait, func() {
+			s.sessionsMu.Lock()
+			defer s.sessionsMu.Unlock()
+			if s.sessions[sess.conn] == sess {
+				sess.conn.SetReadDeadline(time.Now())
+			}
+		})
+	}
+	return len(sessions)
+}
+
+// Notify sends an info message to the clients sessionsFor matches and
+// returns how many connections it was queued on
+func (s *ServiceImpl) Notify(id, msg string) int {
+	sessions := s.sessionsFor(id)
+	for _, sess := range sessions {
+		sess.send("info", infoFrame(msg))
+	}
+	return len(sessions)
+}
+
+// NotifyAll sends an info message to every connected client and returns
+// how many connections it was queued on
+func (s *ServiceImpl) NotifyAll(msg string) int {
+	sessions := s.liveSessions()
+	for _, sess := range sessions {
+		sess.send("info", infoFrame(msg))
+	}
+	return len(sessions)
+}
+
+// infoFrame builds a server-initiated info message
+func infoFrame(msg string) sdk.WebSocketResponse {
+	return sdk.WebSocketResponse{
+		Type:      sdk.MessageTypeInfo,
+		Msg:       msg,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// slowConsumerFrame tells a client its subscription to topic was closed
+// because its queue overflowed
+func slowConsumerFrame(topic string) sdk.WebSocketResponse {
+	frame := errorFrame("", sdk.ErrorCodeSlowConsumer, "subscriber queue overflow, subscription closed")
+	frame.Topic = topic
+	return frame
+}
+
+// ListClients returns the connected WebSocket clients via REST API
+func (s *ServiceImpl) ListClients(ctx context.Context, c *fiber.Ctx) error {
+	return c.JSON(sdk.ListClientsResponse{Clients: s.Clients()})
+}
+
+// DisconnectClient force-disconnects a client, by session or client id, via
+// REST API. The optional reason query parameter is sent to the client.
+func (s *ServiceImpl) DisconnectClient(ctx context.Context, c *fiber.Ctx) error {
+	id := c.Params("id")
+	n := s.Kick(id, c.Query("reason"))
+	if n == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(sdk.ErrorResponse{
+			Error: ErrClientNotFound.Error(),
+		})
+	}
+	return c.JSON(sdk.DisconnectClientResponse{
+		Status:   sdk.StatusDisconnected,
+		ID:       id,
+		Sessions: n,
+	})
+}
+
+// MessageClient sends an info message to a client, by session or client
+// id, via REST API
+func (s *ServiceImpl) MessageClient(ctx context.Context, c *fiber.Ctx) error {
+	var req sdk.ClientMessageRequest
+	if err := c.BodyParser(&req); err != nil || req.Msg == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(sdk.ErrorResponse{
+			Error: "invalid request - msg required",
+		})
+	}
+
+	n := s.Notify(c.Params("id"), req.Msg)
+	if n == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(sdk.ErrorResponse{
+			Error: ErrClientNotFound.Error(),
+		})
+	}
+	return c.JSON(sdk.ClientMessageResponse{Status: sdk.StatusOK, Sessions: n})
+}
+
+// BroadcastMessage sends an info message to every connected client via
+// REST API
+func (s *ServiceImpl) BroadcastMessage(ctx context.Context, c *fiber.Ctx) error {
+	var req sdk.ClientMessageRequest
+	if err := c.BodyParser(&req); err != nil || req.Msg == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(sdk.ErrorResponse{
+			Error: "invalid request - msg required",
+		})
+	}
+	return c.JSON(sdk.ClientMessageResponse{Status: sdk.StatusOK, Sessions: s.NotifyAll(req.Msg)})
+}
@@ -0,0 +1,133 @@
+package pubsub
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/Aryaman/pub-sub/sdk"
+)
+
+// A compacted topic keeps, besides its ring buffer of recent messages, the
+// newest message for every key, so its current state survives however far
+// the ring has moved on. A message with a nil payload is a tombstone: it is
+// delivered and retained in the ring like any other, and deletes its key
+// from the state.
+
+var (
+	// ErrKeyRequired is returned when a message to a compacted topic has no key
+	ErrKeyRequired = errors.New("messages to a compacted topic need a key")
+
+	// ErrNotCompacted is returned when the state of a topic that is not
+	// compacted is requested
+	ErrNotCompacted = errors.New("topic is not compacted")
+
+	// ErrStateClustered is returned for a state subscription in cluster
+	// mode, where the owner's state is not shared with other nodes
+	ErrStateClustered = errors.New("state subscriptions are not supported in cluster mode")
+
+	// ErrCompactedClustered is returned when enabling compaction in cluster
+	// mode, where the setting would hold only on the node that got it
+	ErrCompactedClustered = errors.New("compacted topics are not supported in cluster mode")
+)
+
+// checkKey rejects a message a compacted topic could not place in its state
+func (t *topic) checkKey(msg sdk.Message) error {
+	if t.compacted.Load() && msg.Key == "" {
+		return ErrKeyRequired
+	}
+	return nil
+}
+
+// retain appends msg to the ring buffer and, on a compacted topic, makes it
+// its key's current value or deletes the key for a tombstone. Caller must
+// hold t.mu.
+func (t *topic) retain(msg sdk.Message) {
+	t.ring.push(msg)
+	if t.state == nil || msg.Key == "" {
+		return
+	}
+	if msg.Payload == nil {
+		delete(t.state, msg.Key)
+		return
+	}
+	t.state[msg.Key] = msg
+}
+
+// setCompacted turns compaction on, seeding the state from the ring, or
+// off, dropping the state. Caller must hold t.mu.
+func (t *topic) setCompacted(on bool) {
+	t.compacted.Store(on)
+	if !on {
+		t.state = nil
+		return
+	}
+	if t.state != nil {
+		return
+	}
+	t.state = make(map[string]sdk.Message)
+	t.ring.scan(0, func(msg sdk.Message) bool {
+		if msg.Key != "" {
+			if msg.Payload == nil {
+				delete(t.state, msg.Key)
+			} else {
+				t.state[msg.Key] = msg
+			}
+		}
+		return true
+	})
+}
+
+// currentState returns the value of every live key sequenced at or before
+// seq, oldest first. Caller must hold t.mu.
+func (t *topic) currentState(seq uint64) []sdk.Message {
+	msgs := make([]sdk.Message, 0, len(t.state))
+	for _, msg := range t.state {
+		if msg.Seq <= seq {
+			msgs = append(msgs, msg)
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].Seq < msgs[j].Seq })
+	return msgs
+}
+
+// retained returns every message the topic keeps, oldest first: for a
+// compacted topic, the current values that have left the ring, then the
+// ring. Replaying it through retain rebuilds the topic. Caller must hold
+// t.mu.
+func (t *topic) retained() []sdk.Message {
+	msgs := t.ring.last(t.ring.len())
+	if t.state == nil {
+		return msgs
+	}
+	oldest := uint64(math.MaxUint64)
+	if len(msgs) > 0 {
+		oldest = msgs[0].Seq
+	}
+	older := t.currentState(oldest - 1)
+	return append(older, msgs...)
+}
+
+// clear drops every retained message and the compacted state. Caller must
+// hold t.mu.
+func (t *topic) clear() {
+	t.ring.reset()
+	if t.state != nil {
+		t.state = make(map[string]sdk.Message)
+	}
+}
+
+// resumable reports whether the ring still holds every message after seq,
+// so replaying from it leaves no gap. Caller must hold t.mu.
+func (t *topic) resumable(seq uint64) bool {
+	if seq >= t.lastSeq {
+		return true
+	}
+	return t.ring.len() > 0 && t.ring.at(0).Seq <= seq+1
+}
+
+// SubscribeState attaches sub to a compacted topic and queues the current
+// value of every key ahead of any live messages
+func (s *ServiceImpl) SubscribeState(name string, sub *sdk.Subscriber) (int, error) {
+	return s.subscribe(name, sub, replayFrom{state: true})
+}
@@ -0,0 +1,223 @@
+package pubsub
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+)
+
+// An exclusive subscription has at most one active subscriber per topic.
+// Other client ids subscribing exclusively wait as standbys, unregistered
+// for fan-out, and the first of them takes over when the active subscriber
+// goes, for whatever reason its channel is closed: unsubscribe, disconnect,
+// eviction or replacement. The active subscriber acknowledges its progress
+// with ack frames, and the standby taking over resumes after the last
+// acknowledged sequence number, so nothing unacknowledged is lost.
+
+const promotedNotice = "promoted to active exclusive subscriber"
+
+var (
+	// ErrNotActive is returned for an ack from a client that is not the
+	// active exclusive subscriber of the topic
+	ErrNotActive = errors.New("not the active exclusive subscriber")
+
+	// ErrExclusiveClustered is returned for an exclusive subscription in
+	// cluster mode, where standbys on other nodes could not be seen
+	ErrExclusiveClustered = errors.New("exclusive subscriptions are not supported in cluster mode")
+)
+
+// exclusiveGroup is a topic's exclusive subscribers
+type exclusiveGroup struct {
+	mu       sync.Mutex
+	formed   bool            // an exclusive subscriber has been active, so acked is meaningful
+	active   *sdk.Subscriber // nil when none is active
+	standbys []standby       // in takeover order
+	acked    uint64          // last sequence number the active subscriber acknowledged
+}
+
+// standby is a waiting exclusive subscriber
+type standby struct {
+	sub      *sdk.Subscriber
+	promoted func(afterSeq uint64) // called before the takeover replay is queued
+}
+
+// activate makes sub the active subscriber. Caller must hold g.mu.
+func (g *exclusiveGroup) activate(sub *sdk.Subscriber) {
+	g.active = sub
+	g.formed = true
+	if sub.StartSeq > g.acked {
+		g.acked = sub.StartSeq
+	}
+}
+
+// dropStandby closes and removes the standby sub, or the one with clientID
+// when sub is nil. Caller must hold g.mu.
+func (g *exclusiveGroup) dropStandby(sub *sdk.Subscriber, clientID string) bool {
+	for i, sb := range g.standbys {
+		if sb.sub == sub || (sub == nil && sb.sub.ClientID == clientID) {
+			close(sb.sub.CloseChannel)
+			g.standbys = append(g.standbys[:i], g.standbys[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// detail describes the group, nil if it never formed
+func (g *exclusiveGroup) detail() *sdk.ExclusiveGroup {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.formed && len(g.standbys) == 0 {
+		return nil
+	}
+	detail := &sdk.ExclusiveGroup{Standbys: make([]string, 0, len(g.standbys)), AckedSeq: g.acked}
+	if g.active != nil {
+		detail.Active = g.active.ClientID
+	}
+	for _, sb := range g.standbys {
+		detail.Standbys = append(detail.Standbys, sb.sub.ClientID)
+	}
+	return detail
+}
+
+// subscribeExclusive makes sub the topic's active exclusive subscriber when
+// there is none, or it belongs to the same client id, and otherwise queues
+// it as a standby. Once a group has formed, a new active subscriber without
+// an after_seq of its own resumes after the last acknowledged message.
+// promoted is called if a standby is later made active. It reports whether
+// sub is active.
+func (s *ServiceImpl) subscribeExclusive(name string, sub *sdk.Subscriber, from replayFrom, promoted func(afterSeq uint64)) (bool, error) {
+	if s.Cluster != nil {
+		return false, ErrExclusiveClustered
+	}
+	if isInbox(name) {
+		return false, ErrInboxUnsupported
+	}
+	if err := s.autoCreate(name, AutoCreateOnSubscribe); err != nil {
+		return false, err
+	}
+	t, ok := s.getTopic(name)
+	if !ok {
+		return false, ErrTopicNotFound
+	}
+
+	g := &t.exclusive
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.dropStandby(nil, sub.ClientID)
+	if g.active != nil && g.active.ClientID != sub.ClientID {
+		if err := s.interceptSubscribe(name, sub); err != nil {
+			return false, err
+		}
+		g.standbys = append(g.standbys, standby{sub: sub, promoted: promoted})
+		return false, nil
+	}
+
+	if g.formed && from.afterSeq == nil {
+		after := g.acked
+		from = replayFrom{afterSeq: &after}
+	}
+	if _, err := s.subscribe(name, sub, from); err != nil {
+		return false, err
+	}
+	g.activate(sub)
+	go s.watchActive(t, sub)
+	return true, nil
+}
+
+// watchActive fails over to a standby once sub stops being active
+func (s *ServiceImpl) watchActive(t *topic, sub *sdk.Subscriber) {
+	select {
+	case <-sub.CloseChannel:
+		s.failover(t, sub)
+	case <-s.done:
+	}
+}
+
+// failover promotes the first standby that can take over from gone. When
+// the topic itself was deleted every standby is closed instead.
+func (s *ServiceImpl) failover(t *topic, gone *sdk.Subscriber) {
+	g := &t.exclusive
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.active != gone {
+		// Replaced by a resubscribe of the same client id
+		return
+	}
+	g.active = nil
+
+	if current, ok := s.getTopic(t.name); !ok || current != t {
+		for _, sb := range g.standbys {
+			close(sb.sub.CloseChannel)
+		}
+		g.standbys = nil
+		return
+	}
+
+	for len(g.standbys) > 0 {
+		next := g.standbys[0]
+		g.standbys = g.standbys[1:]
+
+		after := g.acked
+		next.promoted(after)
+		if _, err := s.subscribe(t.name, next.sub, replayFrom{afterSeq: &after}); err != nil {
+			// A replay overflow has already closed it; anything else has not
+			if !errors.Is(err, ErrReplayOverflow) {
+				close(next.sub.CloseChannel)
+			}
+			continue
+		}
+		g.activate(next.sub)
+		go s.watchActive(t, next.sub)
+		return
+	}
+}
+
+// Ack records that clientID, the topic's active exclusive subscriber, has
+// handled every message up to seq
+func (s *ServiceImpl) Ack(name, clientID string, seq uint64) error {
+	t, ok := s.getTopic(name)
+	if !ok {
+		return ErrTopicNotFound
+	}
+
+	g := &t.exclusive
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.active == nil || g.active.ClientID != clientID {
+		return ErrNotActive
+	}
+
+	t.mu.RLock()
+	if seq > t.lastSeq {
+		seq = t.lastSeq
+	}
+	t.mu.RUnlock()
+	if seq > g.acked {
+		g.acked = seq
+	}
+	return nil
+}
+
+// dropExclusive removes a standby, by subscriber or else by client id, and
+// reports whether there was one
+func (t *topic) dropExclusive(sub *sdk.Subscriber, clientID string) bool {
+	t.exclusive.mu.Lock()
+	defer t.exclusive.mu.Unlock()
+	return t.exclusive.dropStandby(sub, clientID)
+}
+
+// promotedFrame tells a standby it is now the active subscriber, resuming
+// after afterSeq
+func promotedFrame(topic string, afterSeq uint64) sdk.WebSocketResponse {
+	return sdk.WebSocketResponse{
+		Type:      sdk.MessageTypeInfo,
+		Topic:     topic,
+		Msg:       promotedNotice,
+		Seq:       afterSeq,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,252 @@
+package pubsub
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// maxImportLine bounds a single line of an import
+const maxImportLine = 4 << 20
+
+// ErrInvalidImport is returned when an import file cannot be parsed
+var ErrInvalidImport = errors.New("invalid import")
+
+// ImportOptions controls how imported messages are rewritten. Sequence
+// numbers are always assigned by the target topic.
+type ImportOptions struct {
+	ReassignIDs        bool // give every message a new id
+	ReassignTimestamps bool // stamp messages with the import time
+}
+
+// Export writes a topic's config and retained messages on this node as JSON
+// Lines: an sdk.ExportTopic line, then one sdk.ExportMessage per message
+func (s *ServiceImpl) Export(name string, w *bufio.Writer) error {
+	detail, err := s.Describe(name)
+	if err != nil {
+		return err
+	}
+	snap, err := s.Snapshot(name)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	header := sdk.ExportTopic{
+		Type:        sdk.ExportTypeTopic,
+		Name:        name,
+		MaxMessages: snap.MaxMessages,
+		RateLimit:   detail.RateLimit,
+		Compacted:   snap.Compacted,
+		LastSeq:     snap.LastSeq,
+	}
+	if err := enc.Encode(header); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	for _, msg := range snap.Messages {
+		line := sdk.ExportMessage{
+			Type: sdk.ExportTypeMessage,
+			MessageRecord: sdk.MessageRecord{
+				ID:         msg.ID,
+				Key:        msg.Key,
+				Payload:    msg.Payload,
+				Attributes: msg.Attributes,
+				Seq:        msg.Seq,
+				Timestamp:  msg.TS.Format(time.RFC3339Nano),
+			},
+		}
+		if err := enc.Encode(line); err != nil {
+			return fmt.Errorf("failed to write export: %w", err)
+		}
+	}
+	return w.Flush()
+}
+
+// importFile is a parsed export
+type importFile struct {
+	header   *sdk.ExportTopic
+	messages []sdk.Message
+}
+
+// parseImport reads an export, failing on the first bad line so nothing is
+// imported from a corrupt file
+func parseImport(data []byte) (importFile, error) {
+	var file importFile
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64<<10), maxImportLine)
+	for line := 1; scanner.Scan(); line++ {
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		var kind struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(raw, &kind); err != nil {
+			return file, fmt.Errorf("line %d: %w", line, err)
+		}
+		switch kind.Type {
+		case sdk.ExportTypeTopic:
+			if file.header != nil || len(file.messages) > 0 {
+				return file, fmt.Errorf("line %d: topic line must come first", line)
+			}
+			file.header = &sdk.ExportTopic{}
+			if err := json.Unmarshal(raw, file.header); err != nil {
+				return file, fmt.Errorf("line %d: %w", line, err)
+			}
+		case sdk.ExportTypeMessage:
+			var rec sdk.ExportMessage
+			if err := json.Unmarshal(raw, &rec); err != nil {
+				return file, fmt.Errorf("line %d: %w", line, err)
+			}
+			msg := sdk.Message{ID: rec.ID, Key: rec.Key, Payload: rec.Payload, Attributes: rec.Attributes}
+			if rec.Timestamp != "" {
+				ts, err := time.Parse(time.RFC3339Nano, rec.Timestamp)
+				if err != nil {
+					return file, fmt.Errorf("line %d: invalid ts: %w", line, err)
+				}
+				msg.TS = ts.UTC()
+			}
+			file.messages = append(file.messages, msg)
+		default:
+			return file, fmt.Errorf("line %d: unknown type %q", line, kind.Type)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return file, err
+	}
+	return file, nil
+}
+
+// validate checks every message before the first is published, so a file
+// the topic would refuse halfway through imports nothing: a compacted topic
+// needs a key on each message, and preserved ids must be unique
+func (file importFile) validate(compacted, reassignIDs bool) error {
+	seen := make(map[string]bool, len(file.messages))
+	for i, msg := range file.messages {
+		if compacted && msg.Key == "" {
+			return fmt.Errorf("message %d: %v", i+1, ErrKeyRequired)
+		}
+		if reassignIDs || msg.ID == "" {
+			continue
+		}
+		if seen[msg.ID] {
+			return fmt.Errorf("message %d: duplicate id %q", i+1, msg.ID)
+		}
+		seen[msg.ID] = true
+	}
+	return nil
+}
+
+// Import loads an export into the named topic, creating it if needed and
+// applying the exported retention, compaction and rate limit. Messages are published
+// in order, so current subscribers receive them.
+func (s *ServiceImpl) Import(name string, data []byte, opts ImportOptions) (sdk.ImportTopicResponse, error) {
+	resp := sdk.ImportTopicResponse{Topic: name}
+	file, err := parseImport(data)
+	if err != nil {
+		return resp, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+
+	// The exported compaction setting replaces the topic's own
+	var compacted bool
+	if file.header != nil {
+		compacted = file.header.Compacted
+	} else if topic, ok := s.getTopic(name); ok {
+		compacted = topic.compacted.Load()
+	}
+	if err := file.validate(compacted, opts.ReassignIDs); err != nil {
+		return resp, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+
+	switch err := s.AddTopic(name); {
+	case err == nil:
+		resp.Created = true
+	case !errors.Is(err, ErrTopicExists):
+		return resp, err
+	}
+	if file.header != nil {
+		update := sdk.UpdateTopicRequest{
+			RateLimit: file.header.RateLimit,
+			Compacted: &file.header.Compacted,
+		}
+		// An export without retention keeps the topic's own
+		if file.header.MaxMessages > 0 {
+			update.MaxMessages = &file.header.MaxMessages
+		}
+		if err := s.Configure(name, update); err != nil {
+			return resp, err
+		}
+	}
+
+	for _, msg := range file.messages {
+		if opts.ReassignIDs || msg.ID == "" {
+			msg.ID = uuid.New().String()
+		}
+		if opts.ReassignTimestamps {
+			msg.TS = time.Time{}
+		}
+		if _, err := s.Publish(name, msg); err != nil {
+			return resp, err
+		}
+		resp.Imported++
+	}
+	return resp, nil
+}
+
+// ExportTopic streams a topic as JSON Lines via REST API
+func (s *ServiceImpl) ExportTopic(ctx context.Context, c *fiber.Ctx) error {
+	name := c.Params("name")
+	if !s.HasTopic(name) {
+		return topicAdminError(c, ErrTopicNotFound)
+	}
+
+	c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", name+".jsonl"))
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		// The status is already sent, so a failure can only cut the body short
+		s.Export(name, w)
+	})
+	return nil
+}
+
+// ImportTopic loads a JSON Lines export into a topic via REST API. The ids
+// and timestamps query parameters take "preserve" (the default) or
+// "reassign".
+func (s *ServiceImpl) ImportTopic(ctx context.Context, c *fiber.Ctx) error {
+	var opts ImportOptions
+	for param, reassign := range map[string]*bool{"ids": &opts.ReassignIDs, "timestamps": &opts.ReassignTimestamps} {
+		switch c.Query(param, "preserve") {
+		case "preserve":
+		case "reassign":
+			*reassign = true
+		default:
+			return c.Status(fiber.StatusBadRequest).JSON(sdk.ErrorResponse{
+				Error: param + " must be preserve or reassign",
+			})
+		}
+	}
+
+	resp, err := s.Import(c.Params("name"), c.Body(), opts)
+	if errors.Is(err, ErrInvalidImport) {
+		return c.Status(fiber.StatusBadRequest).JSON(sdk.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(sdk.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	return c.JSON(resp)
+}
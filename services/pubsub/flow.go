@@ -0,0 +1,100 @@
+package pubsub
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+)
+
+// creditWindow counts the events a credit-mode subscriber may still receive
+type creditWindow struct {
+	mu        sync.Mutex
+	available int
+	wake      chan struct{}
+}
+
+func newCreditWindow(initial int) *creditWindow {
+	return &creditWindow{available: initial, wake: make(chan struct{}, 1)}
+}
+
+// grant adds n credits and wakes a waiting writer
+func (w *creditWindow) grant(n int) {
+	w.mu.Lock()
+	if w.available > math.MaxInt32-n {
+		w.available = math.MaxInt32
+	} else {
+		w.available += n
+	}
+	w.mu.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take consumes one credit, waiting until one is granted. It returns false
+// if done closes first.
+func (w *creditWindow) take(done <-chan struct{}) bool {
+	for {
+		w.mu.Lock()
+		if w.available > 0 {
+			w.available--
+			w.mu.Unlock()
+			return true
+		}
+		w.mu.Unlock()
+
+		select {
+		case <-w.wake:
+		case <-done:
+			return false
+		}
+	}
+}
+
+// creditWriter delivers events to a credit-mode subscriber, never more than
+// it has been granted. While it waits, fanOut may overflow the queue; the
+// subscriber is then marked lagging and the missed messages are read back
+// from the topic's ring buffer, using sequence numbers to skip duplicates.
+func (s *ServiceImpl) creditWriter(sub *sdk.Subscriber, topic string, window *creditWindow, sendMessage func(string, interface{})) {
+	lastSeq := sub.StartSeq
+	var pending []sdk.Message
+
+	for {
+		if !window.take(sub.CloseChannel) {
+			return
+		}
+
+		var msg sdk.Message
+		for {
+			if len(pending) == 0 && sub.Lagging.Swap(false) {
+				pending = deliverable(s.delivery(topic), sub, s.messagesAfter(topic, lastSeq))
+			}
+			if len(pending) > 0 {
+				msg, pending = pending[0], pending[1:]
+			} else {
+				select {
+				case msg = <-sub.Queue:
+				case <-sub.CloseChannel:
+					return
+				}
+			}
+			if msg.Seq == 0 || msg.Seq > lastSeq {
+				break
+			}
+			// Already delivered from the ring buffer
+		}
+		lastSeq = msg.Seq
+
+		sub.LastActive = time.Now()
+		sendMessage("event", sdk.WebSocketResponse{
+			Type:      sdk.MessageTypeEvent,
+			Topic:     topic,
+			Message:   &msg,
+			Timestamp: msg.TS.Format(time.RFC3339),
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ErrInvalidGenerator is returned for a generator without a topic, without
+// exactly one of a rate and a burst pattern, or without exactly one of a
+// schema and a template
+var ErrInvalidGenerator = errors.New("invalid generator")
+
+// generatorFields is what a generator synthesizes for each message
+type generatorFields struct {
+	payload    synthesizer
+	key        synthesizer
+	attrKeys   []string
+	attributes []synthesizer
+}
+
+// compileGenerator checks req and compiles its payload, key and attribute
+// templates
+func compileGenerator(req sdk.GeneratorRequest) (generatorFields, error) {
+	var fields generatorFields
+	switch {
+	case req.Topic == "":
+		return fields, fmt.Errorf("%w: topic required", ErrInvalidGenerator)
+	case (req.Rate > 0) == (req.Burst != nil):
+		return fields, fmt.Errorf("%w: exactly one of rate and burst required", ErrInvalidGenerator)
+	case req.Rate < 0 || math.IsInf(req.Rate, 0) || math.IsNaN(req.Rate):
+		return fields, fmt.Errorf("%w: rate must be positive", ErrInvalidGenerator)
+	case req.Burst != nil && (req.Burst.Size <= 0 || req.Burst.IntervalMS <= 0):
+		return fields, fmt.Errorf("%w: burst size and interval must be positive", ErrInvalidGenerator)
+	case (req.Schema != nil) == (req.Template != nil):
+		return fields, fmt.Errorf("%w: exactly one of schema and template required", ErrInvalidGenerator)
+	case req.Count < 0:
+		return fields, fmt.Errorf("%w: count must not be negative", ErrInvalidGenerator)
+	}
+
+	var err error
+	if req.Schema != nil {
+		fields.payload, err = compileSchema(req.Schema)
+	} else {
+		fields.payload, err = compileTemplate(req.Template)
+	}
+	if err != nil {
+		return fields, err
+	}
+	if req.Key != "" {
+		if fields.key, err = compileString(req.Key); err != nil {
+			return fields, fmt.Errorf("key: %w", err)
+		}
+	}
+	for name := range req.Attributes {
+		fields.attrKeys = append(fields.attrKeys, name)
+	}
+	sort.Strings(fields.attrKeys)
+	for _, name := range fields.attrKeys {
+		fn, err := compileString(req.Attributes[name])
+		if err != nil {
+			return fields, fmt.Errorf("attribute %s: %w", name, err)
+		}
+		fields.attributes = append(fields.attributes, fn)
+	}
+	return fields, nil
+}
+
+// message synthesizes the generator's next message
+func (f generatorFields) message(st *synthState) sdk.Message {
+	msg := sdk.Message{Payload: f.payload(st)}
+	if f.key != nil {
+		msg.Key = fmt.Sprint(f.key(st))
+	}
+	if len(f.attributes) > 0 {
+		msg.Attributes = make(map[string]string, len(f.attributes))
+		for i, name := range f.attrKeys {
+			msg.Attributes[name] = fmt.Sprint(f.attributes[i](st))
+		}
+	}
+	return msg
+}
+
+// StartGenerator starts a job publishing synthetic messages to a topic
+// until it has published Count of them, or until it is cancelled when Count
+// is 0. A request without a seed is given a random one, which the job
+// records so the run can be reproduced.
+func (s *ServiceImpl) StartGenerator(req sdk.GeneratorRequest) (sdk.Job, error) {
+	fields, err := compileGenerator(req)
+	if err != nil {
+		return sdk.Job{}, err
+	}
+	for req.Seed == 0 {
+		req.Seed = rand.Int63()
+	}
+	if err := s.autoCreate(req.Topic, AutoCreateOnPublish); err != nil {
+		return sdk.Job{}, err
+	}
+	if !isInbox(req.Topic) && !s.HasTopic(req.Topic) {
+		return sdk.Job{}, ErrTopicNotFound
+	}
+
+	info := sdk.Job{
+		Kind:      sdk.JobKindGenerator,
+		Target:    req.Topic,
+		Total:     req.Count,
+		Generator: &req,
+	}
+	return s.startJob(info, func(j *job) error {
+		return s.generate(j, req, fields)
+	}), nil
+}
+
+// generate publishes the generator's messages. Like a replay, each message
+// or burst is due relative to the start, so the rate holds however long
+// publishing takes.
+func (s *ServiceImpl) generate(j *job, req sdk.GeneratorRequest, fields generatorFields) error {
+	st := &synthState{rng: rand.New(rand.NewSource(req.Seed))}
+	start := time.Now()
+	for n := int64(0); req.Count == 0 || n < req.Count; n++ {
+		var offset time.Duration
+		if req.Burst != nil {
+			offset = time.Duration(n/int64(req.Burst.Size)) * time.Duration(req.Burst.IntervalMS) * time.Millisecond
+		} else {
+			offset = time.Duration(float64(n) / req.Rate * float64(time.Second))
+		}
+		paused, err := j.sleep(time.Until(start.Add(offset)), s.done)
+		if err != nil {
+			return err
+		}
+		start = start.Add(paused)
+
+		st.seq = n + 1
+		msg := fields.message(st)
+		msg.ID = j.info.ID + "-" + strconv.FormatInt(st.seq, 10)
+		if _, err := s.Publish(req.Topic, msg); err != nil {
+			return fmt.Errorf("message %s: %w", msg.ID, err)
+		}
+		j.published()
+	}
+	return nil
+}
+
+// Generators describes every generator job, oldest first
+func (s *ServiceImpl) Generators() []sdk.Job {
+	generators := []sdk.Job{}
+	for _, job := range s.Jobs() {
+		if job.Kind == sdk.JobKindGenerator {
+			generators = append(generators, job)
+		}
+	}
+	return generators
+}
+
+// StopGenerator cancels a generator job
+func (s *ServiceImpl) StopGenerator(id string) (sdk.Job, error) {
+	j, ok := s.getJob(id)
+	if !ok || j.detail().Kind != sdk.JobKindGenerator {
+		return sdk.Job{}, ErrJobNotFound
+	}
+	return s.SetJobState(id, sdk.JobStateCancelled)
+}
+
+// CreateGenerator starts a generator job via REST API
+func (s *ServiceImpl) CreateGenerator(ctx context.Context, c *fiber.Ctx) error {
+	var req sdk.GeneratorRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(sdk.ErrorResponse{
+			Error: "invalid request body",
+		})
+	}
+
+	job, err := s.StartGenerator(req)
+	if err != nil {
+		return jobError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(job)
+}
+
+// ListGenerators returns the generator jobs via REST API
+func (s *ServiceImpl) ListGenerators(ctx context.Context, c *fiber.Ctx) error {
+	return c.JSON(sdk.ListJobsResponse{Jobs: s.Generators()})
+}
+
+// DeleteGenerator stops a generator job via REST API
+func (s *ServiceImpl) DeleteGenerator(ctx context.Context, c *fiber.Ctx) error {
+	job, err := s.StopGenerator(c.Params("id"))
+	if err != nil {
+		return jobError(c, err)
+	}
+	return c.JSON(job)
+}
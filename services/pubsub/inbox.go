@@ -0,0 +1,210 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+)
+
+// Every client id has an inbox, $inbox.<client_id>, that anyone may publish
+// to and only that client id may subscribe to. Unlike a topic, an inbox
+// delivers to every session the client has subscribed from, and keeps
+// messages only while none is subscribed: they are held for InboxTTL, up to
+// InboxSize of them, and handed to the next session that subscribes.
+// Inboxes are not topics: they cannot be created, configured or listed, and
+// their messages are not routed onwards.
+//
+// A WebSocket connection reads only the inbox of the first client id it
+// uses. Client ids are asserted by clients, not verified, so this keeps
+// clients apart but does not stop one that connects under another's id.
+// Deployments that need private inboxes must authenticate client ids in a
+// Subscribe interceptor, which inbox subscriptions pass through like any
+// other; sub.Conn gives it the connection's handshake headers.
+
+var (
+	// ErrInboxUnsupported is returned for topic features an inbox lacks,
+	// such as exclusive and state subscriptions or transactions
+	ErrInboxUnsupported = errors.New("not supported on client inboxes")
+
+	// ErrInboxClustered is returned for inboxes in cluster mode, where the
+	// client's sessions may be on other nodes
+	ErrInboxClustered = errors.New("client inboxes are not supported in cluster mode")
+
+	// ErrReservedName is returned when creating a topic named like an inbox
+	ErrReservedName = errors.New("topic names starting with " + sdk.InboxPrefix + " are reserved")
+)
+
+// isInbox reports whether name addresses a client inbox
+func isInbox(name string) bool {
+	return strings.HasPrefix(name, sdk.InboxPrefix)
+}
+
+// inbox is the subscribers and held messages of one client id
+type inbox struct {
+	subs    []*sdk.Subscriber
+	pending []sdk.Message // published while nobody was subscribed, oldest first
+}
+
+// expire drops held messages older than ttl
+func (ib *inbox) expire(now time.Time, ttl time.Duration) {
+	n := 0
+	for n < len(ib.pending) && now.Sub(ib.pending[n].TS) >= ttl {
+		n++
+	}
+	ib.pending = ib.pending[n:]
+}
+
+// drop closes and removes the subscribers keep rejects
+func (ib *inbox) drop(keep func(*sdk.Subscriber) bool) {
+	subs := ib.subs[:0]
+	for _, sub := range ib.subs {
+		if keep(sub) {
+			subs = append(subs, sub)
+			continue
+		}
+		close(sub.CloseChannel)
+	}
+	ib.subs = subs
+}
+
+// withInbox runs fn on the inbox of owner, creating it if needed, and
+// forgets the inbox again if fn leaves it empty. Inboxes are cheap and
+// short-lived, so one lock guards them all.
+func (s *ServiceImpl) withInbox(owner string, fn func(ib *inbox)) {
+	s.inboxesMu.Lock()
+	defer s.inboxesMu.Unlock()
+	ib, ok := s.inboxes[owner]
+	if !ok {
+		ib = &inbox{}
+	}
+	fn(ib)
+	switch {
+	case len(ib.subs) == 0 && len(ib.pending) == 0:
+		delete(s.inboxes, owner)
+	case !ok:
+		s.inboxes[owner] = ib
+	}
+}
+
+// publishInbox delivers msg to every session subscribed to the inbox, or
+// holds it for the next one. A session whose queue is full is evicted.
+func (s *ServiceImpl) publishInbox(name string, msg sdk.Message) (sdk.Message, error) {
+	if s.Cluster != nil {
+		return msg, ErrInboxClustered
+	}
+	if name == sdk.InboxPrefix {
+		return msg, ErrTopicNotFound
+	}
+	if msg.TS.IsZero() {
+		msg.TS = time.Now().UTC()
+	}
+	msg.Seq = s.inboxSeq.Add(1)
+
+	deliver, trace := s.delivery(name), s.traceFanOut(name, msg)
+	s.withInbox(strings.TrimPrefix(name, sdk.InboxPrefix), func(ib *inbox) {
+		if len(ib.subs) == 0 {
+			if s.InboxTTL > 0 && s.InboxSize > 0 {
+				ib.expire(msg.TS, s.InboxTTL)
+				if len(ib.pending) >= s.InboxSize {
+					ib.pending = ib.pending[len(ib.pending)-s.InboxSize+1:]
+				}
+				ib.pending = append(ib.pending, msg)
+			}
+			return
+		}
+
+		ib.drop(func(sub *sdk.Subscriber) bool {
+			start := trace.start()
+			out := msg
+			if deliver != nil {
+				var ok bool
+				if out, ok = deliver(sub, msg); !ok {
+					trace.record(sdk.SpanNameDrop, sub, start, "withheld by interceptor")
+					return true
+				}
+			}
+			select {
+			case sub.Queue <- out:
+				trace.record(sdk.SpanNameEnqueue, sub, start, "")
+				return true
+			default:
+				sub.Evicted.Store(true)
+				trace.record(sdk.SpanNameEvict, sub, start, "subscriber queue full")
+				return false
+			}
+		})
+	})
+	return msg, nil
+}
+
+// subscribeInbox adds sub to its own inbox and queues the messages held
+// for it. A session subscribing again replaces its earlier subscription.
+func (s *ServiceImpl) subscribeInbox(name string, sub *sdk.Subscriber, from replayFrom) (int, error) {
+	if s.Cluster != nil {
+		return 0, ErrInboxClustered
+	}
+	if from.state {
+		return 0, ErrInboxUnsupported
+	}
+	owner := strings.TrimPrefix(name, sdk.InboxPrefix)
+	if owner == "" || sub.ClientID != owner {
+		return 0, fmt.Errorf("%w: %s belongs to another client", ErrUnauthorized, name)
+	}
+	if err := s.interceptSubscribe(name, sub); err != nil {
+		return 0, err
+	}
+
+	var (
+		replayed int
+		err      error
+	)
+	s.withInbox(owner, func(ib *inbox) {
+		ib.expire(time.Now(), s.InboxTTL)
+		replay := deliverable(s.delivery(name), sub, ib.pending)
+		if len(replay) > cap(sub.Queue)-len(sub.Queue) {
+			// Held messages stay for a subscriber that can take them
+			err = ErrReplayOverflow
+			return
+		}
+		for _, msg := range replay {
+			sub.Queue <- msg
+		}
+		replayed = len(replay)
+		ib.pending = nil
+		sub.StartSeq = s.inboxSeq.Load()
+
+		ib.drop(func(other *sdk.Subscriber) bool {
+			return other != sub && (other.Conn == nil || other.Conn != sub.Conn)
+		})
+		ib.subs = append(ib.subs, sub)
+	})
+	return replayed, err
+}
+
+// leaveInbox removes sub, or every subscriber of clientID when sub is nil,
+// from the inbox
+func (s *ServiceImpl) leaveInbox(name string, sub *sdk.Subscriber, clientID string) {
+	s.withInbox(strings.TrimPrefix(name, sdk.InboxPrefix), func(ib *inbox) {
+		ib.drop(func(other *sdk.Subscriber) bool {
+			if sub != nil {
+				return other != sub
+			}
+			return other.ClientID != clientID
+		})
+	})
+}
+
+// expireInboxes drops held messages older than InboxTTL
+func (s *ServiceImpl) expireInboxes(now time.Time) {
+	s.inboxesMu.Lock()
+	defer s.inboxesMu.Unlock()
+	for owner, ib := range s.inboxes {
+		ib.expire(now, s.InboxTTL)
+		if len(ib.subs) == 0 && len(ib.pending) == 0 {
+			delete(s.inboxes, owner)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Aryaman/pub-sub/sdk"
+)
+
+var (
+	// ErrRejected is returned for a publish an interceptor refused
+	ErrRejected = errors.New("publish rejected")
+
+	// ErrUnauthorized is returned for a subscription an interceptor refused
+	ErrUnauthorized = errors.New("subscription not authorized")
+)
+
+// Interceptor hooks into publishing, delivery and subscribing, for modules
+// such as auditing, scrubbing or tracing. Any of its functions may be nil.
+// Interceptors run in the order they were added, each seeing the message
+// the previous one returned. Messages share their Payload and Attributes
+// with other subscribers and the ring buffer, so an interceptor changing
+// them must copy first.
+type Interceptor struct {
+	Name string
+
+	// Publish sees every message a client publishes, before rate limits
+	// and storage. It returns the message to publish, or an error to
+	// reject it.
+	Publish func(clientID, topic string, msg sdk.Message) (sdk.Message, error)
+
+	// Deliver sees each message before it is queued for one subscriber,
+	// live or replayed. It returns the message to deliver, or false to
+	// withhold it from that subscriber.
+	Deliver func(topic string, sub *sdk.Subscriber, msg sdk.Message) (sdk.Message, bool)
+
+	// Subscribe authorizes a subscription; an error refuses it
+	Subscribe func(topic string, sub *sdk.Subscriber) error
+}
+
+// deliverFunc filters and transforms a message for one subscriber
+type deliverFunc func(sub *sdk.Subscriber, msg sdk.Message) (sdk.Message, bool)
+
+// Use appends interceptors to the chain. It is meant for startup, but is
+// safe at any time; publishes already running keep the chain they started
+// with.
+func (s *ServiceImpl) Use(interceptors ...Interceptor) {
+	s.interceptorsMu.Lock()
+	defer s.interceptorsMu.Unlock()
+	chain := append(append([]Interceptor{}, s.chain()...), interceptors...)
+	s.interceptors.Store(&chain)
+}
+
+// chain returns the current interceptors, which must not be modified
+func (s *ServiceImpl) chain() []Interceptor {
+	if chain := s.interceptors.Load(); chain != nil {
+		return *chain
+	}
+	return nil
+}
+
+// interceptPublish runs the publish interceptors over msg
+func (s *ServiceImpl) interceptPublish(clientID, topic string, msg sdk.Message) (sdk.Message, error) {
+	for _, i := range s.chain() {
+		if i.Publish == nil {
+			continue
+		}
+		var err error
+		if msg, err = i.Publish(clientID, topic, msg); err != nil {
+			return msg, fmt.Errorf("%w by %s: %v", ErrRejected, i.Name, err)
+		}
+	}
+	return msg, nil
+}
+
+// interceptSubscribe runs the subscribe interceptors for sub
+func (s *ServiceImpl) interceptSubscribe(topic string, sub *sdk.Subscriber) error {
+	for _, i := range s.chain() {
+		if i.Subscribe == nil {
+			continue
+		}
+		if err := i.Subscribe(topic, sub); err != nil {
+			return fmt.Errorf("%w by %s: %v", ErrUnauthorized, i.Name, err)
+		}
+	}
+	return nil
+}
+
+// delivery returns the deliver interceptors for topic as one function, nil
+// when there are none so fan-out skips the call
+func (s *ServiceImpl) delivery(topic string) deliverFunc {
+	var hooks []func(string, *sdk.Subscriber, sdk.Message) (sdk.Message, bool)
+	for _, i := range s.chain() {
+		if i.Deliver != nil {
+			hooks = append(hooks, i.Deliver)
+		}
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+	return func(sub *sdk.Subscriber, msg sdk.Message) (sdk.Message, bool) {
+		for _, hook := range hooks {
+			var ok bool
+			if msg, ok = hook(topic, sub, msg); !ok {
+				return msg, false
+			}
+		}
+		return msg, true
+	}
+}
+
+// deliverable applies deliver to msgs for sub, dropping those withheld
+func deliverable(deliver deliverFunc, sub *sdk.Subscriber, msgs []sdk.Message) []sdk.Message {
+	if deliver == nil {
+		return msgs
+	}
+	out := make([]sdk.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg, ok := deliver(sub, msg); ok {
+			out = append(out, msg)
+		}
+	}
+	return out
+}
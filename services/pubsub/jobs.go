@@ -0,0 +1,279 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Jobs are long-running publishers, such as replays and generators, that
+// run in the background on this node and can be paused, resumed and
+// cancelled while they run. Finished jobs are kept for inspection, up to maxFinishedJobs.
+
+// maxFinishedJobs bounds how many finished jobs are kept
+const maxFinishedJobs = 100
+
+var (
+	// ErrJobNotFound is returned when an operation targets an unknown job
+	ErrJobNotFound = errors.New("job not found")
+
+	// ErrJobFinished is returned when pausing or resuming a finished job
+	ErrJobFinished = errors.New("job already finished")
+
+	// errJobStopped ends a job's run once it has been cancelled
+	errJobStopped = errors.New("job stopped")
+)
+
+// job is a running or finished job. Its run function publishes and calls
+// sleep between messages, which is where pauses and cancellation take hold.
+type job struct {
+	mu      sync.Mutex
+	info    sdk.Job
+	changed chan struct{} // signalled when the state is changed from outside
+}
+
+// detail copies the job's description
+func (j *job) detail() sdk.Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.info
+}
+
+// finished reports whether the job has stopped for good. Caller must hold
+// j.mu.
+func (j *job) finished() bool {
+	switch j.info.State {
+	case sdk.JobStateCompleted, sdk.JobStateCancelled, sdk.JobStateFailed:
+		return true
+	}
+	return false
+}
+
+// setState moves a running or paused job to state
+func (j *job) setState(state string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.finished() {
+		return ErrJobFinished
+	}
+	j.info.State = state
+	select {
+	case j.changed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// published counts a message the job has published
+func (j *job) published() {
+	j.mu.Lock()
+	j.info.Published++
+	j.mu.Unlock()
+}
+
+// finish records how the run ended. A job cancelled after its last publish
+// stays cancelled.
+func (j *job) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now().UTC()
+	j.info.FinishedAt = &now
+	switch {
+	case j.info.State == sdk.JobStateCancelled, errors.Is(err, errJobStopped):
+		j.info.State = sdk.JobStateCancelled
+	case err == nil:
+		j.info.State = sdk.JobStateCompleted
+	default:
+		j.info.State = sdk.JobStateFailed
+		j.info.Error = err.Error()
+	}
+}
+
+// sleep waits for d of running time, stretched by any pause in between,
+// and returns how long the job was paused. It fails with errJobStopped once
+// the job is cancelled or the service shuts down.
+func (j *job) sleep(d time.Duration, done <-chan struct{}) (time.Duration, error) {
+	var paused time.Duration
+	deadline := time.Now().Add(d)
+	for {
+		j.mu.Lock()
+		state := j.info.State
+		j.mu.Unlock()
+
+		switch state {
+		case sdk.JobStateCancelled:
+			return paused, errJobStopped
+		case sdk.JobStatePaused:
+			start := time.Now()
+			select {
+			case <-j.changed:
+			case <-done:
+				return paused, errJobStopped
+			}
+			paused += time.Since(start)
+			deadline = deadline.Add(time.Since(start))
+			continue
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return paused, nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+			return paused, nil
+		case <-j.changed:
+			timer.Stop()
+		case <-done:
+			timer.Stop()
+			return paused, errJobStopped
+		}
+	}
+}
+
+// startJob registers a job described by info and runs it in the background
+func (s *ServiceImpl) startJob(info sdk.Job, run func(j *job) error) sdk.Job {
+	info.ID = uuid.New().String()
+	info.State = sdk.JobStateRunning
+	info.CreatedAt = time.Now().UTC()
+	j := &job{info: info, changed: make(chan struct{}, 1)}
+
+	s.jobsMu.Lock()
+	s.pruneJobs()
+	s.jobs[info.ID] = j
+	s.jobsMu.Unlock()
+
+	go func() {
+		var err error
+		defer func() {
+			// A bug in one job fails it rather than the server
+			if r := recover(); r != nil {
+				err = fmt.Errorf("job panicked: %v", r)
+			}
+			j.finish(err)
+		}()
+		err = run(j)
+	}()
+	return info
+}
+
+// pruneJobs forgets the oldest finished jobs beyond maxFinishedJobs. Caller
+// must hold s.jobsMu.
+func (s *ServiceImpl) pruneJobs() {
+	var finished []*job
+	for _, j := range s.jobs {
+		j.mu.Lock()
+		if j.finished() {
+			finished = append(finished, j)
+		}
+		j.mu.Unlock()
+	}
+	if len(finished) < maxFinishedJobs {
+		return
+	}
+	sort.Slice(finished, func(a, b int) bool {
+		return finished[a].info.FinishedAt.Before(*finished[b].info.FinishedAt)
+	})
+	for _, j := range finished[:len(finished)-maxFinishedJobs+1] {
+		delete(s.jobs, j.info.ID)
+	}
+}
+
+// getJob looks up a job by id
+func (s *ServiceImpl) getJob(id string) (*job, bool) {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	j, ok := s.jobs[id]
+	return j, ok
+}
+
+// Jobs describes every job, oldest first
+func (s *ServiceImpl) Jobs() []sdk.Job {
+	s.jobsMu.Lock()
+	jobs := make([]sdk.Job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, j.detail())
+	}
+	s.jobsMu.Unlock()
+
+	sort.Slice(jobs, func(a, b int) bool {
+		if !jobs[a].CreatedAt.Equal(jobs[b].CreatedAt) {
+			return jobs[a].CreatedAt.Before(jobs[b].CreatedAt)
+		}
+		return jobs[a].ID < jobs[b].ID
+	})
+	return jobs
+}
+
+// SetJobState pauses, resumes or cancels a job: state is one of
+// sdk.JobStatePaused, sdk.JobStateRunning and sdk.JobStateCancelled
+func (s *ServiceImpl) SetJobState(id, state string) (sdk.Job, error) {
+	j, ok := s.getJob(id)
+	if !ok {
+		return sdk.Job{}, ErrJobNotFound
+	}
+	if err := j.setState(state); err != nil {
+		return j.detail(), err
+	}
+	return j.detail(), nil
+}
+
+// jobError writes the REST response for a failed job operation
+func jobError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusBadRequest
+	switch {
+	case errors.Is(err, ErrJobNotFound), errors.Is(err, ErrTopicNotFound):
+		status = fiber.StatusNotFound
+	case errors.Is(err, ErrJobFinished):
+		status = fiber.StatusConflict
+	}
+	return c.Status(status).JSON(sdk.ErrorResponse{
+		Error: err.Error(),
+	})
+}
+
+// ListJobs returns every job via REST API
+func (s *ServiceImpl) ListJobs(ctx context.Context, c *fiber.Ctx) error {
+	return c.JSON(sdk.ListJobsResponse{Jobs: s.Jobs()})
+}
+
+// GetJob returns one job and its progress via REST API
+func (s *ServiceImpl) GetJob(ctx context.Context, c *fiber.Ctx) error {
+	j, ok := s.getJob(c.Params("id"))
+	if !ok {
+		return jobError(c, ErrJobNotFound)
+	}
+	return c.JSON(j.detail())
+}
+
+// PauseJob pauses a job via REST API
+func (s *ServiceImpl) PauseJob(ctx context.Context, c *fiber.Ctx) error {
+	return s.controlJob(c, sdk.JobStatePaused)
+}
+
+// ResumeJob resumes a paused job via REST API
+func (s *ServiceImpl) ResumeJob(ctx context.Context, c *fiber.Ctx) error {
+	return s.controlJob(c, sdk.JobStateRunning)
+}
+
+// CancelJob stops a job for good via REST API
+func (s *ServiceImpl) CancelJob(ctx context.Context, c *fiber.Ctx) error {
+	return s.controlJob(c, sdk.JobStateCancelled)
+}
+
+func (s *ServiceImpl) controlJob(c *fiber.Ctx, state string) error {
+	job, err := s.SetJobState(c.Params("id"), state)
+	if err != nil {
+		return jobError(c, err)
+	}
+	return c.JSON(job)
+}
@@ -0,0 +1,147 @@
+package pubsub
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+
+	"github.com/gofiber/websocket/v2"
+	"github.com/google/uuid"
+)
+
+// writeWait bounds how long a control frame write may block
+const writeWait = 10 * time.Second
+
+// wsSession tracks liveness and subscriptions of a single WebSocket
+// connection
+type wsSession struct {
+	id          string
+	conn        *websocket.Conn
+	remoteAddr  string
+	connectedAt time.Time
+	send        func(string, interface{}) // enqueues a frame on the connection's writer
+	lastSeen    atomic.Int64              // unix nanos of the last inbound frame or pong
+	kicked      atomic.Bool
+
+	mu            sync.Mutex
+	identity      string // the first client id used, the only inbox it may read
+	clientIDs     map[string]struct{}
+	subscriptions map[string]*sdk.Subscriber // by topic name
+}
+
+// touch records inbound activity on the connection
+func (ws *wsSession) touch() {
+	ws.lastSeen.Store(time.Now().UnixNano())
+}
+
+// registerSession starts tracking a connection and arms its read deadline
+func (s *ServiceImpl) registerSession(c *websocket.Conn, send func(string, interface{})) *wsSession {
+	sess := &wsSession{
+		id:            uuid.New().String(),
+		conn:          c,
+		remoteAddr:    c.RemoteAddr().String(),
+		connectedAt:   time.Now(),
+		send:          send,
+		clientIDs:     make(map[string]struct{}),
+		subscriptions: make(map[string]*sdk.Subscriber),
+	}
+	sess.touch()
+
+	s.sessionsMu.Lock()
+	s.sessions[c] = sess
+	s.sessionsMu.Unlock()
+
+	s.extendReadDeadline(c)
+	c.SetPongHandler(func(string) error {
+		sess.touch()
+		s.extendReadDeadline(c)
+		return nil
+	})
+
+	return sess
+}
+
+// unregisterSession stops tracking a connection and records why it ended
+func (s *ServiceImpl) unregisterSession(sess *wsSession, readErr error) {
+	s.sessionsMu.Lock()
+	delete(s.sessions, sess.conn)
+	s.sessionsMu.Unlock()
+
+	if !sess.kicked.Load() && isTimeout(readErr) {
+		s.reapedClients.Add(1)
+	}
+}
+
+// extendReadDeadline pushes the read deadline forward after any activity
+func (s *ServiceImpl) extendReadDeadline(c *websocket.Conn) {
+	if s.ReadTimeout > 0 {
+		c.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+	}
+}
+
+// StartReaper periodically drops orphaned subscribers, removes idle
+// auto-created topics and expires messages held in client inboxes. Dead
+// clients are disconnected by their read deadline, which only frames and
+// pongs extend.
+func (s *ServiceImpl) StartReaper(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				now := time.Now()
+				s.reapOrphans()
+				s.collectIdleTopics(now)
+				s.expireInboxes(now)
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+// reapOrphans drops subscribers whose connection is gone, since they will
+// never drain their queue
+func (s *ServiceImpl) reapOrphans() {
+	for _, topic := range s.topics.all() {
+		topic.subsMu.Lock()
+		reaped := 0
+		for clientID, sub := range topic.subscribers {
+			conn, ok := sub.Conn.(*websocket.Conn)
+			if !ok || s.isLive(conn) {
+				continue
+			}
+			close(sub.CloseChannel)
+			delete(topic.subscribers, clientID)
+			reaped++
+		}
+		if reaped > 0 {
+			topic.refresh()
+			s.reapedSubscribers.Add(int64(reaped))
+		}
+		topic.subsMu.Unlock()
+	}
+}
+
+// isLive reports whether a connection still has a running handler
+func (s *ServiceImpl) isLive(c *websocket.Conn) bool {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	_, ok := s.sessions[c]
+	return ok
+}
+
+// isTimeout reports whether err was caused by an expired deadline
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
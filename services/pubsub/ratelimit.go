@@ -0,0 +1,351 @@
+package pubsub
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+	"github.com/Aryaman/pub-sub/utils"
+)
+
+// RateLimit is a token bucket refilled at Messages and Bytes per second,
+// holding at most one second's worth. A zero field is unlimited.
+type RateLimit struct {
+	Messages float64 `json:"messages"`
+	Bytes    float64 `json:"bytes"`
+}
+
+func (l RateLimit) enabled() bool {
+	return l.Messages > 0 || l.Bytes > 0
+}
+
+// RateLimits configures publish limits for each publisher, each topic and
+// each namespace. A topic's namespace is the part of its name before the
+// first '.'; topics without one have no namespace limit.
+type RateLimits struct {
+	Client    RateLimit
+	Topic     RateLimit
+	Namespace RateLimit
+}
+
+func (l RateLimits) enabled() bool {
+	return l.Client.enabled() || l.Topic.enabled() || l.Namespace.enabled()
+}
+
+// RateLimitError is returned when a publish exceeds a limit
+type RateLimitError struct {
+	Scope      string // "client", "topic" or "namespace"
+	Key        string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s %q publish rate exceeded, retry after %s", e.Scope, e.Key, e.RetryAfter)
+}
+
+// namespaceOf returns the namespace of a topic, empty when it has none
+func namespaceOf(topic string) string {
+	if i := strings.IndexByte(topic, '.'); i > 0 {
+		return topic[:i]
+	}
+	return ""
+}
+
+// bucketIdle is how long an untouched bucket is kept before it is dropped;
+// by then it has long refilled, so dropping it changes nothing
+const bucketIdle = time.Minute
+
+// bucket is a token bucket on messages and bytes with usage counters
+type bucket struct {
+	limit    RateLimit
+	messages float64
+	bytes    float64
+	last     time.Time
+
+	admitted      int64
+	admittedBytes int64
+	throttled     int64
+}
+
+func newBucket(limit RateLimit, now time.Time) *bucket {
+	return &bucket{limit: limit, messages: limit.Messages, bytes: limit.Bytes, last: now}
+}
+
+// refill adds the tokens earned since the last call
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	b.last = now
+	b.messages = math.Min(b.limit.Messages, b.messages+elapsed*b.limit.Messages)
+	b.bytes = math.Min(b.limit.Bytes, b.bytes+elapsed*b.limit.Bytes)
+}
+
+// wait returns how long until n messages totalling size bytes fit, zero if
+// they fit now. A charge larger than the burst waits for a full bucket and
+// then drives it negative, so it is delayed rather than never sent.
+func (b *bucket) wait(n, size int) time.Duration {
+	var wait float64
+	if b.limit.Messages > 0 {
+		need := math.Min(float64(n), b.limit.Messages)
+		if b.messages < need {
+			wait = (need - b.messages) / b.limit.Messages
+		}
+	}
+	if b.limit.Bytes > 0 {
+		need := math.Min(float64(size), b.limit.Bytes)
+		if b.bytes < need {
+			wait = math.Max(wait, (need-b.bytes)/b.limit.Bytes)
+		}
+	}
+	return time.Duration(math.Ceil(wait * float64(time.Second)))
+}
+
+func (b *bucket) take(n, size int) {
+	if b.limit.Messages > 0 {
+		b.messages -= float64(n)
+	}
+	if b.limit.Bytes > 0 {
+		b.bytes -= float64(size)
+	}
+	b.admitted += int64(n)
+	b.admittedBytes += int64(size)
+}
+
+func (b *bucket) usage() sdk.RateUsage {
+	usage := sdk.RateUsage{
+		Messages:  b.admitted,
+		Bytes:     b.admittedBytes,
+		Throttled: b.throttled,
+	}
+	if b.limit.Messages > 0 {
+		usage.MessagesPerSec = b.limit.Messages
+		usage.AvailableMessages = math.Max(0, b.messages)
+	}
+	if b.limit.Bytes > 0 {
+		usage.BytesPerSec = b.limit.Bytes
+		usage.AvailableBytes = math.Max(0, b.bytes)
+	}
+	return usage
+}
+
+// rateLimiter holds a bucket per publisher, topic and namespace
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[rateKey]*bucket
+	admits  int
+}
+
+type rateKey struct {
+	scope string
+	key   string
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[rateKey]*bucket)}
+}
+
+// charge is one publish's claim on the limiter
+type charge struct {
+	limits RateLimits
+	topic  string
+	size   int
+}
+
+// admit charges a publish of size bytes to every bucket it falls under, or
+// to none of them when any is exhausted
+func (r *rateLimiter) admit(limits RateLimits, clientID, topic string, size int, now time.Time) error {
+	return r.admitAll(clientID, []charge{{limits: limits, topic: topic, size: size}}, now)
+}
+
+// admitAll charges several publishes from clientID at once. Each bucket
+// must hold the combined share of every publish it falls under, otherwise
+// nothing is charged.
+func (r *rateLimiter) admitAll(clientID string, charges []charge, now time.Time) error {
+	type demand struct {
+		key      rateKey
+		bucket   *bucket
+		messages int
+		bytes    int
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.admits++
+	if r.admits%1024 == 0 {
+		r.prune(now)
+	}
+
+	var (
+		demands []*demand
+		byKey   = make(map[rateKey]*demand)
+		limited *RateLimitError
+	)
+	for _, ch := range charges {
+		scopes := [...]struct {
+			scope string
+			key   string
+			limit RateLimit
+		}{
+			{"client", clientID, ch.limits.Client},
+			{"topic", ch.topic, ch.limits.Topic},
+			{"namespace", namespaceOf(ch.topic), ch.limits.Namespace},
+		}
+		for _, sc := range scopes {
+			if !sc.limit.enabled() || sc.key == "" {
+				continue
+			}
+			key := rateKey{sc.scope, sc.key}
+			d, ok := byKey[key]
+			if !ok {
+				d = &demand{key: key, bucket: r.bucket(key, sc.limit, now)}
+				byKey[key] = d
+				demands = append(demands, d)
+			}
+			d.messages++
+			d.bytes += ch.size
+		}
+	}
+
+	for _, d := range demands {
+		if wait := d.bucket.wait(d.messages, d.bytes); wait > 0 && (limited == nil || wait > limited.RetryAfter) {
+			limited = &RateLimitError{Scope: d.key.scope, Key: d.key.key, RetryAfter: wait}
+		}
+	}
+
+	if limited != nil {
+		for _, d := range demands {
+			d.bucket.throttled++
+		}
+		return limited
+	}
+	for _, d := range demands {
+		d.bucket.take(d.messages, d.bytes)
+	}
+	return nil
+}
+
+// bucket returns the refilled bucket for key, creating it or applying a
+// changed limit as needed. Caller must hold r.mu.
+func (r *rateLimiter) bucket(key rateKey, limit RateLimit, now time.Time) *bucket {
+	b, ok := r.buckets[key]
+	if !ok {
+		b = newBucket(limit, now)
+		r.buckets[key] = b
+	}
+	if b.limit != limit {
+		// The topic's limit was changed since the bucket was made
+		b.refill(now)
+		b.limit = limit
+		b.messages = math.Min(b.messages, limit.Messages)
+		b.bytes = math.Min(b.bytes, limit.Bytes)
+	}
+	b.refill(now)
+	return b
+}
+
+// prune drops buckets that have been idle long enough to be full again.
+// Caller must hold r.mu.
+func (r *rateLimiter) prune(now time.Time) {
+	for key, b := range r.buckets {
+		if now.Sub(b.last) > bucketIdle {
+			delete(r.buckets, key)
+		}
+	}
+}
+
+// len returns the number of live buckets
+func (r *rateLimiter) len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.buckets)
+}
+
+// stats reports the usage of every live bucket
+func (r *rateLimiter) stats(now time.Time) *sdk.RateLimitStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := &sdk.RateLimitStats{
+		Clients:    make(map[string]sdk.RateUsage),
+		Topics:     make(map[string]sdk.RateUsage),
+		Namespaces: make(map[string]sdk.RateUsage),
+	}
+	for key, b := range r.buckets {
+		b.refill(now)
+		switch key.scope {
+		case "client":
+			stats.Clients[key.key] = b.usage()
+		case "topic":
+			stats.Topics[key.key] = b.usage()
+		case "namespace":
+			stats.Namespaces[key.key] = b.usage()
+		}
+	}
+	return stats
+}
+
+// Admit charges a publish from clientID against the configured rate limits
+// and returns a *RateLimitError when it must wait
+func (s *ServiceImpl) Admit(clientID, topic string, msg sdk.Message) error {
+	ch, err := s.charge(topic, msg)
+	if err != nil || !ch.limits.enabled() {
+		return err
+	}
+	return s.limiter.admit(ch.limits, clientID, topic, ch.size, time.Now())
+}
+
+// charge works out the limits a publish to topic falls under and, when any
+// limits bytes, the size of its payload
+func (s *ServiceImpl) charge(topic string, msg sdk.Message) (charge, error) {
+	limits := s.RateLimits
+	if t, ok := s.getTopic(topic); ok {
+		if override := t.limit.Load(); override != nil {
+			limits.Topic = *override
+		}
+	} else if auto := s.AutoCreate.Settings.RateLimit; auto != nil && s.createsOn(topic, AutoCreateOnPublish) {
+		// Charged before it is created, at the limit it will be created with
+		limits.Topic = RateLimit{Messages: auto.MessagesPerSec, Bytes: auto.BytesPerSec}
+	}
+	ch := charge{limits: limits, topic: topic}
+	if limits.Client.Bytes > 0 || limits.Topic.Bytes > 0 || limits.Namespace.Bytes > 0 {
+		data, err := utils.EncodePayload(msg.Payload)
+		if err != nil {
+			return ch, fmt.Errorf("failed to size payload: %w", err)
+		}
+		ch.size = len(data)
+	}
+	return ch, nil
+}
+
+// PublishFrom publishes msg on behalf of clientID once the publish
+// interceptors and the rate limits admit it, creating the topic if the
+// auto-create policy allows. Limits are enforced on the node the publish
+// arrives at.
+func (s *ServiceImpl) PublishFrom(clientID, topic string, msg sdk.Message) (sdk.Message, error) {
+	span := s.startPublishSpan(clientID, topic, &msg)
+	msg, err := s.publishFrom(clientID, topic, msg)
+	span.end(msg.Seq, err)
+	return msg, err
+}
+
+// publishFrom is PublishFrom without tracing
+func (s *ServiceImpl) publishFrom(clientID, topic string, msg sdk.Message) (sdk.Message, error) {
+	msg, err := s.interceptPublish(clientID, topic, msg)
+	if err != nil {
+		return msg, err
+	}
+	if err := s.Admit(clientID, topic, msg); err != nil {
+		return msg, err
+	}
+	// Only an admitted publish creates its topic
+	if err := s.autoCreate(topic, AutoCreateOnPublish); err != nil {
+		return msg, err
+	}
+	return s.publish(topic, msg)
+}
@@ -0,0 +1,309 @@
+package pubsub
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+)
+
+// registryShards is the number of independently locked slices of the topic
+// table
+const registryShards = 32
+
+// registry is the topic table, split into shards so lookups and topic
+// changes on different topics rarely meet on the same lock
+type registry struct {
+	shards [registryShards]registryShard
+}
+
+type registryShard struct {
+	mu     sync.RWMutex
+	topics map[string]*topic
+}
+
+func newRegistry() *registry {
+	r := &registry{}
+	for i := range r.shards {
+		r.shards[i].topics = make(map[string]*topic)
+	}
+	return r
+}
+
+// shardFor hashes name with FNV-1a, inlined to keep lookups allocation free
+func (r *registry) shardFor(name string) *registryShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(name); i++ {
+		h ^= uint32(name[i])
+		h *= 16777619
+	}
+	return &r.shards[h%registryShards]
+}
+
+func (r *registry) get(name string) (*topic, bool) {
+	shard := r.shardFor(name)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	t, ok := shard.topics[name]
+	return t, ok
+}
+
+// add registers t and reports false if the name is taken
+func (r *registry) add(t *topic) bool {
+	shard := r.shardFor(t.name)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if _, exists := shard.topics[t.name]; exists {
+		return false
+	}
+	shard.topics[t.name] = t
+	return true
+}
+
+// put registers t, replacing any topic with the same name
+func (r *registry) put(t *topic) {
+	shard := r.shardFor(t.name)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.topics[t.name] = t
+}
+
+func (r *registry) remove(name string) (*topic, bool) {
+	shard := r.shardFor(name)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	t, ok := shard.topics[name]
+	delete(shard.topics, name)
+	return t, ok
+}
+
+// removeTopic unregisters t, and reports false if its name now belongs to
+// another topic or to none
+func (r *registry) removeTopic(t *topic) bool {
+	shard := r.shardFor(t.name)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if shard.topics[t.name] != t {
+		return false
+	}
+	delete(shard.topics, t.name)
+	return true
+}
+
+// all returns every topic, one shard at a time
+func (r *registry) all() []*topic {
+	var out []*topic
+	for i := range r.shards {
+		shard := &r.shards[i]
+		shard.mu.RLock()
+		for _, t := range shard.topics {
+			out = append(out, t)
+		}
+		shard.mu.RUnlock()
+	}
+	return out
+}
+
+func (r *registry) len() int {
+	n := 0
+	for i := range r.shards {
+		shard := &r.shards[i]
+		shard.mu.RLock()
+		n += len(shard.topics)
+		shard.mu.RUnlock()
+	}
+	return n
+}
+
+// topic holds one topic's state. Publishing is split in two: sequencing and
+// the ring buffer append happen under mu, which is only held briefly, and
+// fan-out happens under fanMu, which keeps delivery in sequence order
+// without blocking subscribers or ring readers. Subscribers are kept in a
+// map under subsMu and handed to fan-out as an immutable copy-on-write
+// snapshot taken under mu, so a subscription made while mu is held sees
+// each message either in its replay or live, never both or neither.
+type topic struct {
+	name        string
+	maxMessages int
+
+	fanMu sync.Mutex
+
+	mu       sync.RWMutex
+	lastSeq  uint64
+	ring     *ring
+	paused   bool                   // delivery held back; the ring buffers what is published
+	pausedAt uint64                 // last sequence number fanned out before the pause
+	state    map[string]sdk.Message // compacted topics: newest live message per key, nil otherwise
+
+	createdAt time.Time // when an auto-created topic was made, zero for others
+
+	limit     atomic.Pointer[RateLimit] // overrides RateLimits.Topic, nil uses it
+	compacted atomic.Bool               // mirrors state != nil for checks made without mu
+
+	published atomic.Int64
+	delivered atomic.Int64
+	evicted   atomic.Int64
+
+	subsMu      sync.Mutex
+	subscribers map[string]*sdk.Subscriber // client_id -> Subscriber
+	snapshot    atomic.Pointer[[]*sdk.Subscriber]
+
+	exclusive exclusiveGroup
+}
+
+func newTopic(name string, maxMessages int) *topic {
+	t := &topic{
+		name:        name,
+		maxMessages: maxMessages,
+		ring:        newRing(maxMessages),
+		subscribers: make(map[string]*sdk.Subscriber),
+	}
+	t.snapshot.Store(&[]*sdk.Subscriber{})
+	return t
+}
+
+// subscriberList returns the current fan-out snapshot, which must not be
+// modified
+func (t *topic) subscriberList() []*sdk.Subscriber {
+	return *t.snapshot.Load()
+}
+
+// swap publishes a copy of the snapshot without old and with added, either
+// of which may be nil. Copying the slice is much cheaper than walking the
+// map. Caller must hold t.subsMu.
+func (t *topic) swap(old, added *sdk.Subscriber) {
+	current := t.subscriberList()
+	next := make([]*sdk.Subscriber, 0, len(current)+1)
+	for _, sub := range current {
+		if sub != old {
+			next = append(next, sub)
+		}
+	}
+	if added != nil {
+		next = append(next, added)
+	}
+	t.snapshot.Store(&next)
+}
+
+// refresh rebuilds the snapshot from the subscriber map after bulk changes.
+// Caller must hold t.subsMu.
+func (t *topic) refresh() {
+	subs := make([]*sdk.Subscriber, 0, len(t.subscribers))
+	for _, sub := range t.subscribers {
+		subs = append(subs, sub)
+	}
+	t.snapshot.Store(&subs)
+}
+
+// register adds sub, replacing and closing any previous subscriber with the
+// same client id
+func (t *topic) register(sub *sdk.Subscriber) {
+	t.subsMu.Lock()
+	defer t.subsMu.Unlock()
+	prev, exists := t.subscribers[sub.ClientID]
+	if exists {
+		close(prev.CloseChannel)
+	}
+	t.subscribers[sub.ClientID] = sub
+	t.swap(prev, sub)
+}
+
+// remove closes and drops sub if it is still the registered subscriber for
+// its client id
+func (t *topic) remove(sub *sdk.Subscriber) bool {
+	t.subsMu.Lock()
+	defer t.subsMu.Unlock()
+	if current, exists := t.subscribers[sub.ClientID]; !exists || current != sub {
+		return false
+	}
+	close(sub.CloseChannel)
+	delete(t.subscribers, sub.ClientID)
+	t.swap(sub, nil)
+	return true
+}
+
+// evict removes an overflowing subscriber like remove, flagging it first
+func (t *topic) evict(sub *sdk.Subscriber) bool {
+	t.subsMu.Lock()
+	defer t.subsMu.Unlock()
+	if current, exists := t.subscribers[sub.ClientID]; !exists || current != sub {
+		return false
+	}
+	sub.Evicted.Store(true)
+	close(sub.CloseChannel)
+	delete(t.subscribers, sub.ClientID)
+	t.swap(sub, nil)
+	return true
+}
+
+// removeClient closes and drops whichever subscriber has clientID
+func (t *topic) removeClient(clientID string) {
+	t.subsMu.Lock()
+	defer t.subsMu.Unlock()
+	if sub, exists := t.subscribers[clientID]; exists {
+		close(sub.CloseChannel)
+		delete(t.subscribers, clientID)
+		t.swap(sub, nil)
+	}
+}
+
+// closeAll disconnects every subscriber
+func (t *topic) closeAll() {
+	t.subsMu.Lock()
+	defer t.subsMu.Unlock()
+	for _, sub := range t.subscribers {
+		close(sub.CloseChannel)
+	}
+	t.subscribers = make(map[string]*sdk.Subscriber)
+	t.swap(nil, nil)
+}
+
+// fanOut queues msg for every subscriber in subs, as deliver has it when
+// set, and disconnects those whose queue is full. Subscribers that joined
+// after msg was sequenced already had it replayed, or never should see it.
+// Caller must hold t.fanMu. With tracing, each subscriber's outcome is
+// recorded as a span of trace.
+func (t *topic) fanOut(subs []*sdk.Subscriber, msg sdk.Message, deliver deliverFunc, trace *fanOutTrace) {
+	var slowConsumers []*sdk.Subscriber
+	delivered := 0
+	for _, sub := range subs {
+		if msg.Seq <= sub.StartSeq {
+			continue
+		}
+		start := trace.start()
+		out := msg
+		if deliver != nil {
+			var ok bool
+			if out, ok = deliver(sub, msg); !ok {
+				trace.record(sdk.SpanNameDrop, sub, start, "withheld by interceptor")
+				continue
+			}
+		}
+		select {
+		case sub.Queue <- out:
+			// Message delivered successfully
+			delivered++
+			trace.record(sdk.SpanNameEnqueue, sub, start, "")
+		default:
+			if sub.FlowControl {
+				// Credit-mode subscribers catch up from the ring buffer,
+				// traced when written
+				sub.Lagging.Store(true)
+				continue
+			}
+			// Queue full - mark as slow consumer for removal
+			slowConsumers = append(slowConsumers, sub)
+			trace.record(sdk.SpanNameEvict, sub, start, "subscriber queue full")
+		}
+	}
+
+	t.delivered.Add(int64(delivered))
+
+	// Remove slow consumers (backpressure policy: disconnect on overflow)
+	for _, sub := range slowConsumers {
+		if t.evict(sub) {
+			t.evicted.Add(1)
+		}
+	}
+}
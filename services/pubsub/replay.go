@@ -0,0 +1,112 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	// ErrInvalidReplay is returned for a replay without a source and a
+	// distinct target, or with a negative speed
+	ErrInvalidReplay = errors.New("invalid replay")
+
+	// ErrReplayClustered is returned for replays in cluster mode, where this
+	// node may hold only part of the source topic's history
+	ErrReplayClustered = errors.New("replays are not supported in cluster mode")
+)
+
+// StartReplay starts a job republishing the source topic's retained
+// messages in the requested range into the target topic. The range is read
+// when the job starts, so messages published later are not replayed. Each
+// message goes out as newly published, keeping its id, key, payload and
+// attributes, after the gap that preceded it originally, divided by the
+// speed.
+func (s *ServiceImpl) StartReplay(req sdk.ReplayRequest) (sdk.Job, error) {
+	if s.Cluster != nil {
+		return sdk.Job{}, ErrReplayClustered
+	}
+	switch {
+	case req.Source == "" || req.Target == "":
+		return sdk.Job{}, fmt.Errorf("%w: source and target required", ErrInvalidReplay)
+	case req.Source == req.Target:
+		return sdk.Job{}, fmt.Errorf("%w: target must differ from source", ErrInvalidReplay)
+	case req.Speed < 0 || math.IsInf(req.Speed, 0) || math.IsNaN(req.Speed):
+		return sdk.Job{}, fmt.Errorf("%w: speed must be positive", ErrInvalidReplay)
+	}
+	if req.Speed == 0 {
+		req.Speed = 1
+	}
+
+	msgs, _, err := s.Browse(req.Source, BrowseQuery{
+		AfterSeq:  req.AfterSeq,
+		BeforeSeq: req.BeforeSeq,
+		Since:     req.Since,
+		Until:     req.Until,
+		Limit:     math.MaxInt,
+	})
+	if err != nil {
+		return sdk.Job{}, err
+	}
+	if err := s.autoCreate(req.Target, AutoCreateOnPublish); err != nil {
+		return sdk.Job{}, err
+	}
+	if !isInbox(req.Target) && !s.HasTopic(req.Target) {
+		return sdk.Job{}, ErrTopicNotFound
+	}
+
+	info := sdk.Job{
+		Kind:   sdk.JobKindReplay,
+		Target: req.Target,
+		Total:  int64(len(msgs)),
+		Replay: &req,
+	}
+	return s.startJob(info, func(j *job) error {
+		return s.replay(j, req.Target, req.Speed, msgs)
+	}), nil
+}
+
+// replay publishes msgs to target on their original schedule, scaled by
+// speed. Each message is due relative to the first rather than to the one
+// before, so publish latency does not add up over a long replay.
+func (s *ServiceImpl) replay(j *job, target string, speed float64, msgs []sdk.Message) error {
+	start := time.Now()
+	for _, msg := range msgs {
+		offset := time.Duration(float64(msg.TS.Sub(msgs[0].TS)) / speed)
+		paused, err := j.sleep(time.Until(start.Add(offset)), s.done)
+		if err != nil {
+			return err
+		}
+		start = start.Add(paused)
+
+		msg.Seq = 0
+		msg.TS = time.Time{}
+		if _, err := s.Publish(target, msg); err != nil {
+			return fmt.Errorf("message %s: %w", msg.ID, err)
+		}
+		j.published()
+	}
+	return nil
+}
+
+// CreateReplay starts a replay job via REST API
+func (s *ServiceImpl) CreateReplay(ctx context.Context, c *fiber.Ctx) error {
+	var req sdk.ReplayRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(sdk.ErrorResponse{
+			Error: "invalid request body",
+		})
+	}
+
+	job, err := s.StartReplay(req)
+	if err != nil {
+		return jobError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(job)
+}
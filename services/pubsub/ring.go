@@ -0,0 +1,112 @@
+package pubsub
+
+import (
+	"sort"
+
+	"github.com/Aryaman/pub-sub/sdk"
+)
+
+// ring is a fixed-size buffer of a topic's newest messages. Once full, each
+// push overwrites the oldest slot, so memory stays constant and nothing is
+// copied or re-sliced on the publish path.
+type ring struct {
+	buf   []sdk.Message
+	start int // index of the oldest message
+	size  int
+}
+
+func newRing(capacity int) *ring {
+	if capacity < 0 {
+		capacity = 0
+	}
+	return &ring{buf: make([]sdk.Message, capacity)}
+}
+
+func (r *ring) len() int {
+	return r.size
+}
+
+// push appends msg, dropping the oldest message when full. A zero-capacity
+// ring retains nothing.
+func (r *ring) push(msg sdk.Message) {
+	if len(r.buf) == 0 {
+		return
+	}
+	if r.size < len(r.buf) {
+		r.buf[(r.start+r.size)%len(r.buf)] = msg
+		r.size++
+		return
+	}
+	r.buf[r.start] = msg
+	r.start = (r.start + 1) % len(r.buf)
+}
+
+// at returns the i-th oldest message
+func (r *ring) at(i int) sdk.Message {
+	return r.buf[(r.start+i)%len(r.buf)]
+}
+
+// last copies up to n of the newest messages, oldest first
+func (r *ring) last(n int) []sdk.Message {
+	if n > r.size {
+		n = r.size
+	}
+	if n <= 0 {
+		return nil
+	}
+	out := make([]sdk.Message, n)
+	for i := range out {
+		out[i] = r.at(r.size - n + i)
+	}
+	return out
+}
+
+// after copies the messages sequenced after seq. Sequence numbers only
+// grow, so the first one is found by binary search.
+func (r *ring) after(seq uint64) []sdk.Message {
+	i := sort.Search(r.size, func(i int) bool { return r.at(i).Seq > seq })
+	return r.last(r.size - i)
+}
+
+// scan calls fn on each message sequenced after seq, oldest first, until
+// fn returns false
+func (r *ring) scan(seq uint64, fn func(sdk.Message) bool) {
+	for i := sort.Search(r.size, func(i int) bool { return r.at(i).Seq > seq }); i < r.size; i++ {
+		if !fn(r.at(i)) {
+			return
+		}
+	}
+}
+
+// upTo copies up to n of the newest messages sequenced at or before seq
+func (r *ring) upTo(seq uint64, n int) []sdk.Message {
+	end := sort.Search(r.size, func(i int) bool { return r.at(i).Seq > seq })
+	start := end - n
+	if start < 0 {
+		start = 0
+	}
+	if start >= end {
+		return nil
+	}
+	out := make([]sdk.Message, end-start)
+	for i := range out {
+		out[i] = r.at(start + i)
+	}
+	return out
+}
+
+// resize changes the capacity, keeping the newest messages that fit
+func (r *ring) resize(capacity int) {
+	if capacity < 0 {
+		capacity = 0
+	}
+	kept := r.last(capacity)
+	r.buf = make([]sdk.Message, capacity)
+	r.start, r.size = 0, copy(r.buf, kept)
+}
+
+// reset drops every message
+func (r *ring) reset() {
+	clear(r.buf)
+	r.start, r.size = 0, 0
+}
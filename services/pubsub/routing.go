@@ -0,0 +1,298 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Aryaman/pub-sub/sdk"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Routing rules copy messages between topics as they are published. They
+// run on the node a message is published through, after it has been
+// delivered to the source topic's subscribers, and copies are routed again,
+// so rules chain. Rules that could send a message back to a topic it came
+// from are refused when they are added. Rules are kept per node, so they are
+// refused in cluster mode, and any restored from a state file do not run.
+
+var (
+	// ErrInvalidRule is returned for a rule without a source or target
+	ErrInvalidRule = errors.New("invalid routing rule")
+
+	// ErrRuleExists is returned when adding a rule with an id in use
+	ErrRuleExists = errors.New("routing rule already exists")
+
+	// ErrRuleNotFound is returned when an operation targets an unknown rule
+	ErrRuleNotFound = errors.New("routing rule not found")
+
+	// ErrRuleLoop is returned for a rule that would route messages in a loop
+	ErrRuleLoop = errors.New("routing rule would create a loop")
+
+	// ErrRoutingClustered is returned for routing rules in cluster mode,
+	// where publishes through other nodes would not see them
+	ErrRoutingClustered = errors.New("routing rules are not supported in cluster mode")
+)
+
+// routeRule is a rule with its compiled match and counters
+type routeRule struct {
+	sdk.RouteRule
+	match  BrowseQuery
+	routed atomic.Int64
+	failed atomic.Int64
+}
+
+func (r *routeRule) detail() sdk.RouteRuleDetail {
+	return sdk.RouteRuleDetail{RouteRule: r.RouteRule, Routed: r.routed.Load(), Failed: r.failed.Load()}
+}
+
+// copyOf returns the message the rule publishes to its target for msg
+func (r *routeRule) copyOf(msg sdk.Message) sdk.Message {
+	out := sdk.Message{ID: msg.ID, Key: msg.Key, Payload: msg.Payload}
+	if len(r.Fields) > 0 {
+		out.Payload = project(msg.Payload, r.Fields)
+	}
+	if len(msg.Attributes) > 0 || len(r.Attributes) > 0 {
+		out.Attributes = make(map[string]string, len(msg.Attributes)+len(r.Attributes))
+		for k, v := range msg.Attributes {
+			out.Attributes[k] = v
+		}
+		for k, v := range r.Attributes {
+			out.Attributes[k] = v
+		}
+	}
+	return out
+}
+
+// project builds an object holding only the given dotted paths of payload.
+// Paths the payload does not have are left out.
+func project(payload interface{}, fields []string) map[string]interface{} {
+	out := make(map[string]interface{})
+	for _, path := range fields {
+		value, ok := payloadValue(payload, path)
+		if !ok {
+			continue
+		}
+		keys := strings.Split(path, ".")
+		dst := out
+		for _, key := range keys[:len(keys)-1] {
+			next, ok := dst[key].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				dst[key] = next
+			}
+			dst = next
+		}
+		dst[keys[len(keys)-1]] = value
+	}
+	return out
+}
+
+// routeTable holds the rules. Changes are serialized by mu and publish a
+// fresh by-source index, so publishes look rules up without locking.
+type routeTable struct {
+	mu       sync.Mutex
+	rules    map[string]*routeRule
+	bySource atomic.Pointer[map[string][]*routeRule]
+}
+
+func newRouteTable() *routeTable {
+	rt := &routeTable{rules: make(map[string]*routeRule)}
+	rt.bySource.Store(&map[string][]*routeRule{})
+	return rt
+}
+
+// from returns the rules whose source is topic, which must not be modified
+func (rt *routeTable) from(topic string) []*routeRule {
+	return (*rt.bySource.Load())[topic]
+}
+
+// reindex rebuilds the by-source index. Caller must hold rt.mu.
+func (rt *routeTable) reindex() {
+	index := make(map[string][]*routeRule)
+	for _, r := range rt.rules {
+		index[r.Source] = append(index[r.Source], r)
+	}
+	for _, rules := range index {
+		sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	}
+	rt.bySource.Store(&index)
+}
+
+// loop returns the topics a message would pass through back to source if
+// a rule from source to target were added, or nil when it would not loop.
+// Match filters are ignored, since any message might pass them. Caller must
+// hold rt.mu.
+func (rt *routeTable) loop(source, target string) []string {
+	index := *rt.bySource.Load()
+	parent := map[string]string{target: ""}
+	queue := []string{target}
+	for len(queue) > 0 {
+		topic := queue[0]
+		queue = queue[1:]
+		if topic == source {
+			var back []string
+			for t := source; t != ""; t = parent[t] {
+				back = append(back, t)
+			}
+			path := []string{source}
+			for i := len(back) - 1; i >= 0; i-- {
+				path = append(path, back[i])
+			}
+			return path
+		}
+		for _, r := range index[topic] {
+			if _, seen := parent[r.Target]; !seen {
+				parent[r.Target] = topic
+				queue = append(queue, r.Target)
+			}
+		}
+	}
+	return nil
+}
+
+// AddRoute validates and adds a routing rule, assigning an id when it has
+// none, and returns the rule as stored
+func (s *ServiceImpl) AddRoute(rule sdk.RouteRule) (sdk.RouteRule, error) {
+	if s.Cluster != nil {
+		return rule, ErrRoutingClustered
+	}
+	if rule.Source == "" || rule.Target == "" {
+		return rule, fmt.Errorf("%w: source and target required", ErrInvalidRule)
+	}
+	for _, path := range rule.Fields {
+		if path == "" || strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") || strings.Contains(path, "..") {
+			return rule, fmt.Errorf("%w: bad field %q", ErrInvalidRule, path)
+		}
+	}
+	if rule.ID == "" {
+		rule.ID = uuid.New().String()
+	}
+
+	rt := s.routes
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if _, exists := rt.rules[rule.ID]; exists {
+		return rule, ErrRuleExists
+	}
+	if path := rt.loop(rule.Source, rule.Target); path != nil {
+		return rule, fmt.Errorf("%w: %s", ErrRuleLoop, strings.Join(path, " -> "))
+	}
+
+	rt.rules[rule.ID] = &routeRule{
+		RouteRule: rule,
+		match:     BrowseQuery{Attributes: rule.Match.Attributes, Payload: rule.Match.Payload},
+	}
+	rt.reindex()
+	return rule, nil
+}
+
+// RemoveRoute deletes a routing rule
+func (s *ServiceImpl) RemoveRoute(id string) error {
+	rt := s.routes
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if _, ok := rt.rules[id]; !ok {
+		return ErrRuleNotFound
+	}
+	delete(rt.rules, id)
+	rt.reindex()
+	return nil
+}
+
+// Routes returns every routing rule with its counters, ordered by id
+func (s *ServiceImpl) Routes() []sdk.RouteRuleDetail {
+	rt := s.routes
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rules := make([]sdk.RouteRuleDetail, 0, len(rt.rules))
+	for _, r := range rt.rules {
+		rules = append(rules, r.detail())
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return rules
+}
+
+// route publishes msg, just published to topic, to the target of every
+// rule it matches. A target that refuses the copy counts against the rule
+// without affecting the original publish.
+func (s *ServiceImpl) route(topic string, msg sdk.Message) {
+	if s.Cluster != nil {
+		return
+	}
+	for _, r := range s.routes.from(topic) {
+		if !r.match.matches(msg) {
+			continue
+		}
+		err := s.autoCreate(r.Target, AutoCreateOnPublish)
+		if err == nil {
+			_, err = s.Publish(r.Target, r.copyOf(msg))
+		}
+		if err != nil {
+			r.failed.Add(1)
+			continue
+		}
+		r.routed.Add(1)
+	}
+}
+
+// CreateRoute adds a routing rule via REST API
+func (s *ServiceImpl) CreateRoute(ctx context.Context, c *fiber.Ctx) error {
+	var req sdk.RouteRule
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(sdk.ErrorResponse{
+			Error: "invalid request body",
+		})
+	}
+
+	rule, err := s.AddRoute(req)
+	if err != nil {
+		status := fiber.StatusBadRequest
+		if errors.Is(err, ErrRuleExists) {
+			status = fiber.StatusConflict
+		}
+		return c.Status(status).JSON(sdk.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusCreated).JSON(sdk.RouteRuleDetail{RouteRule: rule})
+}
+
+// ListRoutes returns every routing rule via REST API
+func (s *ServiceImpl) ListRoutes(ctx context.Context, c *fiber.Ctx) error {
+	return c.JSON(sdk.ListRouteRulesResponse{Rules: s.Routes()})
+}
+
+// GetRoute returns one routing rule with its counters via REST API
+func (s *ServiceImpl) GetRoute(ctx context.Context, c *fiber.Ctx) error {
+	id := c.Params("id")
+	s.routes.mu.Lock()
+	r, ok := s.routes.rules[id]
+	s.routes.mu.Unlock()
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(sdk.ErrorResponse{
+			Error: ErrRuleNotFound.Error(),
+		})
+	}
+	return c.JSON(r.detail())
+}
+
+// DeleteRoute removes a routing rule via REST API
+func (s *ServiceImpl) DeleteRoute(ctx context.Context, c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := s.RemoveRoute(id); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(sdk.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	return c.JSON(sdk.DeleteRouteRuleResponse{
+		Status: sdk.StatusDeleted,
+		ID:     id,
+	})
+}
@@ -16,7 +16,35 @@ type PubSub interface {
 	HandleWebSocket(ctx context.Context, c *websocket.Conn)
 	CreateTopic(ctx context.Context, c *fiber.Ctx) error
 	DeleteTopic(ctx context.Context, c *fiber.Ctx) error
+	GetTopic(ctx context.Context, c *fiber.Ctx) error
+	UpdateTopic(ctx context.Context, c *fiber.Ctx) error
+	PurgeTopic(ctx context.Context, c *fiber.Ctx) error
+	PauseTopic(ctx context.Context, c *fiber.Ctx) error
+	ResumeTopic(ctx context.Context, c *fiber.Ctx) error
+	BrowseMessages(ctx context.Context, c *fiber.Ctx) error
+	ExportTopic(ctx context.Context, c *fiber.Ctx) error
+	ImportTopic(ctx context.Context, c *fiber.Ctx) error
+	PublishTx(ctx context.Context, c *fiber.Ctx) error
+	CreateRoute(ctx context.Context, c *fiber.Ctx) error
+	ListRoutes(ctx context.Context, c *fiber.Ctx) error
+	GetRoute(ctx context.Context, c *fiber.Ctx) error
+	DeleteRoute(ctx context.Context, c *fiber.Ctx) error
+	ListClients(ctx context.Context, c *fiber.Ctx) error
+	DisconnectClient(ctx context.Context, c *fiber.Ctx) error
+	MessageClient(ctx context.Context, c *fiber.Ctx) error
+	BroadcastMessage(ctx context.Context, c *fiber.Ctx) error
+	CreateReplay(ctx context.Context, c *fiber.Ctx) error
+	ListJobs(ctx context.Context, c *fiber.Ctx) error
+	GetJob(ctx context.Context, c *fiber.Ctx) error
+	PauseJob(ctx context.Context, c *fiber.Ctx) error
+	ResumeJob(ctx context.Context, c *fiber.Ctx) error
+	CancelJob(ctx context.Context, c *fiber.Ctx) error
+	GetTrace(ctx context.Context, c *fiber.Ctx) error
+	CreateGenerator(ctx context.Context, c *fiber.Ctx) error
+	ListGenerators(ctx context.Context, c *fiber.Ctx) error
+	DeleteGenerator(ctx context.Context, c *fiber.Ctx) error
 	ListTopics(ctx context.Context, c *fiber.Ctx) error
 	Health(ctx context.Context, c *fiber.Ctx) error
 	Stats(ctx context.Context, c *fiber.Ctx) error
+	Shutdown(ctx context.Context) error
 }
@@ -2,10 +2,15 @@ package pubsub
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Aryaman/pub-sub/sdk"
+	"github.com/Aryaman/pub-sub/sdk/codec"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
@@ -13,20 +18,65 @@ import (
 
 // ServiceImpl implements the PubSub interface with thread-safe operations
 type ServiceImpl struct {
-	Topics      map[string]*sdk.Topic
-	mu          sync.RWMutex
+	topics      *registry
 	Uptime      time.Time
 	MaxQueue    int // per-subscriber queue size
 	MaxMessages int // per-topic ring buffer size
+
+	PingInterval time.Duration // server-initiated WS ping period, 0 disables
+	ReadTimeout  time.Duration // read deadline extended on activity, 0 disables
+
+	sessions          map[*websocket.Conn]*wsSession
+	sessionsMu        sync.Mutex
+	reapedClients     atomic.Int64
+	reapedSubscribers atomic.Int64
+	done              chan struct{}
+	draining          atomic.Bool
+
+	StateFile string // snapshot written on shutdown and loaded on start, empty disables
+
+	Cluster Cluster // routes topic operations to their owner, nil when standalone
+
+	RateLimits RateLimits // publish limits, enforced by PublishFrom
+	limiter    *rateLimiter
+
+	AutoCreate AutoCreatePolicy // topics created by client publishes and subscribes
+
+	routes *routeTable
+
+	InboxTTL  time.Duration // how long a client inbox holds messages while nobody reads it, 0 disables holding
+	InboxSize int           // most messages an inbox holds
+	inboxes   map[string]*inbox
+	inboxesMu sync.Mutex
+	inboxSeq  atomic.Uint64
+
+	jobs   map[string]*job
+	jobsMu sync.Mutex
+
+	interceptors   atomic.Pointer[[]Interceptor]
+	interceptorsMu sync.Mutex
+
+	tracer atomic.Pointer[tracer] // nil while tracing is off
+
+	observers    map[int]TopicObserver
+	observersMu  sync.Mutex
+	nextObserver int
 }
 
 // NewService creates a new PubSub service instance with config
 func NewService(maxQueue, maxMessages int) *ServiceImpl {
 	return &ServiceImpl{
-		Topics:      make(map[string]*sdk.Topic),
+		topics:      newRegistry(),
 		Uptime:      time.Now(),
 		MaxQueue:    maxQueue,
 		MaxMessages: maxMessages,
+		sessions:    make(map[*websocket.Conn]*wsSession),
+		done:        make(chan struct{}),
+		observers:   make(map[int]TopicObserver),
+		limiter:     newRateLimiter(),
+		routes:      newRouteTable(),
+		inboxes:     make(map[string]*inbox),
+		jobs:        make(map[string]*job),
 	}
 }
 
@@ -36,13 +86,24 @@ type wsMessage struct {
 	Data interface{}
 }
 
+// wsTypeClose asks the writer to send a close frame carrying Data and stop
+const wsTypeClose = "close"
+
 // HandleWebSocket processes WebSocket connections and messages
 func (s *ServiceImpl) HandleWebSocket(ctx context.Context, c *websocket.Conn) {
 	defer c.Close()
 
-	var clientID string
-	var currentTopic *sdk.Topic
-	var currentSub *sdk.Subscriber
+	var readErr error
+
+	// Frames use the encoding negotiated through Sec-WebSocket-Protocol
+	wire := codec.ForSubprotocol(c.Subprotocol())
+
+	// Refuse new connections once shutdown has begun
+	if s.draining.Load() {
+		writeFrame(c, wire, infoFrame(shutdownNotice))
+		c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, shutdownNotice), time.Now().Add(writeWait))
+		return
+	}
 
 	// Create a channel for serializing all WebSocket writes
 	writeChannel := make(chan wsMessage, 100)
@@ -51,247 +112,254 @@ func (s *ServiceImpl) HandleWebSocket(ctx context.Context, c *websocket.Conn) {
 	// Start WebSocket writer goroutine - this is the ONLY goroutine that writes to the connection
 	go func() {
 		defer close(writerDone)
-		for msg := range writeChannel {
-			if err := c.WriteJSON(msg.Data); err != nil {
-				// Connection closed or error - stop processing
-				return
+
+		var pings <-chan time.Time
+		if s.PingInterval > 0 {
+			ticker := time.NewTicker(s.PingInterval)
+			defer ticker.Stop()
+			pings = ticker.C
+		}
+
+		for {
+			select {
+			case msg, ok := <-writeChannel:
+				if !ok {
+					return
+				}
+				if msg.Type == wsTypeClose {
+					// Server-initiated close; the reader unwinds once the peer answers
+					c.WriteControl(websocket.CloseMessage, msg.Data.([]byte), time.Now().Add(writeWait))
+					return
+				}
+				frame := msg.Data.(sdk.WebSocketResponse)
+				start := time.Now()
+				err := writeFrame(c, wire, frame)
+				if frame.Type == sdk.MessageTypeEvent || frame.Type == sdk.MessageTypeEventBatch {
+					s.traceWrite(frame, start, c.RemoteAddr().String(), err)
+				}
+				if err != nil {
+					// Connection closed or error - stop processing
+					return
+				}
+			case <-pings:
+				if err := c.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+					return
+				}
 			}
 		}
 	}()
 
+	// writeMu guards writeChannel so it is closed exactly once
+	var writeMu sync.Mutex
+	writeClosed := false
+	closeWriter := func() {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if !writeClosed {
+			writeClosed = true
+			close(writeChannel)
+		}
+	}
+
 	// Helper function to safely send messages through the writer channel
 	sendMessage := func(msgType string, data interface{}) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if writeClosed {
+			return
+		}
 		select {
 		case writeChannel <- wsMessage{Type: msgType, Data: data}:
 		default:
 			// Write channel full - connection is too slow, close it
+			writeClosed = true
 			close(writeChannel)
 		}
 	}
 
+	sess := s.registerSession(c, sendMessage)
+	defer func() { s.unregisterSession(sess, readErr) }()
+
+	// Credit windows of the credit-mode subscriptions, by topic name
+	windows := make(map[string]*creditWindow)
+
 	for {
 		// Parse incoming message using SDK struct
+		_, data, err := c.ReadMessage()
+		if err != nil {
+			// Connection closed or read deadline expired
+			readErr = err
+			break
+		}
 		var req sdk.WebSocketRequest
-		if err := c.ReadJSON(&req); err != nil {
-			// Connection closed or invalid JSON
+		if err := wire.DecodeRequest(data, &req); err != nil {
+			// Undecodable frame
+			readErr = err
 			break
 		}
+		sess.touch()
+		sess.identify(req.ClientID)
+		s.extendReadDeadline(c)
 
 		// Handle different message types according to protocol specification
 		switch req.Type {
 		case sdk.MessageTypeSubscribe:
 			if req.Topic == "" || req.ClientID == "" {
-				sendMessage("error", sdk.WebSocketResponse{
-					Type:      sdk.MessageTypeError,
-					RequestID: req.RequestID,
-					Error: &sdk.ErrorDetail{
-						Code:    sdk.ErrorCodeBadRequest,
-						Message: "topic and client_id required",
-					},
-					Timestamp: time.Now().UTC().Format(time.RFC3339),
-				})
+				sendMessage("error", errorFrame(req.RequestID, sdk.ErrorCodeBadRequest, "topic and client_id required"))
 				continue
 			}
-
-			clientID = req.ClientID
-
-			// Check if topic exists
-			s.mu.RLock()
-			topic, ok := s.Topics[req.Topic]
-			s.mu.RUnlock()
-
-			if !ok {
-				sendMessage("error", sdk.WebSocketResponse{
-					Type:      sdk.MessageTypeError,
-					RequestID: req.RequestID,
-					Error: &sdk.ErrorDetail{
-						Code:    sdk.ErrorCodeTopicNotFound,
-						Message: "topic not found",
-					},
-					Timestamp: time.Now().UTC().Format(time.RFC3339),
-				})
+			if req.Credit < 0 {
+				sendMessage("error", errorFrame(req.RequestID, sdk.ErrorCodeBadRequest, "credit must not be negative"))
+				continue
+			}
+			if req.BatchSize < 0 || req.BatchSize > maxBatch || req.BatchWaitMS < 0 {
+				sendMessage("error", errorFrame(req.RequestID, sdk.ErrorCodeBadRequest, fmt.Sprintf("batch_size must be 0-%d and batch_wait_ms not negative", maxBatch)))
+				continue
+			}
+			if req.Credit > 0 && req.BatchSize > 1 {
+				sendMessage("error", errorFrame(req.RequestID, sdk.ErrorCodeBadRequest, "credit and batch delivery cannot be combined"))
+				continue
+			}
+			if isInbox(req.Topic) && !sess.mayReadInbox(req.Topic) {
+				sendMessage("error", topicErrorFrame(req.RequestID, fmt.Errorf("%w: %s belongs to another client", ErrUnauthorized, req.Topic)))
 				continue
 			}
 
-			// Create subscriber with bounded queue
+			// Create subscriber with bounded queue; a credit opts into pull mode
 			sub := &sdk.Subscriber{
 				Conn:         c,
-				ClientID:     clientID,
+				ClientID:     req.ClientID,
 				Queue:        make(chan sdk.Message, s.MaxQueue),
 				QueueSize:    s.MaxQueue,
 				LastActive:   time.Now(),
 				CloseChannel: make(chan struct{}),
+				FlowControl:  req.Credit > 0,
 			}
 
-			// Add subscriber to topic and handle replay if requested
-			topic.Mu.Lock()
-			topic.Subscribers[clientID] = sub
-
-			// Replay last_n messages from ring buffer
-			if req.LastN > 0 && len(topic.Messages) > 0 {
-				n := req.LastN
-				if n > len(topic.Messages) {
-					n = len(topic.Messages)
-				}
-				// Send the last n messages
-				start := len(topic.Messages) - n
-				for _, msg := range topic.Messages[start:] {
-					select {
-					case sub.Queue <- msg:
-					default:
-						// Queue full during replay - disconnect slow consumer
-						close(sub.CloseChannel)
-						delete(topic.Subscribers, clientID)
-						sendMessage("error", sdk.WebSocketResponse{
-							Type:      sdk.MessageTypeError,
-							RequestID: req.RequestID,
-							Error: &sdk.ErrorDetail{
-								Code:    sdk.ErrorCodeSlowConsumer,
-								Message: "subscriber queue overflow during replay",
-							},
-							Timestamp: time.Now().UTC().Format(time.RFC3339),
-						})
-						topic.Mu.Unlock()
-						goto nextMessage
-					}
-				}
+			// Add subscriber to topic and handle replay if requested; a
+			// reconnecting client resumes after the last sequence it saw,
+			// and a state subscription starts from a compacted topic's
+			// current values. An exclusive subscriber may have to wait as
+			// a standby, told once it takes over.
+			from := replayFrom{lastN: req.LastN, afterSeq: req.AfterSeq, state: req.State}
+			active := true
+			var err error
+			if req.Exclusive {
+				topic := req.Topic
+				active, err = s.subscribeExclusive(topic, sub, from, func(afterSeq uint64) {
+					sendMessage("info", promotedFrame(topic, afterSeq))
+				})
+			} else {
+				_, err = s.subscribe(req.Topic, sub, from)
 			}
-			topic.Mu.Unlock()
-
-			currentTopic = topic
-			currentSub = sub
+			if err != nil {
+				sendMessage("error", topicErrorFrame(req.RequestID, err))
+				continue
+			}
+			sess.setSubscription(req.Topic, sub)
+			delete(windows, req.Topic)
 
 			// Start message delivery goroutine - it will use the same writeChannel
-			go s.subscriberWriter(sub, req.Topic, sendMessage)
+			if sub.FlowControl {
+				window := newCreditWindow(req.Credit)
+				windows[req.Topic] = window
+				go s.creditWriter(sub, req.Topic, window, sendMessage)
+			} else if req.BatchSize > 1 {
+				go s.batchWriter(sub, req.Topic, req.BatchSize, time.Duration(req.BatchWaitMS)*time.Millisecond, sendMessage)
+			} else {
+				go s.subscriberWriter(sub, req.Topic, sendMessage)
+			}
 
-			sendMessage("ack", sdk.WebSocketResponse{
-				Type:      sdk.MessageTypeAck,
-				RequestID: req.RequestID,
-				Topic:     req.Topic,
-				Status:    sdk.StatusOK,
-				Timestamp: time.Now().UTC().Format(time.RFC3339),
-			})
+			ack := ackFrame(req.RequestID, req.Topic)
+			ack.Seq = sub.StartSeq
+			if !active {
+				ack.Status = sdk.StatusStandby
+			}
+			sendMessage("ack", ack)
 
 		case sdk.MessageTypeUnsubscribe:
 			if req.Topic == "" || req.ClientID == "" {
-				sendMessage("error", sdk.WebSocketResponse{
-					Type:      sdk.MessageTypeError,
-					RequestID: req.RequestID,
-					Error: &sdk.ErrorDetail{
-						Code:    sdk.ErrorCodeBadRequest,
-						Message: "topic and client_id required",
-					},
-					Timestamp: time.Now().UTC().Format(time.RFC3339),
-				})
+				sendMessage("error", errorFrame(req.RequestID, sdk.ErrorCodeBadRequest, "topic and client_id required"))
 				continue
 			}
 
-			s.mu.RLock()
-			topic, ok := s.Topics[req.Topic]
-			s.mu.RUnlock()
-
-			if !ok {
-				sendMessage("error", sdk.WebSocketResponse{
-					Type:      sdk.MessageTypeError,
-					RequestID: req.RequestID,
-					Error: &sdk.ErrorDetail{
-						Code:    sdk.ErrorCodeTopicNotFound,
-						Message: "topic not found",
-					},
-					Timestamp: time.Now().UTC().Format(time.RFC3339),
-				})
+			// Remove subscriber from topic; the client's other sessions
+			// keep reading its inbox
+			if sub := sess.subscription(req.Topic); sub != nil && isInbox(req.Topic) {
+				s.Detach(req.Topic, sub)
+			} else if err := s.Unsubscribe(req.Topic, req.ClientID); err != nil {
+				sendMessage("error", topicErrorFrame(req.RequestID, err))
 				continue
 			}
+			sess.setSubscription(req.Topic, nil)
+			delete(windows, req.Topic)
 
-			// Remove subscriber from topic
-			topic.Mu.Lock()
-			if sub, exists := topic.Subscribers[req.ClientID]; exists {
-				close(sub.CloseChannel)
-				delete(topic.Subscribers, req.ClientID)
-			}
-			topic.Mu.Unlock()
+			sendMessage("ack", ackFrame(req.RequestID, req.Topic))
 
-			sendMessage("ack", sdk.WebSocketResponse{
-				Type:      sdk.MessageTypeAck,
-				RequestID: req.RequestID,
-				Topic:     req.Topic,
-				Status:    sdk.StatusOK,
-				Timestamp: time.Now().UTC().Format(time.RFC3339),
-			})
+		case sdk.MessageTypePublishBatch:
+			if req.Topic == "" || len(req.Messages) == 0 {
+				sendMessage("error", errorFrame(req.RequestID, sdk.ErrorCodeBadRequest, "topic and messages required"))
+				continue
+			}
+			if len(req.Messages) > maxBatch {
+				sendMessage("error", errorFrame(req.RequestID, sdk.ErrorCodeBadRequest, fmt.Sprintf("at most %d messages per batch", maxBatch)))
+				continue
+			}
 
-		case sdk.MessageTypePublish:
-			if req.Topic == "" || req.Message == nil {
-				sendMessage("error", sdk.WebSocketResponse{
-					Type:      sdk.MessageTypeError,
-					RequestID: req.RequestID,
-					Error: &sdk.ErrorDetail{
-						Code:    sdk.ErrorCodeBadRequest,
-						Message: "topic and message required",
-					},
-					Timestamp: time.Now().UTC().Format(time.RFC3339),
-				})
+			results, err := s.publishBatch(publisherID(c, req.ClientID), req.Topic, req.Messages)
+			if err != nil {
+				sendMessage("error", topicErrorFrame(req.RequestID, err))
 				continue
 			}
 
-			s.mu.RLock()
-			topic, ok := s.Topics[req.Topic]
-			s.mu.RUnlock()
+			ack := ackFrame(req.RequestID, req.Topic)
+			ack.Results = results
+			sendMessage("ack", ack)
 
-			if !ok {
-				sendMessage("error", sdk.WebSocketResponse{
-					Type:      sdk.MessageTypeError,
-					RequestID: req.RequestID,
-					Error: &sdk.ErrorDetail{
-						Code:    sdk.ErrorCodeTopicNotFound,
-						Message: "topic not found",
-					},
-					Timestamp: time.Now().UTC().Format(time.RFC3339),
-				})
+		case sdk.MessageTypePublishTx:
+			results, err := s.publishTx(publisherID(c, req.ClientID), req.Publishes)
+			if err != nil {
+				sendMessage("error", txErrorFrame(req.RequestID, err))
 				continue
 			}
 
-			// Add server timestamp
-			req.Message.TS = time.Now().UTC()
+			ack := ackFrame(req.RequestID, "")
+			ack.Results = results
+			sendMessage("ack", ack)
 
-			topic.Mu.Lock()
+		case sdk.MessageTypeAck:
+			if req.Topic == "" || req.ClientID == "" {
+				sendMessage("error", errorFrame(req.RequestID, sdk.ErrorCodeBadRequest, "topic and client_id required"))
+				continue
+			}
+			if err := s.Ack(req.Topic, req.ClientID, req.Seq); err != nil {
+				sendMessage("error", topicErrorFrame(req.RequestID, err))
+			}
 
-			// Add to ring buffer for replay functionality
-			if len(topic.Messages) >= topic.MaxMessages {
-				// Remove oldest message (ring buffer behavior)
-				topic.Messages = topic.Messages[1:]
+		case sdk.MessageTypeCredit:
+			window, ok := windows[req.Topic]
+			if !ok {
+				sendMessage("error", errorFrame(req.RequestID, sdk.ErrorCodeBadRequest, "no credit-mode subscription for topic"))
+				continue
 			}
-			topic.Messages = append(topic.Messages, *req.Message)
-
-			// Fan-out message to all subscribers
-			slowConsumers := make([]string, 0)
-			for clientID, sub := range topic.Subscribers {
-				select {
-				case sub.Queue <- *req.Message:
-					// Message delivered successfully
-				default:
-					// Queue full - mark as slow consumer for removal
-					slowConsumers = append(slowConsumers, clientID)
-				}
+			if req.Credit <= 0 {
+				sendMessage("error", errorFrame(req.RequestID, sdk.ErrorCodeBadRequest, "credit must be positive"))
+				continue
 			}
+			window.grant(req.Credit)
 
-			// Remove slow consumers (backpressure policy: disconnect on overflow)
-			for _, clientID := range slowConsumers {
-				if sub, exists := topic.Subscribers[clientID]; exists {
-					close(sub.CloseChannel)
-					delete(topic.Subscribers, clientID)
-					// Note: We can't send error to slow consumer's connection here
-					// because we don't have access to their writeChannel
-				}
+		case sdk.MessageTypePublish:
+			if req.Topic == "" || req.Message == nil {
+				sendMessage("error", errorFrame(req.RequestID, sdk.ErrorCodeBadRequest, "topic and message required"))
+				continue
 			}
 
-			topic.Mu.Unlock()
-			sendMessage("ack", sdk.WebSocketResponse{
-				Type:      sdk.MessageTypeAck,
-				RequestID: req.RequestID,
-				Topic:     req.Topic,
-				Status:    sdk.StatusOK,
-				Timestamp: time.Now().UTC().Format(time.RFC3339),
-			})
+			if _, err := s.PublishFrom(publisherID(c, req.ClientID), req.Topic, *req.Message); err != nil {
+				sendMessage("error", topicErrorFrame(req.RequestID, err))
+				continue
+			}
+
+			sendMessage("ack", ackFrame(req.RequestID, req.Topic))
 
 		case sdk.MessageTypePing:
 			sendMessage("pong", sdk.WebSocketResponse{
@@ -301,31 +369,93 @@ func (s *ServiceImpl) HandleWebSocket(ctx context.Context, c *websocket.Conn) {
 			})
 
 		default:
-			sendMessage("error", sdk.WebSocketResponse{
-				Type:      sdk.MessageTypeError,
-				RequestID: req.RequestID,
-				Error: &sdk.ErrorDetail{
-					Code:    sdk.ErrorCodeBadRequest,
-					Message: "unknown message type",
-				},
-				Timestamp: time.Now().UTC().Format(time.RFC3339),
-			})
+			sendMessage("error", errorFrame(req.RequestID, sdk.ErrorCodeBadRequest, "unknown message type"))
 		}
-	nextMessage:
 	}
 
 	// Cleanup on connection close
-	if currentTopic != nil && currentSub != nil {
-		currentTopic.Mu.Lock()
-		delete(currentTopic.Subscribers, currentSub.ClientID)
-		currentTopic.Mu.Unlock()
+	for name, sub := range sess.subscriptionList() {
+		s.Detach(name, sub)
 	}
 
 	// Close write channel and wait for writer to finish
-	close(writeChannel)
+	closeWriter()
 	<-writerDone
 }
 
+// writeFrame encodes resp with the connection's codec and writes it
+func writeFrame(c *websocket.Conn, wire codec.Codec, resp sdk.WebSocketResponse) error {
+	data, err := wire.EncodeResponse(resp)
+	if err != nil {
+		return err
+	}
+	messageType := websocket.TextMessage
+	if wire.Binary() {
+		messageType = websocket.BinaryMessage
+	}
+	return c.WriteMessage(messageType, data)
+}
+
+// ackFrame builds a successful acknowledgement
+func ackFrame(requestID, topic string) sdk.WebSocketResponse {
+	return sdk.WebSocketResponse{
+		Type:      sdk.MessageTypeAck,
+		RequestID: requestID,
+		Topic:     topic,
+		Status:    sdk.StatusOK,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// errorFrame builds an error response with the given code
+func errorFrame(requestID, code, message string) sdk.WebSocketResponse {
+	return sdk.WebSocketResponse{
+		Type:      sdk.MessageTypeError,
+		RequestID: requestID,
+		Error: &sdk.ErrorDetail{
+			Code:    code,
+			Message: message,
+		},
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// publisherID names the publisher rate limits are charged to: the request's
+// client_id, or the connection's remote host when it has none
+func publisherID(c *websocket.Conn, clientID string) string {
+	if clientID != "" {
+		return clientID
+	}
+	addr := c.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// topicErrorFrame maps a topic operation error to its protocol error code
+func topicErrorFrame(requestID string, err error) sdk.WebSocketResponse {
+	var limited *RateLimitError
+	switch {
+	case errors.As(err, &limited):
+		frame := errorFrame(requestID, sdk.ErrorCodeRateLimited, err.Error())
+		frame.Error.RetryAfterMS = limited.RetryAfter.Milliseconds()
+		return frame
+	case errors.Is(err, ErrTopicNotFound):
+		return errorFrame(requestID, sdk.ErrorCodeTopicNotFound, "topic not found")
+	case errors.Is(err, ErrReplayOverflow):
+		return errorFrame(requestID, sdk.ErrorCodeSlowConsumer, err.Error())
+	case errors.Is(err, ErrUnauthorized):
+		return errorFrame(requestID, sdk.ErrorCodeUnauthorized, err.Error())
+	case errors.Is(err, ErrKeyRequired), errors.Is(err, ErrNotCompacted), errors.Is(err, ErrStateClustered),
+		errors.Is(err, ErrNotActive), errors.Is(err, ErrExclusiveClustered), errors.Is(err, ErrRejected),
+		errors.Is(err, ErrInboxUnsupported), errors.Is(err, ErrInboxClustered):
+		return errorFrame(requestID, sdk.ErrorCodeBadRequest, err.Error())
+	default:
+		return errorFrame(requestID, sdk.ErrorCodeInternal, err.Error())
+	}
+}
+
 // CreateTopic creates a new topic via REST API
 func (s *ServiceImpl) CreateTopic(ctx context.Context, c *fiber.Ctx) error {
 	var req sdk.CreateTopicRequest
@@ -336,23 +466,33 @@ func (s *ServiceImpl) CreateTopic(ctx context.Context, c *fiber.Ctx) error {
 		})
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	// Compaction is set on this node only, which in cluster mode may not own
+	// the topic
+	if req.Compacted && s.Cluster != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(sdk.ErrorResponse{
+			Error: ErrCompactedClustered.Error(),
+		})
+	}
 
-	// Check if topic already exists
-	if _, exists := s.Topics[req.Name]; exists {
+	err := s.AddTopic(req.Name)
+	if errors.Is(err, ErrTopicExists) {
 		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
 			"status": sdk.StatusConflict,
 			"topic":  req.Name,
 		})
 	}
-
-	// Create new topic
-	s.Topics[req.Name] = &sdk.Topic{
-		Name:        req.Name,
-		Subscribers: make(map[string]*sdk.Subscriber),
-		Messages:    make([]sdk.Message, 0, s.MaxMessages),
-		MaxMessages: s.MaxMessages,
+	if errors.Is(err, ErrReservedName) {
+		return c.Status(fiber.StatusBadRequest).JSON(sdk.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	if err == nil && req.Compacted {
+		err = s.Configure(req.Name, sdk.UpdateTopicRequest{Compacted: &req.Compacted})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(sdk.ErrorResponse{
+			Error: err.Error(),
+		})
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(sdk.CreateTopicResponse{
@@ -370,26 +510,17 @@ func (s *ServiceImpl) DeleteTopic(ctx context.Context, c *fiber.Ctx) error {
 		})
 	}
 
-	s.mu.Lock()
-	topic, exists := s.Topics[name]
-	if !exists {
-		s.mu.Unlock()
+	err := s.RemoveTopic(name)
+	if errors.Is(err, ErrTopicNotFound) {
 		return c.Status(fiber.StatusNotFound).JSON(sdk.ErrorResponse{
 			Error: "topic not found",
 		})
 	}
-
-	// Close all subscriber channels - their writer goroutines will handle cleanup
-	topic.Mu.Lock()
-	for _, sub := range topic.Subscribers {
-		close(sub.CloseChannel)
+	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(sdk.ErrorResponse{
+			Error: err.Error(),
+		})
 	}
-	topic.Subscribers = make(map[string]*sdk.Subscriber)
-	topic.Mu.Unlock()
-
-	// Remove topic
-	delete(s.Topics, name)
-	s.mu.Unlock()
 
 	return c.Status(fiber.StatusOK).JSON(sdk.DeleteTopicResponse{
 		Status: sdk.StatusDeleted,
@@ -399,17 +530,13 @@ func (s *ServiceImpl) DeleteTopic(ctx context.Context, c *fiber.Ctx) error {
 
 // ListTopics returns all topics with subscriber counts
 func (s *ServiceImpl) ListTopics(ctx context.Context, c *fiber.Ctx) error {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	topics := make([]sdk.TopicInfo, 0, len(s.Topics))
-	for name, topic := range s.Topics {
-		topic.Mu.RLock()
+	all := s.topics.all()
+	topics := make([]sdk.TopicInfo, 0, len(all))
+	for _, topic := range all {
 		topics = append(topics, sdk.TopicInfo{
-			Name:        name,
-			Subscribers: len(topic.Subscribers),
+			Name:        topic.name,
+			Subscribers: len(topic.subscriberList()),
 		})
-		topic.Mu.RUnlock()
 	}
 
 	return c.JSON(sdk.ListTopicsResponse{Topics: topics})
@@ -417,41 +544,52 @@ func (s *ServiceImpl) ListTopics(ctx context.Context, c *fiber.Ctx) error {
 
 // Health returns system health information
 func (s *ServiceImpl) Health(ctx context.Context, c *fiber.Ctx) error {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
+	all := s.topics.all()
 	totalSubscribers := 0
-	for _, topic := range s.Topics {
-		topic.Mu.RLock()
-		totalSubscribers += len(topic.Subscribers)
-		topic.Mu.RUnlock()
+	for _, topic := range all {
+		totalSubscribers += len(topic.subscriberList())
 	}
 
 	uptimeSeconds := int(time.Since(s.Uptime).Seconds())
 
 	return c.JSON(sdk.HealthResponse{
 		UptimeSeconds: uptimeSeconds,
-		Topics:        len(s.Topics),
+		Topics:        len(all),
 		Subscribers:   totalSubscribers,
 	})
 }
 
 // Stats returns detailed statistics per topic
 func (s *ServiceImpl) Stats(ctx context.Context, c *fiber.Ctx) error {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
 	stats := make(map[string]sdk.TopicStats)
-	for name, topic := range s.Topics {
-		topic.Mu.RLock()
-		stats[name] = sdk.TopicStats{
-			Messages:    len(topic.Messages),
-			Subscribers: len(topic.Subscribers),
+	for _, topic := range s.topics.all() {
+		topic.mu.RLock()
+		messages := topic.ring.len()
+		topic.mu.RUnlock()
+		stats[topic.name] = sdk.TopicStats{
+			Messages:    messages,
+			Subscribers: len(topic.subscriberList()),
 		}
-		topic.Mu.RUnlock()
 	}
 
-	return c.JSON(sdk.StatsResponse{Topics: stats})
+	s.sessionsMu.Lock()
+	active := len(s.sessions)
+	s.sessionsMu.Unlock()
+
+	var rateLimits *sdk.RateLimitStats
+	if s.RateLimits.enabled() || s.limiter.len() > 0 {
+		rateLimits = s.limiter.stats(time.Now())
+	}
+
+	return c.JSON(sdk.StatsResponse{
+		Topics:     stats,
+		RateLimits: rateLimits,
+		Connections: sdk.ConnectionStats{
+			Active:            active,
+			ReapedClients:     s.reapedClients.Load(),
+			ReapedSubscribers: s.reapedSubscribers.Load(),
+		},
+	})
 }
 
 // subscriberWriter delivers messages from subscriber queue to WebSocket
@@ -468,6 +606,9 @@ func (s *ServiceImpl) subscriberWriter(sub *sdk.Subscriber, topic string, sendMe
 				Timestamp: msg.TS.Format(time.RFC3339),
 			})
 		case <-sub.CloseChannel:
+			if sub.Evicted.Load() {
+				sendMessage("error", slowConsumerFrame(topic))
+			}
 			return
 		}
 	}
@@ -1,8 +1,16 @@
 package pubsub
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
 	"net/http/httptest"
 	"strings"
 	"sync"
@@ -10,7 +18,10 @@ import (
 	"time"
 
 	"github.com/Aryaman/pub-sub/sdk"
+	"github.com/Aryaman/pub-sub/sdk/codec"
+	"github.com/fasthttp/websocket"
 	"github.com/gofiber/fiber/v2"
+	fiberws "github.com/gofiber/websocket/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -80,12 +91,7 @@ func TestDeleteTopic(t *testing.T) {
 	app := fiber.New()
 
 	// Create a topic first
-	service.Topics["test-topic"] = &sdk.Topic{
-		Name:        "test-topic",
-		Subscribers: make(map[string]*sdk.Subscriber),
-		Messages:    make([]sdk.Message, 0),
-		MaxMessages: 100,
-	}
+	require.NoError(t, service.AddTopic("test-topic"))
 
 	app.Delete("/topics/:name", func(c *fiber.Ctx) error {
 		return service.DeleteTopic(c.Context(), c)
@@ -123,18 +129,8 @@ func TestListTopics(t *testing.T) {
 	app := fiber.New()
 
 	// Add some test topics
-	service.Topics["topic1"] = &sdk.Topic{
-		Name:        "topic1",
-		Subscribers: make(map[string]*sdk.Subscriber),
-		Messages:    make([]sdk.Message, 0),
-		MaxMessages: 100,
-	}
-	service.Topics["topic2"] = &sdk.Topic{
-		Name:        "topic2",
-		Subscribers: make(map[string]*sdk.Subscriber),
-		Messages:    make([]sdk.Message, 0),
-		MaxMessages: 100,
-	}
+	require.NoError(t, service.AddTopic("topic1"))
+	require.NoError(t, service.AddTopic("topic2"))
 
 	app.Get("/topics", func(c *fiber.Ctx) error {
 		return service.ListTopics(c.Context(), c)
@@ -180,48 +176,91 @@ func TestHealthEndpoint(t *testing.T) {
 func TestTopicMessageStorage(t *testing.T) {
 	service := NewService(100, 100)
 	service.MaxMessages = 3 // Small buffer for testing
+	require.NoError(t, service.AddTopic("test-topic"))
 
-	topic := &sdk.Topic{
-		Name:        "test-topic",
-		Subscribers: make(map[string]*sdk.Subscriber),
-		Messages:    make([]sdk.Message, 0),
-		MaxMessages: service.MaxMessages,
+	// Add messages beyond buffer size; 4 and 5 replace 1 and 2
+	for i := 1; i <= 5; i++ {
+		_, err := service.Publish("test-topic", sdk.Message{ID: fmt.Sprint(i), Payload: fmt.Sprintf("msg%d", i)})
+		require.NoError(t, err)
 	}
 
-	// Add messages beyond buffer size
-	messages := []sdk.Message{
-		{ID: "1", Payload: "msg1", TS: time.Now()},
-		{ID: "2", Payload: "msg2", TS: time.Now()},
-		{ID: "3", Payload: "msg3", TS: time.Now()},
-		{ID: "4", Payload: "msg4", TS: time.Now()}, // Should replace msg1
-		{ID: "5", Payload: "msg5", TS: time.Now()}, // Should replace msg2
+	// Should only have last 3 messages
+	messages, err := service.LastMessages("test-topic", 10)
+	require.NoError(t, err)
+	require.Len(t, messages, 3)
+	assert.Equal(t, "3", messages[0].ID)
+	assert.Equal(t, "4", messages[1].ID)
+	assert.Equal(t, "5", messages[2].ID)
+}
+
+func TestRingBuffer(t *testing.T) {
+	r := newRing(3)
+	assert.Nil(t, r.last(5))
+	assert.Nil(t, r.after(0))
+
+	for seq := uint64(1); seq <= 7; seq++ {
+		r.push(sdk.Message{ID: fmt.Sprint(seq), Seq: seq})
 	}
+	assert.Equal(t, 3, r.len())
 
-	for _, msg := range messages {
-		// Simulate ring buffer behavior
-		if len(topic.Messages) >= topic.MaxMessages {
-			topic.Messages = topic.Messages[1:]
+	ids := func(msgs []sdk.Message) []string {
+		out := make([]string, len(msgs))
+		for i, msg := range msgs {
+			out[i] = msg.ID
 		}
-		topic.Messages = append(topic.Messages, msg)
+		return out
 	}
+	assert.Equal(t, []string{"5", "6", "7"}, ids(r.last(10)))
+	assert.Equal(t, []string{"6", "7"}, ids(r.last(2)))
+	assert.Equal(t, []string{"5", "6", "7"}, ids(r.after(2)))
+	assert.Equal(t, []string{"7"}, ids(r.after(6)))
+	assert.Empty(t, r.after(7))
 
-	// Should only have last 3 messages
-	assert.Len(t, topic.Messages, 3)
-	assert.Equal(t, "3", topic.Messages[0].ID)
-	assert.Equal(t, "4", topic.Messages[1].ID)
-	assert.Equal(t, "5", topic.Messages[2].ID)
+	// Copies do not alias the buffer
+	last := r.last(1)
+	r.push(sdk.Message{ID: "8", Seq: 8})
+	assert.Equal(t, "7", last[0].ID)
+
+	r.reset()
+	assert.Equal(t, 0, r.len())
+	r.push(sdk.Message{ID: "9", Seq: 9})
+	assert.Equal(t, []string{"9"}, ids(r.last(10)))
+
+	// A zero-capacity ring retains nothing
+	empty := newRing(0)
+	empty.push(sdk.Message{ID: "1", Seq: 1})
+	assert.Equal(t, 0, empty.len())
+}
+
+func TestRegistrySpreadsTopicsAcrossShards(t *testing.T) {
+	r := newRegistry()
+	for i := 0; i < 256; i++ {
+		require.True(t, r.add(newTopic(fmt.Sprintf("topic-%d", i), 10)))
+	}
+	assert.False(t, r.add(newTopic("topic-0", 10)))
+	assert.Equal(t, 256, r.len())
+	assert.Len(t, r.all(), 256)
+
+	used := 0
+	for i := range r.shards {
+		if len(r.shards[i].topics) > 0 {
+			used++
+		}
+	}
+	assert.Equal(t, registryShards, used)
+
+	_, ok := r.remove("topic-7")
+	assert.True(t, ok)
+	_, ok = r.get("topic-7")
+	assert.False(t, ok)
+	assert.Equal(t, 255, r.len())
 }
 
 func TestSlowConsumerDetection(t *testing.T) {
 	service := NewService(100, 100)
 	service.MaxQueue = 2 // Small queue for testing
 
-	topic := &sdk.Topic{
-		Name:        "test-topic",
-		Subscribers: make(map[string]*sdk.Subscriber),
-		Messages:    make([]sdk.Message, 0),
-		MaxMessages: 100,
-	}
+	topic := newTopic("test-topic", 100)
 
 	// Create a subscriber with small queue
 	sub := &sdk.Subscriber{
@@ -232,7 +271,7 @@ func TestSlowConsumerDetection(t *testing.T) {
 		CloseChannel: make(chan struct{}),
 	}
 
-	topic.Subscribers["test-client"] = sub
+	topic.register(sub)
 
 	// Fill the queue
 	for i := 0; i < service.MaxQueue; i++ {
@@ -253,103 +292,73 @@ func TestSlowConsumerDetection(t *testing.T) {
 }
 
 func TestConcurrentAccess(t *testing.T) {
-	service := NewService(100, 100)
-
-	// Create topic
-	topic := &sdk.Topic{
-		Name:        "concurrent-test",
-		Subscribers: make(map[string]*sdk.Subscriber),
-		Messages:    make([]sdk.Message, 0),
-		MaxMessages: 1000,
-	}
-	service.Topics["concurrent-test"] = topic
+	service := NewService(100, 1000)
+	require.NoError(t, service.AddTopic("concurrent-test"))
 
 	var wg sync.WaitGroup
 	numGoroutines := 10
 	messagesPerGoroutine := 100
 
-	// Concurrent message publishing
+	// Concurrent publishing, subscribing and unsubscribing
 	for i := 0; i < numGoroutines; i++ {
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
+			clientID := fmt.Sprintf("worker-%d", workerID)
 			for j := 0; j < messagesPerGoroutine; j++ {
-				topic.Mu.Lock()
-				msg := sdk.Message{
+				_, err := service.Publish("concurrent-test", sdk.Message{
 					ID:      fmt.Sprintf("worker-%d-msg-%d", workerID, j),
 					Payload: fmt.Sprintf("payload-%d-%d", workerID, j),
-					TS:      time.Now(),
+				})
+				assert.NoError(t, err)
+				if j%10 == 0 {
+					_, err := service.Subscribe("concurrent-test", createTestSubscriber(clientID, 1000), 5)
+					assert.NoError(t, err)
 				}
-				if len(topic.Messages) >= topic.MaxMessages {
-					topic.Messages = topic.Messages[1:]
+				if j%10 == 5 {
+					assert.NoError(t, service.Unsubscribe("concurrent-test", clientID))
 				}
-				topic.Messages = append(topic.Messages, msg)
-				topic.Mu.Unlock()
 			}
 		}(i)
 	}
 
 	wg.Wait()
 
-	// Verify final state
-	topic.Mu.RLock()
-	messageCount := len(topic.Messages)
-	topic.Mu.RUnlock()
-
-	assert.Greater(t, messageCount, 0)
-	assert.LessOrEqual(t, messageCount, topic.MaxMessages)
+	// Sequence numbers are dense and the ring holds the newest messages
+	messages, err := service.LastMessages("concurrent-test", 1000)
+	require.NoError(t, err)
+	assert.Len(t, messages, numGoroutines*messagesPerGoroutine)
+	for i, msg := range messages {
+		assert.Equal(t, uint64(i+1), msg.Seq)
+	}
 }
 
 // Benchmark tests
 func BenchmarkMessagePublishing(b *testing.B) {
-	// service := NewService() // removed unused variable
-	topic := &sdk.Topic{
-		Name:        "bench-topic",
-		Subscribers: make(map[string]*sdk.Subscriber),
-		Messages:    make([]sdk.Message, 0),
-		MaxMessages: 10000,
-	}
+	service := NewService(100, 10000)
+	require.NoError(b, service.AddTopic("bench-topic"))
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		topic.Mu.Lock()
-		msg := sdk.Message{
+		service.Publish("bench-topic", sdk.Message{
 			ID:      fmt.Sprintf("msg-%d", i),
 			Payload: "benchmark payload",
-			TS:      time.Now(),
-		}
-		if len(topic.Messages) >= topic.MaxMessages {
-			topic.Messages = topic.Messages[1:]
-		}
-		topic.Messages = append(topic.Messages, msg)
-		topic.Mu.Unlock()
+		})
 	}
 }
 
 func BenchmarkConcurrentPublishing(b *testing.B) {
-	// service := NewService() // removed unused variable
-	topic := &sdk.Topic{
-		Name:        "bench-topic",
-		Subscribers: make(map[string]*sdk.Subscriber),
-		Messages:    make([]sdk.Message, 0),
-		MaxMessages: 10000,
-	}
+	service := NewService(100, 10000)
+	require.NoError(b, service.AddTopic("bench-topic"))
 
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		i := 0
 		for pb.Next() {
-			topic.Mu.Lock()
-			msg := sdk.Message{
+			service.Publish("bench-topic", sdk.Message{
 				ID:      fmt.Sprintf("msg-%d", i),
 				Payload: "benchmark payload",
-				TS:      time.Now(),
-			}
-			if len(topic.Messages) >= topic.MaxMessages {
-				topic.Messages = topic.Messages[1:]
-			}
-			topic.Messages = append(topic.Messages, msg)
-			topic.Mu.Unlock()
+			})
 			i++
 		}
 	})
@@ -365,3 +374,1973 @@ func createTestSubscriber(clientID string, queueSize int) *sdk.Subscriber {
 		CloseChannel: make(chan struct{}),
 	}
 }
+
+// startTestServer serves the service's WebSocket handler on a loopback port
+func startTestServer(t testing.TB, service *ServiceImpl) string {
+	t.Helper()
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/ws", fiberws.New(func(c *fiberws.Conn) {
+		service.HandleWebSocket(context.Background(), c)
+	}, fiberws.Config{Subprotocols: codec.Subprotocols}))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go app.Listener(ln)
+	t.Cleanup(func() { app.Shutdown() })
+
+	return "ws://" + ln.Addr().String() + "/ws"
+}
+
+func TestReadDeadlineReapsSilentClient(t *testing.T) {
+	service := NewService(100, 100)
+	service.PingInterval = 50 * time.Millisecond
+	service.ReadTimeout = 200 * time.Millisecond
+	url := startTestServer(t, service)
+
+	// The client never reads, so server pings are never answered
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	assert.Eventually(t, func() bool {
+		return service.reapedClients.Load() == 1
+	}, 2*time.Second, 20*time.Millisecond)
+}
+
+func TestPongKeepsClientAlive(t *testing.T) {
+	service := NewService(100, 100)
+	service.PingInterval = 50 * time.Millisecond
+	service.ReadTimeout = 200 * time.Millisecond
+	url := startTestServer(t, service)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Reading lets the default ping handler answer with pongs
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	time.Sleep(600 * time.Millisecond)
+	assert.Equal(t, int64(0), service.reapedClients.Load())
+
+	require.NoError(t, conn.WriteJSON(sdk.WebSocketRequest{Type: sdk.MessageTypePing}))
+}
+
+func TestReaperRemovesOrphans(t *testing.T) {
+	service := NewService(100, 100)
+	url := startTestServer(t, service)
+
+	require.NoError(t, service.AddTopic("orders"))
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.WriteJSON(sdk.WebSocketRequest{
+		Type:     sdk.MessageTypeSubscribe,
+		Topic:    "orders",
+		ClientID: "c1",
+	}))
+	var ack sdk.WebSocketResponse
+	require.NoError(t, conn.ReadJSON(&ack))
+	assert.Equal(t, sdk.MessageTypeAck, ack.Type)
+
+	// An orphaned subscriber left behind by a connection that is already gone
+	orphan := createTestSubscriber("ghost", 1)
+	orphan.Conn = &fiberws.Conn{}
+	_, err = service.Subscribe("orders", orphan, 0)
+	require.NoError(t, err)
+
+	// A quiet but live connection keeps its subscription
+	service.reapOrphans()
+	assert.Equal(t, int64(1), service.reapedSubscribers.Load())
+	assert.Equal(t, int64(0), service.reapedClients.Load())
+	assert.Equal(t, 1, service.SubscriberCount("orders"))
+}
+
+func TestShutdownDrainsClientsAndPersistsState(t *testing.T) {
+	service := NewService(100, 100)
+	service.StateFile = t.TempDir() + "/state.json"
+	url := startTestServer(t, service)
+
+	require.NoError(t, service.AddTopic("orders"))
+	_, err := service.Publish("orders", sdk.Message{ID: "m1", Payload: "hello"})
+	require.NoError(t, err)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.WriteJSON(sdk.WebSocketRequest{
+		Type:     sdk.MessageTypeSubscribe,
+		Topic:    "orders",
+		ClientID: "c1",
+		LastN:    1,
+	}))
+	var types []string
+	for len(types) == 0 || types[len(types)-1] != sdk.MessageTypeAck {
+		var resp sdk.WebSocketResponse
+		require.NoError(t, conn.ReadJSON(&resp))
+		types = append(types, resp.Type)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- service.Shutdown(ctx) }()
+
+	// The restart notice is the last frame before the close
+	for {
+		var resp sdk.WebSocketResponse
+		if err := conn.ReadJSON(&resp); err != nil {
+			assert.True(t, websocket.IsCloseError(err, websocket.CloseServiceRestart))
+			break
+		}
+		types = append(types, resp.Type)
+	}
+	assert.Contains(t, types, sdk.MessageTypeEvent)
+	assert.Equal(t, sdk.MessageTypeInfo, types[len(types)-1])
+	require.NoError(t, <-done)
+
+	restored := NewService(100, 100)
+	restored.StateFile = service.StateFile
+	require.NoError(t, restored.LoadState())
+	messages, err := restored.LastMessages("orders", 1)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "m1", messages[0].ID)
+
+	// New connections are refused while draining
+	late, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer late.Close()
+	var notice sdk.WebSocketResponse
+	require.NoError(t, late.ReadJSON(&notice))
+	assert.Equal(t, sdk.MessageTypeInfo, notice.Type)
+}
+
+func TestNegotiatedEncodingsCarrySameFrames(t *testing.T) {
+	service := NewService(100, 100)
+	require.NoError(t, service.AddTopic("orders"))
+	url := startTestServer(t, service)
+
+	for _, name := range codec.Subprotocols {
+		t.Run(name, func(t *testing.T) {
+			dialer := websocket.Dialer{Subprotocols: []string{name}}
+			conn, _, err := dialer.Dial(url, nil)
+			require.NoError(t, err)
+			defer conn.Close()
+			require.Equal(t, name, conn.Subprotocol())
+			wire := codec.ForSubprotocol(name)
+
+			send := func(req sdk.WebSocketRequest) {
+				data, err := wire.EncodeRequest(req)
+				require.NoError(t, err)
+				messageType := websocket.TextMessage
+				if wire.Binary() {
+					messageType = websocket.BinaryMessage
+				}
+				require.NoError(t, conn.WriteMessage(messageType, data))
+			}
+			read := func() sdk.WebSocketResponse {
+				conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+				_, data, err := conn.ReadMessage()
+				require.NoError(t, err)
+				var resp sdk.WebSocketResponse
+				require.NoError(t, wire.DecodeResponse(data, &resp))
+				return resp
+			}
+
+			send(sdk.WebSocketRequest{Type: sdk.MessageTypeSubscribe, Topic: "orders", ClientID: name, RequestID: "r1"})
+			ack := read()
+			assert.Equal(t, sdk.MessageTypeAck, ack.Type)
+			assert.Equal(t, "r1", ack.RequestID)
+
+			payload := map[string]interface{}{"item": "book", "tags": []interface{}{"a", "b"}}
+			send(sdk.WebSocketRequest{Type: sdk.MessageTypePublish, Topic: "orders", Message: &sdk.Message{ID: "m-" + name, Payload: payload}})
+
+			var event sdk.WebSocketResponse
+			for event.Type != sdk.MessageTypeEvent {
+				event = read()
+			}
+			require.NotNil(t, event.Message)
+			assert.Equal(t, "m-"+name, event.Message.ID)
+			assert.Equal(t, payload, event.Message.Payload)
+			assert.NotZero(t, event.Message.Seq)
+
+			send(sdk.WebSocketRequest{Type: "bogus", RequestID: "r2"})
+			var errResp sdk.WebSocketResponse
+			for errResp.Type != sdk.MessageTypeError {
+				errResp = read()
+			}
+			require.NotNil(t, errResp.Error)
+			assert.Equal(t, sdk.ErrorCodeBadRequest, errResp.Error.Code)
+		})
+	}
+}
+
+// BenchmarkWebSocketThroughput publishes over one connection and receives
+// the events on another, once per encoding
+func BenchmarkWebSocketThroughput(b *testing.B) {
+	for _, name := range codec.Subprotocols {
+		b.Run(name, func(b *testing.B) {
+			service := NewService(b.N+100, 100)
+			require.NoError(b, service.AddTopic("bench"))
+			url := startTestServer(b, service)
+			wire := codec.ForSubprotocol(name)
+			messageType := websocket.TextMessage
+			if wire.Binary() {
+				messageType = websocket.BinaryMessage
+			}
+
+			dialer := websocket.Dialer{Subprotocols: []string{name}}
+			sub, _, err := dialer.Dial(url, nil)
+			require.NoError(b, err)
+			defer sub.Close()
+			pub, _, err := dialer.Dial(url, nil)
+			require.NoError(b, err)
+			defer pub.Close()
+
+			data, err := wire.EncodeRequest(sdk.WebSocketRequest{Type: sdk.MessageTypeSubscribe, Topic: "bench", ClientID: "sub"})
+			require.NoError(b, err)
+			require.NoError(b, sub.WriteMessage(messageType, data))
+			_, _, err = sub.ReadMessage()
+			require.NoError(b, err)
+
+			publish, err := wire.EncodeRequest(sdk.WebSocketRequest{
+				Type:    sdk.MessageTypePublish,
+				Topic:   "bench",
+				Message: &sdk.Message{ID: "m", Payload: map[string]interface{}{"order_id": "ORD-123", "amount": 99.5}},
+			})
+			require.NoError(b, err)
+
+			// Drain publisher acks so its write queue never fills
+			go func() {
+				for {
+					if _, _, err := pub.ReadMessage(); err != nil {
+						return
+					}
+				}
+			}()
+
+			// Keep a bounded number of events in flight so the server's
+			// slow-consumer protection never trips
+			window := make(chan struct{}, 50)
+			b.ResetTimer()
+			go func() {
+				for i := 0; i < b.N; i++ {
+					window <- struct{}{}
+					if err := pub.WriteMessage(messageType, publish); err != nil {
+						return
+					}
+				}
+			}()
+			for i := 0; i < b.N; i++ {
+				_, frame, err := sub.ReadMessage()
+				if err != nil {
+					b.Fatal(err)
+				}
+				var resp sdk.WebSocketResponse
+				if err := wire.DecodeResponse(frame, &resp); err != nil {
+					b.Fatal(err)
+				}
+				<-window
+			}
+		})
+	}
+}
+
+func TestCreditModeThrottlesInsteadOfEvicting(t *testing.T) {
+	// A queue of 2 overflows immediately, which would evict a push subscriber
+	service := NewService(2, 100)
+	require.NoError(t, service.AddTopic("orders"))
+	url := startTestServer(t, service)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	read := func() sdk.WebSocketResponse {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var resp sdk.WebSocketResponse
+		require.NoError(t, conn.ReadJSON(&resp))
+		return resp
+	}
+	readSeqs := func(n int) []uint64 {
+		var seqs []uint64
+		for len(seqs) < n {
+			event := read()
+			require.Equal(t, sdk.MessageTypeEvent, event.Type)
+			seqs = append(seqs, event.Message.Seq)
+		}
+		return seqs
+	}
+
+	require.NoError(t, conn.WriteJSON(sdk.WebSocketRequest{Type: sdk.MessageTypeSubscribe, Topic: "orders", ClientID: "c1", Credit: 2}))
+	require.Equal(t, sdk.MessageTypeAck, read().Type)
+
+	for i := 1; i <= 6; i++ {
+		_, err := service.Publish("orders", sdk.Message{ID: fmt.Sprintf("m%d", i), Payload: i})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []uint64{1, 2}, readSeqs(2))
+
+	// With the credit spent, the pong is the next frame rather than an event
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, conn.WriteJSON(sdk.WebSocketRequest{Type: sdk.MessageTypePing}))
+	assert.Equal(t, sdk.MessageTypePong, read().Type)
+	assert.True(t, service.HasSubscribers("orders"), "credit subscriber must not be evicted")
+
+	// Messages that overflowed the queue are read back from the ring buffer
+	require.NoError(t, conn.WriteJSON(sdk.WebSocketRequest{Type: sdk.MessageTypeCredit, Topic: "orders", Credit: 10}))
+	assert.Equal(t, []uint64{3, 4, 5, 6}, readSeqs(4))
+
+	// Replay larger than the queue is delivered in full as well
+	require.NoError(t, conn.WriteJSON(sdk.WebSocketRequest{Type: sdk.MessageTypeUnsubscribe, Topic: "orders", ClientID: "c1"}))
+	require.Equal(t, sdk.MessageTypeAck, read().Type)
+	require.NoError(t, conn.WriteJSON(sdk.WebSocketRequest{Type: sdk.MessageTypeSubscribe, Topic: "orders", ClientID: "c2", Credit: 10, LastN: 5}))
+	var seqs []uint64
+	for len(seqs) < 5 {
+		resp := read()
+		if resp.Type == sdk.MessageTypeEvent {
+			seqs = append(seqs, resp.Message.Seq)
+		}
+	}
+	assert.Equal(t, []uint64{2, 3, 4, 5, 6}, seqs)
+
+	require.NoError(t, conn.WriteJSON(sdk.WebSocketRequest{Type: sdk.MessageTypeCredit, Topic: "missing", Credit: 1}))
+	errResp := read()
+	require.NotNil(t, errResp.Error)
+	assert.Equal(t, sdk.ErrorCodeBadRequest, errResp.Error.Code)
+}
+
+func TestPublishBatchAndEventBatches(t *testing.T) {
+	service := NewService(100, 100)
+	require.NoError(t, service.AddTopic("orders"))
+	url := startTestServer(t, service)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	read := func() sdk.WebSocketResponse {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var resp sdk.WebSocketResponse
+		require.NoError(t, conn.ReadJSON(&resp))
+		return resp
+	}
+
+	require.NoError(t, conn.WriteJSON(sdk.WebSocketRequest{
+		Type: sdk.MessageTypeSubscribe, Topic: "orders", ClientID: "c1", BatchSize: 3, BatchWaitMS: 100,
+	}))
+	require.Equal(t, sdk.MessageTypeAck, read().Type)
+
+	// Five messages with one invalid entry: four are stored
+	batch := []sdk.Message{{ID: "m1", Payload: 1}, {ID: "m2", Payload: 2}, {Payload: 3}, {ID: "m4", Payload: 4}, {ID: "m5", Payload: 5}}
+	require.NoError(t, conn.WriteJSON(sdk.WebSocketRequest{Type: sdk.MessageTypePublishBatch, Topic: "orders", Messages: batch, RequestID: "b1"}))
+
+	var ack sdk.WebSocketResponse
+	var events []sdk.WebSocketResponse
+	for ack.Type == "" || len(events) < 2 {
+		resp := read()
+		switch resp.Type {
+		case sdk.MessageTypeAck:
+			ack = resp
+		case sdk.MessageTypeEventBatch:
+			events = append(events, resp)
+		default:
+			t.Fatalf("unexpected frame %s", resp.Type)
+		}
+	}
+
+	assert.Equal(t, "b1", ack.RequestID)
+	require.Len(t, ack.Results, 5)
+	assert.Equal(t, sdk.PublishResult{ID: "m1", Seq: 1, Status: sdk.StatusOK}, ack.Results[0])
+	assert.Equal(t, sdk.StatusError, ack.Results[2].Status)
+	assert.Equal(t, sdk.ErrorCodeBadRequest, ack.Results[2].Error.Code)
+	assert.Equal(t, uint64(4), ack.Results[4].Seq)
+
+	// A full batch of three, then the remainder once the wait expires
+	require.Len(t, events[0].Messages, 3)
+	require.Len(t, events[1].Messages, 1)
+	assert.Equal(t, "m1", events[0].Messages[0].ID)
+	assert.Equal(t, "m5", events[1].Messages[0].ID)
+
+	require.NoError(t, conn.WriteJSON(sdk.WebSocketRequest{Type: sdk.MessageTypePublishBatch, Topic: "missing", Messages: batch}))
+	assert.Equal(t, sdk.ErrorCodeTopicNotFound, read().Error.Code)
+	require.NoError(t, conn.WriteJSON(sdk.WebSocketRequest{Type: sdk.MessageTypeSubscribe, Topic: "orders", ClientID: "c2", BatchSize: 2, Credit: 5}))
+	assert.Equal(t, sdk.ErrorCodeBadRequest, read().Error.Code)
+}
+
+func TestBatchWriterFlushesHeldBatchOnClose(t *testing.T) {
+	service := NewService(100, 10)
+	require.NoError(t, service.AddTopic("orders"))
+	sub := createTestSubscriber("c1", 10)
+	_, err := service.Subscribe("orders", sub, 0)
+	require.NoError(t, err)
+
+	frames := make(chan sdk.WebSocketResponse, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		service.batchWriter(sub, "orders", 5, time.Hour, func(_ string, frame interface{}) {
+			frames <- frame.(sdk.WebSocketResponse)
+		})
+	}()
+
+	// A partial batch is held, and draining waits for it
+	_, err = service.Publish("orders", sdk.Message{ID: "m1"})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return sub.Held.Load() == 1 }, time.Second, time.Millisecond)
+	assert.False(t, service.queuesDrained())
+
+	// Closing the subscription sends it rather than dropping it
+	require.NoError(t, service.Unsubscribe("orders", "c1"))
+	<-done
+	require.Len(t, frames, 1)
+	frame := <-frames
+	require.Len(t, frame.Messages, 1)
+	assert.Equal(t, "m1", frame.Messages[0].ID)
+	assert.Zero(t, sub.Held.Load())
+}
+
+func TestRateLimiterBuckets(t *testing.T) {
+	limits := RateLimits{
+		Client:    RateLimit{Messages: 2},
+		Namespace: RateLimit{Bytes: 100},
+	}
+	r := newRateLimiter()
+	now := time.Now()
+
+	// The client bucket holds a burst of two messages, refilled at two per second
+	require.NoError(t, r.admit(limits, "c1", "orders", 10, now))
+	require.NoError(t, r.admit(limits, "c1", "orders", 10, now))
+	err := r.admit(limits, "c1", "orders", 10, now)
+	var limited *RateLimitError
+	require.ErrorAs(t, err, &limited)
+	assert.Equal(t, "client", limited.Scope)
+	assert.Equal(t, 500*time.Millisecond, limited.RetryAfter)
+	require.NoError(t, r.admit(limits, "c2", "orders", 10, now))
+	require.NoError(t, r.admit(limits, "c1", "orders", 10, now.Add(limited.RetryAfter)))
+
+	// Byte limits apply to the namespace before the first dot
+	require.NoError(t, r.admit(limits, "c3", "eu.orders", 60, now))
+	require.ErrorAs(t, r.admit(limits, "c4", "eu.invoices", 60, now), &limited)
+	assert.Equal(t, "namespace", limited.Scope)
+	assert.Equal(t, "eu", limited.Key)
+	assert.Equal(t, 200*time.Millisecond, limited.RetryAfter)
+
+	stats := r.stats(now)
+	assert.Equal(t, int64(1), stats.Clients["c1"].Throttled)
+	assert.Equal(t, int64(3), stats.Clients["c1"].Messages)
+	assert.Equal(t, int64(60), stats.Namespaces["eu"].Bytes)
+	assert.Equal(t, 40.0, stats.Namespaces["eu"].AvailableBytes)
+	assert.Empty(t, stats.Topics)
+}
+
+func TestPublishRateLimitedOverWebSocket(t *testing.T) {
+	service := NewService(100, 100)
+	service.RateLimits.Topic = RateLimit{Messages: 1}
+	require.NoError(t, service.AddTopic("orders"))
+	url := startTestServer(t, service)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	publish := func(id string) sdk.WebSocketResponse {
+		require.NoError(t, conn.WriteJSON(sdk.WebSocketRequest{
+			Type: sdk.MessageTypePublish, Topic: "orders", Message: &sdk.Message{ID: id, Payload: id}, RequestID: id,
+		}))
+		var resp sdk.WebSocketResponse
+		require.NoError(t, conn.ReadJSON(&resp))
+		return resp
+	}
+
+	assert.Equal(t, sdk.MessageTypeAck, publish("m1").Type)
+	resp := publish("m2")
+	require.Equal(t, sdk.MessageTypeError, resp.Type)
+	assert.Equal(t, sdk.ErrorCodeRateLimited, resp.Error.Code)
+	assert.Greater(t, resp.Error.RetryAfterMS, int64(0))
+	assert.LessOrEqual(t, resp.Error.RetryAfterMS, int64(1000))
+
+	app := fiber.New()
+	app.Get("/stats", func(c *fiber.Ctx) error { return service.Stats(c.Context(), c) })
+	httpResp, err := app.Test(httptest.NewRequest("GET", "/stats", nil))
+	require.NoError(t, err)
+	var stats sdk.StatsResponse
+	require.NoError(t, json.NewDecoder(httpResp.Body).Decode(&stats))
+	require.NotNil(t, stats.RateLimits)
+	assert.Equal(t, int64(1), stats.RateLimits.Topics["orders"].Messages)
+	assert.Equal(t, int64(1), stats.RateLimits.Topics["orders"].Throttled)
+	assert.Equal(t, 1.0, stats.RateLimits.Topics["orders"].MessagesPerSec)
+}
+
+func TestTopicAdministration(t *testing.T) {
+	service := NewService(100, 5)
+	require.NoError(t, service.AddTopic("orders"))
+
+	app := fiber.New()
+	app.Get("/topics/:name", func(c *fiber.Ctx) error { return service.GetTopic(c.Context(), c) })
+	app.Patch("/topics/:name", func(c *fiber.Ctx) error { return service.UpdateTopic(c.Context(), c) })
+	app.Post("/topics/:name/purge", func(c *fiber.Ctx) error { return service.PurgeTopic(c.Context(), c) })
+	app.Post("/topics/:name/pause", func(c *fiber.Ctx) error { return service.PauseTopic(c.Context(), c) })
+	app.Post("/topics/:name/resume", func(c *fiber.Ctx) error { return service.ResumeTopic(c.Context(), c) })
+	call := func(method, path, body string) (int, sdk.TopicDetail) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		var detail sdk.TopicDetail
+		if resp.StatusCode == 200 {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&detail))
+		}
+		return resp.StatusCode, detail
+	}
+	publish := func(ids ...string) {
+		for _, id := range ids {
+			_, err := service.Publish("orders", sdk.Message{ID: id, Payload: id})
+			require.NoError(t, err)
+		}
+	}
+	drain := func(sub *sdk.Subscriber) []string {
+		var ids []string
+		for {
+			select {
+			case msg := <-sub.Queue:
+				ids = append(ids, msg.ID)
+			default:
+				return ids
+			}
+		}
+	}
+
+	early := createTestSubscriber("early", 10)
+	_, err := service.Subscribe("orders", early, 0)
+	require.NoError(t, err)
+	publish("m1", "m2")
+	assert.Equal(t, []string{"m1", "m2"}, drain(early))
+
+	status, detail := call("GET", "/topics/orders", "")
+	require.Equal(t, 200, status)
+	assert.Equal(t, sdk.TopicDetail{Name: "orders", MaxMessages: 5, LastSeq: 2, Messages: 2, Subscribers: 1, Published: 2, Delivered: 2}, detail)
+	status, _ = call("GET", "/topics/missing", "")
+	assert.Equal(t, 404, status)
+
+	// Paused topics accept publishes and hold them back
+	status, detail = call("POST", "/topics/orders/pause", "")
+	require.Equal(t, 200, status)
+	assert.True(t, detail.Paused)
+	publish("m3", "m4")
+	assert.Empty(t, drain(early))
+
+	// A subscriber joining mid-pause replays only what was delivered
+	late := createTestSubscriber("late", 10)
+	replayed, err := service.Subscribe("orders", late, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 2, replayed)
+	assert.Equal(t, []string{"m1", "m2"}, drain(late))
+
+	_, detail = call("GET", "/topics/orders", "")
+	assert.Equal(t, 2, detail.Pending)
+
+	// Resume flushes the backlog once to everyone, in order
+	_, detail = call("POST", "/topics/orders/resume", "")
+	assert.False(t, detail.Paused)
+	publish("m5")
+	assert.Equal(t, []string{"m3", "m4", "m5"}, drain(early))
+	assert.Equal(t, []string{"m3", "m4", "m5"}, drain(late))
+
+	// Shrinking retention keeps the newest messages
+	status, detail = call("PATCH", "/topics/orders", `{"max_messages": 2, "rate_limit": {"messages_per_sec": 1}}`)
+	require.Equal(t, 200, status)
+	assert.Equal(t, 2, detail.MaxMessages)
+	assert.Equal(t, 2, detail.Messages)
+	assert.Equal(t, &sdk.TopicRateLimit{MessagesPerSec: 1}, detail.RateLimit)
+	messages, err := service.LastMessages("orders", 10)
+	require.NoError(t, err)
+	assert.Equal(t, "m4", messages[0].ID)
+
+	// The topic's own rate limit now applies
+	_, err = service.PublishFrom("c1", "orders", sdk.Message{ID: "m6"})
+	require.NoError(t, err)
+	_, err = service.PublishFrom("c1", "orders", sdk.Message{ID: "m7"})
+	var limited *RateLimitError
+	assert.ErrorAs(t, err, &limited)
+
+	status, _ = call("PATCH", "/topics/orders", `{"max_messages": -1}`)
+	assert.Equal(t, 400, status)
+
+	_, detail = call("POST", "/topics/orders/purge", "")
+	assert.Equal(t, 0, detail.Messages)
+	assert.Equal(t, uint64(6), detail.LastSeq)
+}
+
+func TestBrowseMessages(t *testing.T) {
+	service := NewService(100, 100)
+	require.NoError(t, service.AddTopic("orders"))
+	sub := createTestSubscriber("c1", 100)
+	_, err := service.Subscribe("orders", sub, 0)
+	require.NoError(t, err)
+
+	for i := 1; i <= 6; i++ {
+		region := "eu"
+		if i%2 == 0 {
+			region = "us"
+		}
+		_, err := service.Publish("orders", sdk.Message{
+			ID:         fmt.Sprintf("m%d", i),
+			Payload:    map[string]interface{}{"order": map[string]interface{}{"qty": float64(i), "sku": fmt.Sprintf("s%d", i%3)}},
+			Attributes: map[string]string{"region": region},
+		})
+		require.NoError(t, err)
+	}
+
+	app := fiber.New()
+	app.Get("/topics/:name/messages", func(c *fiber.Ctx) error { return service.BrowseMessages(c.Context(), c) })
+	browse := func(query string) (int, sdk.BrowseMessagesResponse) {
+		resp, err := app.Test(httptest.NewRequest("GET", "/topics/orders/messages?"+query, nil))
+		require.NoError(t, err)
+		var page sdk.BrowseMessagesResponse
+		if resp.StatusCode == 200 {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+		}
+		return resp.StatusCode, page
+	}
+	ids := func(page sdk.BrowseMessagesResponse) []string {
+		var out []string
+		for _, msg := range page.Messages {
+			out = append(out, msg.ID)
+		}
+		return out
+	}
+
+	// Pages by sequence number
+	_, page := browse("limit=4")
+	assert.Equal(t, []string{"m1", "m2", "m3", "m4"}, ids(page))
+	assert.Equal(t, uint64(4), page.NextAfterSeq)
+	_, page = browse("limit=4&after_seq=4")
+	assert.Equal(t, []string{"m5", "m6"}, ids(page))
+	assert.Zero(t, page.NextAfterSeq)
+	_, page = browse("after_seq=1&before_seq=4")
+	assert.Equal(t, []string{"m2", "m3"}, ids(page))
+
+	// Filters on attributes, payload fields and id
+	_, page = browse("attr.region=us&payload.order.sku=s1")
+	assert.Equal(t, []string{"m4"}, ids(page))
+	_, page = browse("payload.order.qty=5")
+	assert.Equal(t, []string{"m5"}, ids(page))
+	_, page = browse("id=m3")
+	require.Len(t, page.Messages, 1)
+	assert.Equal(t, uint64(3), page.Messages[0].Seq)
+	assert.Equal(t, "eu", page.Messages[0].Attributes["region"])
+	assert.NotEmpty(t, page.Messages[0].Timestamp)
+
+	// Time window
+	_, page = browse("until=" + time.Now().Add(-time.Hour).UTC().Format(time.RFC3339))
+	assert.Empty(t, page.Messages)
+
+	status, _ := browse("limit=0")
+	assert.Equal(t, 400, status)
+	status, _ = browse("since=yesterday")
+	assert.Equal(t, 400, status)
+	resp, err := app.Test(httptest.NewRequest("GET", "/topics/missing/messages", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 404, resp.StatusCode)
+
+	// Browsing leaves delivery alone
+	assert.Len(t, sub.Queue, 6)
+	assert.Equal(t, 1, service.SubscriberCount("orders"))
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	source := NewService(100, 10)
+	require.NoError(t, source.AddTopic("orders"))
+	require.NoError(t, source.Configure("orders", sdk.UpdateTopicRequest{RateLimit: &sdk.TopicRateLimit{MessagesPerSec: 50}}))
+	for i := 1; i <= 3; i++ {
+		_, err := source.Publish("orders", sdk.Message{
+			ID: fmt.Sprintf("m%d", i), Payload: map[string]interface{}{"n": float64(i)}, Attributes: map[string]string{"k": "v"},
+		})
+		require.NoError(t, err)
+	}
+	original, err := source.LastMessages("orders", 3)
+	require.NoError(t, err)
+
+	app := fiber.New()
+	app.Get("/topics/:name/export", func(c *fiber.Ctx) error { return source.ExportTopic(c.Context(), c) })
+	resp, err := app.Test(httptest.NewRequest("GET", "/topics/orders/export", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	export, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(export)), "\n")
+	require.Len(t, lines, 4)
+	var header sdk.ExportTopic
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &header))
+	assert.Equal(t, sdk.ExportTopic{Type: sdk.ExportTypeTopic, Name: "orders", MaxMessages: 10, RateLimit: &sdk.TopicRateLimit{MessagesPerSec: 50}, LastSeq: 3}, header)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/topics/missing/export", nil))
+	require.NoError(t, err)
+	assert.Equal(t, 404, resp.StatusCode)
+
+	// Preserving ids and timestamps into a new topic
+	target := NewService(100, 100)
+	require.NoError(t, target.AddTopic("copy"))
+	_, err = target.Publish("copy", sdk.Message{ID: "existing"})
+	require.NoError(t, err)
+	importApp := fiber.New()
+	importApp.Post("/topics/:name/import", func(c *fiber.Ctx) error { return target.ImportTopic(c.Context(), c) })
+	post := func(path string, body []byte) (int, sdk.ImportTopicResponse) {
+		resp, err := importApp.Test(httptest.NewRequest("POST", path, bytes.NewReader(body)))
+		require.NoError(t, err)
+		var result sdk.ImportTopicResponse
+		if resp.StatusCode == 200 {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		}
+		return resp.StatusCode, result
+	}
+
+	status, result := post("/topics/orders/import", export)
+	require.Equal(t, 200, status)
+	assert.Equal(t, sdk.ImportTopicResponse{Topic: "orders", Created: true, Imported: 3}, result)
+	detail, err := target.Describe("orders")
+	require.NoError(t, err)
+	assert.Equal(t, 10, detail.MaxMessages)
+	assert.Equal(t, &sdk.TopicRateLimit{MessagesPerSec: 50}, detail.RateLimit)
+	copied, err := target.LastMessages("orders", 10)
+	require.NoError(t, err)
+	require.Len(t, copied, 3)
+	for i := range copied {
+		assert.Equal(t, original[i].ID, copied[i].ID)
+		assert.True(t, original[i].TS.Equal(copied[i].TS))
+		assert.Equal(t, original[i].Payload, copied[i].Payload)
+		assert.Equal(t, original[i].Attributes, copied[i].Attributes)
+	}
+
+	// Reassigning into an existing topic appends with new ids and times
+	status, result = post("/topics/copy/import?ids=reassign&timestamps=reassign", export)
+	require.Equal(t, 200, status)
+	assert.False(t, result.Created)
+	copied, err = target.LastMessages("copy", 10)
+	require.NoError(t, err)
+	require.Len(t, copied, 4)
+	assert.Equal(t, uint64(4), copied[3].Seq)
+	assert.NotEqual(t, "m3", copied[3].ID)
+	assert.True(t, copied[3].TS.After(original[2].TS))
+
+	status, _ = post("/topics/copy/import", []byte("{\"type\":\"message\",\"id\":\"x\"}\nnot json\n"))
+	assert.Equal(t, 400, status)
+	status, _ = post("/topics/copy/import?ids=maybe", export)
+	assert.Equal(t, 400, status)
+	copied, err = target.LastMessages("copy", 10)
+	require.NoError(t, err)
+	assert.Len(t, copied, 4)
+
+	// A file the topic would refuse partway through imports nothing
+	status, _ = post("/topics/copy/import", []byte("{\"type\":\"message\",\"id\":\"d1\"}\n{\"type\":\"message\",\"id\":\"d1\"}\n"))
+	assert.Equal(t, 400, status)
+	status, _ = post("/topics/keyed/import", []byte("{\"type\":\"topic\",\"compacted\":true}\n{\"type\":\"message\",\"key\":\"a\"}\n{\"type\":\"message\"}\n"))
+	assert.Equal(t, 400, status)
+	assert.False(t, target.HasTopic("keyed"))
+	copied, err = target.LastMessages("copy", 10)
+	require.NoError(t, err)
+	assert.Len(t, copied, 4)
+
+	// A header without retention keeps the topic's own
+	status, _ = post("/topics/copy/import", []byte("{\"type\":\"topic\"}\n{\"type\":\"message\"}\n"))
+	require.Equal(t, 200, status)
+	detail, err = target.Describe("copy")
+	require.NoError(t, err)
+	assert.Equal(t, 10, detail.MaxMessages)
+}
+
+func TestSubscribeAfterResumesFromSequence(t *testing.T) {
+	service := NewService(100, 3)
+	require.NoError(t, service.AddTopic("orders"))
+	for i := 1; i <= 5; i++ {
+		_, err := service.Publish("orders", sdk.Message{ID: fmt.Sprintf("m%d", i)})
+		require.NoError(t, err)
+	}
+
+	// m4 and m5 are retained after seq 3
+	sub := createTestSubscriber("resumer", 10)
+	n, err := service.SubscribeAfter("orders", sub, 3)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, uint64(3), sub.StartSeq)
+	assert.Equal(t, "m4", (<-sub.Queue).ID)
+	assert.Equal(t, "m5", (<-sub.Queue).ID)
+
+	// Seq 1 has left the ring buffer, so the replay starts at the oldest
+	// retained message and the client sees the gap
+	gap := createTestSubscriber("gap", 10)
+	n, err = service.SubscribeAfter("orders", gap, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, uint64(3), (<-gap.Queue).Seq)
+
+	// Nothing is replayed past the head, and live delivery follows
+	caughtUp := createTestSubscriber("caught-up", 10)
+	n, err = service.SubscribeAfter("orders", caughtUp, 5)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	_, err = service.Publish("orders", sdk.Message{ID: "m6"})
+	require.NoError(t, err)
+	assert.Equal(t, "m6", (<-caughtUp.Queue).ID)
+}
+
+func TestPublishTxIsAllOrNothing(t *testing.T) {
+	service := NewService(100, 100)
+	service.RateLimits.Client = RateLimit{Messages: 3}
+	require.NoError(t, service.AddTopic("order.created"))
+	require.NoError(t, service.AddTopic("inventory.reserved"))
+	orders := createTestSubscriber("orders", 10)
+	_, err := service.Subscribe("order.created", orders, 0)
+	require.NoError(t, err)
+	url := startTestServer(t, service)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	publishTx := func(requestID string, entries ...sdk.TopicMessage) sdk.WebSocketResponse {
+		require.NoError(t, conn.WriteJSON(sdk.WebSocketRequest{
+			Type: sdk.MessageTypePublishTx, ClientID: "checkout", RequestID: requestID, Publishes: entries,
+		}))
+		var resp sdk.WebSocketResponse
+		require.NoError(t, conn.ReadJSON(&resp))
+		assert.Equal(t, requestID, resp.RequestID)
+		return resp
+	}
+	retained := func(topic string) int {
+		msgs, err := service.LastMessages(topic, 100)
+		require.NoError(t, err)
+		return len(msgs)
+	}
+
+	resp := publishTx("tx1",
+		sdk.TopicMessage{Topic: "order.created", Message: sdk.Message{ID: "o1", Payload: "order"}},
+		sdk.TopicMessage{Topic: "inventory.reserved", Message: sdk.Message{ID: "i1", Payload: "sku"}},
+	)
+	require.Equal(t, sdk.MessageTypeAck, resp.Type)
+	assert.Equal(t, []sdk.PublishResult{
+		{ID: "o1", Seq: 1, Status: sdk.StatusOK},
+		{ID: "i1", Seq: 1, Status: sdk.StatusOK},
+	}, resp.Results)
+	assert.Equal(t, "o1", (<-orders.Queue).ID)
+
+	// A missing topic fails the transaction before anything is appended
+	resp = publishTx("tx2",
+		sdk.TopicMessage{Topic: "order.created", Message: sdk.Message{ID: "o2"}},
+		sdk.TopicMessage{Topic: "inventory.missing", Message: sdk.Message{ID: "i2"}},
+	)
+	require.Equal(t, sdk.MessageTypeError, resp.Type)
+	assert.Equal(t, sdk.ErrorCodeTopicNotFound, resp.Error.Code)
+	assert.Contains(t, resp.Error.Message, `message 1 to "inventory.missing"`)
+	assert.Equal(t, 1, retained("order.created"))
+	assert.Empty(t, orders.Queue)
+
+	resp = publishTx("tx3", sdk.TopicMessage{Topic: "order.created", Message: sdk.Message{Payload: "no id"}})
+	require.Equal(t, sdk.MessageTypeError, resp.Type)
+	assert.Equal(t, sdk.ErrorCodeBadRequest, resp.Error.Code)
+
+	// One token is left, so a two-message transaction is refused whole
+	resp = publishTx("tx4",
+		sdk.TopicMessage{Topic: "order.created", Message: sdk.Message{ID: "o3"}},
+		sdk.TopicMessage{Topic: "inventory.reserved", Message: sdk.Message{ID: "i3"}},
+	)
+	require.Equal(t, sdk.MessageTypeError, resp.Type)
+	assert.Equal(t, sdk.ErrorCodeRateLimited, resp.Error.Code)
+	assert.Equal(t, 1, retained("order.created"))
+	assert.Equal(t, 1, retained("inventory.reserved"))
+
+	// The same transaction over REST
+	service.RateLimits.Client = RateLimit{}
+	app := fiber.New()
+	app.Post("/publish_tx", func(c *fiber.Ctx) error { return service.PublishTx(c.Context(), c) })
+	post := func(body sdk.PublishTxRequest) (int, sdk.PublishTxResponse) {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		req := httptest.NewRequest("POST", "/publish_tx", bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		httpResp, err := app.Test(req)
+		require.NoError(t, err)
+		var result sdk.PublishTxResponse
+		if httpResp.StatusCode == 200 {
+			require.NoError(t, json.NewDecoder(httpResp.Body).Decode(&result))
+		}
+		return httpResp.StatusCode, result
+	}
+
+	status, result := post(sdk.PublishTxRequest{Messages: []sdk.TopicMessage{
+		{Topic: "inventory.reserved", Message: sdk.Message{ID: "i4"}},
+		{Topic: "order.created", Message: sdk.Message{ID: "o4"}},
+		{Topic: "order.created", Message: sdk.Message{ID: "o5"}},
+	}})
+	require.Equal(t, 200, status)
+	assert.Equal(t, []uint64{2, 2, 3}, []uint64{result.Results[0].Seq, result.Results[1].Seq, result.Results[2].Seq})
+	assert.Equal(t, "o4", (<-orders.Queue).ID)
+	assert.Equal(t, "o5", (<-orders.Queue).ID)
+
+	status, _ = post(sdk.PublishTxRequest{Messages: []sdk.TopicMessage{{Topic: "nope", Message: sdk.Message{ID: "x"}}}})
+	assert.Equal(t, 404, status)
+	status, _ = post(sdk.PublishTxRequest{})
+	assert.Equal(t, 400, status)
+
+	// Routing runs once the transaction's topics are unlocked, so a rule
+	// between two of them copies instead of deadlocking
+	_, err = service.AddRoute(sdk.RouteRule{Source: "order.created", Target: "inventory.reserved"})
+	require.NoError(t, err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := service.publishTx("c1", []sdk.TopicMessage{
+			{Topic: "order.created", Message: sdk.Message{ID: "o6"}},
+			{Topic: "inventory.reserved", Message: sdk.Message{ID: "i6"}},
+		})
+		assert.NoError(t, err)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("transaction touching a route's source and target hung")
+	}
+	assert.Equal(t, "o6", (<-orders.Queue).ID)
+	msgs, _, err := service.Browse("inventory.reserved", BrowseQuery{Limit: 10})
+	require.NoError(t, err)
+	ids := make([]string, len(msgs))
+	for i, msg := range msgs {
+		ids[i] = msg.ID
+	}
+	assert.Subset(t, ids, []string{"i6", "o6"})
+}
+
+func TestCompactedTopic(t *testing.T) {
+	service := NewService(100, 3)
+	service.StateFile = t.TempDir() + "/state.json"
+	require.NoError(t, service.AddTopic("prices"))
+	compacted := true
+	require.NoError(t, service.Configure("prices", sdk.UpdateTopicRequest{Compacted: &compacted}))
+
+	_, err := service.Publish("prices", sdk.Message{ID: "no-key", Payload: 1})
+	assert.ErrorIs(t, err, ErrKeyRequired)
+
+	// The ring holds the last three messages; the state keeps every key's
+	// newest value however old, and the tombstone deletes sku-2
+	for i, m := range []sdk.Message{
+		{ID: "m1", Key: "sku-1", Payload: 10},
+		{ID: "m2", Key: "sku-2", Payload: 20},
+		{ID: "m3", Key: "sku-3", Payload: 30},
+		{ID: "m4", Key: "sku-1", Payload: 11},
+		{ID: "m5", Key: "sku-2"},
+		{ID: "m6", Key: "sku-4", Payload: 40},
+	} {
+		_, err := service.Publish("prices", m)
+		require.NoError(t, err, i)
+	}
+	detail, err := service.Describe("prices")
+	require.NoError(t, err)
+	assert.True(t, detail.Compacted)
+	assert.Equal(t, 3, detail.Keys)
+	assert.Equal(t, 3, detail.Messages)
+
+	ids := func(sub *sdk.Subscriber, n int) []string {
+		var got []string
+		for i := 0; i < n; i++ {
+			got = append(got, (<-sub.Queue).ID)
+		}
+		return got
+	}
+	sub := createTestSubscriber("state", 10)
+	n, err := service.SubscribeState("prices", sub)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []string{"m3", "m4", "m6"}, ids(sub, 3))
+	_, err = service.Publish("prices", sdk.Message{ID: "m7", Key: "sku-3", Payload: 31})
+	require.NoError(t, err)
+	assert.Equal(t, "m7", (<-sub.Queue).ID)
+
+	// Resuming inside the ring replays from there; a gap falls back to state
+	resumed := createTestSubscriber("resumed", 10)
+	after := uint64(5)
+	n, err = service.subscribe("prices", resumed, replayFrom{afterSeq: &after, state: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"m6", "m7"}, ids(resumed, n))
+	stale := createTestSubscriber("stale", 10)
+	after = 1
+	n, err = service.subscribe("prices", stale, replayFrom{afterSeq: &after, state: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"m4", "m6", "m7"}, ids(stale, n))
+
+	require.NoError(t, service.AddTopic("plain"))
+	_, err = service.SubscribeState("plain", createTestSubscriber("plain", 10))
+	assert.ErrorIs(t, err, ErrNotCompacted)
+
+	// The state survives a restart and an export round trip
+	require.NoError(t, service.SaveState())
+	restored := NewService(100, 3)
+	restored.StateFile = service.StateFile
+	require.NoError(t, restored.LoadState())
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	require.NoError(t, restored.Export("prices", w))
+	require.NoError(t, w.Flush())
+	imported := NewService(100, 3)
+	_, err = imported.Import("prices", buf.Bytes(), ImportOptions{})
+	require.NoError(t, err)
+	for _, svc := range []*ServiceImpl{restored, imported} {
+		sub := createTestSubscriber("after-restart", 10)
+		n, err := svc.SubscribeState("prices", sub)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"m4", "m6", "m7"}, ids(sub, n))
+	}
+}
+
+func TestAutoCreateTopics(t *testing.T) {
+	service := NewService(100, 100)
+	retention := 5
+	service.AutoCreate = AutoCreatePolicy{
+		Mode:        AutoCreateOnPublish,
+		Namespaces:  map[string]AutoCreateMode{"metrics": AutoCreateBoth, "billing": AutoCreateOff},
+		Settings:    sdk.UpdateTopicRequest{MaxMessages: &retention},
+		IdleTimeout: time.Minute,
+	}
+
+	// Publishing creates topics with the default settings; subscribing only
+	// where the namespace allows it
+	_, err := service.PublishFrom("p1", "orders.created", sdk.Message{ID: "m1"})
+	require.NoError(t, err)
+	detail, err := service.Describe("orders.created")
+	require.NoError(t, err)
+	assert.True(t, detail.AutoCreated)
+	assert.Equal(t, 5, detail.MaxMessages)
+	assert.Equal(t, uint64(1), detail.LastSeq)
+
+	_, err = service.Subscribe("orders.shipped", createTestSubscriber("s1", 10), 0)
+	assert.ErrorIs(t, err, ErrTopicNotFound)
+	_, err = service.Subscribe("metrics.cpu", createTestSubscriber("s1", 10), 0)
+	require.NoError(t, err)
+	_, err = service.PublishFrom("p1", "billing.invoices", sdk.Message{ID: "m1"})
+	assert.ErrorIs(t, err, ErrTopicNotFound)
+	_, err = service.publishTx("p1", []sdk.TopicMessage{{Topic: "orders.paid", Message: sdk.Message{ID: "m1"}}})
+	require.NoError(t, err)
+	assert.True(t, service.HasTopic("orders.paid"))
+
+	// Only idle auto-created topics without subscribers are collected
+	require.NoError(t, service.AddTopic("orders.manual"))
+	assert.Empty(t, service.collectIdleTopics(time.Now()))
+	removed := service.collectIdleTopics(time.Now().Add(2 * time.Minute))
+	assert.ElementsMatch(t, []string{"orders.created", "orders.paid"}, removed)
+	assert.True(t, service.HasTopic("metrics.cpu"))
+	assert.True(t, service.HasTopic("orders.manual"))
+
+	// A rejected publish or subscription creates nothing
+	service.Use(Interceptor{
+		Publish: func(clientID, topic string, msg sdk.Message) (sdk.Message, error) {
+			if clientID == "blocked" {
+				return msg, ErrRejected
+			}
+			return msg, nil
+		},
+		Subscribe: func(topic string, sub *sdk.Subscriber) error {
+			if sub.ClientID == "blocked" {
+				return ErrUnauthorized
+			}
+			return nil
+		},
+	})
+	_, err = service.PublishFrom("blocked", "orders.refunded", sdk.Message{ID: "m1"})
+	assert.ErrorIs(t, err, ErrRejected)
+	_, err = service.Subscribe("metrics.mem", createTestSubscriber("blocked", 10), 0)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	service.RateLimits.Client = RateLimit{Messages: 1}
+	_, err = service.PublishFrom("p2", "orders.created", sdk.Message{ID: "m1"})
+	require.NoError(t, err)
+	_, err = service.PublishFrom("p2", "orders.refunded", sdk.Message{ID: "m2"})
+	var limited *RateLimitError
+	assert.ErrorAs(t, err, &limited)
+	_, err = service.publishTx("p2", []sdk.TopicMessage{
+		{Topic: "orders.created", Message: sdk.Message{ID: "m3"}},
+		{Topic: "orders.voided", Message: sdk.Message{ID: "m4"}},
+	})
+	assert.ErrorAs(t, err, &limited)
+	assert.False(t, service.HasTopic("orders.voided"))
+	assert.False(t, service.HasTopic("orders.refunded"))
+	assert.False(t, service.HasTopic("metrics.mem"))
+
+	// Auto-created topics are still collected after a restart
+	service.StateFile = t.TempDir() + "/state.json"
+	require.NoError(t, service.SaveState())
+	restored := NewService(100, 100)
+	restored.AutoCreate = service.AutoCreate
+	restored.StateFile = service.StateFile
+	require.NoError(t, restored.LoadState())
+	detail, err = restored.Describe("orders.created")
+	require.NoError(t, err)
+	assert.True(t, detail.AutoCreated)
+	removed = restored.collectIdleTopics(time.Now().Add(2 * time.Minute))
+	assert.ElementsMatch(t, []string{"orders.created", "metrics.cpu"}, removed)
+	assert.True(t, restored.HasTopic("orders.manual"))
+
+	mode, err := ParseAutoCreateMode("sometimes")
+	assert.Error(t, err)
+	assert.Equal(t, AutoCreateOff, mode)
+}
+
+func TestRoutingRules(t *testing.T) {
+	service := NewService(100, 100)
+	service.StateFile = t.TempDir() + "/state.json"
+	for _, name := range []string{"orders", "orders.de", "orders.de.audit"} {
+		require.NoError(t, service.AddTopic(name))
+	}
+
+	_, err := service.AddRoute(sdk.RouteRule{
+		ID:         "de",
+		Source:     "orders",
+		Target:     "orders.de",
+		Match:      sdk.RouteMatch{Payload: map[string]string{"country": "DE"}},
+		Fields:     []string{"id", "address.city"},
+		Attributes: map[string]string{"routed-by": "de"},
+	})
+	require.NoError(t, err)
+	_, err = service.AddRoute(sdk.RouteRule{ID: "audit", Source: "orders.de", Target: "orders.de.audit"})
+	require.NoError(t, err)
+
+	// Rules that would send messages back where they came from are refused
+	_, err = service.AddRoute(sdk.RouteRule{Source: "orders.de.audit", Target: "orders"})
+	assert.ErrorIs(t, err, ErrRuleLoop)
+	assert.Contains(t, err.Error(), "orders.de.audit -> orders -> orders.de -> orders.de.audit")
+	_, err = service.AddRoute(sdk.RouteRule{Source: "orders", Target: "orders"})
+	assert.ErrorIs(t, err, ErrRuleLoop)
+	_, err = service.AddRoute(sdk.RouteRule{ID: "audit", Source: "orders", Target: "orders.de.audit"})
+	assert.ErrorIs(t, err, ErrRuleExists)
+	_, err = service.AddRoute(sdk.RouteRule{Source: "orders"})
+	assert.ErrorIs(t, err, ErrInvalidRule)
+
+	de := createTestSubscriber("de", 10)
+	_, err = service.Subscribe("orders.de", de, 0)
+	require.NoError(t, err)
+	audit := createTestSubscriber("audit", 10)
+	_, err = service.Subscribe("orders.de.audit", audit, 0)
+	require.NoError(t, err)
+
+	_, err = service.Publish("orders", sdk.Message{ID: "o1", Payload: map[string]interface{}{
+		"id": "o1", "country": "DE", "total": 10.0, "address": map[string]interface{}{"city": "Berlin", "zip": "10115"},
+	}, Attributes: map[string]string{"source": "web"}})
+	require.NoError(t, err)
+	_, err = service.Publish("orders", sdk.Message{ID: "o2", Payload: map[string]interface{}{"id": "o2", "country": "FR"}})
+	require.NoError(t, err)
+
+	// Copies carry the projected payload and injected attributes, and are
+	// routed again
+	copied := <-de.Queue
+	assert.Equal(t, "o1", copied.ID)
+	assert.Equal(t, map[string]interface{}{"id": "o1", "address": map[string]interface{}{"city": "Berlin"}}, copied.Payload)
+	assert.Equal(t, map[string]string{"source": "web", "routed-by": "de"}, copied.Attributes)
+	assert.Equal(t, "o1", (<-audit.Queue).ID)
+	assert.Empty(t, de.Queue)
+
+	routes := service.Routes()
+	require.Len(t, routes, 2)
+	assert.Equal(t, "audit", routes[0].ID)
+	assert.Equal(t, int64(1), routes[0].Routed)
+	assert.Equal(t, int64(1), routes[1].Routed)
+
+	// A missing target counts against the rule without failing the publish
+	require.NoError(t, service.RemoveTopic("orders.de.audit"))
+	_, err = service.Publish("orders.de", sdk.Message{ID: "d1"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), service.Routes()[0].Failed)
+
+	// Rules survive a restart
+	require.NoError(t, service.SaveState())
+	restored := NewService(100, 100)
+	restored.StateFile = service.StateFile
+	require.NoError(t, restored.LoadState())
+	assert.Len(t, restored.Routes(), 2)
+
+	require.NoError(t, service.RemoveRoute("audit"))
+	assert.ErrorIs(t, service.RemoveRoute("audit"), ErrRuleNotFound)
+	rule, err := service.AddRoute(sdk.RouteRule{Source: "orders.de.audit", Target: "orders"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, rule.ID)
+}
+
+func TestExclusiveSubscriptionFailover(t *testing.T) {
+	service := NewService(100, 100)
+	require.NoError(t, service.AddTopic("ledger"))
+	url := startTestServer(t, service)
+
+	dial := func(clientID string) (*websocket.Conn, sdk.WebSocketResponse) {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		require.NoError(t, conn.WriteJSON(sdk.WebSocketRequest{
+			Type: sdk.MessageTypeSubscribe, Topic: "ledger", ClientID: clientID, Exclusive: true,
+		}))
+		var ack sdk.WebSocketResponse
+		require.NoError(t, conn.ReadJSON(&ack))
+		return conn, ack
+	}
+	read := func(conn *websocket.Conn) sdk.WebSocketResponse {
+		var resp sdk.WebSocketResponse
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+		require.NoError(t, conn.ReadJSON(&resp))
+		return resp
+	}
+
+	active, ack := dial("writer-a")
+	assert.Equal(t, sdk.StatusOK, ack.Status)
+	standby, ack := dial("writer-b")
+	assert.Equal(t, sdk.StatusStandby, ack.Status)
+
+	for _, id := range []string{"m1", "m2", "m3"} {
+		_, err := service.Publish("ledger", sdk.Message{ID: id})
+		require.NoError(t, err)
+	}
+	for _, id := range []string{"m1", "m2", "m3"} {
+		assert.Equal(t, id, read(active).Message.ID)
+	}
+	require.NoError(t, active.WriteJSON(sdk.WebSocketRequest{Type: sdk.MessageTypeAck, Topic: "ledger", ClientID: "writer-a", Seq: 2}))
+
+	// Only the active subscriber may ack
+	require.NoError(t, standby.WriteJSON(sdk.WebSocketRequest{Type: sdk.MessageTypeAck, Topic: "ledger", ClientID: "writer-b", Seq: 3, RequestID: "r1"}))
+	resp := read(standby)
+	require.Equal(t, sdk.MessageTypeError, resp.Type)
+	assert.Equal(t, sdk.ErrorCodeBadRequest, resp.Error.Code)
+
+	assert.Eventually(t, func() bool {
+		detail, err := service.Describe("ledger")
+		return err == nil && detail.Exclusive.AckedSeq == 2
+	}, time.Second, 10*time.Millisecond)
+	detail, err := service.Describe("ledger")
+	require.NoError(t, err)
+	assert.Equal(t, &sdk.ExclusiveGroup{Active: "writer-a", Standbys: []string{"writer-b"}, AckedSeq: 2}, detail.Exclusive)
+	assert.Equal(t, 1, detail.Subscribers)
+
+	// The standby is told it took over and resumes after the last ack
+	active.Close()
+	resp = read(standby)
+	assert.Equal(t, sdk.MessageTypeInfo, resp.Type)
+	assert.Equal(t, promotedNotice, resp.Msg)
+	assert.Equal(t, uint64(2), resp.Seq)
+	assert.Equal(t, "m3", read(standby).Message.ID)
+	_, err = service.Publish("ledger", sdk.Message{ID: "m4"})
+	require.NoError(t, err)
+	assert.Equal(t, "m4", read(standby).Message.ID)
+
+	detail, err = service.Describe("ledger")
+	require.NoError(t, err)
+	assert.Equal(t, "writer-b", detail.Exclusive.Active)
+	assert.Empty(t, detail.Exclusive.Standbys)
+}
+
+func TestInterceptors(t *testing.T) {
+	service := NewService(100, 100)
+	require.NoError(t, service.AddTopic("audit"))
+
+	var order []string
+	service.Use(
+		Interceptor{
+			Name: "enrich",
+			Publish: func(clientID, topic string, msg sdk.Message) (sdk.Message, error) {
+				order = append(order, "enrich")
+				if msg.Attributes["drop"] == "yes" {
+					return msg, errors.New("dropped")
+				}
+				attrs := map[string]string{"publisher": clientID}
+				for k, v := range msg.Attributes {
+					attrs[k] = v
+				}
+				msg.Attributes = attrs
+				return msg, nil
+			},
+			Subscribe: func(topic string, sub *sdk.Subscriber) error {
+				if sub.ClientID == "mallory" {
+					return errors.New("banned")
+				}
+				return nil
+			},
+		},
+		Interceptor{
+			Name: "private",
+			Publish: func(clientID, topic string, msg sdk.Message) (sdk.Message, error) {
+				// Runs second, so it sees the publisher the first one added
+				order = append(order, "private:"+msg.Attributes["publisher"])
+				return msg, nil
+			},
+			Deliver: func(topic string, sub *sdk.Subscriber, msg sdk.Message) (sdk.Message, bool) {
+				if msg.Attributes["private"] == "yes" && sub.ClientID != "admin" {
+					return msg, false
+				}
+				if sub.ClientID == "redacted" {
+					msg.Payload = nil
+				}
+				return msg, true
+			},
+		},
+	)
+
+	stored, err := service.PublishFrom("p1", "audit", sdk.Message{ID: "m1", Payload: "one"})
+	require.NoError(t, err)
+	assert.Equal(t, "p1", stored.Attributes["publisher"])
+	assert.Equal(t, []string{"enrich", "private:p1"}, order)
+
+	_, err = service.PublishFrom("p1", "audit", sdk.Message{ID: "m2", Attributes: map[string]string{"drop": "yes"}})
+	assert.ErrorIs(t, err, ErrRejected)
+	_, err = service.publishTx("p1", []sdk.TopicMessage{
+		{Topic: "audit", Message: sdk.Message{ID: "t1"}},
+		{Topic: "audit", Message: sdk.Message{ID: "t2", Attributes: map[string]string{"drop": "yes"}}},
+	})
+	var txErr *TxError
+	require.ErrorAs(t, err, &txErr)
+	assert.Equal(t, 1, txErr.Index)
+	assert.ErrorIs(t, err, ErrRejected)
+
+	_, err = service.PublishFrom("p1", "audit", sdk.Message{ID: "m3", Attributes: map[string]string{"private": "yes"}})
+	require.NoError(t, err)
+
+	// Replay is filtered per subscriber, like live delivery
+	admin := createTestSubscriber("admin", 10)
+	guest := createTestSubscriber("guest", 10)
+	redacted := createTestSubscriber("redacted", 10)
+	n, err := service.Subscribe("audit", admin, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = service.Subscribe("audit", guest, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	_, err = service.Subscribe("audit", redacted, 10)
+	require.NoError(t, err)
+	assert.Equal(t, "m1", (<-guest.Queue).ID)
+	assert.Nil(t, (<-redacted.Queue).Payload)
+
+	_, err = service.PublishFrom("p2", "audit", sdk.Message{ID: "m4", Payload: "four", Attributes: map[string]string{"private": "yes"}})
+	require.NoError(t, err)
+	_, err = service.PublishFrom("p2", "audit", sdk.Message{ID: "m5", Payload: "five"})
+	require.NoError(t, err)
+	for _, id := range []string{"m1", "m3", "m4", "m5"} {
+		assert.Equal(t, id, (<-admin.Queue).ID)
+	}
+	msg := <-guest.Queue
+	assert.Equal(t, "m5", msg.ID)
+	assert.Equal(t, "five", msg.Payload)
+	assert.Empty(t, guest.Queue)
+	msg = <-redacted.Queue
+	assert.Equal(t, "m5", msg.ID)
+	assert.Nil(t, msg.Payload)
+
+	// Withheld messages are still retained for everyone else
+	snapshot, err := service.Snapshot("audit")
+	require.NoError(t, err)
+	assert.Len(t, snapshot.Messages, 4)
+
+	_, err = service.Subscribe("audit", createTestSubscriber("mallory", 10), 0)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+
+	url := startTestServer(t, service)
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.WriteJSON(sdk.WebSocketRequest{Type: sdk.MessageTypeSubscribe, Topic: "audit", ClientID: "mallory"}))
+	var resp sdk.WebSocketResponse
+	require.NoError(t, conn.ReadJSON(&resp))
+	require.Equal(t, sdk.MessageTypeError, resp.Type)
+	assert.Equal(t, sdk.ErrorCodeUnauthorized, resp.Error.Code)
+	assert.Equal(t, 3, service.SubscriberCount("audit"))
+}
+
+func TestClientManagement(t *testing.T) {
+	service := NewService(100, 100)
+	require.NoError(t, service.AddTopic("ops"))
+	url := startTestServer(t, service)
+
+	read := func(conn *websocket.Conn) sdk.WebSocketResponse {
+		var resp sdk.WebSocketResponse
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+		require.NoError(t, conn.ReadJSON(&resp))
+		return resp
+	}
+	dial := func(clientID string) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		require.NoError(t, conn.WriteJSON(sdk.WebSocketRequest{Type: sdk.MessageTypeSubscribe, Topic: "ops", ClientID: clientID}))
+		require.Equal(t, sdk.MessageTypeAck, read(conn).Type)
+		return conn
+	}
+	alice := dial("alice")
+	bob := dial("bob")
+
+	clients := service.Clients()
+	require.Len(t, clients, 2)
+	assert.Equal(t, []string{"alice"}, clients[0].ClientIDs)
+	assert.Equal(t, []sdk.ClientSubscription{{Topic: "ops", ClientID: "alice"}}, clients[0].Subscriptions)
+	assert.Equal(t, []string{"bob"}, clients[1].ClientIDs)
+
+	assert.Equal(t, 1, service.Notify("alice", "hello"))
+	resp := read(alice)
+	assert.Equal(t, sdk.MessageTypeInfo, resp.Type)
+	assert.Equal(t, "hello", resp.Msg)
+
+	assert.Equal(t, 2, service.NotifyAll("maintenance at noon"))
+	assert.Equal(t, "maintenance at noon", read(alice).Msg)
+	assert.Equal(t, "maintenance at noon", read(bob).Msg)
+
+	// A session id works as well as a client id
+	assert.Equal(t, 1, service.Kick(clients[1].ID, "too many reconnects"))
+	assert.Equal(t, "too many reconnects", read(bob).Msg)
+	_, _, err := bob.ReadMessage()
+	var closeErr *websocket.CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, websocket.ClosePolicyViolation, closeErr.Code)
+	assert.Equal(t, "too many reconnects", closeErr.Text)
+	assert.Eventually(t, func() bool { return len(service.Clients()) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, service.SubscriberCount("ops"))
+	assert.Equal(t, 0, service.Kick("nobody", ""))
+
+	// An evicted subscriber is told why before its writer stops
+	slow := createTestSubscriber("slow", 1)
+	_, err = service.Subscribe("ops", slow, 0)
+	require.NoError(t, err)
+	for _, id := range []string{"m1", "m2"} {
+		_, err = service.Publish("ops", sdk.Message{ID: id})
+		require.NoError(t, err)
+	}
+	var frames []sdk.WebSocketResponse
+	service.subscriberWriter(slow, "ops", func(_ string, data interface{}) {
+		frames = append(frames, data.(sdk.WebSocketResponse))
+	})
+	require.NotEmpty(t, frames)
+	last := frames[len(frames)-1]
+	require.Equal(t, sdk.MessageTypeError, last.Type)
+	assert.Equal(t, sdk.ErrorCodeSlowConsumer, last.Error.Code)
+	assert.Equal(t, "ops", last.Topic)
+}
+
+func TestClientInboxes(t *testing.T) {
+	service := NewService(100, 100)
+	service.InboxTTL = time.Minute
+	service.InboxSize = 2
+	inbox := sdk.InboxTopic("bob")
+
+	// Held while bob is offline, the oldest dropped beyond the limit
+	for _, id := range []string{"m1", "m2", "m3"} {
+		_, err := service.PublishFrom("alice", inbox, sdk.Message{ID: id})
+		require.NoError(t, err)
+	}
+
+	_, err := service.Subscribe(inbox, createTestSubscriber("alice", 10), 0)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+
+	phone := createTestSubscriber("bob", 10)
+	n, err := service.Subscribe(inbox, phone, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "m2", (<-phone.Queue).ID)
+	assert.Equal(t, "m3", (<-phone.Queue).ID)
+
+	// Every session of the client gets live messages; none are held
+	laptop := createTestSubscriber("bob", 10)
+	n, err = service.Subscribe(inbox, laptop, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	_, err = service.Publish(inbox, sdk.Message{ID: "m4"})
+	require.NoError(t, err)
+	assert.Equal(t, "m4", (<-phone.Queue).ID)
+	assert.Equal(t, "m4", (<-laptop.Queue).ID)
+
+	service.Detach(inbox, phone)
+	_, err = service.Publish(inbox, sdk.Message{ID: "m5"})
+	require.NoError(t, err)
+	assert.Equal(t, "m5", (<-laptop.Queue).ID)
+	assert.Empty(t, phone.Queue)
+
+	// Held messages expire
+	require.NoError(t, service.Unsubscribe(inbox, "bob"))
+	_, err = service.Publish(inbox, sdk.Message{ID: "m6"})
+	require.NoError(t, err)
+	service.expireInboxes(time.Now().Add(2 * time.Minute))
+	n, err = service.Subscribe(inbox, createTestSubscriber("bob", 10), 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// Inboxes are not topics
+	assert.ErrorIs(t, service.AddTopic(inbox), ErrReservedName)
+	_, err = service.SubscribeState(inbox, createTestSubscriber("bob", 10))
+	assert.ErrorIs(t, err, ErrInboxUnsupported)
+	_, err = service.subscribeExclusive(inbox, createTestSubscriber("bob", 10), replayFrom{}, nil)
+	assert.ErrorIs(t, err, ErrInboxUnsupported)
+	_, err = service.publishTx("alice", []sdk.TopicMessage{{Topic: inbox, Message: sdk.Message{ID: "t1"}}})
+	assert.ErrorIs(t, err, ErrInboxUnsupported)
+	assert.Empty(t, service.TopicNames())
+}
+
+func TestReplayJob(t *testing.T) {
+	service := NewService(100, 100)
+	for _, name := range []string{"incident", "staging", "slow"} {
+		require.NoError(t, service.AddTopic(name))
+	}
+	base := time.Now().UTC().Add(-time.Hour)
+	for i, id := range []string{"r1", "r2", "r3", "r4"} {
+		_, err := service.PublishLocal("incident", sdk.Message{ID: id, Key: id, TS: base.Add(time.Duration(i) * 100 * time.Millisecond)})
+		require.NoError(t, err)
+	}
+	staging := createTestSubscriber("consumer", 10)
+	_, err := service.Subscribe("staging", staging, 0)
+	require.NoError(t, err)
+
+	// Twice as fast: r2 and r3 follow r1 after 50ms and 100ms
+	start := time.Now()
+	job, err := service.StartReplay(sdk.ReplayRequest{Source: "incident", Target: "staging", BeforeSeq: 4, Speed: 2})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), job.Total)
+	for _, id := range []string{"r1", "r2", "r3"} {
+		msg := <-staging.Queue
+		assert.Equal(t, id, msg.ID)
+		assert.Equal(t, id, msg.Key)
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		job, err = service.SetJobState(job.ID, sdk.JobStatePaused)
+		return errors.Is(err, ErrJobFinished)
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, sdk.JobStateCompleted, job.State)
+	assert.Equal(t, int64(3), job.Published)
+	require.NotNil(t, job.FinishedAt)
+
+	// A slow replay can be paused, resumed and cancelled between messages
+	for i, id := range []string{"s1", "s2", "s3"} {
+		_, err := service.PublishLocal("slow", sdk.Message{ID: id, TS: base.Add(time.Duration(i) * 10 * time.Second)})
+		require.NoError(t, err)
+	}
+	job, err = service.StartReplay(sdk.ReplayRequest{Source: "slow", Target: "staging"})
+	require.NoError(t, err)
+	assert.Equal(t, "s1", (<-staging.Queue).ID)
+	job, err = service.SetJobState(job.ID, sdk.JobStatePaused)
+	require.NoError(t, err)
+	assert.Equal(t, sdk.JobStatePaused, job.State)
+	_, err = service.SetJobState(job.ID, sdk.JobStateRunning)
+	require.NoError(t, err)
+	_, err = service.SetJobState(job.ID, sdk.JobStateCancelled)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		jobs := service.Jobs()
+		return len(jobs) == 2 && jobs[1].FinishedAt != nil
+	}, time.Second, 10*time.Millisecond)
+	job = service.Jobs()[1]
+	assert.Equal(t, sdk.JobStateCancelled, job.State)
+	assert.Equal(t, int64(1), job.Published)
+	assert.Empty(t, staging.Queue)
+
+	_, err = service.StartReplay(sdk.ReplayRequest{Source: "slow", Target: "slow"})
+	assert.ErrorIs(t, err, ErrInvalidReplay)
+	_, err = service.StartReplay(sdk.ReplayRequest{Source: "missing", Target: "staging"})
+	assert.ErrorIs(t, err, ErrTopicNotFound)
+	_, err = service.SetJobState("missing", sdk.JobStatePaused)
+	assert.ErrorIs(t, err, ErrJobNotFound)
+}
+
+func TestGeneratorJob(t *testing.T) {
+	service := NewService(100, 100)
+	for _, name := range []string{"orders-a", "orders-b", "users", "bursty", "endless"} {
+		require.NoError(t, service.AddTopic(name))
+	}
+	drain := func(sub *sdk.Subscriber, n int) []sdk.Message {
+		msgs := make([]sdk.Message, n)
+		for i := range msgs {
+			select {
+			case msgs[i] = <-sub.Queue:
+			case <-time.After(2 * time.Second):
+				t.Fatalf("got %d of %d messages", i, n)
+			}
+		}
+		return msgs
+	}
+
+	// The same seed gives the same payloads, keys and attributes
+	template := map[string]interface{}{
+		"order":    "{{uuid}}",
+		"customer": map[string]interface{}{"name": "{{name}}", "email": "{{email}}"},
+		"quantity": "{{int 1 5}}",
+		"note":     "{{word}} from {{city}}",
+		"status":   "{{pick new paid shipped}}",
+		"n":        "{{seq}}",
+	}
+	var runs [][]sdk.Message
+	for _, topic := range []string{"orders-a", "orders-b"} {
+		sub := createTestSubscriber("consumer", 10)
+		_, err := service.Subscribe(topic, sub, 0)
+		require.NoError(t, err)
+		job, err := service.StartGenerator(sdk.GeneratorRequest{
+			Topic: topic, Rate: 1000, Count: 5, Seed: 42, Template: template,
+			Key: "{{first_name}}", Attributes: map[string]string{"source": "synth-{{int 1 3}}"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), job.Total)
+		assert.Equal(t, sdk.JobKindGenerator, job.Kind)
+		runs = append(runs, drain(sub, 5))
+	}
+	for i, msg := range runs[0] {
+		other := runs[1][i]
+		assert.Equal(t, msg.Payload, other.Payload)
+		assert.Equal(t, msg.Key, other.Key)
+		assert.Equal(t, msg.Attributes, other.Attributes)
+		assert.NotEqual(t, msg.ID, other.ID)
+
+		payload := msg.Payload.(map[string]interface{})
+		assert.Equal(t, int64(i+1), payload["n"])
+		assert.Contains(t, []string{"new", "paid", "shipped"}, payload["status"])
+		assert.Contains(t, payload["customer"].(map[string]interface{})["email"], "@")
+		assert.Contains(t, payload["note"], " from ")
+		assert.InDelta(t, 3, payload["quantity"], 2)
+		assert.Regexp(t, `^synth-[1-3]$`, msg.Attributes["source"])
+	}
+	assert.NotEqual(t, runs[0][0].Payload, runs[0][1].Payload)
+	assert.Eventually(t, func() bool {
+		jobs := service.Generators()
+		return len(jobs) == 2 && jobs[1].State == sdk.JobStateCompleted && jobs[1].Published == 5
+	}, time.Second, 10*time.Millisecond)
+
+	// A schema gives values of its types and bounds
+	users := createTestSubscriber("consumer", 10)
+	_, err := service.Subscribe("users", users, 0)
+	require.NoError(t, err)
+	job, err := service.StartGenerator(sdk.GeneratorRequest{Topic: "users", Rate: 1000, Count: 3, Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"id":     map[string]interface{}{"type": "string", "format": "uuid"},
+			"age":    map[string]interface{}{"type": "integer", "minimum": float64(18), "maximum": float64(30)},
+			"score":  map[string]interface{}{"type": "number", "minimum": float64(0), "maximum": float64(1)},
+			"active": map[string]interface{}{"type": "boolean"},
+			"plan":   map[string]interface{}{"enum": []interface{}{"free", "pro"}},
+			"city":   map[string]interface{}{"type": "string", "faker": "city"},
+			"tags":   map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "minItems": float64(1), "maxItems": float64(3)},
+		},
+	}})
+	require.NoError(t, err)
+	assert.NotZero(t, job.Generator.Seed)
+	for _, msg := range drain(users, 3) {
+		user := msg.Payload.(map[string]interface{})
+		assert.Len(t, user["id"], 36)
+		assert.IsType(t, int64(0), user["age"])
+		assert.GreaterOrEqual(t, user["age"], int64(18))
+		assert.LessOrEqual(t, user["age"], int64(30))
+		assert.IsType(t, float64(0), user["score"])
+		assert.IsType(t, true, user["active"])
+		assert.Contains(t, []interface{}{"free", "pro"}, user["plan"])
+		assert.IsType(t, "", user["city"])
+		assert.NotEmpty(t, user["tags"])
+		assert.LessOrEqual(t, len(user["tags"].([]interface{})), 3)
+	}
+
+	// Bursts publish back to back, then wait for the interval
+	bursty := createTestSubscriber("consumer", 10)
+	_, err = service.Subscribe("bursty", bursty, 0)
+	require.NoError(t, err)
+	start := time.Now()
+	_, err = service.StartGenerator(sdk.GeneratorRequest{Topic: "bursty", Burst: &sdk.GeneratorBurst{Size: 3, IntervalMS: 100}, Count: 6, Template: "{{seq}}"})
+	require.NoError(t, err)
+	drain(bursty, 3)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+	msgs := drain(bursty, 3)
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	assert.Equal(t, int64(6), msgs[2].Payload)
+
+	// Without a count a generator runs until stopped
+	endless := createTestSubscriber("consumer", 100)
+	_, err = service.Subscribe("endless", endless, 0)
+	require.NoError(t, err)
+	job, err = service.StartGenerator(sdk.GeneratorRequest{Topic: "endless", Rate: 50, Template: "{{sentence}}"})
+	require.NoError(t, err)
+	drain(endless, 2)
+	job, err = service.StopGenerator(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, sdk.JobStateCancelled, job.State)
+	_, err = service.StopGenerator(job.ID)
+	assert.ErrorIs(t, err, ErrJobFinished)
+
+	for _, req := range []sdk.GeneratorRequest{
+		{Rate: 1, Template: "x"},
+		{Topic: "users", Template: "x"},
+		{Topic: "users", Rate: 1, Burst: &sdk.GeneratorBurst{Size: 1, IntervalMS: 1}, Template: "x"},
+		{Topic: "users", Burst: &sdk.GeneratorBurst{Size: 0, IntervalMS: 1}, Template: "x"},
+		{Topic: "users", Rate: 1},
+		{Topic: "users", Rate: 1, Count: -1, Template: "x"},
+	} {
+		_, err = service.StartGenerator(req)
+		assert.ErrorIs(t, err, ErrInvalidGenerator)
+	}
+	_, err = service.StartGenerator(sdk.GeneratorRequest{Topic: "users", Rate: 1, Template: "{{nonsense}}"})
+	assert.ErrorIs(t, err, ErrInvalidSynth)
+	for _, template := range []string{"{{int 5 1}}", "{{int 0 1e19}}", "{{int 0.5 0.7}}", "{{float 0 Inf}}"} {
+		_, err = service.StartGenerator(sdk.GeneratorRequest{Topic: "users", Rate: 1, Template: template})
+		assert.ErrorIs(t, err, ErrInvalidSynth, template)
+	}
+	_, err = service.StartGenerator(sdk.GeneratorRequest{Topic: "users", Rate: 1, Schema: map[string]interface{}{
+		"type": "integer", "minimum": 0.5, "maximum": 0.7,
+	}})
+	assert.ErrorIs(t, err, ErrInvalidSynth)
+
+	// Schemas cannot make huge arrays or strings
+	synth, err := compileSchema(map[string]interface{}{
+		"type":     "array",
+		"items":    map[string]interface{}{"type": "string", "minLength": float64(1e9)},
+		"minItems": float64(1e9),
+	})
+	require.NoError(t, err)
+	huge := synth(&synthState{rng: rand.New(rand.NewSource(1))}).([]interface{})
+	assert.Len(t, huge, maxSynthItems)
+	assert.Len(t, huge[0], maxSynthLength)
+
+	_, err = service.StartGenerator(sdk.GeneratorRequest{Topic: "missing", Rate: 1, Template: "x"})
+	assert.ErrorIs(t, err, ErrTopicNotFound)
+	_, err = service.StopGenerator("missing")
+	assert.ErrorIs(t, err, ErrJobNotFound)
+}
+
+func TestJobPanicFailsJob(t *testing.T) {
+	service := NewService(100, 100)
+	started := service.startJob(sdk.Job{Kind: sdk.JobKindGenerator}, func(*job) error {
+		panic("boom")
+	})
+	assert.Eventually(t, func() bool {
+		j, ok := service.getJob(started.ID)
+		return ok && j.detail().State == sdk.JobStateFailed
+	}, time.Second, 10*time.Millisecond)
+	j, _ := service.getJob(started.ID)
+	assert.Equal(t, "job panicked: boom", j.detail().Error)
+}
+
+func TestJobCancelledAfterLastPublishStaysCancelled(t *testing.T) {
+	service := NewService(100, 100)
+	started := service.startJob(sdk.Job{Kind: sdk.JobKindReplay}, func(j *job) error {
+		_, err := service.SetJobState(j.info.ID, sdk.JobStateCancelled)
+		return err
+	})
+	assert.Eventually(t, func() bool {
+		j, ok := service.getJob(started.ID)
+		return ok && j.detail().FinishedAt != nil
+	}, time.Second, 10*time.Millisecond)
+	j, _ := service.getJob(started.ID)
+	assert.Equal(t, sdk.JobStateCancelled, j.detail().State)
+}
+
+func TestInboxBoundToConnectionIdentity(t *testing.T) {
+	service := NewService(100, 100)
+	service.InboxTTL, service.InboxSize = time.Minute, 10
+	_, err := service.Publish(sdk.InboxTopic("bob"), sdk.Message{ID: "secret"})
+	require.NoError(t, err)
+
+	conn, _, err := websocket.DefaultDialer.Dial(startTestServer(t, service), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	subscribe := func(clientID, topic string) sdk.WebSocketResponse {
+		require.NoError(t, conn.WriteJSON(sdk.WebSocketRequest{Type: sdk.MessageTypeSubscribe, Topic: topic, ClientID: clientID, RequestID: topic}))
+		var frame sdk.WebSocketResponse
+		require.NoError(t, conn.ReadJSON(&frame))
+		return frame
+	}
+
+	// The connection is alice once it first says so, and cannot turn into bob
+	assert.Equal(t, sdk.MessageTypeAck, subscribe("alice", sdk.InboxTopic("alice")).Type)
+	frame := subscribe("bob", sdk.InboxTopic("bob"))
+	require.NotNil(t, frame.Error)
+	assert.Equal(t, sdk.ErrorCodeUnauthorized, frame.Error.Code)
+
+	// Bob's held message is still there for bob
+	sub := createTestSubscriber("bob", 10)
+	n, err := service.subscribeInbox(sdk.InboxTopic("bob"), sub, replayFrom{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+// spanRecorder is a SpanExporter keeping what it is sent
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []sdk.Span
+}
+
+func (r *spanRecorder) Export(ctx context.Context, spans []sdk.Span) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func TestMessageTracing(t *testing.T) {
+	service := NewService(100, 100)
+	_, err := service.Trace("m1")
+	assert.ErrorIs(t, err, ErrTracingDisabled)
+
+	recorder := &spanRecorder{}
+	service.EnableTracing(TraceConfig{Exporter: recorder})
+	require.NoError(t, service.AddTopic("orders"))
+	service.Use(Interceptor{
+		Name: "gate",
+		Publish: func(clientID, topic string, msg sdk.Message) (sdk.Message, error) {
+			if msg.Attributes["reject"] == "yes" {
+				return msg, errors.New("rejected")
+			}
+			return msg, nil
+		},
+		Deliver: func(topic string, sub *sdk.Subscriber, msg sdk.Message) (sdk.Message, bool) {
+			return msg, sub.ClientID != "blocked"
+		},
+	})
+	fast := createTestSubscriber("fast", 10)
+	slow := createTestSubscriber("slow", 1)
+	for _, sub := range []*sdk.Subscriber{fast, slow, createTestSubscriber("blocked", 10)} {
+		_, err := service.Subscribe("orders", sub, 0)
+		require.NoError(t, err)
+	}
+
+	// An incoming trace context is continued, and replaced by the publish span's
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	stored, err := service.PublishFrom("pub", "orders", sdk.Message{ID: "m1", Attributes: map[string]string{sdk.TraceParentAttribute: parent}})
+	require.NoError(t, err)
+	tc, ok := parseTraceParent(stored.Attributes[sdk.TraceParentAttribute])
+	require.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", tc.traceID)
+	assert.NotEqual(t, "00f067aa0ba902b7", tc.spanID)
+	assert.Equal(t, stored.Attributes, (<-fast.Queue).Attributes)
+
+	trace, err := service.Trace("m1")
+	require.NoError(t, err)
+	require.Len(t, trace.Spans, 4)
+	publish := trace.Spans[0]
+	assert.Equal(t, sdk.SpanNamePublish, publish.Name)
+	assert.Equal(t, "00f067aa0ba902b7", publish.ParentSpanID)
+	assert.Equal(t, tc.spanID, publish.SpanID)
+	assert.Equal(t, "pub", publish.ClientID)
+	assert.Equal(t, "1", publish.Attributes["seq"])
+	outcomes := map[string]string{}
+	for _, span := range trace.Spans[1:] {
+		assert.Equal(t, tc.traceID, span.TraceID)
+		assert.Equal(t, tc.spanID, span.ParentSpanID)
+		assert.Equal(t, "orders", span.Topic)
+		outcomes[span.ClientID] = span.Name
+	}
+	assert.Equal(t, map[string]string{"fast": sdk.SpanNameEnqueue, "slow": sdk.SpanNameEnqueue, "blocked": sdk.SpanNameDrop}, outcomes)
+
+	// Without one a trace is started; the full queue evicts its subscriber
+	stored, err = service.Publish("orders", sdk.Message{ID: "m2"})
+	require.NoError(t, err)
+	tc2, ok := parseTraceParent(stored.Attributes[sdk.TraceParentAttribute])
+	require.True(t, ok)
+	assert.NotEqual(t, tc.traceID, tc2.traceID)
+	trace, err = service.Trace("m2")
+	require.NoError(t, err)
+	var evicted sdk.Span
+	for _, span := range trace.Spans {
+		if span.Name == sdk.SpanNameEvict {
+			evicted = span
+		}
+	}
+	assert.Equal(t, "slow", evicted.ClientID)
+	assert.Equal(t, "subscriber queue full", evicted.Error)
+	<-fast.Queue
+
+	// A refused publish is traced too
+	_, err = service.PublishFrom("pub", "orders", sdk.Message{ID: "m3", Attributes: map[string]string{"reject": "yes"}})
+	assert.ErrorIs(t, err, ErrRejected)
+	trace, err = service.Trace("m3")
+	require.NoError(t, err)
+	require.Len(t, trace.Spans, 1)
+	assert.Contains(t, trace.Spans[0].Error, "rejected")
+
+	// Writing to a WebSocket subscriber closes the trace
+	conn, _, err := websocket.DefaultDialer.Dial(startTestServer(t, service), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.WriteJSON(sdk.WebSocketRequest{Type: sdk.MessageTypeSubscribe, Topic: "orders", ClientID: "ws"}))
+	var frame sdk.WebSocketResponse
+	require.NoError(t, conn.ReadJSON(&frame))
+	_, err = service.Publish("orders", sdk.Message{ID: "m4"})
+	require.NoError(t, err)
+	require.NoError(t, conn.ReadJSON(&frame))
+	require.Equal(t, "m4", frame.Message.ID)
+	assert.Eventually(t, func() bool {
+		trace, err := service.Trace("m4")
+		return err == nil && trace.Spans[len(trace.Spans)-1].Name == sdk.SpanNameWrite
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = service.Trace("missing")
+	assert.ErrorIs(t, err, ErrTraceNotFound)
+
+	// Stopping flushes every span to the exporter
+	service.stopTracing(context.Background())
+	recorder.mu.Lock()
+	exported := map[string]int{}
+	for _, span := range recorder.spans {
+		exported[span.MessageID]++
+	}
+	recorder.mu.Unlock()
+	assert.Equal(t, 4, exported["m1"])
+	assert.Equal(t, 1, exported["m3"])
+	assert.GreaterOrEqual(t, exported["m4"], 2)
+
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		_, ok := parseTraceParent(value)
+		assert.False(t, ok, value)
+	}
+	_, ok = parseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra")
+	assert.True(t, ok)
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body map[string]interface{}
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer collector.Close()
+
+	start := time.Unix(1700000000, 0)
+	exporter := NewOTLPExporter(collector.URL, "pubsub-test")
+	require.NoError(t, exporter.Export(context.Background(), []sdk.Span{{
+		TraceID:      "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:       "00f067aa0ba902b7",
+		ParentSpanID: "b7ad6b7169203331",
+		Name:         sdk.SpanNameEvict,
+		Topic:        "orders",
+		MessageID:    "m1",
+		ClientID:     "slow",
+		Start:        start,
+		End:          start.Add(time.Millisecond),
+		Error:        "subscriber queue full",
+	}}))
+
+	resource := body["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "pubsub-test", resource["resource"].(map[string]interface{})["attributes"].([]interface{})[0].(map[string]interface{})["value"].(map[string]interface{})["stringValue"])
+	span := resource["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span["traceId"])
+	assert.Equal(t, "b7ad6b7169203331", span["parentSpanId"])
+	assert.Equal(t, "evict orders", span["name"])
+	assert.Equal(t, "1700000000000000000", span["startTimeUnixNano"])
+	assert.Equal(t, "1700000000001000000", span["endTimeUnixNano"])
+	assert.Equal(t, map[string]interface{}{"code": float64(2), "message": "subscriber queue full"}, span["status"])
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	assert.Error(t, NewOTLPExporter(failing.URL, "pubsub-test").Export(context.Background(), []sdk.Span{{Name: sdk.SpanNamePublish}}))
+
+	var out bytes.Buffer
+	require.NoError(t, NewStdoutExporter(&out).Export(context.Background(), []sdk.Span{{Name: sdk.SpanNamePublish, MessageID: "m1"}, {Name: sdk.SpanNameWrite, MessageID: "m1"}}))
+	assert.Equal(t, 2, strings.Count(out.String(), "\n"))
+}
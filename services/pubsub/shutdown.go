@@ -0,0 +1,217 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+	"github.com/gofiber/websocket/v2"
+)
+
+// shutdownNotice is announced to clients when the server starts draining
+const shutdownNotice = "server restarting, please reconnect"
+
+// drainPoll is how often Shutdown re-checks queues and connections
+const drainPoll = 20 * time.Millisecond
+
+// Shutdown announces the restart to every client, flushes subscriber queues,
+// closes connections and persists state. It gives up waiting when ctx expires.
+func (s *ServiceImpl) Shutdown(ctx context.Context) error {
+	if !s.draining.CompareAndSwap(false, true) {
+		return nil
+	}
+	close(s.done)
+
+	for _, sess := range s.liveSessions() {
+		sess.send("info", infoFrame(shutdownNotice))
+	}
+
+	// Let subscriber writers hand queued events to their connections
+	s.waitUntil(ctx, s.queuesDrained)
+
+	closeFrame := websocket.FormatCloseMessage(websocket.CloseServiceRestart, shutdownNotice)
+	for _, sess := range s.liveSessions() {
+		sess.send(wsTypeClose, closeFrame)
+	}
+
+	if !s.waitUntil(ctx, func() bool { return len(s.liveSessions()) == 0 }) {
+		// Deadline hit - force the remaining readers to unwind
+		for _, sess := range s.liveSessions() {
+			sess.conn.SetReadDeadline(time.Now())
+		}
+	}
+
+	s.stopTracing(ctx)
+	return s.SaveState()
+}
+
+// waitUntil polls cond until it holds or ctx expires, reporting which happened
+func (s *ServiceImpl) waitUntil(ctx context.Context, cond func() bool) bool {
+	ticker := time.NewTicker(drainPoll)
+	defer ticker.Stop()
+
+	for !cond() {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+// liveSessions snapshots the connected sessions
+func (s *ServiceImpl) liveSessions() []*wsSession {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	sessions := make([]*wsSession, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	return sessions
+}
+
+// queuesDrained reports whether every subscriber queue is empty and no
+// batching writer holds messages back. Credit-mode subscribers only drain as
+// fast as they grant credit, so they are not waited for.
+func (s *ServiceImpl) queuesDrained() bool {
+	for _, topic := range s.topics.all() {
+		for _, sub := range topic.subscriberList() {
+			if !sub.FlowControl && (len(sub.Queue) > 0 || sub.Held.Load() > 0) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// savedState is the layout of StateFile
+type savedState struct {
+	Topics []topicState    `json:"topics"`
+	Routes []sdk.RouteRule `json:"routes,omitempty"`
+}
+
+// topicState is the persisted form of a topic
+type topicState struct {
+	Name        string         `json:"name"`
+	MaxMessages int            `json:"max_messages"`
+	LastSeq     uint64         `json:"last_seq"`
+	Messages    []messageState `json:"messages"`
+	Paused      bool           `json:"paused,omitempty"`
+	PausedAt    uint64         `json:"paused_at,omitempty"`
+	RateLimit   *RateLimit     `json:"rate_limit,omitempty"`
+	Compacted   bool           `json:"compacted,omitempty"`
+
+	// AutoCreatedAt is when an auto-created topic was made, so it is still
+	// collected once idle after a restart; nil for other topics
+	AutoCreatedAt *time.Time `json:"auto_created_at,omitempty"`
+}
+
+// messageState keeps the server timestamp that sdk.Message does not serialize
+type messageState struct {
+	ID         string            `json:"id"`
+	Key        string            `json:"key,omitempty"`
+	Payload    interface{}       `json:"payload"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Seq        uint64            `json:"seq"`
+	TS         time.Time         `json:"ts"`
+}
+
+// SaveState writes topics and their retained messages, including the
+// compacted state, and the routing rules to StateFile
+func (s *ServiceImpl) SaveState() error {
+	if s.StateFile == "" {
+		return nil
+	}
+
+	topics := s.topics.all()
+	state := savedState{Topics: make([]topicState, 0, len(topics))}
+	for _, topic := range topics {
+		topic.mu.RLock()
+		retained := topic.retained()
+		ts := topicState{
+			Name:        topic.name,
+			MaxMessages: topic.maxMessages,
+			LastSeq:     topic.lastSeq,
+			Messages:    make([]messageState, 0, len(retained)),
+			Paused:      topic.paused,
+			PausedAt:    topic.pausedAt,
+			RateLimit:   topic.limit.Load(),
+			Compacted:   topic.state != nil,
+		}
+		if !topic.createdAt.IsZero() {
+			createdAt := topic.createdAt
+			ts.AutoCreatedAt = &createdAt
+		}
+		for _, msg := range retained {
+			ts.Messages = append(ts.Messages, messageState{ID: msg.ID, Key: msg.Key, Payload: msg.Payload, Attributes: msg.Attributes, Seq: msg.Seq, TS: msg.TS})
+		}
+		topic.mu.RUnlock()
+		state.Topics = append(state.Topics, ts)
+	}
+	for _, rule := range s.Routes() {
+		state.Routes = append(state.Routes, rule.RouteRule)
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	// Write to a temp file first so a crash never leaves a truncated snapshot
+	tmp := s.StateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	if err := os.Rename(tmp, s.StateFile); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	return nil
+}
+
+// LoadState restores topics and routing rules saved by SaveState. A missing
+// file is not an error.
+func (s *ServiceImpl) LoadState() error {
+	if s.StateFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state: %w", err)
+	}
+
+	var state savedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to decode state: %w", err)
+	}
+
+	for _, ts := range state.Topics {
+		topic := newTopic(ts.Name, ts.MaxMessages)
+		topic.lastSeq = ts.LastSeq
+		topic.paused, topic.pausedAt = ts.Paused, ts.PausedAt
+		topic.limit.Store(ts.RateLimit)
+		topic.setCompacted(ts.Compacted)
+		if ts.AutoCreatedAt != nil {
+			topic.createdAt = *ts.AutoCreatedAt
+		}
+		for _, msg := range ts.Messages {
+			topic.retain(sdk.Message{ID: msg.ID, Key: msg.Key, Payload: msg.Payload, Attributes: msg.Attributes, Seq: msg.Seq, TS: msg.TS})
+		}
+		s.topics.put(topic)
+	}
+	for _, rule := range state.Routes {
+		if _, err := s.AddRoute(rule); err != nil && !errors.Is(err, ErrRuleExists) {
+			return fmt.Errorf("failed to restore routing rule %q: %w", rule.ID, err)
+		}
+	}
+	return nil
+}
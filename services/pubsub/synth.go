@@ -0,0 +1,444 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Synthetic payloads are compiled once from a template or a JSON Schema
+// into a synthesizer, which draws every random choice from the generator's
+// seeded source in a fixed order, so a seed always yields the same
+// payloads. Only {{timestamp}} reads the clock.
+
+// ErrInvalidSynth is returned for a template or schema that cannot be
+// synthesized
+var ErrInvalidSynth = errors.New("invalid synthetic payload")
+
+// Schemas cannot ask for arrays or strings longer than these
+const (
+	maxSynthItems  = 100
+	maxSynthLength = 1024
+)
+
+// synthState is what a synthesizer draws from for one message
+type synthState struct {
+	rng *rand.Rand
+	seq int64 // 1 for the first message
+}
+
+// synthesizer produces one value
+type synthesizer func(st *synthState) interface{}
+
+// fakers produce a value from their arguments; each checks its arguments
+// when compiled and returns the synthesizer
+var fakers = map[string]func(args []string) (synthesizer, error){
+	"uuid": noArgs(func(st *synthState) interface{} {
+		id, _ := uuid.NewRandomFromReader(st.rng)
+		return id.String()
+	}),
+	"first_name": pickFrom(firstNames),
+	"last_name":  pickFrom(lastNames),
+	"name": noArgs(func(st *synthState) interface{} {
+		return pick(st, firstNames) + " " + pick(st, lastNames)
+	}),
+	"username": noArgs(func(st *synthState) interface{} {
+		return strings.ToLower(pick(st, firstNames)) + strconv.Itoa(st.rng.Intn(1000))
+	}),
+	"email": noArgs(func(st *synthState) interface{} {
+		return strings.ToLower(pick(st, firstNames)+"."+pick(st, lastNames)) + "@" + pick(st, domains)
+	}),
+	"phone": noArgs(func(st *synthState) interface{} {
+		return fmt.Sprintf("+1-%03d-%03d-%04d", 200+st.rng.Intn(800), st.rng.Intn(1000), st.rng.Intn(10000))
+	}),
+	"company": pickFrom(companies),
+	"city":    pickFrom(cities),
+	"country": pickFrom(countries),
+	"word":    pickFrom(words),
+	"sentence": noArgs(func(st *synthState) interface{} {
+		n := 4 + st.rng.Intn(6)
+		parts := make([]string, n)
+		for i := range parts {
+			parts[i] = pick(st, words)
+		}
+		s := strings.Join(parts, " ")
+		return strings.ToUpper(s[:1]) + s[1:] + "."
+	}),
+	"url": noArgs(func(st *synthState) interface{} {
+		return "https://" + pick(st, domains) + "/" + pick(st, words)
+	}),
+	"bool": noArgs(func(st *synthState) interface{} {
+		return st.rng.Intn(2) == 1
+	}),
+	"seq": noArgs(func(st *synthState) interface{} {
+		return st.seq
+	}),
+	"timestamp": noArgs(func(st *synthState) interface{} {
+		return time.Now().UTC().Format(time.RFC3339Nano)
+	}),
+	"int": func(args []string) (synthesizer, error) {
+		lo, hi, err := bounds(args, 0, 100)
+		if err != nil {
+			return nil, err
+		}
+		return intBetween(lo, hi)
+	},
+	"float": func(args []string) (synthesizer, error) {
+		lo, hi, err := bounds(args, 0, 1)
+		if err != nil {
+			return nil, err
+		}
+		return floatBetween(lo, hi), nil
+	},
+	"pick": func(args []string) (synthesizer, error) {
+		if len(args) == 0 {
+			return nil, errors.New("pick needs at least one choice")
+		}
+		return func(st *synthState) interface{} { return pick(st, args) }, nil
+	},
+}
+
+func noArgs(fn synthesizer) func([]string) (synthesizer, error) {
+	return func(args []string) (synthesizer, error) {
+		if len(args) > 0 {
+			return nil, errors.New("takes no arguments")
+		}
+		return fn, nil
+	}
+}
+
+func pickFrom(list []string) func([]string) (synthesizer, error) {
+	return noArgs(func(st *synthState) interface{} { return pick(st, list) })
+}
+
+func pick(st *synthState, list []string) string {
+	return list[st.rng.Intn(len(list))]
+}
+
+// bounds parses optional min and max arguments
+func bounds(args []string, lo, hi float64) (float64, float64, error) {
+	if len(args) != 0 && len(args) != 2 {
+		return 0, 0, errors.New("takes a minimum and a maximum or nothing")
+	}
+	if len(args) == 2 {
+		var err error
+		if lo, err = strconv.ParseFloat(args[0], 64); err != nil {
+			return 0, 0, err
+		}
+		if hi, err = strconv.ParseFloat(args[1], 64); err != nil {
+			return 0, 0, err
+		}
+	}
+	if math.IsInf(lo, 0) || math.IsNaN(lo) || math.IsInf(hi, 0) || math.IsNaN(hi) {
+		return 0, 0, errors.New("bounds must be finite")
+	}
+	if hi < lo {
+		return 0, 0, errors.New("maximum below minimum")
+	}
+	return lo, hi, nil
+}
+
+// maxIntBound keeps the width of an integer range within an int64
+const maxIntBound = 1 << 61
+
+// intBetween draws whole numbers from [lo, hi], refusing a range that holds
+// none or is too wide to draw from
+func intBetween(lo, hi float64) (synthesizer, error) {
+	lo, hi = math.Ceil(lo), math.Floor(hi)
+	if lo < -maxIntBound || hi > maxIntBound {
+		return nil, fmt.Errorf("integer bounds must be within ±%d", int64(maxIntBound))
+	}
+	if hi < lo {
+		return nil, errors.New("no integer between minimum and maximum")
+	}
+	base, width := int64(lo), int64(hi)-int64(lo)+1
+	return func(st *synthState) interface{} {
+		return base + st.rng.Int63n(width)
+	}, nil
+}
+
+func floatBetween(lo, hi float64) synthesizer {
+	return func(st *synthState) interface{} {
+		return lo + st.rng.Float64()*(hi-lo)
+	}
+}
+
+// compileFaker compiles the inside of a placeholder, such as "int 1 10"
+func compileFaker(spec string) (synthesizer, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%w: empty placeholder", ErrInvalidSynth)
+	}
+	faker, ok := fakers[fields[0]]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidSynth, fields[0])
+	}
+	fn, err := faker(fields[1:])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidSynth, fields[0], err)
+	}
+	return fn, nil
+}
+
+var placeholder = regexp.MustCompile(`\{\{([^{}]*)\}\}`)
+
+// compileString compiles a string holding placeholders. A string that is a
+// single placeholder keeps the field's type, so "{{int}}" makes a number;
+// otherwise the fields are written into the string.
+func compileString(s string) (synthesizer, error) {
+	matches := placeholder.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return func(*synthState) interface{} { return s }, nil
+	}
+	if len(matches) == 1 && matches[0][0] == 0 && matches[0][1] == len(s) {
+		return compileFaker(s[matches[0][2]:matches[0][3]])
+	}
+
+	var (
+		literals []string
+		fields   []synthesizer
+		last     int
+	)
+	for _, m := range matches {
+		fn, err := compileFaker(s[m[2]:m[3]])
+		if err != nil {
+			return nil, err
+		}
+		literals = append(literals, s[last:m[0]])
+		fields = append(fields, fn)
+		last = m[1]
+	}
+	tail := s[last:]
+	return func(st *synthState) interface{} {
+		var b strings.Builder
+		for i, fn := range fields {
+			b.WriteString(literals[i])
+			fmt.Fprint(&b, fn(st))
+		}
+		b.WriteString(tail)
+		return b.String()
+	}, nil
+}
+
+// compileTemplate compiles a template payload: strings may hold
+// placeholders, and objects and arrays are compiled member by member
+func compileTemplate(tmpl interface{}) (synthesizer, error) {
+	switch v := tmpl.(type) {
+	case string:
+		return compileString(v)
+	case map[string]interface{}:
+		keys, fields := sortedKeys(v), make([]synthesizer, 0, len(v))
+		for _, key := range keys {
+			fn, err := compileTemplate(v[key])
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, fn)
+		}
+		return objectOf(keys, fields), nil
+	case []interface{}:
+		items := make([]synthesizer, len(v))
+		for i, item := range v {
+			fn, err := compileTemplate(item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = fn
+		}
+		return func(st *synthState) interface{} {
+			out := make([]interface{}, len(items))
+			for i, fn := range items {
+				out[i] = fn(st)
+			}
+			return out
+		}, nil
+	default:
+		return func(*synthState) interface{} { return v }, nil
+	}
+}
+
+// compileSchema compiles a JSON Schema. It understands type, properties,
+// items, minItems, maxItems, enum, const, minimum, maximum, minLength,
+// maxLength and the string formats email, uuid, uri, hostname, date and
+// date-time. The extension keyword faker names a template field instead,
+// as in {"type": "string", "faker": "city"}.
+func compileSchema(schema map[string]interface{}) (synthesizer, error) {
+	if v, ok := schema["const"]; ok {
+		return func(*synthState) interface{} { return v }, nil
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok && len(enum) > 0 {
+		return func(st *synthState) interface{} { return enum[st.rng.Intn(len(enum))] }, nil
+	}
+	if spec, ok := schema["faker"].(string); ok {
+		return compileFaker(spec)
+	}
+
+	typ, err := schemaType(schema)
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case "object":
+		props, _ := schema["properties"].(map[string]interface{})
+		keys, fields := sortedKeys(props), make([]synthesizer, 0, len(props))
+		for _, key := range keys {
+			sub, ok := props[key].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%w: property %q is not a schema", ErrInvalidSynth, key)
+			}
+			fn, err := compileSchema(sub)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			fields = append(fields, fn)
+		}
+		return objectOf(keys, fields), nil
+	case "array":
+		items, ok := schema["items"].(map[string]interface{})
+		if !ok {
+			items = map[string]interface{}{"type": "string"}
+		}
+		item, err := compileSchema(items)
+		if err != nil {
+			return nil, err
+		}
+		lo, hi := schemaInt(schema, "minItems", 1, maxSynthItems), schemaInt(schema, "maxItems", -1, maxSynthItems)
+		if hi < lo {
+			hi = min(lo+2, maxSynthItems)
+		}
+		return func(st *synthState) interface{} {
+			out := make([]interface{}, lo+st.rng.Intn(hi-lo+1))
+			for i := range out {
+				out[i] = item(st)
+			}
+			return out
+		}, nil
+	case "string":
+		return stringSchema(schema)
+	case "integer":
+		fn, err := intBetween(schemaNumber(schema, "minimum", 0), schemaNumber(schema, "maximum", 1000))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSynth, err)
+		}
+		return fn, nil
+	case "number":
+		lo, hi := schemaNumber(schema, "minimum", 0), schemaNumber(schema, "maximum", 1000)
+		if hi < lo {
+			return nil, fmt.Errorf("%w: maximum below minimum", ErrInvalidSynth)
+		}
+		return floatBetween(lo, hi), nil
+	case "boolean":
+		return fakers["bool"](nil)
+	case "null":
+		return func(*synthState) interface{} { return nil }, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported type %q", ErrInvalidSynth, typ)
+	}
+}
+
+// schemaType returns a schema's type, the first non-null one of a list,
+// or object when it has properties and none is given
+func schemaType(schema map[string]interface{}) (string, error) {
+	switch t := schema["type"].(type) {
+	case string:
+		return t, nil
+	case []interface{}:
+		for _, v := range t {
+			if s, ok := v.(string); ok && s != "null" {
+				return s, nil
+			}
+		}
+		return "null", nil
+	case nil:
+		if _, ok := schema["properties"]; ok {
+			return "object", nil
+		}
+	}
+	return "", fmt.Errorf("%w: schema without a type", ErrInvalidSynth)
+}
+
+func stringSchema(schema map[string]interface{}) (synthesizer, error) {
+	switch format, _ := schema["format"].(string); format {
+	case "email", "uuid":
+		return compileFaker(format)
+	case "uri":
+		return compileFaker("url")
+	case "hostname":
+		return pickFrom(domains)(nil)
+	case "date-time":
+		return compileFaker("timestamp")
+	case "date":
+		return func(*synthState) interface{} { return time.Now().UTC().Format(time.DateOnly) }, nil
+	}
+
+	lo, hi := schemaInt(schema, "minLength", -1, maxSynthLength), schemaInt(schema, "maxLength", -1, maxSynthLength)
+	if lo < 0 && hi < 0 {
+		return compileFaker("word")
+	}
+	if lo < 0 {
+		lo = 1
+	}
+	if hi < lo {
+		hi = min(lo+8, maxSynthLength)
+	}
+	return func(st *synthState) interface{} {
+		b := make([]byte, lo+st.rng.Intn(hi-lo+1))
+		for i := range b {
+			b[i] = byte('a' + st.rng.Intn(26))
+		}
+		return string(b)
+	}, nil
+}
+
+func schemaNumber(schema map[string]interface{}, key string, fallback float64) float64 {
+	if v, ok := schema[key].(float64); ok {
+		return v
+	}
+	return fallback
+}
+
+// schemaInt reads a count, capped at limit
+func schemaInt(schema map[string]interface{}, key string, fallback, limit int) int {
+	if v, ok := schema[key].(float64); ok && v >= 0 {
+		return int(min(v, float64(limit)))
+	}
+	return fallback
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// objectOf builds an object from fields compiled for keys, in key order
+func objectOf(keys []string, fields []synthesizer) synthesizer {
+	return func(st *synthState) interface{} {
+		out := make(map[string]interface{}, len(keys))
+		for i, key := range keys {
+			out[key] = fields[i](st)
+		}
+		return out
+	}
+}
+
+var (
+	firstNames = []string{"Ada", "Alan", "Amara", "Bjorn", "Chen", "Diego", "Elena", "Farah", "Grace", "Hiro", "Ines", "Jonas", "Kofi", "Lena", "Mateo", "Nadia", "Omar", "Priya", "Quinn", "Rosa", "Sven", "Tara", "Umar", "Vera", "Wen", "Yara", "Zane"}
+	lastNames  = []string{"Abe", "Baker", "Costa", "Dubois", "Eze", "Fischer", "Garcia", "Haddad", "Ivanova", "Jensen", "Kim", "Lopez", "Moreau", "Nakamura", "Okafor", "Patel", "Rossi", "Silva", "Tanaka", "Novak", "Weber", "Yilmaz", "Zhang"}
+	companies  = []string{"Acme Corp", "Globex", "Initech", "Umbrella", "Hooli", "Stark Industries", "Wayne Enterprises", "Vandelay Industries", "Soylent", "Cyberdyne"}
+	cities     = []string{"Amsterdam", "Berlin", "Cairo", "Denver", "Lagos", "Lima", "Lisbon", "Mumbai", "Nairobi", "Osaka", "Paris", "Seoul", "Sydney", "Toronto", "Zurich"}
+	countries  = []string{"BR", "CA", "DE", "EG", "FR", "IN", "JP", "KE", "KR", "MX", "NG", "NL", "PE", "US", "ZA"}
+	domains    = []string{"example.com", "example.org", "example.net", "test.dev", "mail.test"}
+	words      = []string{"alpha", "amber", "beacon", "cobalt", "delta", "ember", "falcon", "garnet", "harbor", "indigo", "juniper", "kestrel", "lumen", "meadow", "nimbus", "orbit", "pixel", "quartz", "ripple", "summit", "tundra", "umbra", "vertex", "willow", "zephyr"}
+)
@@ -0,0 +1,520 @@
+package pubsub
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+)
+
+var (
+	// ErrTopicNotFound is returned when an operation targets an unknown topic
+	ErrTopicNotFound = errors.New("topic not found")
+
+	// ErrTopicExists is returned when creating a topic that already exists
+	ErrTopicExists = errors.New("topic already exists")
+
+	// ErrReplayOverflow is returned when last_n replay does not fit the subscriber queue
+	ErrReplayOverflow = errors.New("subscriber queue overflow during replay")
+)
+
+// Cluster routes topic operations to the node that owns the topic.
+// When ServiceImpl.Cluster is nil every operation is handled locally.
+type Cluster interface {
+	// CreateTopic creates the topic on its owner and announces it to every node
+	CreateTopic(name string) error
+
+	// DeleteTopic deletes the topic on its owner and announces it to every node
+	DeleteTopic(name string) error
+
+	// Publish appends msg on the owner and returns it with its sequence number
+	Publish(topic string, msg sdk.Message) (sdk.Message, error)
+
+	// Subscribe registers this node's interest with the owner and returns
+	// the last n messages for replay
+	Subscribe(topic string, lastN int) ([]sdk.Message, error)
+}
+
+// getTopic looks up a topic by name
+func (s *ServiceImpl) getTopic(name string) (*topic, bool) {
+	return s.topics.get(name)
+}
+
+// registered reports whether t is still the topic registered under its
+// name, rather than one deleted since it was looked up
+func (s *ServiceImpl) registered(t *topic) bool {
+	current, ok := s.topics.get(t.name)
+	return ok && current == t
+}
+
+// AddTopic creates a topic, routing through the cluster when configured
+func (s *ServiceImpl) AddTopic(name string) error {
+	if isInbox(name) {
+		return ErrReservedName
+	}
+	if s.Cluster != nil {
+		return s.Cluster.CreateTopic(name)
+	}
+	return s.AddTopicLocal(name)
+}
+
+// AddTopicLocal creates a topic on this node only
+func (s *ServiceImpl) AddTopicLocal(name string) error {
+	return s.addTopic(newTopic(name, s.MaxMessages))
+}
+
+// addTopic registers a topic built by the caller
+func (s *ServiceImpl) addTopic(t *topic) error {
+	if !s.topics.add(t) {
+		return ErrTopicExists
+	}
+
+	s.notifyTopic(t.name, true)
+	return nil
+}
+
+// RemoveTopic deletes a topic, routing through the cluster when configured
+func (s *ServiceImpl) RemoveTopic(name string) error {
+	if s.Cluster != nil {
+		return s.Cluster.DeleteTopic(name)
+	}
+	return s.RemoveTopicLocal(name)
+}
+
+// RemoveTopicLocal deletes a topic on this node and disconnects its subscribers
+func (s *ServiceImpl) RemoveTopicLocal(name string) error {
+	topic, exists := s.topics.remove(name)
+	if !exists {
+		return ErrTopicNotFound
+	}
+
+	// Close all subscriber channels - their writer goroutines will handle
+	// cleanup. The topic is already unregistered, so writers can tell a
+	// deletion from an eviction.
+	topic.closeAll()
+
+	s.notifyTopic(name, false)
+	return nil
+}
+
+// TopicObserver is called after a topic is created or deleted on this node
+type TopicObserver func(name string, created bool)
+
+// ObserveTopics registers fn for topic lifecycle events and returns a
+// function that unregisters it
+func (s *ServiceImpl) ObserveTopics(fn TopicObserver) func() {
+	s.observersMu.Lock()
+	defer s.observersMu.Unlock()
+
+	s.nextObserver++
+	id := s.nextObserver
+	s.observers[id] = fn
+	return func() {
+		s.observersMu.Lock()
+		defer s.observersMu.Unlock()
+		delete(s.observers, id)
+	}
+}
+
+// notifyTopic runs the registered topic observers
+func (s *ServiceImpl) notifyTopic(name string, created bool) {
+	s.observersMu.Lock()
+	observers := make([]TopicObserver, 0, len(s.observers))
+	for _, fn := range s.observers {
+		observers = append(observers, fn)
+	}
+	s.observersMu.Unlock()
+
+	for _, fn := range observers {
+		fn(name, created)
+	}
+}
+
+// Publish stores msg and fans it out, routing through the cluster when
+// configured, then copies it on as the routing rules say. A message to a
+// client inbox goes to that client alone.
+func (s *ServiceImpl) Publish(name string, msg sdk.Message) (sdk.Message, error) {
+	span := s.startPublishSpan("", name, &msg)
+	msg, err := s.publish(name, msg)
+	span.end(msg.Seq, err)
+	return msg, err
+}
+
+// publish is Publish without tracing
+func (s *ServiceImpl) publish(name string, msg sdk.Message) (sdk.Message, error) {
+	if isInbox(name) {
+		return s.publishInbox(name, msg)
+	}
+	var err error
+	if s.Cluster != nil {
+		msg, err = s.Cluster.Publish(name, msg)
+	} else {
+		msg, err = s.PublishLocal(name, msg)
+	}
+	if err != nil {
+		return msg, err
+	}
+	s.route(name, msg)
+	return msg, nil
+}
+
+// PublishLocal stamps msg, appends it to the local ring buffer and fans it
+// out. A timestamp already set, as by an import, is kept.
+func (s *ServiceImpl) PublishLocal(name string, msg sdk.Message) (sdk.Message, error) {
+	topic, ok := s.getTopic(name)
+	if !ok {
+		return msg, ErrTopicNotFound
+	}
+	if err := topic.checkKey(msg); err != nil {
+		return msg, err
+	}
+
+	// Add server timestamp
+	if msg.TS.IsZero() {
+		msg.TS = time.Now().UTC()
+	}
+
+	topic.fanMu.Lock()
+	defer topic.fanMu.Unlock()
+	if !s.registered(topic) {
+		return msg, ErrTopicNotFound
+	}
+
+	topic.mu.Lock()
+	topic.lastSeq++
+	msg.Seq = topic.lastSeq
+	topic.retain(msg)
+	paused, subs := topic.paused, topic.subscriberList()
+	topic.mu.Unlock()
+
+	topic.published.Add(1)
+	if !paused {
+		topic.fanOut(subs, msg, s.delivery(name), s.traceFanOut(name, msg))
+	}
+	return msg, nil
+}
+
+// ApplyReplica stores a message already sequenced by the owner and fans it
+// out to local subscribers. Messages at or below the topic's last sequence
+// number are ignored so replays are idempotent.
+func (s *ServiceImpl) ApplyReplica(name string, msg sdk.Message) error {
+	topic, ok := s.getTopic(name)
+	if !ok {
+		return ErrTopicNotFound
+	}
+
+	topic.fanMu.Lock()
+	defer topic.fanMu.Unlock()
+
+	topic.mu.Lock()
+	if msg.Seq <= topic.lastSeq {
+		topic.mu.Unlock()
+		return nil
+	}
+	topic.lastSeq = msg.Seq
+	topic.retain(msg)
+	paused, subs := topic.paused, topic.subscriberList()
+	topic.mu.Unlock()
+
+	topic.published.Add(1)
+	if !paused {
+		topic.fanOut(subs, msg, s.delivery(name), s.traceFanOut(name, msg))
+	}
+	return nil
+}
+
+// Deliver fans a message out to local subscribers without storing it, or
+// buffers it in the ring while the topic is paused. It reports whether the
+// topic still has local subscribers.
+func (s *ServiceImpl) Deliver(name string, msg sdk.Message) bool {
+	topic, ok := s.getTopic(name)
+	if !ok {
+		return false
+	}
+
+	topic.fanMu.Lock()
+	defer topic.fanMu.Unlock()
+
+	topic.mu.Lock()
+	paused, subs := topic.paused, topic.subscriberList()
+	if paused {
+		topic.retain(msg)
+	}
+	topic.mu.Unlock()
+
+	if !paused {
+		topic.fanOut(subs, msg, s.delivery(name), s.traceFanOut(name, msg))
+	}
+	return len(subs) > 0
+}
+
+// Subscribe attaches sub to the topic and queues up to lastN retained
+// messages ahead of any live ones. It returns how many were replayed.
+func (s *ServiceImpl) Subscribe(name string, sub *sdk.Subscriber, lastN int) (int, error) {
+	return s.subscribe(name, sub, replayFrom{lastN: lastN})
+}
+
+// SubscribeAfter attaches sub to the topic and queues the retained messages
+// sequenced after afterSeq, so a client that reconnects picks up where it
+// left off. Messages that have already left the ring buffer show up as a gap
+// in the sequence numbers.
+func (s *ServiceImpl) SubscribeAfter(name string, sub *sdk.Subscriber, afterSeq uint64) (int, error) {
+	return s.subscribe(name, sub, replayFrom{afterSeq: &afterSeq})
+}
+
+// replayFrom selects what a new subscription is sent ahead of live messages
+type replayFrom struct {
+	lastN    int     // the newest lastN messages
+	afterSeq *uint64 // or every retained message after this sequence number
+	state    bool    // or the current value of every key of a compacted topic; with afterSeq, only when resuming would leave a gap
+}
+
+// subscribe registers sub and queues its replay, creating the topic if the
+// auto-create policy allows
+func (s *ServiceImpl) subscribe(name string, sub *sdk.Subscriber, from replayFrom) (int, error) {
+	if isInbox(name) {
+		return s.subscribeInbox(name, sub, from)
+	}
+	if err := s.interceptSubscribe(name, sub); err != nil {
+		return 0, err
+	}
+	// Only an authorized subscription creates its topic
+	if err := s.autoCreate(name, AutoCreateOnSubscribe); err != nil {
+		return 0, err
+	}
+
+	var replay []sdk.Message
+	if s.Cluster != nil {
+		if from.state {
+			return 0, ErrStateClustered
+		}
+		n := from.lastN
+		if from.afterSeq != nil {
+			n = s.MaxMessages
+		}
+		msgs, err := s.Cluster.Subscribe(name, n)
+		if err != nil {
+			return 0, err
+		}
+		replay = msgs
+		if from.afterSeq != nil {
+			replay = replay[:0]
+			for _, msg := range msgs {
+				if msg.Seq > *from.afterSeq {
+					replay = append(replay, msg)
+				}
+			}
+		}
+	}
+
+	topic, ok := s.getTopic(name)
+	if !ok {
+		return 0, ErrTopicNotFound
+	}
+
+	// Registering under mu places the subscription between two sequence
+	// numbers, so the replay and the first live message neither overlap nor
+	// leave a gap
+	topic.mu.Lock()
+	defer topic.mu.Unlock()
+	if !s.registered(topic) {
+		return 0, ErrTopicNotFound
+	}
+
+	if from.state && topic.state == nil {
+		return 0, ErrNotCompacted
+	}
+
+	// A paused topic replays only what was delivered before the pause; the
+	// rest reaches the subscriber on resume
+	startSeq := topic.lastSeq
+	if topic.paused {
+		startSeq = topic.pausedAt
+	}
+	stateReplay := false
+	switch {
+	case s.Cluster != nil:
+	case from.afterSeq != nil && (!from.state || topic.resumable(*from.afterSeq)):
+		topic.ring.scan(*from.afterSeq, func(msg sdk.Message) bool {
+			if msg.Seq > startSeq {
+				return false
+			}
+			replay = append(replay, msg)
+			return true
+		})
+	case from.state:
+		replay = topic.currentState(startSeq)
+		stateReplay = true
+	default:
+		replay = topic.ring.upTo(startSeq, from.lastN)
+	}
+	sub.StartSeq = startSeq
+	if len(replay) > 0 && !stateReplay {
+		sub.StartSeq = replay[0].Seq - 1
+	}
+	replay = deliverable(s.delivery(name), sub, replay)
+
+	// A client re-subscribing replaces its previous subscription
+	topic.register(sub)
+
+	for _, msg := range replay {
+		select {
+		case sub.Queue <- msg:
+		default:
+			if sub.FlowControl && !stateReplay {
+				// The writer reads the rest back from the ring buffer
+				sub.Lagging.Store(true)
+				return len(replay), nil
+			}
+			// Queue full during replay - disconnect slow consumer
+			topic.remove(sub)
+			return 0, ErrReplayOverflow
+		}
+	}
+	return len(replay), nil
+}
+
+// Unsubscribe detaches a client from the topic
+func (s *ServiceImpl) Unsubscribe(name, clientID string) error {
+	if isInbox(name) {
+		s.leaveInbox(name, nil, clientID)
+		return nil
+	}
+	topic, ok := s.getTopic(name)
+	if !ok {
+		return ErrTopicNotFound
+	}
+
+	topic.removeClient(clientID)
+	topic.dropExclusive(nil, clientID)
+	return nil
+}
+
+// Detach removes sub from the topic if it is still the registered subscriber
+// or a standby, leaving a newer subscription under the same client id in
+// place
+func (s *ServiceImpl) Detach(name string, sub *sdk.Subscriber) {
+	if isInbox(name) {
+		s.leaveInbox(name, sub, "")
+		return
+	}
+	if topic, ok := s.getTopic(name); ok {
+		if !topic.remove(sub) {
+			topic.dropExclusive(sub, "")
+		}
+	}
+}
+
+// LastMessages returns up to n of the most recent retained messages
+func (s *ServiceImpl) LastMessages(name string, n int) ([]sdk.Message, error) {
+	topic, ok := s.getTopic(name)
+	if !ok {
+		return nil, ErrTopicNotFound
+	}
+
+	topic.mu.RLock()
+	defer topic.mu.RUnlock()
+	return topic.ring.last(n), nil
+}
+
+// TopicSnapshot is a point-in-time copy of a topic's retained messages
+type TopicSnapshot struct {
+	Name        string
+	MaxMessages int
+	LastSeq     uint64
+	Messages    []sdk.Message // oldest first, including compacted values older than the ring
+	Compacted   bool
+}
+
+// Snapshot copies a topic's retained messages
+func (s *ServiceImpl) Snapshot(name string) (TopicSnapshot, error) {
+	topic, ok := s.getTopic(name)
+	if !ok {
+		return TopicSnapshot{}, ErrTopicNotFound
+	}
+
+	topic.mu.RLock()
+	defer topic.mu.RUnlock()
+	return TopicSnapshot{
+		Name:        name,
+		MaxMessages: topic.maxMessages,
+		LastSeq:     topic.lastSeq,
+		Messages:    topic.retained(),
+		Compacted:   topic.state != nil,
+	}, nil
+}
+
+// Restore replaces a topic's retained messages with snap when snap is newer,
+// creating the topic if needed. It reports whether the snapshot was adopted.
+func (s *ServiceImpl) Restore(snap TopicSnapshot) bool {
+	s.AddTopicLocal(snap.Name)
+	topic, ok := s.getTopic(snap.Name)
+	if !ok {
+		return false
+	}
+
+	topic.mu.Lock()
+	defer topic.mu.Unlock()
+
+	if snap.LastSeq <= topic.lastSeq {
+		return false
+	}
+	topic.lastSeq = snap.LastSeq
+	topic.clear()
+	topic.setCompacted(snap.Compacted)
+	for _, msg := range snap.Messages {
+		topic.retain(msg)
+	}
+	return true
+}
+
+// TopicNames lists every topic known to this node
+func (s *ServiceImpl) TopicNames() []string {
+	topics := s.topics.all()
+	names := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		names = append(names, topic.name)
+	}
+	return names
+}
+
+// HasSubscribers reports whether the topic has subscribers on this node
+func (s *ServiceImpl) HasSubscribers(name string) bool {
+	return s.SubscriberCount(name) > 0
+}
+
+// SubscriberCount returns how many subscribers the topic has on this node
+func (s *ServiceImpl) SubscriberCount(name string) int {
+	topic, ok := s.getTopic(name)
+	if !ok {
+		return 0
+	}
+	return len(topic.subscriberList())
+}
+
+// HasTopic reports whether the topic exists on this node
+func (s *ServiceImpl) HasTopic(name string) bool {
+	_, ok := s.getTopic(name)
+	return ok
+}
+
+// messagesAfter copies the retained messages newer than seq
+func (s *ServiceImpl) messagesAfter(name string, seq uint64) []sdk.Message {
+	topic, ok := s.getTopic(name)
+	if !ok {
+		return nil
+	}
+
+	topic.mu.RLock()
+	defer topic.mu.RUnlock()
+	msgs := topic.ring.after(seq)
+	if topic.paused {
+		// Messages held back by a pause are flushed on resume
+		for i, msg := range msgs {
+			if msg.Seq > topic.pausedAt {
+				return msgs[:i]
+			}
+		}
+	}
+	return msgs
+}
@@ -0,0 +1,451 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Tracing follows each message through this node: a publish span when it is
+// accepted or refused, an enqueue, drop or evict span for every subscriber
+// it fans out to, and a write span when a WebSocket connection sends it on.
+// The spans of a message share the trace named by its traceparent
+// attribute, so a trace continues across nodes and into subscribers. Spans
+// are kept in memory for lookup by message id and handed to the exporter in
+// the background. Tracing is off until EnableTracing is called.
+
+const (
+	// defaultTraceRetain is how many message ids keep their spans by default
+	defaultTraceRetain = 10000
+
+	// maxTraceSpans bounds the spans kept per message id for lookup; a
+	// message fanned out to more subscribers is still exported in full
+	maxTraceSpans = 1000
+
+	// traceQueue bounds the spans waiting for export; more are dropped
+	traceQueue = 4096
+
+	// traceBatch and traceFlush bound how many spans an export carries and
+	// how long a span waits for one
+	traceBatch = 512
+	traceFlush = time.Second
+)
+
+var (
+	// ErrTracingDisabled is returned when looking up a trace with tracing off
+	ErrTracingDisabled = errors.New("tracing is disabled")
+
+	// ErrTraceNotFound is returned when no spans are kept for a message id
+	ErrTraceNotFound = errors.New("no spans recorded for message")
+)
+
+// SpanExporter sends finished spans to a tracing backend
+type SpanExporter interface {
+	Export(ctx context.Context, spans []sdk.Span) error
+}
+
+// TraceConfig configures message tracing
+type TraceConfig struct {
+	Exporter      SpanExporter // nil keeps spans for lookup only
+	Retain        int          // message ids whose spans are kept for lookup, 0 uses defaultTraceRetain
+	OnExportError func(error)  // called when an export fails or spans are dropped, may be nil
+}
+
+// tracer records spans and exports them
+type tracer struct {
+	cfg TraceConfig
+
+	mu    sync.Mutex
+	kept  map[string]*traceRecord
+	order []string // kept message ids, oldest first
+
+	queue   chan sdk.Span
+	dropped atomic.Int64
+	stop    chan struct{}
+	stopped chan struct{} // closed once the export loop has flushed
+	once    sync.Once
+}
+
+// traceRecord is the spans kept for one message id
+type traceRecord struct {
+	spans     []sdk.Span
+	truncated bool
+}
+
+// EnableTracing starts recording spans, replacing any earlier tracer. The
+// export loop runs until Shutdown.
+func (s *ServiceImpl) EnableTracing(cfg TraceConfig) {
+	if cfg.Retain <= 0 {
+		cfg.Retain = defaultTraceRetain
+	}
+	t := &tracer{
+		cfg:     cfg,
+		kept:    make(map[string]*traceRecord),
+		queue:   make(chan sdk.Span, traceQueue),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if cfg.Exporter != nil {
+		go t.run()
+	}
+	if old := s.tracer.Swap(t); old != nil {
+		old.close(context.Background())
+	}
+}
+
+// stopTracing flushes the spans waiting for export, giving up when ctx
+// expires
+func (s *ServiceImpl) stopTracing(ctx context.Context) {
+	if t := s.tracer.Load(); t != nil {
+		t.close(ctx)
+	}
+}
+
+// record finishes span, ending it now unless it has ended, keeps it for
+// lookup and queues it for export
+func (t *tracer) record(span sdk.Span) {
+	if span.End.IsZero() {
+		span.End = time.Now()
+	}
+	span.DurationUS = span.End.Sub(span.Start).Microseconds()
+	span.Start, span.End = span.Start.UTC(), span.End.UTC()
+
+	if span.MessageID != "" {
+		t.keep(span)
+	}
+	if t.cfg.Exporter == nil {
+		return
+	}
+	select {
+	case t.queue <- span:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// keep stores span under its message id, forgetting the oldest message ids
+// beyond Retain
+func (t *tracer) keep(span sdk.Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rec, ok := t.kept[span.MessageID]
+	if !ok {
+		rec = &traceRecord{}
+		t.kept[span.MessageID] = rec
+		t.order = append(t.order, span.MessageID)
+		for len(t.order) > t.cfg.Retain {
+			delete(t.kept, t.order[0])
+			t.order = t.order[1:]
+		}
+	}
+	if len(rec.spans) >= maxTraceSpans {
+		rec.truncated = true
+		return
+	}
+	rec.spans = append(rec.spans, span)
+}
+
+// run exports queued spans in batches until the tracer is closed
+func (t *tracer) run() {
+	defer close(t.stopped)
+	ticker := time.NewTicker(traceFlush)
+	defer ticker.Stop()
+
+	batch := make([]sdk.Span, 0, traceBatch)
+	for {
+		select {
+		case span := <-t.queue:
+			if batch = append(batch, span); len(batch) < traceBatch {
+				continue
+			}
+		case <-ticker.C:
+		case <-t.stop:
+			for len(t.queue) > 0 {
+				batch = append(batch, <-t.queue)
+			}
+			t.export(batch)
+			return
+		}
+		t.export(batch)
+		batch = batch[:0]
+	}
+}
+
+// export sends a batch, reporting failures and spans dropped since the
+// last batch
+func (t *tracer) export(batch []sdk.Span) {
+	if n := t.dropped.Swap(0); n > 0 {
+		t.exportError(fmt.Errorf("dropped %d spans, export queue full", n))
+	}
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := t.cfg.Exporter.Export(ctx, batch); err != nil {
+		t.exportError(fmt.Errorf("failed to export %d spans: %w", len(batch), err))
+	}
+}
+
+func (t *tracer) exportError(err error) {
+	if t.cfg.OnExportError != nil {
+		t.cfg.OnExportError(err)
+	}
+}
+
+// close stops the export loop once it has flushed, or once ctx expires
+func (t *tracer) close(ctx context.Context) {
+	t.once.Do(func() { close(t.stop) })
+	if t.cfg.Exporter == nil {
+		return
+	}
+	select {
+	case <-t.stopped:
+	case <-ctx.Done():
+	}
+}
+
+// traceContext is a parsed W3C traceparent
+type traceContext struct {
+	traceID string // 32 lowercase hex digits
+	spanID  string // 16 lowercase hex digits
+	flags   string // 2 hex digits, 01 when sampled
+}
+
+func (tc traceContext) String() string {
+	return "00-" + tc.traceID + "-" + tc.spanID + "-" + tc.flags
+}
+
+// parseTraceParent parses a W3C traceparent header value. Versions after 00
+// may append fields, which are ignored.
+func parseTraceParent(value string) (traceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return traceContext{}, false
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if !isHex(version, 2) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return traceContext{}, false
+	}
+	if !isHex(traceID, 32) || traceID == strings.Repeat("0", 32) ||
+		!isHex(spanID, 16) || spanID == strings.Repeat("0", 16) || !isHex(flags, 2) {
+		return traceContext{}, false
+	}
+	return traceContext{traceID: traceID, spanID: spanID, flags: flags}, true
+}
+
+// isHex reports whether s is n lowercase hex digits
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func newTraceID() string {
+	for {
+		hi, lo := rand.Uint64(), rand.Uint64()
+		if hi != 0 || lo != 0 {
+			return fmt.Sprintf("%016x%016x", hi, lo)
+		}
+	}
+}
+
+func newSpanID() string {
+	for {
+		if id := rand.Uint64(); id != 0 {
+			return fmt.Sprintf("%016x", id)
+		}
+	}
+}
+
+// publishSpan is a publish in progress; nil when tracing is off
+type publishSpan struct {
+	t    *tracer
+	span sdk.Span
+}
+
+// startPublishSpan starts the publish span of msg, a child of the trace
+// context in its traceparent attribute or the root of a new trace, and
+// replaces the attribute with the span's own context. The attributes are
+// copied, not changed in place.
+func (s *ServiceImpl) startPublishSpan(clientID, topic string, msg *sdk.Message) *publishSpan {
+	t := s.tracer.Load()
+	if t == nil {
+		return nil
+	}
+	span := sdk.Span{
+		SpanID:    newSpanID(),
+		Name:      sdk.SpanNamePublish,
+		Topic:     topic,
+		MessageID: msg.ID,
+		ClientID:  clientID,
+		Start:     time.Now(),
+	}
+	flags := "01"
+	if parent, ok := parseTraceParent(msg.Attributes[sdk.TraceParentAttribute]); ok {
+		span.TraceID, span.ParentSpanID, flags = parent.traceID, parent.spanID, parent.flags
+	} else {
+		span.TraceID = newTraceID()
+	}
+
+	attributes := make(map[string]string, len(msg.Attributes)+1)
+	maps.Copy(attributes, msg.Attributes)
+	attributes[sdk.TraceParentAttribute] = traceContext{traceID: span.TraceID, spanID: span.SpanID, flags: flags}.String()
+	msg.Attributes = attributes
+	return &publishSpan{t: t, span: span}
+}
+
+// end records the publish, stored as seq or refused with err
+func (p *publishSpan) end(seq uint64, err error) {
+	if p == nil {
+		return
+	}
+	if seq > 0 {
+		p.span.Attributes = map[string]string{"seq": strconv.FormatUint(seq, 10)}
+	}
+	if err != nil {
+		p.span.Error = err.Error()
+	}
+	p.t.record(p.span)
+}
+
+// fanOutTrace records the per-subscriber spans of one message; nil when
+// tracing is off or the message carries no trace context
+type fanOutTrace struct {
+	t      *tracer
+	topic  string
+	id     string
+	parent traceContext
+}
+
+// traceFanOut prepares the spans of fanning msg out on topic
+func (s *ServiceImpl) traceFanOut(topic string, msg sdk.Message) *fanOutTrace {
+	t := s.tracer.Load()
+	if t == nil {
+		return nil
+	}
+	parent, ok := parseTraceParent(msg.Attributes[sdk.TraceParentAttribute])
+	if !ok {
+		return nil
+	}
+	return &fanOutTrace{t: t, topic: topic, id: msg.ID, parent: parent}
+}
+
+// start is when a subscriber's span begins, zero when not tracing
+func (f *fanOutTrace) start() time.Time {
+	if f == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// record records what became of the message for sub; reason explains a
+// drop or eviction
+func (f *fanOutTrace) record(name string, sub *sdk.Subscriber, start time.Time, reason string) {
+	if f == nil {
+		return
+	}
+	f.t.record(sdk.Span{
+		TraceID:      f.parent.traceID,
+		SpanID:       newSpanID(),
+		ParentSpanID: f.parent.spanID,
+		Name:         name,
+		Topic:        f.topic,
+		MessageID:    f.id,
+		ClientID:     sub.ClientID,
+		Start:        start,
+		Error:        reason,
+	})
+}
+
+// traceWrite records the write span of every traced message in an event or
+// event_batch frame, written from start until now with result err
+func (s *ServiceImpl) traceWrite(frame sdk.WebSocketResponse, start time.Time, remoteAddr string, err error) {
+	t := s.tracer.Load()
+	if t == nil {
+		return
+	}
+	msgs := frame.Messages
+	if frame.Message != nil {
+		msgs = []sdk.Message{*frame.Message}
+	}
+	end := time.Now()
+	for _, msg := range msgs {
+		parent, ok := parseTraceParent(msg.Attributes[sdk.TraceParentAttribute])
+		if !ok {
+			continue
+		}
+		span := sdk.Span{
+			TraceID:      parent.traceID,
+			SpanID:       newSpanID(),
+			ParentSpanID: parent.spanID,
+			Name:         sdk.SpanNameWrite,
+			Topic:        frame.Topic,
+			MessageID:    msg.ID,
+			Start:        start,
+			End:          end,
+			Attributes:   map[string]string{"remote_addr": remoteAddr},
+		}
+		if err != nil {
+			span.Error = err.Error()
+		}
+		t.record(span)
+	}
+}
+
+// Trace returns the spans kept for a message id, oldest first. Message ids
+// are not unique across topics, so the spans may cover several messages.
+func (s *ServiceImpl) Trace(messageID string) (sdk.TraceResponse, error) {
+	t := s.tracer.Load()
+	if t == nil {
+		return sdk.TraceResponse{}, ErrTracingDisabled
+	}
+	t.mu.Lock()
+	rec, ok := t.kept[messageID]
+	var resp sdk.TraceResponse
+	if ok {
+		resp = sdk.TraceResponse{
+			MessageID: messageID,
+			Spans:     append([]sdk.Span(nil), rec.spans...),
+			Truncated: rec.truncated,
+		}
+	}
+	t.mu.Unlock()
+	if !ok {
+		return sdk.TraceResponse{}, ErrTraceNotFound
+	}
+
+	sort.SliceStable(resp.Spans, func(i, j int) bool {
+		return resp.Spans[i].Start.Before(resp.Spans[j].Start)
+	})
+	return resp, nil
+}
+
+// GetTrace returns the spans recorded for a message id via REST API
+func (s *ServiceImpl) GetTrace(ctx context.Context, c *fiber.Ctx) error {
+	resp, err := s.Trace(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(sdk.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	return c.JSON(resp)
+}
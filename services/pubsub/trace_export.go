@@ -0,0 +1,182 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+)
+
+// DefaultOTLPEndpoint is where a local OpenTelemetry collector accepts
+// traces over OTLP/HTTP
+const DefaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+
+// traceScope names the instrumentation in exported spans
+const traceScope = "github.com/Aryaman/pub-sub"
+
+// OTLP span kinds and status codes
+const (
+	otlpKindInternal = 1
+	otlpKindProducer = 4
+	otlpKindConsumer = 5
+
+	otlpStatusOK    = 1
+	otlpStatusError = 2
+)
+
+// OTLPExporter posts spans to an OpenTelemetry collector using the OTLP/HTTP
+// JSON encoding
+type OTLPExporter struct {
+	Endpoint    string // the collector's traces URL
+	ServiceName string // service.name of the exported spans
+	Client      *http.Client
+}
+
+// NewOTLPExporter creates an exporter posting to endpoint, or to
+// DefaultOTLPEndpoint when it is empty
+func NewOTLPExporter(endpoint, serviceName string) *OTLPExporter {
+	if endpoint == "" {
+		endpoint = DefaultOTLPEndpoint
+	}
+	return &OTLPExporter{
+		Endpoint:    endpoint,
+		ServiceName: serviceName,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Export posts one ExportTraceServiceRequest holding spans
+func (e *OTLPExporter) Export(ctx context.Context, spans []sdk.Span) error {
+	body, err := json.Marshal(otlpRequest(e.ServiceName, spans))
+	if err != nil {
+		return fmt.Errorf("failed to encode spans: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// otlpRequest builds the JSON form of an ExportTraceServiceRequest. Trace
+// and span ids are hex strings and 64-bit integers decimal strings, as the
+// OTLP JSON encoding requires.
+func otlpRequest(serviceName string, spans []sdk.Span) map[string]interface{} {
+	out := make([]map[string]interface{}, len(spans))
+	for i, span := range spans {
+		attributes := map[string]string{
+			"messaging.system":           "pubsub",
+			"messaging.destination.name": span.Topic,
+			"messaging.message.id":       span.MessageID,
+		}
+		if span.ClientID != "" {
+			attributes["messaging.client.id"] = span.ClientID
+		}
+		for key, value := range span.Attributes {
+			attributes["pubsub."+key] = value
+		}
+
+		status := map[string]interface{}{"code": otlpStatusOK}
+		if span.Error != "" {
+			status = map[string]interface{}{"code": otlpStatusError, "message": span.Error}
+		}
+
+		otlp := map[string]interface{}{
+			"traceId":           span.TraceID,
+			"spanId":            span.SpanID,
+			"name":              span.Name + " " + span.Topic,
+			"kind":              otlpKind(span.Name),
+			"startTimeUnixNano": strconv.FormatInt(span.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.End.UnixNano(), 10),
+			"attributes":        otlpAttributes(attributes),
+			"status":            status,
+		}
+		if span.ParentSpanID != "" {
+			otlp["parentSpanId"] = span.ParentSpanID
+		}
+		out[i] = otlp
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]string{"service.name": serviceName}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": traceScope},
+				"spans": out,
+			}},
+		}},
+	}
+}
+
+// otlpKind is the span kind of a span name: publishing produces, writing
+// to a subscriber consumes and the steps between are internal
+func otlpKind(name string) int {
+	switch name {
+	case sdk.SpanNamePublish:
+		return otlpKindProducer
+	case sdk.SpanNameWrite:
+		return otlpKindConsumer
+	}
+	return otlpKindInternal
+}
+
+// otlpAttributes converts string attributes to OTLP key-values, in key order
+func otlpAttributes(attributes map[string]string) []interface{} {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	out := make([]interface{}, len(keys))
+	for i, key := range keys {
+		out[i] = map[string]interface{}{
+			"key":   key,
+			"value": map[string]interface{}{"stringValue": attributes[key]},
+		}
+	}
+	return out
+}
+
+// StdoutExporter writes spans as JSON Lines, one sdk.Span per line
+type StdoutExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewStdoutExporter creates an exporter writing to w, usually os.Stdout
+func NewStdoutExporter(w io.Writer) *StdoutExporter {
+	return &StdoutExporter{enc: json.NewEncoder(w)}
+}
+
+// Export writes spans, one per line
+func (e *StdoutExporter) Export(ctx context.Context, spans []sdk.Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, span := range spans {
+		if err := e.enc.Encode(span); err != nil {
+			return fmt.Errorf("failed to write span: %w", err)
+		}
+	}
+	return nil
+}
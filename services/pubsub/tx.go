@@ -0,0 +1,257 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	// ErrInvalidTx is returned for a publish_tx that is empty, too long or
+	// has a message without a topic or id
+	ErrInvalidTx = errors.New("invalid transaction")
+
+	// ErrTxClustered is returned for a publish_tx in cluster mode, where the
+	// topics may be owned by different nodes and no node can commit them
+	// together
+	ErrTxClustered = errors.New("publish_tx is not supported in cluster mode")
+)
+
+// TxError reports which message of a transaction failed validation
+type TxError struct {
+	Index int
+	Topic string
+	Err   error
+}
+
+func (e *TxError) Error() string {
+	return fmt.Sprintf("message %d to %q: %v", e.Index, e.Topic, e.Err)
+}
+
+func (e *TxError) Unwrap() error {
+	return e.Err
+}
+
+// publishTx publishes every message on behalf of clientID or none of them.
+// The publish interceptors and rate limits must admit the whole transaction
+// before anything is appended, and only then are missing topics
+// auto-created. The target
+// topics are locked in name order for the appends and fan-out, so readers
+// see all of the messages or none, and fan-out starts only once every
+// append is done. Routing rules then copy the messages on one by one, once
+// the locks are released.
+func (s *ServiceImpl) publishTx(clientID string, entries []sdk.TopicMessage) (results []sdk.PublishResult, err error) {
+	if s.Cluster != nil {
+		return nil, ErrTxClustered
+	}
+	if len(entries) == 0 || len(entries) > maxBatch {
+		return nil, fmt.Errorf("%w: 1-%d messages required", ErrInvalidTx, maxBatch)
+	}
+	for i, entry := range entries {
+		switch {
+		case entry.Topic == "":
+			return nil, &TxError{Index: i, Err: fmt.Errorf("%w: topic required", ErrInvalidTx)}
+		case entry.Message.ID == "":
+			return nil, &TxError{Index: i, Topic: entry.Topic, Err: fmt.Errorf("%w: message id required", ErrInvalidTx)}
+		case isInbox(entry.Topic):
+			return nil, &TxError{Index: i, Topic: entry.Topic, Err: ErrInboxUnsupported}
+		}
+	}
+
+	// Interceptors may rewrite messages; the caller's entries stay as given
+	entries = append([]sdk.TopicMessage(nil), entries...)
+	spans := make([]*publishSpan, len(entries))
+	for i := range entries {
+		spans[i] = s.startPublishSpan(clientID, entries[i].Topic, &entries[i].Message)
+	}
+	defer func() {
+		for i, span := range spans {
+			var seq uint64
+			if results != nil {
+				seq = results[i].Seq
+			}
+			span.end(seq, err)
+		}
+	}()
+	for i := range entries {
+		msg, err := s.interceptPublish(clientID, entries[i].Topic, entries[i].Message)
+		if err != nil {
+			return nil, &TxError{Index: i, Topic: entries[i].Topic, Err: err}
+		}
+		entries[i].Message = msg
+	}
+
+	// Only an admitted transaction creates its topics, so every topic must
+	// exist or be one auto-creation would make, and keys are checked
+	// against the settings it would have
+	autoCompacted := s.AutoCreate.Settings.Compacted != nil && *s.AutoCreate.Settings.Compacted
+	for i, entry := range entries {
+		var err error
+		t, ok := s.getTopic(entry.Topic)
+		switch {
+		case ok:
+			err = t.checkKey(entry.Message)
+		case !s.createsOn(entry.Topic, AutoCreateOnPublish):
+			err = ErrTopicNotFound
+		case autoCompacted && entry.Message.Key == "":
+			err = ErrKeyRequired
+		}
+		if err != nil {
+			return nil, &TxError{Index: i, Topic: entry.Topic, Err: err}
+		}
+	}
+
+	charges := make([]charge, len(entries))
+	for i, entry := range entries {
+		ch, err := s.charge(entry.Topic, entry.Message)
+		if err != nil {
+			return nil, &TxError{Index: i, Topic: entry.Topic, Err: err}
+		}
+		charges[i] = ch
+	}
+	if err := s.limiter.admitAll(clientID, charges, time.Now()); err != nil {
+		return nil, err
+	}
+
+	// Lock each distinct topic once, in name order so transactions sharing
+	// topics cannot deadlock
+	names := make([]string, 0, len(entries))
+	topics := make(map[string]*topic, len(entries))
+	for i, entry := range entries {
+		if _, seen := topics[entry.Topic]; seen {
+			continue
+		}
+		if err := s.autoCreate(entry.Topic, AutoCreateOnPublish); err != nil {
+			return nil, &TxError{Index: i, Topic: entry.Topic, Err: err}
+		}
+		t, ok := s.getTopic(entry.Topic)
+		if !ok {
+			return nil, &TxError{Index: i, Topic: entry.Topic, Err: ErrTopicNotFound}
+		}
+		topics[entry.Topic] = t
+		names = append(names, entry.Topic)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		topics[name].fanMu.Lock()
+	}
+	// Routing publishes to other topics, possibly ones locked here, so the
+	// locks are released before it runs
+	unlock := sync.OnceFunc(func() {
+		for _, name := range names {
+			topics[name].fanMu.Unlock()
+		}
+	})
+	defer unlock()
+
+	// A topic deleted or reconfigured while waiting for its lock fails the
+	// transaction
+	for i, entry := range entries {
+		t, ok := s.getTopic(entry.Topic)
+		if !ok || t != topics[entry.Topic] {
+			return nil, &TxError{Index: i, Topic: entry.Topic, Err: ErrTopicNotFound}
+		}
+		if err := t.checkKey(entry.Message); err != nil {
+			return nil, &TxError{Index: i, Topic: entry.Topic, Err: err}
+		}
+	}
+
+	// Nothing can fail from here on
+	type pending struct {
+		topic  *topic
+		msg    sdk.Message
+		paused bool
+		subs   []*sdk.Subscriber
+	}
+	now := time.Now().UTC()
+	appended := make([]pending, len(entries))
+	results = make([]sdk.PublishResult, len(entries))
+
+	for _, name := range names {
+		topics[name].mu.Lock()
+	}
+	for i, entry := range entries {
+		t := topics[entry.Topic]
+		msg := entry.Message
+		msg.TS = now
+		t.lastSeq++
+		msg.Seq = t.lastSeq
+		t.retain(msg)
+		appended[i] = pending{topic: t, msg: msg, paused: t.paused, subs: t.subscriberList()}
+		results[i] = sdk.PublishResult{ID: msg.ID, Seq: msg.Seq, Status: sdk.StatusOK}
+	}
+	for _, name := range names {
+		topics[name].mu.Unlock()
+	}
+
+	for _, p := range appended {
+		p.topic.published.Add(1)
+		if !p.paused {
+			p.topic.fanOut(p.subs, p.msg, s.delivery(p.topic.name), s.traceFanOut(p.topic.name, p.msg))
+		}
+	}
+	unlock()
+	for _, p := range appended {
+		s.route(p.topic.name, p.msg)
+	}
+	return results, nil
+}
+
+// txErrorFrame maps a failed publish_tx to its protocol error, naming the
+// message at fault
+func txErrorFrame(requestID string, err error) sdk.WebSocketResponse {
+	frame := topicErrorFrame(requestID, err)
+	if errors.Is(err, ErrInvalidTx) || errors.Is(err, ErrTxClustered) || errors.Is(err, ErrKeyRequired) || errors.Is(err, ErrInboxUnsupported) {
+		frame.Error.Code = sdk.ErrorCodeBadRequest
+	}
+	frame.Error.Message = err.Error()
+	return frame
+}
+
+// PublishTx publishes to several topics atomically via REST API
+func (s *ServiceImpl) PublishTx(ctx context.Context, c *fiber.Ctx) error {
+	var req sdk.PublishTxRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(sdk.ErrorResponse{
+			Error: "invalid request body",
+		})
+	}
+
+	clientID := req.ClientID
+	if clientID == "" {
+		clientID = c.IP()
+	}
+	results, err := s.publishTx(clientID, req.Messages)
+	if err != nil {
+		var limited *RateLimitError
+		status := fiber.StatusInternalServerError
+		switch {
+		case errors.As(err, &limited):
+			status = fiber.StatusTooManyRequests
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+		case errors.Is(err, ErrTopicNotFound):
+			status = fiber.StatusNotFound
+		case errors.Is(err, ErrInvalidTx), errors.Is(err, ErrTxClustered), errors.Is(err, ErrKeyRequired),
+			errors.Is(err, ErrRejected), errors.Is(err, ErrInboxUnsupported):
+			status = fiber.StatusBadRequest
+		}
+		return c.Status(status).JSON(sdk.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(sdk.PublishTxResponse{
+		Status:  sdk.StatusOK,
+		Results: results,
+	})
+}
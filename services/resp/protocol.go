@@ -0,0 +1,134 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Limits guarding against hostile or corrupt input
+const (
+	maxArgs      = 1024 * 1024
+	maxBulkLen   = 64 * 1024 * 1024
+	maxInlineLen = 64 * 1024
+)
+
+var errProtocol = errors.New("protocol error")
+
+// readCommand reads one command, either as a RESP array of bulk strings or
+// as an inline command typed into telnet or nc
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] != '*' {
+		line, err := readLine(r, maxInlineLen)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.Fields(line), nil
+	}
+
+	line, err := readLine(r, maxInlineLen)
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+	}
+
+	args := make([][]byte, 0, max(n, 0))
+	for i := 0; i < n; i++ {
+		line, err := readLine(r, maxInlineLen)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got '%s'", errProtocol, line)
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > maxBulkLen {
+			return nil, fmt.Errorf("%w: invalid bulk length", errProtocol)
+		}
+
+		// The buffer grows as the body arrives, so a declared length alone
+		// allocates little
+		var buf bytes.Buffer
+		buf.Grow(min(size+2, maxInlineLen))
+		if _, err := io.CopyN(&buf, r, int64(size)+2); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		arg := buf.Bytes()
+		if arg[size] != '\r' || arg[size+1] != '\n' {
+			return nil, fmt.Errorf("%w: bulk string not terminated", errProtocol)
+		}
+		args = append(args, arg[:size])
+	}
+	return args, nil
+}
+
+// readLine reads a CRLF (or bare LF) terminated line without the terminator
+func readLine(r *bufio.Reader, limit int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, chunk...)
+		if len(line) > limit {
+			return nil, fmt.Errorf("%w: line too long", errProtocol)
+		}
+		if !isPrefix {
+			return line, nil
+		}
+	}
+}
+
+func appendSimple(b []byte, s string) []byte {
+	b = append(b, '+')
+	b = append(b, s...)
+	return append(b, '\r', '\n')
+}
+
+func appendError(b []byte, s string) []byte {
+	b = append(b, '-')
+	b = append(b, s...)
+	return append(b, '\r', '\n')
+}
+
+func appendInt(b []byte, n int) []byte {
+	b = append(b, ':')
+	b = strconv.AppendInt(b, int64(n), 10)
+	return append(b, '\r', '\n')
+}
+
+func appendBulk(b []byte, s []byte) []byte {
+	b = append(b, '$')
+	b = strconv.AppendInt(b, int64(len(s)), 10)
+	b = append(b, '\r', '\n')
+	b = append(b, s...)
+	return append(b, '\r', '\n')
+}
+
+func appendBulkString(b []byte, s string) []byte {
+	return appendBulk(b, []byte(s))
+}
+
+func appendNull(b []byte) []byte {
+	return append(b, "$-1\r\n"...)
+}
+
+func appendArrayHeader(b []byte, n int) []byte {
+	b = append(b, '*')
+	b = strconv.AppendInt(b, int64(n), 10)
+	return append(b, '\r', '\n')
+}
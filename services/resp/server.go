@@ -0,0 +1,561 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+	"github.com/Aryaman/pub-sub/services/pubsub"
+	"github.com/Aryaman/pub-sub/utils"
+	"github.com/google/uuid"
+)
+
+// clientPrefix namespaces RESP connections among a topic's subscribers
+const clientPrefix = "resp/"
+
+// writeWait bounds every write to a client
+const writeWait = 10 * time.Second
+
+// Server speaks the pub/sub subset of the Redis protocol so existing Redis
+// clients can use pubsub topics as channels. Channels must exist as topics
+// to carry messages; subscribing to a channel that does not exist yet
+// attaches as soon as the topic is created.
+type Server struct {
+	svc *pubsub.ServiceImpl
+
+	ln        net.Listener
+	mu        sync.Mutex
+	conns     map[*client]struct{}
+	nextID    atomic.Int64
+	unobserve func()
+	closeOnce sync.Once
+}
+
+// NewServer creates a RESP frontend backed by svc
+func NewServer(svc *pubsub.ServiceImpl) *Server {
+	srv := &Server{
+		svc:   svc,
+		conns: make(map[*client]struct{}),
+	}
+	srv.unobserve = svc.ObserveTopics(srv.topicChanged)
+	return srv
+}
+
+// Start listens on addr and serves RESP clients
+func (srv *Server) Start(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for resp: %w", err)
+	}
+	go srv.Serve(ln)
+	return nil
+}
+
+// Serve accepts RESP clients on ln until Close is called
+func (srv *Server) Serve(ln net.Listener) {
+	srv.mu.Lock()
+	srv.ln = ln
+	srv.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go srv.handleConn(conn)
+	}
+}
+
+// Close stops the listener and disconnects every client
+func (srv *Server) Close() error {
+	srv.closeOnce.Do(func() {
+		srv.unobserve()
+
+		srv.mu.Lock()
+		if srv.ln != nil {
+			srv.ln.Close()
+		}
+		for c := range srv.conns {
+			c.conn.Close()
+		}
+		srv.mu.Unlock()
+	})
+	return nil
+}
+
+// topicChanged attaches clients whose channels or patterns match a newly
+// created topic
+func (srv *Server) topicChanged(name string, created bool) {
+	if !created {
+		return
+	}
+
+	srv.mu.Lock()
+	clients := make([]*client, 0, len(srv.conns))
+	for c := range srv.conns {
+		clients = append(clients, c)
+	}
+	srv.mu.Unlock()
+
+	for _, c := range clients {
+		c.attachIfMatched(name)
+	}
+}
+
+func (srv *Server) handleConn(conn net.Conn) {
+	c := &client{
+		srv:      srv,
+		conn:     conn,
+		id:       clientPrefix + strconv.FormatInt(srv.nextID.Add(1), 10),
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+		subs:     make(map[string]*sdk.Subscriber),
+	}
+
+	srv.mu.Lock()
+	srv.conns[c] = struct{}{}
+	srv.mu.Unlock()
+
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.conns, c)
+		srv.mu.Unlock()
+		c.detachAll()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				c.write(appendError(nil, "ERR "+err.Error()))
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if err := c.handle(args); err != nil {
+			return
+		}
+	}
+}
+
+// errQuit ends the connection after QUIT has been answered
+var errQuit = errors.New("client quit")
+
+// client is one RESP connection
+type client struct {
+	srv     *Server
+	conn    net.Conn
+	id      string
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	channels map[string]struct{}
+	patterns map[string]struct{}
+	subs     map[string]*sdk.Subscriber // topic name -> attached subscriber
+}
+
+// handle runs one command
+func (c *client) handle(args [][]byte) error {
+	cmd := strings.ToLower(string(args[0]))
+	params := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		params[i] = string(arg)
+	}
+
+	// A client with subscriptions may only manage them, as in Redis
+	if c.subscribed() {
+		switch cmd {
+		case "subscribe", "psubscribe", "unsubscribe", "punsubscribe", "ping", "quit":
+		default:
+			return c.write(appendError(nil, fmt.Sprintf(
+				"ERR Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context", cmd)))
+		}
+	}
+
+	switch cmd {
+	case "ping":
+		return c.ping(params)
+	case "publish":
+		if len(params) != 2 {
+			return c.write(wrongArgs(cmd))
+		}
+		return c.publish(params[0], args[2])
+	case "subscribe", "psubscribe":
+		if len(params) == 0 {
+			return c.write(wrongArgs(cmd))
+		}
+		return c.subscribe(cmd == "psubscribe", params)
+	case "unsubscribe", "punsubscribe":
+		return c.unsubscribe(cmd == "punsubscribe", params)
+	case "quit":
+		c.write(appendSimple(nil, "OK"))
+		return errQuit
+	default:
+		return c.write(appendError(nil, fmt.Sprintf("ERR unknown command '%s'", args[0])))
+	}
+}
+
+func wrongArgs(cmd string) []byte {
+	return appendError(nil, fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmd))
+}
+
+// ping answers PONG, or a push-style pong while subscribed
+func (c *client) ping(params []string) error {
+	if len(params) > 1 {
+		return c.write(wrongArgs("ping"))
+	}
+	if c.subscribed() {
+		reply := appendArrayHeader(nil, 2)
+		reply = appendBulkString(reply, "pong")
+		if len(params) == 1 {
+			return c.write(appendBulkString(reply, params[0]))
+		}
+		return c.write(appendBulkString(reply, ""))
+	}
+	if len(params) == 1 {
+		return c.write(appendBulkString(nil, params[0]))
+	}
+	return c.write(appendSimple(nil, "PONG"))
+}
+
+// publish replies with the number of subscribers on this node, like Redis.
+// Publishing to a channel with no topic reaches nobody and replies 0.
+func (c *client) publish(channel string, payload []byte) error {
+	receivers := c.srv.svc.SubscriberCount(channel)
+	msg := sdk.Message{ID: uuid.New().String(), Payload: utils.DecodePayload(payload)}
+	if _, err := c.srv.svc.PublishFrom(c.id, channel, msg); err != nil {
+		if errors.Is(err, pubsub.ErrTopicNotFound) {
+			return c.write(appendInt(nil, 0))
+		}
+		var limited *pubsub.RateLimitError
+		if errors.As(err, &limited) {
+			return c.write(appendError(nil, fmt.Sprintf("RATE_LIMITED retry after %dms", limited.RetryAfter.Milliseconds())))
+		}
+		return c.write(appendError(nil, "ERR "+err.Error()))
+	}
+	return c.write(appendInt(nil, receivers))
+}
+
+// subscribe adds channels or patterns, replying once per argument
+func (c *client) subscribe(pattern bool, names []string) error {
+	kind := "subscribe"
+	if pattern {
+		kind = "psubscribe"
+	}
+
+	// Attach while holding the write lock so no message overtakes the
+	// subscription confirmation
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	var reply []byte
+	for _, name := range names {
+		c.mu.Lock()
+		if pattern {
+			c.patterns[name] = struct{}{}
+		} else {
+			c.channels[name] = struct{}{}
+		}
+		count := len(c.channels) + len(c.patterns)
+		c.mu.Unlock()
+
+		if pattern {
+			for _, topic := range c.srv.svc.TopicNames() {
+				c.attachIfMatched(topic)
+			}
+		} else {
+			c.attachIfMatched(name)
+		}
+
+		reply = appendArrayHeader(reply, 3)
+		reply = appendBulkString(reply, kind)
+		reply = appendBulkString(reply, name)
+		reply = appendInt(reply, count)
+	}
+	return c.writeLocked(reply)
+}
+
+// unsubscribe removes the given channels or patterns, or all of them when
+// none are given
+func (c *client) unsubscribe(pattern bool, names []string) error {
+	kind := "unsubscribe"
+	if pattern {
+		kind = "punsubscribe"
+	}
+
+	c.mu.Lock()
+	set := c.channels
+	if pattern {
+		set = c.patterns
+	}
+	if len(names) == 0 {
+		for name := range set {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	var reply []byte
+	for _, name := range names {
+		delete(set, name)
+		reply = appendArrayHeader(reply, 3)
+		reply = appendBulkString(reply, kind)
+		reply = appendBulkString(reply, name)
+		reply = appendInt(reply, len(c.channels)+len(c.patterns))
+	}
+	if len(names) == 0 {
+		// Nothing to remove: Redis still confirms with a null name
+		reply = appendArrayHeader(reply, 3)
+		reply = appendBulkString(reply, kind)
+		reply = appendNull(reply)
+		reply = appendInt(reply, len(c.channels)+len(c.patterns))
+	}
+
+	var detach []string
+	for topic := range c.subs {
+		if !c.wants(topic) {
+			detach = append(detach, topic)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, topic := range detach {
+		c.detach(topic)
+	}
+	return c.write(reply)
+}
+
+func (c *client) subscribed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.channels)+len(c.patterns) > 0
+}
+
+// wants reports whether a channel or pattern matches the topic.
+// Caller must hold c.mu.
+func (c *client) wants(topic string) bool {
+	if _, ok := c.channels[topic]; ok {
+		return true
+	}
+	for pattern := range c.patterns {
+		if globMatch(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// attachIfMatched subscribes to a topic once something matches it
+func (c *client) attachIfMatched(topic string) {
+	c.mu.Lock()
+	if _, attached := c.subs[topic]; attached || !c.wants(topic) {
+		c.mu.Unlock()
+		return
+	}
+
+	sub := &sdk.Subscriber{
+		ClientID:     c.id,
+		Queue:        make(chan sdk.Message, c.srv.svc.MaxQueue),
+		QueueSize:    c.srv.svc.MaxQueue,
+		LastActive:   time.Now(),
+		CloseChannel: make(chan struct{}),
+	}
+	c.subs[topic] = sub
+	c.mu.Unlock()
+
+	if _, err := c.srv.svc.Subscribe(topic, sub, 0); err != nil {
+		c.mu.Lock()
+		delete(c.subs, topic)
+		c.mu.Unlock()
+		return
+	}
+	go c.forward(topic, sub)
+}
+
+// forward writes queued messages as message and pmessage pushes
+func (c *client) forward(topic string, sub *sdk.Subscriber) {
+	for {
+		select {
+		case msg := <-sub.Queue:
+			payload, err := utils.EncodePayload(msg.Payload)
+			if err != nil {
+				log.Printf("resp: cannot encode message %s on %s: %v", msg.ID, topic, err)
+				continue
+			}
+
+			var out []byte
+			c.mu.Lock()
+			if _, ok := c.channels[topic]; ok {
+				out = appendArrayHeader(out, 3)
+				out = appendBulkString(out, "message")
+				out = appendBulkString(out, topic)
+				out = appendBulk(out, payload)
+			}
+			for pattern := range c.patterns {
+				if globMatch(pattern, topic) {
+					out = appendArrayHeader(out, 4)
+					out = appendBulkString(out, "pmessage")
+					out = appendBulkString(out, pattern)
+					out = appendBulkString(out, topic)
+					out = appendBulk(out, payload)
+				}
+			}
+			c.mu.Unlock()
+
+			if len(out) > 0 {
+				if err := c.write(out); err != nil {
+					c.conn.Close()
+					return
+				}
+			}
+		case <-sub.CloseChannel:
+			c.mu.Lock()
+			ours := c.subs[topic] == sub
+			if ours {
+				delete(c.subs, topic)
+			}
+			c.mu.Unlock()
+
+			// A close we did not ask for on a topic that still exists means
+			// the client fell behind; Redis drops such clients too
+			if ours && c.srv.svc.HasTopic(topic) {
+				log.Printf("resp: disconnecting slow client %s on %s", c.id, topic)
+				c.conn.Close()
+			}
+			return
+		}
+	}
+}
+
+// detach drops the subscription for one topic
+func (c *client) detach(topic string) {
+	c.mu.Lock()
+	sub, ok := c.subs[topic]
+	delete(c.subs, topic)
+	c.mu.Unlock()
+
+	if ok {
+		c.srv.svc.Detach(topic, sub)
+	}
+}
+
+// detachAll drops every subscription when the connection ends
+func (c *client) detachAll() {
+	c.mu.Lock()
+	topics := make([]string, 0, len(c.subs))
+	for topic := range c.subs {
+		topics = append(topics, topic)
+	}
+	c.mu.Unlock()
+
+	for _, topic := range topics {
+		c.detach(topic)
+	}
+}
+
+func (c *client) write(data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeLocked(data)
+}
+
+// writeLocked writes data. Caller must hold c.writeMu.
+func (c *client) writeLocked(data []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// globMatch implements Redis glob-style patterns: '*', '?', '[...]' with
+// ranges and '^' negation, and '\' escapes. Every other token matches one
+// byte, so on a mismatch only the last '*' needs to take one more byte and
+// retry, which keeps matching within O(len(pattern)*len(s)).
+func globMatch(pattern, s string) bool {
+	p, i := 0, 0
+	star, mark := -1, 0
+	for i < len(s) {
+		if p < len(pattern) && pattern[p] == '*' {
+			star, mark = p, i
+			p++
+			continue
+		}
+		if p < len(pattern) {
+			if width, ok := matchToken(pattern[p:], s[i]); ok {
+				p += width
+				i++
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		mark++
+		p, i = star+1, mark
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchToken matches b against the first token of pattern, which is not
+// '*', and returns the token's width
+func matchToken(pattern string, b byte) (int, bool) {
+	switch pattern[0] {
+	case '?':
+		return 1, true
+	case '[':
+		end := strings.IndexByte(pattern[1:], ']')
+		if end < 0 {
+			// Unterminated class matches literally
+			return 1, b == '['
+		}
+		return end + 2, matchClass(pattern[1:end+1], b)
+	case '\\':
+		if len(pattern) > 1 {
+			return 2, pattern[1] == b
+		}
+	}
+	return 1, pattern[0] == b
+}
+
+// matchClass reports whether b is in a bracket expression body
+func matchClass(class string, b byte) bool {
+	negate := false
+	if len(class) > 0 && class[0] == '^' {
+		negate = true
+		class = class[1:]
+	}
+
+	matched := false
+	for i := 0; i < len(class); i++ {
+		switch {
+		case class[i] == '\\' && i+1 < len(class):
+			i++
+			matched = matched || class[i] == b
+		case i+2 < len(class) && class[i+1] == '-':
+			lo, hi := class[i], class[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (b >= lo && b <= hi)
+			i += 2
+		default:
+			matched = matched || class[i] == b
+		}
+	}
+	return matched != negate
+}
@@ -0,0 +1,207 @@
+package resp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Aryaman/pub-sub/sdk"
+	"github.com/Aryaman/pub-sub/services/pubsub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, svc *pubsub.ServiceImpl) *testClient {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := NewServer(svc)
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends a command as a RESP array
+func (c *testClient) do(args ...string) {
+	c.t.Helper()
+	cmd := appendArrayHeader(nil, len(args))
+	for _, arg := range args {
+		cmd = appendBulkString(cmd, arg)
+	}
+	_, err := c.conn.Write(cmd)
+	require.NoError(c.t, err)
+}
+
+// reply reads one reply and renders it compactly: arrays as space-separated
+// elements, null bulk strings as "(nil)"
+func (c *testClient) reply() string {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := readLine(c.r, maxInlineLen)
+	require.NoError(c.t, err)
+
+	switch line[0] {
+	case '*':
+		var n int
+		for _, d := range line[1:] {
+			n = n*10 + int(d-'0')
+		}
+		parts := make([]string, n)
+		for i := range parts {
+			parts[i] = c.reply()
+		}
+		return strings.Join(parts, " ")
+	case '$':
+		if string(line) == "$-1" {
+			return "(nil)"
+		}
+		body, err := readLine(c.r, maxBulkLen)
+		require.NoError(c.t, err)
+		return string(body)
+	default:
+		return string(line)
+	}
+}
+
+func TestPingAndPublishOutsideSubscribeMode(t *testing.T) {
+	svc := pubsub.NewService(10, 10)
+	require.NoError(t, svc.AddTopic("orders"))
+	c := dial(t, svc)
+
+	c.do("PING")
+	assert.Equal(t, "+PONG", c.reply())
+
+	// Inline commands work too
+	_, err := c.conn.Write([]byte("ping hello\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", c.reply())
+
+	c.do("PUBLISH", "orders", "x")
+	assert.Equal(t, ":0", c.reply())
+	c.do("PUBLISH", "missing", "x")
+	assert.Equal(t, ":0", c.reply())
+	c.do("GET", "k")
+	assert.Equal(t, "-ERR unknown command 'GET'", c.reply())
+}
+
+func TestSubscribeReceivesMessagesFromBothSides(t *testing.T) {
+	svc := pubsub.NewService(10, 10)
+	require.NoError(t, svc.AddTopic("orders"))
+	c := dial(t, svc)
+	pub := dial(t, svc)
+
+	c.do("SUBSCRIBE", "orders", "later")
+	assert.Equal(t, "subscribe orders :1", c.reply())
+	assert.Equal(t, "subscribe later :2", c.reply())
+
+	c.do("PUBLISH", "orders", "x")
+	assert.True(t, strings.HasPrefix(c.reply(), "-ERR Can't execute 'publish'"))
+	c.do("PING")
+	assert.Equal(t, "pong ", c.reply())
+
+	pub.do("PUBLISH", "orders", `{"id":1}`)
+	assert.Equal(t, ":1", pub.reply())
+	assert.Equal(t, `message orders {"id":1}`, c.reply())
+
+	// Messages published over WebSocket or REST arrive too
+	_, err := svc.Publish("orders", sdk.Message{ID: "m1", Payload: "hi"})
+	require.NoError(t, err)
+	assert.Equal(t, "message orders hi", c.reply())
+
+	// A channel attaches once its topic is created
+	require.NoError(t, svc.AddTopic("later"))
+	pub.do("PUBLISH", "later", "now")
+	assert.Equal(t, ":1", pub.reply())
+	assert.Equal(t, "message later now", c.reply())
+
+	c.do("UNSUBSCRIBE")
+	assert.Equal(t, "unsubscribe later :1", c.reply())
+	assert.Equal(t, "unsubscribe orders :0", c.reply())
+	assert.Equal(t, 0, svc.SubscriberCount("orders"))
+}
+
+func TestPatternSubscription(t *testing.T) {
+	svc := pubsub.NewService(10, 10)
+	require.NoError(t, svc.AddTopic("news.sports"))
+	require.NoError(t, svc.AddTopic("weather"))
+	c := dial(t, svc)
+
+	c.do("PSUBSCRIBE", "news.*")
+	assert.Equal(t, "psubscribe news.* :1", c.reply())
+	c.do("SUBSCRIBE", "news.sports")
+	assert.Equal(t, "subscribe news.sports :2", c.reply())
+
+	_, err := svc.Publish("weather", sdk.Message{ID: "m0", Payload: "rain"})
+	require.NoError(t, err)
+	_, err = svc.Publish("news.sports", sdk.Message{ID: "m1", Payload: "goal"})
+	require.NoError(t, err)
+
+	// One subscription per topic still yields both pushes, as in Redis
+	assert.Equal(t, "message news.sports goal", c.reply())
+	assert.Equal(t, "pmessage news.* news.sports goal", c.reply())
+	assert.Equal(t, 1, svc.SubscriberCount("news.sports"))
+	assert.Equal(t, 0, svc.SubscriberCount("weather"))
+
+	c.do("PUNSUBSCRIBE", "news.*")
+	assert.Equal(t, "punsubscribe news.* :1", c.reply())
+	c.do("UNSUBSCRIBE", "news.sports")
+	assert.Equal(t, "unsubscribe news.sports :0", c.reply())
+	c.do("UNSUBSCRIBE")
+	assert.Equal(t, "unsubscribe (nil) :0", c.reply())
+}
+
+func TestDeclaredBulkLengthIsNotPreallocated(t *testing.T) {
+	// A client declaring a 60MB argument and sending three bytes of it
+	input := "*1\r\n$60000000\r\nabc"
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := readCommand(bufio.NewReader(strings.NewReader(input)))
+	runtime.ReadMemStats(&after)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20))
+
+	args, err := readCommand(bufio.NewReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$5\r\nhello\r\n")))
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("GET"), []byte("hello")}, args)
+}
+
+func TestGlobMatch(t *testing.T) {
+	assert.True(t, globMatch("h?llo", "hello"))
+	assert.True(t, globMatch("h*llo", "heeeello"))
+	assert.True(t, globMatch("h[ae]llo", "hallo"))
+	assert.False(t, globMatch("h[^e]llo", "hello"))
+	assert.True(t, globMatch("h[a-b]llo", "hbllo"))
+	assert.True(t, globMatch(`a\*b`, "a*b"))
+	assert.False(t, globMatch(`a\*b`, "axb"))
+	assert.True(t, globMatch("orders/*", "orders/eu/1"))
+	assert.True(t, globMatch("*.*.b", "a.x.y.b"))
+	assert.True(t, globMatch("a**", "a"))
+	assert.False(t, globMatch("a*c?", "abc"))
+	assert.True(t, globMatch("[b", "[b"))
+
+	// Many stars against a near miss must not backtrack exponentially
+	done := make(chan bool)
+	go func() {
+		done <- globMatch("*a*a*a*a*a*a*a*a*a*a*a*a*b", strings.Repeat("a", 40))
+	}()
+	select {
+	case matched := <-done:
+		assert.False(t, matched)
+	case <-time.After(time.Second):
+		t.Fatal("pathological pattern did not finish")
+	}
+}
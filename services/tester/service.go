@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -94,12 +95,21 @@ type Service interface {
 
 	// GenerateTestCases automatically generates test cases for API endpoints
 	GenerateTestCases(ctx context.Context, baseURL string) ([]TestCase, error)
+
+	// Shutdown rejects new executions and waits for running ones
+	Shutdown(ctx context.Context) error
 }
 
 // ServiceImpl implements the Service interface
 type ServiceImpl struct {
 	client *http.Client
 	store  Store
+
+	mutex    sync.Mutex
+	draining bool
+	inflight sync.WaitGroup
+	stopCtx  context.Context // cancelled when the drain deadline passes
+	stop     context.CancelFunc
 }
 
 // Store defines the interface for test data storage
@@ -113,11 +123,14 @@ type Store interface {
 
 // NewService creates a new testing service
 func NewService(store Store) Service {
+	stopCtx, stop := context.WithCancel(context.Background())
 	return &ServiceImpl{
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
-		store: store,
+		store:   store,
+		stopCtx: stopCtx,
+		stop:    stop,
 	}
 }
 
@@ -134,6 +147,16 @@ func (s *ServiceImpl) GetTestSuite(ctx context.Context, suiteID string) (*TestSu
 
 // ExecuteTestSuite runs all tests in a suite against a tunnel
 func (s *ServiceImpl) ExecuteTestSuite(ctx context.Context, suiteID, tunnelURL string) (*TestExecution, error) {
+	if !s.beginExecution() {
+		return nil, fmt.Errorf("tester is shutting down")
+	}
+	defer s.inflight.Done()
+
+	// Abort outstanding requests if shutdown runs out of time
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(s.stopCtx, cancel)()
+
 	suite, err := s.store.GetTestSuite(ctx, suiteID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get test suite: %w", err)
@@ -157,6 +180,10 @@ func (s *ServiceImpl) ExecuteTestSuite(ctx context.Context, suiteID, tunnelURL s
 
 	// Execute tests
 	for _, testCase := range suite.TestCases {
+		if ctx.Err() != nil {
+			execution.Status = StatusCancelled
+			break
+		}
 		result := s.executeTestCase(ctx, testCase, tunnelURL, suite.GlobalHeaders)
 		execution.Results = append(execution.Results, result)
 
@@ -170,9 +197,12 @@ func (s *ServiceImpl) ExecuteTestSuite(ctx context.Context, suiteID, tunnelURL s
 	// Update execution status
 	execution.EndTime = time.Now()
 	execution.Duration = execution.EndTime.Sub(execution.StartTime)
-	execution.Status = StatusCompleted
+	if execution.Status != StatusCancelled {
+		execution.Status = StatusCompleted
+	}
 
-	err = s.store.UpdateTestExecution(ctx, execution)
+	// Record the outcome even when the run itself was cancelled
+	err = s.store.UpdateTestExecution(context.WithoutCancel(ctx), execution)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update test execution: %w", err)
 	}
@@ -180,6 +210,40 @@ func (s *ServiceImpl) ExecuteTestSuite(ctx context.Context, suiteID, tunnelURL s
 	return execution, nil
 }
 
+// Shutdown rejects new executions and waits for running ones to finish.
+// When ctx expires, running executions are cancelled and recorded as such.
+func (s *ServiceImpl) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	s.draining = true
+	s.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.stop()
+		<-done
+	}
+	return nil
+}
+
+// beginExecution registers an in-flight execution unless draining
+func (s *ServiceImpl) beginExecution() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.draining {
+		return false
+	}
+	s.inflight.Add(1)
+	return true
+}
+
 // executeTestCase executes a single test case
 func (s *ServiceImpl) executeTestCase(ctx context.Context, testCase TestCase, baseURL string, globalHeaders map[string]string) TestResult {
 	result := TestResult{
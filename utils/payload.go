@@ -0,0 +1,28 @@
+package utils
+
+import "encoding/json"
+
+// DecodePayload turns raw bytes from a non-JSON protocol into a message
+// payload: JSON documents are decoded so WebSocket clients see structured
+// data, anything else becomes a string
+func DecodePayload(b []byte) interface{} {
+	var v interface{}
+	if json.Valid(b) && json.Unmarshal(b, &v) == nil {
+		return v
+	}
+	return string(b)
+}
+
+// EncodePayload is the inverse of DecodePayload
+func EncodePayload(payload interface{}) ([]byte, error) {
+	switch v := payload.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	default:
+		return json.Marshal(v)
+	}
+}